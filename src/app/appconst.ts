@@ -3,3 +3,4 @@ export const CREATE_REMOTE = "createRemote";
 export const VIEW_REMOTE = "viewRemote";
 export const EDIT_REMOTE = "editRemote";
 export const ALERT = "alert";
+export const BOOKMARK_PARAMS = "bookmarkParams";
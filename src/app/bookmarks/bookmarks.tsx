@@ -164,14 +164,21 @@ class Bookmark extends React.Component<{ bookmark: BookmarkType }, {}> {
                         limitHeight={false}
                     />
                 </div>
-                <div className="bookmark-controls">
-                    <div className="bookmark-control" onClick={this.handleEditClick}>
-                        <PenIcon className={"icon"} />
+                <If condition={bm.library == null}>
+                    <div className="bookmark-controls">
+                        <div className="bookmark-control" onClick={this.handleEditClick}>
+                            <PenIcon className={"icon"} />
+                        </div>
+                        <div className="bookmark-control" onClick={this.handleDeleteClick}>
+                            <TrashIcon className={"icon"} />
+                        </div>
                     </div>
-                    <div className="bookmark-control" onClick={this.handleDeleteClick}>
-                        <TrashIcon className={"icon"} />
+                </If>
+                <If condition={bm.library != null}>
+                    <div className="bookmark-library" title="from a bookmark library (read-only)">
+                        {bm.library}
                     </div>
-                </div>
+                </If>
             </div>
         );
     }
@@ -230,6 +230,73 @@ class AlertModal extends React.Component<{}, {}> {
     }
 }
 
+@mobxReact.observer
+class BookmarkParamsModal extends React.Component<{}, {}> {
+    values: mobx.ObservableMap<string, string>;
+
+    constructor(props: {}) {
+        super(props);
+        let prompt = GlobalModel.bookmarksModel.paramPrompt.get();
+        this.values = mobx.observable.map({}, { name: "BookmarkParamsModal-values" });
+        for (let param of prompt?.params ?? []) {
+            this.values.set(param.name, param.lastvalue ?? param.default ?? "");
+        }
+    }
+
+    @boundMethod
+    closeModal(): void {
+        GlobalModel.bookmarksModel.closeParamPrompt();
+    }
+
+    @boundMethod
+    handleRun(): void {
+        let prompt = GlobalModel.bookmarksModel.paramPrompt.get();
+        let values: Record<string, string> = Object.fromEntries(this.values.entries());
+        GlobalModel.bookmarksModel.closeParamPrompt();
+        if (prompt != null) {
+            GlobalCommandRunner.runBookmark(prompt.bookmarkid, values);
+        }
+    }
+
+    @boundMethod
+    handleChangeValue(name: string, value: string): void {
+        mobx.action(() => {
+            this.values.set(name, value);
+        })();
+    }
+
+    render() {
+        let prompt = GlobalModel.bookmarksModel.paramPrompt.get();
+        if (prompt == null) {
+            return null;
+        }
+        return (
+            <Modal className="bookmark-params-modal">
+                <Modal.Header onClose={this.closeModal} title="Run Bookmark" />
+                <div className="wave-modal-body">
+                    <code className="bookmark-params-cmdstr">{prompt.cmdstr}</code>
+                    <For each="param" index="idx" of={prompt.params}>
+                        <TextField
+                            key={param.name}
+                            label={param.name}
+                            placeholder={param.default}
+                            autoFocus={idx == 0}
+                            value={this.values.get(param.name)}
+                            onChange={(value) => this.handleChangeValue(param.name, value)}
+                        />
+                    </For>
+                </div>
+                <div className="wave-modal-footer">
+                    <Button theme="secondary" onClick={this.closeModal}>
+                        Cancel
+                    </Button>
+                    <Button onClick={this.handleRun}>Run</Button>
+                </div>
+            </Modal>
+        );
+    }
+}
+
 @mobxReact.observer
 class TosModal extends React.Component<{}, {}> {
     @boundMethod
@@ -1452,6 +1519,7 @@ export {
     LoadingSpinner,
     ClientStopModal,
     AlertModal,
+    BookmarkParamsModal,
     DisconnectedModal,
     TosModal,
     AboutModal,
@@ -8,6 +8,7 @@ import {
     ViewRemoteConnDetailModal,
     EditRemoteConnModal,
     AlertModal,
+    BookmarkParamsModal,
 } from "./modals";
 import * as constants from "../../appconst";
 
@@ -17,6 +18,7 @@ const modalsRegistry: { [key: string]: () => React.ReactElement } = {
     [constants.VIEW_REMOTE]: () => <ViewRemoteConnDetailModal />,
     [constants.EDIT_REMOTE]: () => <EditRemoteConnModal />,
     [constants.ALERT]: () => <AlertModal />,
+    [constants.BOOKMARK_PARAMS]: () => <BookmarkParamsModal />,
 };
 
 export { modalsRegistry };
@@ -46,6 +46,7 @@ import type {
     RendererModel,
     PtyDataType,
     BookmarkType,
+    BookmarkPromptType,
     ClientDataType,
     HistoryViewDataType,
     AlertMessageType,
@@ -59,6 +60,7 @@ import type {
     TermContextUnion,
     RemoteEditType,
     RemoteViewType,
+    FileChangedType,
     CommandRtnType,
     WebCmd,
     WebRemote,
@@ -1915,6 +1917,8 @@ class SpecialHistoryViewLineContainer {
 }
 
 const HistoryPageSize = 50;
+// same as bookmarkParamRe in wavesrv (cmdrunner.go), {{name}} or {{name:default}}
+const BookmarkParamRe = /\{\{([a-zA-Z_][a-zA-Z0-9_]*)(?::([^}]*))?\}\}/;
 
 class HistoryViewModel {
     items: OArr<HistoryItem> = mobx.observable.array([], {
@@ -2239,6 +2243,9 @@ class BookmarksModel {
     tempCmd: OV<string> = mobx.observable.box("", {
         name: "bookmarkEdit-tempCmd",
     });
+    paramPrompt: OV<BookmarkPromptType> = mobx.observable.box(null, {
+        name: "bookmarkParamPrompt",
+    });
 
     showBookmarksView(bmArr: BookmarkType[], selectedBookmarkId: string): void {
         bmArr = bmArr ?? [];
@@ -2280,6 +2287,15 @@ class BookmarksModel {
         if (bm == null) {
             return;
         }
+        if (BookmarkParamRe.test(bm.cmdstr)) {
+            // wavesrv asks for the placeholder values (bookmarkprompt update) and then runs the command
+            mobx.action(() => {
+                this.reset();
+                GlobalModel.showSessionView();
+            })();
+            GlobalCommandRunner.runBookmark(bm.bookmarkid, null);
+            return;
+        }
         mobx.action(() => {
             this.reset();
             GlobalModel.showSessionView();
@@ -2288,6 +2304,20 @@ class BookmarksModel {
         })();
     }
 
+    showParamPrompt(prompt: BookmarkPromptType): void {
+        mobx.action(() => {
+            this.paramPrompt.set(prompt);
+            GlobalModel.modalsModel.pushModal(constants.BOOKMARK_PARAMS);
+        })();
+    }
+
+    closeParamPrompt(): void {
+        mobx.action(() => {
+            this.paramPrompt.set(null);
+            GlobalModel.modalsModel.popModal();
+        })();
+    }
+
     selectBookmark(bookmarkId: string): void {
         let bm = this.getBookmark(bookmarkId);
         if (bm == null) {
@@ -2327,6 +2357,9 @@ class BookmarksModel {
     }
 
     handleDeleteBookmark(bookmarkId: string): void {
+        if (this.getBookmark(bookmarkId)?.library != null) {
+            return;
+        }
         if (this.pendingDelete.get() == null || this.pendingDelete.get() != this.activeBookmark.get()) {
             mobx.action(() => this.pendingDelete.set(this.activeBookmark.get()))();
             setTimeout(this.clearPendingDelete, 2000);
@@ -2369,7 +2402,7 @@ class BookmarksModel {
 
     handleEditBookmark(bookmarkId: string): void {
         let bm = this.getBookmark(bookmarkId);
-        if (bm == null) {
+        if (bm == null || bm.library != null) {
             return;
         }
         mobx.action(() => {
@@ -2946,6 +2979,7 @@ class Model {
     });
     screenLines: OMap<string, ScreenLines> = mobx.observable.map({}, { name: "screenLines", deep: false }); // key = "sessionid/screenid" (screenlines)
     termUsedRowsCache: Record<string, number> = {}; // key = "screenid/lineid"
+    fileChangedMap: OMap<string, FileChangedType> = mobx.observable.map({}, { name: "fileChangedMap", deep: false }); // key = "screenid/lineid"
     debugCmds: number = 0;
     debugScreen: OV<boolean> = mobx.observable.box(false);
     waveSrvRunning: OV<boolean>;
@@ -3542,6 +3576,13 @@ class Model {
         if ("cmdline" in update) {
             this.inputModel.updateCmdLine(update.cmdline);
         }
+        if (interactive && "bookmarkprompt" in update) {
+            this.bookmarksModel.showParamPrompt(update.bookmarkprompt);
+        }
+        if ("filechanged" in update) {
+            let fc: FileChangedType = update.filechanged;
+            this.fileChangedMap.set(fc.screenid + "/" + fc.lineid, fc);
+        }
         if (interactive && "history" in update) {
             if (uiContext.sessionid == update.history.sessionid && uiContext.screenid == update.history.screenid) {
                 this.inputModel.setHistoryInfo(update.history);
@@ -3951,6 +3992,16 @@ class Model {
         return remote.remotecanonicalname;
     }
 
+    getFileChanged(screenId: string, lineId: string): FileChangedType {
+        return this.fileChangedMap.get(screenId + "/" + lineId);
+    }
+
+    clearFileChanged(screenId: string, lineId: string): void {
+        mobx.action(() => {
+            this.fileChangedMap.delete(screenId + "/" + lineId);
+        })();
+    }
+
     readRemoteFile(screenId: string, lineId: string, path: string): Promise<T.ExtFile> {
         let urlParams = {
             screenid: screenId,
@@ -3986,6 +4037,7 @@ class Model {
                     let isWriteable = (fileInfo.perm & 0o222) > 0; // checks for unix permission "w" bits
                     (file as any).readOnly = !isWriteable;
                     (file as any).notFound = !!fileInfo.notfound;
+                    (file as any).fileInfo = fileInfo;
                     return file as T.ExtFile;
                 } else {
                     let textError: string = blobOrText;
@@ -3999,19 +4051,130 @@ class Model {
         return prtn;
     }
 
+    // reads [start, end) (end is non-inclusive, it is clamped to the file size by the server).
+    // if entry is set, path is an archive and the range is read from that entry.
+    readRemoteFileRange(
+        screenId: string,
+        lineId: string,
+        path: string,
+        start: number,
+        end: number,
+        entry?: string
+    ): Promise<{ data: Uint8Array; fileInfo: T.FileInfoType }> {
+        let urlParams: Record<string, string> = { screenid: screenId, lineid: lineId, path: path };
+        if (entry != null) {
+            urlParams.entry = entry;
+        }
+        let usp = new URLSearchParams(urlParams);
+        let url = new URL(GlobalModel.getBaseHostPort() + "/api/read-file?" + usp.toString());
+        let fetchHeaders = this.getFetchHeaders();
+        fetchHeaders["Range"] = sprintf("bytes=%d-%d", start, end - 1);
+        let fileInfo: T.FileInfoType = null;
+        return fetch(url, { method: "get", headers: fetchHeaders })
+            .then((resp) => {
+                if (resp.status == 416) {
+                    // range is past the end of the file
+                    fileInfo = JSON.parse(atob(resp.headers.get("X-FileInfo")));
+                    return new ArrayBuffer(0);
+                }
+                if (!resp.ok) {
+                    return resp.text().then((text) => {
+                        throw new Error(
+                            sprintf("Bad fetch response for /api/read-file: %d %s %s", resp.status, resp.statusText, text)
+                        );
+                    });
+                }
+                fileInfo = JSON.parse(atob(resp.headers.get("X-FileInfo")));
+                return resp.arrayBuffer();
+            })
+            .then((buf: ArrayBuffer) => {
+                return { data: new Uint8Array(buf), fileInfo: fileInfo };
+            });
+    }
+
+    listRemoteArchive(
+        screenId: string,
+        lineId: string,
+        path: string
+    ): Promise<{ info: T.FileInfoType; entries: T.FileInfoType[] }> {
+        let usp = new URLSearchParams({ screenid: screenId, lineid: lineId, path: path });
+        let url = new URL(GlobalModel.getBaseHostPort() + "/api/list-archive?" + usp.toString());
+        let fetchHeaders = this.getFetchHeaders();
+        return fetch(url, { method: "get", headers: fetchHeaders })
+            .then((resp) => handleJsonFetchResponse(url, resp))
+            .then((data) => {
+                let rtn = data?.data ?? {};
+                return { info: rtn.info, entries: rtn.entries ?? [] };
+            });
+    }
+
+    // polls the line's disk usage scan (starting one if needed).  fromEntry is the number of entries already received.
+    getRemoteDiskUsage(
+        screenId: string,
+        lineId: string,
+        path: string,
+        fromEntry: number,
+        opts: { oneFs?: boolean; restart?: boolean; cancel?: boolean }
+    ): Promise<T.DiskUsageSnapshotType> {
+        let usp = new URLSearchParams({ screenid: screenId, lineid: lineId, path: path, from: String(fromEntry) });
+        if (opts.oneFs) {
+            usp.set("onefs", "1");
+        }
+        if (opts.restart) {
+            usp.set("restart", "1");
+        }
+        if (opts.cancel) {
+            usp.set("cancel", "1");
+        }
+        let url = new URL(GlobalModel.getBaseHostPort() + "/api/disk-usage?" + usp.toString());
+        let fetchHeaders = this.getFetchHeaders();
+        return fetch(url, { method: "get", headers: fetchHeaders })
+            .then((resp) => handleJsonFetchResponse(url, resp))
+            .then((data) => data?.data);
+    }
+
+    // pattern is a hex string.  resolves to the offsets of matches at or after offset.
+    searchRemoteFile(
+        screenId: string,
+        lineId: string,
+        path: string,
+        pattern: string,
+        offset: number,
+        maxResults?: number
+    ): Promise<{ offsets: number[]; truncated: boolean; info: T.FileInfoType }> {
+        let usp = new URLSearchParams({
+            screenid: screenId,
+            lineid: lineId,
+            path: path,
+            pattern: pattern,
+            offset: String(offset),
+            maxresults: String(maxResults ?? 1),
+        });
+        let url = new URL(GlobalModel.getBaseHostPort() + "/api/search-file?" + usp.toString());
+        let fetchHeaders = this.getFetchHeaders();
+        return fetch(url, { method: "get", headers: fetchHeaders })
+            .then((resp) => handleJsonFetchResponse(url, resp))
+            .then((data) => {
+                let rtn = data?.data ?? {};
+                return { offsets: rtn.offsets ?? [], truncated: !!rtn.truncated, info: rtn.info };
+            });
+    }
+
     writeRemoteFile(
         screenId: string,
         lineId: string,
         path: string,
         data: Uint8Array,
-        opts?: { useTemp?: boolean }
-    ): Promise<void> {
+        opts?: { useTemp?: boolean; expectedInfo?: T.FileInfoType }
+    ): Promise<T.FileInfoType> {
         opts = opts || {};
+        // if expectedInfo is set and the file has changed, the promise is rejected with errorcode "conflict"
         let params = {
             screenid: screenId,
             lineid: lineId,
             path: path,
             usetemp: !!opts.useTemp,
+            expectedinfo: opts.expectedInfo,
         };
         let formData = new FormData();
         formData.append("params", JSON.stringify(params));
@@ -4023,7 +4186,7 @@ class Model {
         return prtn
             .then((resp) => handleJsonFetchResponse(url, resp))
             .then((data) => {
-                return;
+                return data?.data;
             });
     }
 }
@@ -4282,7 +4445,7 @@ class CommandRunner {
             kwargs["searchremote"] = params.searchRemoteId;
         }
         if (params.fromTs != null) {
-            kwargs["fromts"] = String(params.fromTs);
+            kwargs["before"] = String(params.fromTs);
         }
         if (params.noMeta) {
             kwargs["meta"] = "0";
@@ -4342,6 +4505,11 @@ class CommandRunner {
         GlobalModel.submitCommand("bookmark", "delete", [bookmarkId], { nohist: "1" }, true);
     }
 
+    runBookmark(bookmarkId: string, values: { [key: string]: string }): void {
+        let kwargs = { ...values, nohist: "1" };
+        GlobalModel.submitCommand("bookmark", "run", [bookmarkId], kwargs, true);
+    }
+
     openSharedSession(): void {
         GlobalModel.submitCommand("session", "openshared", null, { nohist: "1" }, true);
     }
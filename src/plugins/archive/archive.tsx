@@ -0,0 +1,198 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+import * as React from "react";
+import * as mobx from "mobx";
+import * as mobxReact from "mobx-react";
+import dayjs from "dayjs";
+import { sprintf } from "sprintf-js";
+import { boundMethod } from "autobind-decorator";
+import * as T from "../../types/types";
+import { GlobalModel } from "../../model/model";
+
+import "./archive.less";
+
+type OV<V> = mobx.IObservableValue<V>;
+
+const PreviewSize = 64 * 1024;
+
+function formatSize(size: number): string {
+    if (size < 1024) {
+        return String(size);
+    }
+    let units = ["K", "M", "G", "T"];
+    let val = size;
+    let unitIdx = -1;
+    while (val >= 1024 && unitIdx < units.length - 1) {
+        val /= 1024;
+        unitIdx++;
+    }
+    return sprintf("%.1f%s", val, units[unitIdx]);
+}
+
+// treat as binary if there are NUL bytes or the data is not valid utf-8
+function decodePreviewText(data: Uint8Array): string {
+    if (data.indexOf(0) != -1) {
+        return null;
+    }
+    try {
+        return new TextDecoder("utf-8", { fatal: true }).decode(data);
+    } catch (e) {
+        // the preview may cut a multi-byte char in half, so retry without the last few bytes
+        if (data.length == PreviewSize) {
+            try {
+                return new TextDecoder("utf-8", { fatal: true }).decode(data.subarray(0, data.length - 3));
+            } catch (e) {
+                return null;
+            }
+        }
+        return null;
+    }
+}
+
+@mobxReact.observer
+class ArchiveRenderer extends React.Component<
+    { data: T.ExtBlob; context: T.RendererContext; opts: T.RendererOpts; lineState: T.LineStateType },
+    {}
+> {
+    archiveInfo: OV<T.FileInfoType> = mobx.observable.box(null, { name: "archive-info" });
+    entries: OV<T.FileInfoType[]> = mobx.observable.box(null, { name: "archive-entries", deep: false });
+    loadError: OV<string> = mobx.observable.box(null, { name: "archive-loadError" });
+    selectedEntry: OV<T.FileInfoType> = mobx.observable.box(null, { name: "archive-selectedEntry" });
+    previewText: OV<string> = mobx.observable.box(null, { name: "archive-previewText" });
+    previewStatus: OV<string> = mobx.observable.box(null, { name: "archive-previewStatus" });
+
+    componentDidMount() {
+        let { screenId, lineId } = this.props.context;
+        GlobalModel.listRemoteArchive(screenId, lineId, this.getPath())
+            .then((rtn) => {
+                mobx.action(() => {
+                    if (rtn.info?.notfound) {
+                        this.loadError.set(sprintf("file %s not found", JSON.stringify(this.getPath())));
+                        return;
+                    }
+                    this.archiveInfo.set(rtn.info);
+                    this.entries.set(rtn.entries);
+                })();
+            })
+            .catch((e) => {
+                mobx.action(() => {
+                    this.loadError.set("error reading archive: " + e);
+                })();
+            });
+    }
+
+    getPath(): string {
+        return this.props.lineState["prompt:file"];
+    }
+
+    @boundMethod
+    selectEntry(entry: T.FileInfoType): void {
+        if (entry.isdir) {
+            return;
+        }
+        mobx.action(() => {
+            this.selectedEntry.set(entry);
+            this.previewText.set(null);
+            this.previewStatus.set("loading...");
+        })();
+        let { screenId, lineId } = this.props.context;
+        GlobalModel.readRemoteFileRange(screenId, lineId, this.getPath(), 0, PreviewSize, entry.name)
+            .then((rtn) => {
+                if (this.selectedEntry.get() != entry) {
+                    return;
+                }
+                let text = decodePreviewText(rtn.data);
+                mobx.action(() => {
+                    if (text == null) {
+                        this.previewStatus.set(sprintf("binary file, %d bytes", entry.size));
+                        return;
+                    }
+                    this.previewText.set(text);
+                    if (entry.size > PreviewSize) {
+                        this.previewStatus.set(
+                            sprintf("showing first %s of %s", formatSize(PreviewSize), formatSize(entry.size))
+                        );
+                    } else {
+                        this.previewStatus.set(null);
+                    }
+                })();
+            })
+            .catch((e) => {
+                mobx.action(() => {
+                    this.previewStatus.set("error reading entry: " + e);
+                })();
+            });
+    }
+
+    renderPreview(): any {
+        let entry = this.selectedEntry.get();
+        if (entry == null) {
+            return null;
+        }
+        return (
+            <div className="archive-preview">
+                <div className="preview-header">
+                    {entry.name}
+                    <If condition={this.previewStatus.get() != null}> ({this.previewStatus.get()})</If>
+                </div>
+                <If condition={this.previewText.get() != null}>
+                    <pre>{this.previewText.get()}</pre>
+                </If>
+            </div>
+        );
+    }
+
+    render() {
+        let opts = this.props.opts;
+        if (this.loadError.get() != null) {
+            return (
+                <div className="archive-renderer" style={{ fontSize: opts.termFontSize }}>
+                    <div className="load-error-text">ERROR: {this.loadError.get()}</div>
+                </div>
+            );
+        }
+        let entries = this.entries.get();
+        if (entries == null) {
+            return (
+                <div className="archive-renderer" style={{ fontSize: opts.termFontSize }}>
+                    loading content <i className="fa fa-ellipsis fa-fade" />
+                </div>
+            );
+        }
+        let numFiles = entries.filter((entry) => !entry.isdir).length;
+        let totalSize = entries.reduce((acc, entry) => acc + (entry.isdir ? 0 : entry.size), 0);
+        let selectedEntry = this.selectedEntry.get();
+        return (
+            <div className="archive-renderer" style={{ fontSize: opts.termFontSize }}>
+                <div className="archive-header">
+                    {sprintf("%d files, %s uncompressed", numFiles, formatSize(totalSize))}
+                </div>
+                <div className="archive-entries">
+                    <table>
+                        <tbody>
+                            {entries.map((entry) => (
+                                <tr
+                                    key={entry.name}
+                                    className={
+                                        (entry.isdir ? "dir-entry" : "file-entry") +
+                                        (entry == selectedEntry ? " selected" : "")
+                                    }
+                                    onClick={() => this.selectEntry(entry)}
+                                >
+                                    <td className="perm">{sprintf("%s%04o", entry.isdir ? "d" : "-", entry.perm)}</td>
+                                    <td className="size">{entry.isdir ? "" : formatSize(entry.size)}</td>
+                                    <td className="modts">{dayjs(entry.modts).format("YYYY-MM-DD HH:mm")}</td>
+                                    <td className="name">{entry.name + (entry.isdir ? "/" : "")}</td>
+                                </tr>
+                            ))}
+                        </tbody>
+                    </table>
+                </div>
+                {this.renderPreview()}
+            </div>
+        );
+    }
+}
+
+export { ArchiveRenderer };
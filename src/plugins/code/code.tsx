@@ -2,8 +2,9 @@
 // SPDX-License-Identifier: Apache-2.0
 
 import * as React from "react";
+import * as mobxReact from "mobx-react";
 import * as T from "../../types/types";
-import Editor from "@monaco-editor/react";
+import Editor, { DiffEditor } from "@monaco-editor/react";
 import { Markdown } from "../../app/common/common";
 import { GlobalModel, GlobalCommandRunner } from "../../model/model";
 import Split from "react-split-it";
@@ -19,6 +20,7 @@ function renderCmdText(text: string): any {
 // there is a global monaco variable (TODO get the correct TS type)
 declare var monaco: any;
 
+@mobxReact.observer
 class SourceCodeRenderer extends React.Component<
     {
         data: T.ExtBlob;
@@ -48,6 +50,9 @@ class SourceCodeRenderer extends React.Component<
         showPreview: boolean;
         editorFraction: number;
         showReadonly: boolean;
+        remoteCode: string;
+        showDiff: boolean;
+        saveConflict: boolean;
     }
 > {
     /**
@@ -61,6 +66,7 @@ class SourceCodeRenderer extends React.Component<
     filePath;
     cacheKey;
     originalCode;
+    fileInfo: T.FileInfoType; // state of the file when it was last read or saved (used for conflict detection)
     monacoEditor: any; // reference to mounted monaco editor.  TODO need the correct type
     markdownRef;
     syncing;
@@ -84,11 +90,15 @@ class SourceCodeRenderer extends React.Component<
             showPreview: this.props.lineState["showPreview"],
             editorFraction: this.props.lineState["editorFraction"] || 0.5,
             showReadonly: false,
+            remoteCode: null,
+            showDiff: false,
+            saveConflict: false,
         };
     }
 
     componentDidMount(): void {
         this.filePath = this.props.lineState["prompt:file"];
+        this.fileInfo = this.props.data.fileInfo;
         const { screenId, lineId } = this.props.context;
         this.cacheKey = `${screenId}-${lineId}-${this.filePath}`;
         const code = SourceCodeRenderer.codeCache.get(this.cacheKey);
@@ -229,13 +239,73 @@ class SourceCodeRenderer extends React.Component<
         }
     };
 
-    doSave = (onSave = () => {}) => {
+    getFileChanged(): T.FileChangedType {
+        const { screenId, lineId } = this.props.context;
+        return GlobalModel.getFileChanged(screenId, lineId);
+    }
+
+    clearFileChanged = () => {
+        const { screenId, lineId } = this.props.context;
+        GlobalModel.clearFileChanged(screenId, lineId);
+        this.setState({ showDiff: false, remoteCode: null, saveConflict: false });
+    };
+
+    readRemoteCode(): Promise<string> {
+        const { screenId, lineId } = this.props.context;
+        return GlobalModel.readRemoteFile(screenId, lineId, this.filePath).then((file) => {
+            this.fileInfo = file.fileInfo;
+            return file.text();
+        });
+    }
+
+    doReload = () => {
+        if (this.state.isSave) {
+            return GlobalModel.showAlert({
+                message: "Reloading will discard your unsaved changes.  Continue?",
+                confirm: true,
+            }).then((result) => {
+                if (result) this.setState({ isSave: false }, this.doReload);
+            });
+        }
+        this.readRemoteCode()
+            .then((code) => {
+                this.originalCode = code;
+                SourceCodeRenderer.codeCache.set(this.cacheKey, code);
+                this.setState({ code, isSave: false }, this.setEditorHeight);
+                this.clearFileChanged();
+            })
+            .catch((e) => {
+                this.setState({ message: { status: "error", text: e.message } });
+                setTimeout(() => this.setState({ message: null }), 3000);
+            });
+    };
+
+    toggleDiff = () => {
+        if (this.state.showDiff) {
+            this.setState({ showDiff: false, remoteCode: null });
+            return;
+        }
+        const { screenId, lineId } = this.props.context;
+        GlobalModel.readRemoteFile(screenId, lineId, this.filePath)
+            .then((file) => file.text())
+            .then((remoteCode) => this.setState({ showDiff: true, remoteCode }))
+            .catch((e) => {
+                this.setState({ message: { status: "error", text: e.message } });
+                setTimeout(() => this.setState({ message: null }), 3000);
+            });
+    };
+
+    // if force is false, the save is rejected when the file has changed on the remote since we read it
+    doSave = (onSave = () => {}, force = false) => {
         if (!this.state.isSave) return;
         const { screenId, lineId } = this.props.context;
         const encodedCode = new TextEncoder().encode(this.state.code);
-        GlobalModel.writeRemoteFile(screenId, lineId, this.filePath, encodedCode, { useTemp: true })
-            .then(() => {
+        const expectedInfo = force ? null : this.fileInfo;
+        GlobalModel.writeRemoteFile(screenId, lineId, this.filePath, encodedCode, { useTemp: true, expectedInfo })
+            .then((newInfo) => {
+                this.fileInfo = newInfo;
                 this.originalCode = this.state.code;
+                this.clearFileChanged();
                 this.setState(
                     {
                         isSave: false,
@@ -246,11 +316,20 @@ class SourceCodeRenderer extends React.Component<
                 setTimeout(() => this.setState({ message: null }), 3000);
             })
             .catch((e) => {
+                if (e.errorcode == "conflict") {
+                    // let the user choose: overwrite, reload (discarding their changes), or diff
+                    this.setState({ saveConflict: true });
+                    return;
+                }
                 this.setState({ message: { status: "error", text: e.message } });
                 setTimeout(() => this.setState({ message: null }), 3000);
             });
     };
 
+    doOverwrite = () => {
+        this.doSave(() => {}, true);
+    };
+
     doClose = () => {
         // if there is unsaved data
         if (this.state.isSave)
@@ -341,6 +420,59 @@ class SourceCodeRenderer extends React.Component<
         </div>
     );
 
+    getDiffEditor = () => (
+        <div style={{ maxHeight: this.props.opts.maxSize.height }}>
+            <DiffEditor
+                theme="hc-black"
+                height={this.state.editorHeight}
+                language={this.state.selectedLanguage}
+                original={this.state.remoteCode}
+                modified={this.state.code}
+                options={{
+                    scrollBeyondLastLine: false,
+                    fontSize: GlobalModel.termFontSize.get() * 0.9,
+                    readOnly: true,
+                }}
+            />
+        </div>
+    );
+
+    getFileChangedBanner = () => {
+        const fileChanged = this.getFileChanged();
+        const { saveConflict } = this.state;
+        if ((fileChanged == null && !saveConflict) || !this.getAllowEditing()) {
+            return null;
+        }
+        const deleted = !!fileChanged?.deleted;
+        let text = deleted ? "file was deleted on the remote" : "file was changed on the remote";
+        if (saveConflict) {
+            text = "cannot save, " + text;
+        }
+        return (
+            <div className="file-changed">
+                <div className="file-changed-text">{text}</div>
+                {saveConflict && (
+                    <div className="button" onClick={this.doOverwrite}>
+                        overwrite
+                    </div>
+                )}
+                {!deleted && (
+                    <div className="button" onClick={this.doReload}>
+                        reload
+                    </div>
+                )}
+                {!deleted && (
+                    <div className="button" onClick={this.toggleDiff}>
+                        {this.state.showDiff ? "hide diff" : "diff"}
+                    </div>
+                )}
+                <div className="button" onClick={this.clearFileChanged}>
+                    dismiss
+                </div>
+            </div>
+        );
+    };
+
     getPreviewer = () => {
         return (
             <div
@@ -439,8 +571,9 @@ class SourceCodeRenderer extends React.Component<
         }
         return (
             <div className="code-renderer">
+                {this.getFileChangedBanner()}
                 <Split sizes={[editorFraction, 1 - editorFraction]} onSetSizes={this.setSizes}>
-                    {this.getCodeEditor()}
+                    {this.state.showDiff ? this.getDiffEditor() : this.getCodeEditor()}
                     {isPreviewerAvailable && showPreview && this.getPreviewer()}
                 </Split>
                 {this.getEditorControls()}
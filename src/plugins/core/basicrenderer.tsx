@@ -113,6 +113,15 @@ class SimpleBlobRendererModel {
             this.reloadPtyData();
         } else if (source == "file") {
             this.reloadFileData();
+        } else if (source == "ranged") {
+            // the renderer reads the file itself (a range at a time)
+            this.readOnly = true;
+            this.dataBlob = new Blob() as T.ExtBlob;
+            this.dataBlob.notFound = false;
+            mobx.action(() => {
+                this.loading.set(false);
+                this.loadError.set(null);
+            })();
         } else {
             mobx.action(() => {
                 this.loadError.set("error: invalid load source: " + source);
@@ -0,0 +1,337 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+import * as React from "react";
+import * as mobx from "mobx";
+import * as mobxReact from "mobx-react";
+import { sprintf } from "sprintf-js";
+import { boundMethod } from "autobind-decorator";
+import * as T from "../../types/types";
+import { GlobalModel } from "../../model/model";
+
+import "./du.less";
+
+type OV<V> = mobx.IObservableValue<V>;
+
+const PollInterval = 500;
+const MaxRows = 200;
+
+function formatSize(size: number): string {
+    if (size < 1024) {
+        return String(size);
+    }
+    let units = ["K", "M", "G", "T"];
+    let val = size;
+    let unitIdx = -1;
+    while (val >= 1024 && unitIdx < units.length - 1) {
+        val /= 1024;
+        unitIdx++;
+    }
+    return sprintf("%.1f%s", val, units[unitIdx]);
+}
+
+function parentPath(path: string): string {
+    let idx = path.lastIndexOf("/");
+    return idx == -1 ? "" : path.substring(0, idx);
+}
+
+function baseName(path: string): string {
+    return path.substring(path.lastIndexOf("/") + 1);
+}
+
+type DuRow = {
+    name: string;
+    size: number;
+    dirPath?: string; // set for directories
+    numFiles?: number;
+};
+
+@mobxReact.observer
+class DiskUsageRenderer extends React.Component<
+    { data: T.ExtBlob; context: T.RendererContext; opts: T.RendererOpts; lineState: T.LineStateType },
+    {}
+> {
+    // entries arrive children-first, so a dir is only in the map once its size is final
+    entryMap: Map<string, T.DiskUsageEntryType> = new Map();
+    childMap: Map<string, T.DiskUsageEntryType[]> = new Map();
+    numEntries: number = 0;
+    startTs: number = 0;
+    pollTimeoutId: any = null;
+    unmounted: boolean = false;
+    version: OV<number> = mobx.observable.box(0, { name: "du-version" });
+    scanState: OV<T.DiskUsageSnapshotType> = mobx.observable.box(null, { name: "du-scanState", deep: false });
+    loadError: OV<string> = mobx.observable.box(null, { name: "du-loadError" });
+    curDir: OV<string> = mobx.observable.box("", { name: "du-curDir" });
+    oneFs: OV<boolean> = mobx.observable.box(true, { name: "du-oneFs" });
+
+    componentDidMount() {
+        this.poll(false);
+    }
+
+    componentWillUnmount() {
+        this.unmounted = true;
+        if (this.pollTimeoutId != null) {
+            clearTimeout(this.pollTimeoutId);
+            this.pollTimeoutId = null;
+        }
+    }
+
+    getPath(): string {
+        return this.props.lineState["prompt:file"];
+    }
+
+    resetEntries(): void {
+        this.entryMap = new Map();
+        this.childMap = new Map();
+        this.numEntries = 0;
+    }
+
+    addEntries(entries: T.DiskUsageEntryType[]): void {
+        for (let entry of entries) {
+            this.entryMap.set(entry.path, entry);
+            this.numEntries++;
+            if (entry.path == "") {
+                continue;
+            }
+            let parent = parentPath(entry.path);
+            let children = this.childMap.get(parent);
+            if (children == null) {
+                children = [];
+                this.childMap.set(parent, children);
+            }
+            children.push(entry);
+        }
+    }
+
+    poll(restart: boolean): void {
+        if (this.pollTimeoutId != null) {
+            clearTimeout(this.pollTimeoutId);
+            this.pollTimeoutId = null;
+        }
+        let { screenId, lineId } = this.props.context;
+        let opts = { oneFs: this.oneFs.get(), restart: restart };
+        GlobalModel.getRemoteDiskUsage(screenId, lineId, this.getPath(), this.numEntries, opts)
+            .then((snapshot) => {
+                if (this.unmounted) {
+                    return;
+                }
+                if (snapshot.startts != this.startTs) {
+                    // a new scan was started, we need all of its entries
+                    let fromStart = this.numEntries == 0;
+                    this.resetEntries();
+                    this.startTs = snapshot.startts;
+                    if (!fromStart) {
+                        this.poll(false);
+                        return;
+                    }
+                }
+                this.addEntries(snapshot.entries ?? []);
+                mobx.action(() => {
+                    if (snapshot.info?.notfound) {
+                        this.loadError.set(sprintf("directory %s not found", JSON.stringify(this.getPath())));
+                        return;
+                    }
+                    this.scanState.set(snapshot);
+                    this.version.set(this.version.get() + 1);
+                })();
+                if (!snapshot.done) {
+                    this.pollTimeoutId = setTimeout(() => this.poll(false), PollInterval);
+                }
+            })
+            .catch((e) => {
+                mobx.action(() => {
+                    this.loadError.set("error getting disk usage: " + e);
+                })();
+            });
+    }
+
+    @boundMethod
+    clickCancel(): void {
+        let { screenId, lineId } = this.props.context;
+        GlobalModel.getRemoteDiskUsage(screenId, lineId, this.getPath(), this.numEntries, { cancel: true }).catch(
+            (e) => {
+                mobx.action(() => {
+                    this.loadError.set("error canceling scan: " + e);
+                })();
+            }
+        );
+    }
+
+    @boundMethod
+    clickRescan(): void {
+        mobx.action(() => {
+            this.loadError.set(null);
+        })();
+        this.poll(true);
+    }
+
+    @boundMethod
+    toggleOneFs(): void {
+        mobx.action(() => {
+            this.oneFs.set(!this.oneFs.get());
+            this.curDir.set("");
+        })();
+        this.poll(true);
+    }
+
+    setCurDir(dirPath: string): void {
+        mobx.action(() => {
+            this.curDir.set(dirPath);
+        })();
+    }
+
+    getRows(dirPath: string): DuRow[] {
+        let rows: DuRow[] = [];
+        for (let child of this.childMap.get(dirPath) ?? []) {
+            rows.push({
+                name: baseName(child.path) + "/",
+                size: child.size,
+                dirPath: child.path,
+                numFiles: child.numfiles,
+            });
+        }
+        let dirEntry = this.entryMap.get(dirPath);
+        if (dirEntry != null) {
+            let topFiles = dirEntry.topfiles ?? [];
+            let topSize = 0;
+            for (let file of topFiles) {
+                rows.push({ name: file.name, size: file.size });
+                topSize += file.size;
+            }
+            let numDirFiles = dirEntry.numfiles - rows.reduce((acc, row) => acc + (row.numFiles ?? 0), 0);
+            let numOther = numDirFiles - topFiles.length;
+            if (numOther > 0) {
+                rows.push({ name: sprintf("(%d other files)", numOther), size: dirEntry.filesize - topSize });
+            }
+        }
+        rows.sort((a, b) => b.size - a.size);
+        return rows;
+    }
+
+    renderBreadcrumbs(): any {
+        let curDir = this.curDir.get();
+        let parts = curDir == "" ? [] : curDir.split("/");
+        let elems = [
+            <span key="root" className="du-crumb" onClick={() => this.setCurDir("")}>
+                {this.scanState.get()?.path ?? this.getPath()}
+            </span>,
+        ];
+        for (let i = 0; i < parts.length; i++) {
+            let dirPath = parts.slice(0, i + 1).join("/");
+            elems.push(<span key={"sep-" + i}>/</span>);
+            elems.push(
+                <span key={dirPath} className="du-crumb" onClick={() => this.setCurDir(dirPath)}>
+                    {parts[i]}
+                </span>
+            );
+        }
+        return <div className="du-breadcrumbs">{elems}</div>;
+    }
+
+    renderStatus(scanState: T.DiskUsageSnapshotType): any {
+        let status = sprintf("%s in %d files", formatSize(scanState.size), scanState.numfiles);
+        if (!scanState.done) {
+            status = "scanning... " + status;
+        } else if (scanState.error) {
+            status = sprintf("scan stopped (%s), %s", scanState.error, status);
+        } else {
+            status = sprintf("%s (%.1fs)", status, (scanState.endts - scanState.startts) / 1000);
+        }
+        if (scanState.numerrors > 0) {
+            status += sprintf(", %d unreadable", scanState.numerrors);
+        }
+        if (scanState.truncated) {
+            status += ", too many directories (not all are shown)";
+        }
+        return (
+            <div className="du-controls">
+                <span className="du-status">{status}</span>
+                <If condition={!scanState.done}>
+                    <span className="du-button" title="cancel scan" onClick={this.clickCancel}>
+                        <i className="fa-sharp fa-solid fa-xmark" /> cancel
+                    </span>
+                </If>
+                <If condition={scanState.done}>
+                    <span className="du-button" title="scan again" onClick={this.clickRescan}>
+                        <i className="fa-sharp fa-solid fa-rotate-right" /> rescan
+                    </span>
+                </If>
+                <span className="du-button" title="do not cross filesystem boundaries" onClick={this.toggleOneFs}>
+                    <i
+                        className={
+                            this.oneFs.get() ? "fa-sharp fa-solid fa-square-check" : "fa-sharp fa-regular fa-square"
+                        }
+                    />{" "}
+                    one filesystem
+                </span>
+            </div>
+        );
+    }
+
+    render() {
+        let opts = this.props.opts;
+        if (this.loadError.get() != null) {
+            return (
+                <div className="du-renderer" style={{ fontSize: opts.termFontSize }}>
+                    <div className="load-error-text">ERROR: {this.loadError.get()}</div>
+                </div>
+            );
+        }
+        let scanState = this.scanState.get();
+        if (scanState == null) {
+            return (
+                <div className="du-renderer" style={{ fontSize: opts.termFontSize }}>
+                    loading content <i className="fa fa-ellipsis fa-fade" />
+                </div>
+            );
+        }
+        this.version.get(); // re-render when entries are added
+        let curDir = this.curDir.get();
+        let rows = this.getRows(curDir);
+        let dirSize = this.entryMap.get(curDir)?.size ?? rows.reduce((acc, row) => acc + row.size, 0);
+        let numHidden = Math.max(0, rows.length - MaxRows);
+        return (
+            <div className="du-renderer" style={{ fontSize: opts.termFontSize }}>
+                {this.renderStatus(scanState)}
+                {this.renderBreadcrumbs()}
+                <div className="du-rows">
+                    <table>
+                        <tbody>
+                            <If condition={curDir != ""}>
+                                <tr className="dir-row" onClick={() => this.setCurDir(parentPath(curDir))}>
+                                    <td className="size" />
+                                    <td className="bar" />
+                                    <td className="name">../</td>
+                                </tr>
+                            </If>
+                            {rows.slice(0, MaxRows).map((row) => (
+                                <tr
+                                    key={row.name}
+                                    className={row.dirPath != null ? "dir-row" : "file-row"}
+                                    onClick={row.dirPath != null ? () => this.setCurDir(row.dirPath) : null}
+                                >
+                                    <td className="size">{formatSize(row.size)}</td>
+                                    <td className="bar">
+                                        <div
+                                            className="du-bar"
+                                            style={{ width: (dirSize > 0 ? (100 * row.size) / dirSize : 0) + "%" }}
+                                        />
+                                    </td>
+                                    <td className="name">{row.name}</td>
+                                </tr>
+                            ))}
+                        </tbody>
+                    </table>
+                    <If condition={rows.length == 0}>
+                        <div className="du-status">{scanState.done ? "(empty directory)" : "(scanning)"}</div>
+                    </If>
+                    <If condition={numHidden > 0}>
+                        <div className="du-status">{sprintf("(%d smaller entries not shown)", numHidden)}</div>
+                    </If>
+                </div>
+            </div>
+        );
+    }
+}
+
+export { DiskUsageRenderer };
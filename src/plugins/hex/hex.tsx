@@ -0,0 +1,346 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+import * as React from "react";
+import * as mobx from "mobx";
+import * as mobxReact from "mobx-react";
+import { sprintf } from "sprintf-js";
+import { boundMethod } from "autobind-decorator";
+import * as T from "../../types/types";
+import { GlobalModel } from "../../model/model";
+
+import "./hex.less";
+
+type OV<V> = mobx.IObservableValue<V>;
+
+const BytesPerRow = 16;
+const PageRows = 32;
+const PageSize = BytesPerRow * PageRows;
+
+function parseOffset(str: string): number {
+    str = str.trim();
+    let rtn = str.toLowerCase().startsWith("0x") ? parseInt(str.substring(2), 16) : parseInt(str, 10);
+    if (isNaN(rtn) || rtn < 0) {
+        return null;
+    }
+    return rtn;
+}
+
+// returns a hex string for the search pattern (or null if it is invalid)
+function makeSearchPattern(str: string, mode: string): string {
+    if (mode == "text") {
+        if (str.length == 0) {
+            return null;
+        }
+        let bytes = new TextEncoder().encode(str);
+        return Array.from(bytes, (b) => sprintf("%02x", b)).join("");
+    }
+    let hexStr = str.replace(/0x/gi, "").replace(/[\s,]/g, "");
+    if (hexStr.length == 0 || hexStr.length % 2 != 0 || !/^[0-9a-fA-F]+$/.test(hexStr)) {
+        return null;
+    }
+    return hexStr.toLowerCase();
+}
+
+function isPrintable(b: number): boolean {
+    return b >= 0x20 && b < 0x7f;
+}
+
+@mobxReact.observer
+class HexViewRenderer extends React.Component<
+    { data: T.ExtBlob; context: T.RendererContext; opts: T.RendererOpts; lineState: T.LineStateType },
+    {}
+> {
+    offset: OV<number> = mobx.observable.box(0, { name: "hex-offset" });
+    pageData: OV<Uint8Array> = mobx.observable.box(null, { name: "hex-pageData", deep: false });
+    fileInfo: OV<T.FileInfoType> = mobx.observable.box(null, { name: "hex-fileInfo" });
+    loadError: OV<string> = mobx.observable.box(null, { name: "hex-loadError" });
+    gotoText: OV<string> = mobx.observable.box("", { name: "hex-gotoText" });
+    searchText: OV<string> = mobx.observable.box("", { name: "hex-searchText" });
+    searchMode: OV<string> = mobx.observable.box("hex", { name: "hex-searchMode" });
+    searchStatus: OV<string> = mobx.observable.box(null, { name: "hex-searchStatus" });
+    matchOffset: OV<number> = mobx.observable.box(null, { name: "hex-matchOffset" });
+    matchLen: OV<number> = mobx.observable.box(0, { name: "hex-matchLen" });
+
+    componentDidMount() {
+        this.loadPage(0);
+    }
+
+    getPath(): string {
+        return this.props.lineState["prompt:file"];
+    }
+
+    getFileSize(): number {
+        return this.fileInfo.get()?.size ?? 0;
+    }
+
+    loadPage(offset: number): Promise<void> {
+        let { screenId, lineId } = this.props.context;
+        offset = Math.max(0, offset - (offset % BytesPerRow));
+        return GlobalModel.readRemoteFileRange(screenId, lineId, this.getPath(), offset, offset + PageSize)
+            .then((rtn) => {
+                mobx.action(() => {
+                    this.fileInfo.set(rtn.fileInfo);
+                    if (rtn.fileInfo?.notfound) {
+                        this.loadError.set(sprintf("file %s not found", JSON.stringify(this.getPath())));
+                        return;
+                    }
+                    this.offset.set(offset);
+                    this.pageData.set(rtn.data);
+                    this.loadError.set(null);
+                })();
+            })
+            .catch((e) => {
+                mobx.action(() => {
+                    this.loadError.set("error loading file data: " + e);
+                })();
+            });
+    }
+
+    @boundMethod
+    clickFirst(): void {
+        this.loadPage(0);
+    }
+
+    @boundMethod
+    clickPrev(): void {
+        this.loadPage(Math.max(0, this.offset.get() - PageSize));
+    }
+
+    @boundMethod
+    clickNext(): void {
+        let nextOffset = this.offset.get() + PageSize;
+        if (nextOffset < this.getFileSize()) {
+            this.loadPage(nextOffset);
+        }
+    }
+
+    @boundMethod
+    clickLast(): void {
+        let size = this.getFileSize();
+        let lastRow = size > 0 ? size - 1 - ((size - 1) % BytesPerRow) : 0;
+        this.loadPage(Math.max(0, lastRow - (PageRows - 1) * BytesPerRow));
+    }
+
+    @boundMethod
+    doGoto(): void {
+        let offset = parseOffset(this.gotoText.get());
+        if (offset == null || offset >= this.getFileSize()) {
+            mobx.action(() => {
+                this.searchStatus.set("invalid offset");
+            })();
+            return;
+        }
+        mobx.action(() => {
+            this.searchStatus.set(null);
+            this.matchOffset.set(offset);
+            this.matchLen.set(1);
+        })();
+        this.loadPage(offset);
+    }
+
+    @boundMethod
+    doSearch(): void {
+        let pattern = makeSearchPattern(this.searchText.get(), this.searchMode.get());
+        if (pattern == null) {
+            mobx.action(() => {
+                this.searchStatus.set(this.searchMode.get() == "hex" ? "invalid hex pattern" : "empty pattern");
+            })();
+            return;
+        }
+        let startOffset = this.matchOffset.get() != null ? this.matchOffset.get() + 1 : this.offset.get();
+        mobx.action(() => {
+            this.searchStatus.set("searching...");
+        })();
+        this.runSearch(pattern, startOffset, true);
+    }
+
+    runSearch(pattern: string, startOffset: number, canWrap: boolean): void {
+        let { screenId, lineId } = this.props.context;
+        GlobalModel.searchRemoteFile(screenId, lineId, this.getPath(), pattern, startOffset, 1)
+            .then((rtn) => {
+                if (rtn.offsets.length == 0) {
+                    if (canWrap && startOffset > 0) {
+                        this.runSearch(pattern, 0, false);
+                        return;
+                    }
+                    mobx.action(() => {
+                        this.searchStatus.set("not found");
+                    })();
+                    return;
+                }
+                let matchOffset = rtn.offsets[0];
+                mobx.action(() => {
+                    this.matchOffset.set(matchOffset);
+                    this.matchLen.set(pattern.length / 2);
+                    this.searchStatus.set(
+                        sprintf("found at 0x%x%s", matchOffset, canWrap ? "" : " (wrapped)")
+                    );
+                })();
+                let pageStart = this.offset.get();
+                if (matchOffset < pageStart || matchOffset >= pageStart + PageSize) {
+                    this.loadPage(matchOffset);
+                }
+            })
+            .catch((e) => {
+                mobx.action(() => {
+                    this.searchStatus.set("search error: " + e);
+                })();
+            });
+    }
+
+    @boundMethod
+    handleGotoKeyDown(e: any): void {
+        if (e.code == "Enter") {
+            e.preventDefault();
+            this.doGoto();
+        }
+    }
+
+    @boundMethod
+    handleSearchKeyDown(e: any): void {
+        if (e.code == "Enter") {
+            e.preventDefault();
+            this.doSearch();
+        }
+    }
+
+    @boundMethod
+    handleGotoChange(e: any): void {
+        mobx.action(() => {
+            this.gotoText.set(e.target.value);
+        })();
+    }
+
+    @boundMethod
+    handleSearchChange(e: any): void {
+        mobx.action(() => {
+            this.searchText.set(e.target.value);
+            this.matchOffset.set(null);
+        })();
+    }
+
+    @boundMethod
+    handleModeChange(e: any): void {
+        mobx.action(() => {
+            this.searchMode.set(e.target.value);
+            this.matchOffset.set(null);
+        })();
+    }
+
+    isMatch(pos: number): boolean {
+        let matchOffset = this.matchOffset.get();
+        return matchOffset != null && pos >= matchOffset && pos < matchOffset + this.matchLen.get();
+    }
+
+    renderRow(rowOffset: number, rowData: Uint8Array): any {
+        let hexElems = [];
+        let asciiElems = [];
+        for (let i = 0; i < BytesPerRow; i++) {
+            let sep = i == BytesPerRow / 2 ? "  " : " ";
+            if (i >= rowData.length) {
+                hexElems.push(<span key={i}>{sep + "  "}</span>);
+                continue;
+            }
+            let b = rowData[i];
+            let className = this.isMatch(rowOffset + i) ? "hex-match" : null;
+            hexElems.push(
+                <span key={i}>
+                    {sep}
+                    <span className={className}>{sprintf("%02x", b)}</span>
+                </span>
+            );
+            asciiElems.push(
+                <span key={i} className={className}>
+                    {isPrintable(b) ? String.fromCharCode(b) : "."}
+                </span>
+            );
+        }
+        return (
+            <div key={rowOffset} className="hex-row">
+                <span className="hex-offset">{sprintf("%08x", rowOffset)}</span>
+                <span className="hex-bytes">{hexElems}</span>
+                <span className="hex-ascii">|{asciiElems}|</span>
+            </div>
+        );
+    }
+
+    render() {
+        let opts = this.props.opts;
+        if (this.loadError.get() != null) {
+            return (
+                <div className="hex-renderer" style={{ fontSize: opts.termFontSize }}>
+                    <div className="load-error-text">ERROR: {this.loadError.get()}</div>
+                </div>
+            );
+        }
+        let pageData = this.pageData.get();
+        if (pageData == null) {
+            return (
+                <div className="hex-renderer" style={{ fontSize: opts.termFontSize }}>
+                    loading content <i className="fa fa-ellipsis fa-fade" />
+                </div>
+            );
+        }
+        let offset = this.offset.get();
+        let size = this.getFileSize();
+        let rows = [];
+        for (let pos = 0; pos < pageData.length; pos += BytesPerRow) {
+            rows.push(this.renderRow(offset + pos, pageData.subarray(pos, pos + BytesPerRow)));
+        }
+        let pageEnd = offset + pageData.length;
+        return (
+            <div className="hex-renderer" style={{ fontSize: opts.termFontSize }}>
+                <div className="hex-controls">
+                    <span className="hex-button" title="first page" onClick={this.clickFirst}>
+                        <i className="fa-sharp fa-solid fa-backward-step" />
+                    </span>
+                    <span className="hex-button" title="previous page" onClick={this.clickPrev}>
+                        <i className="fa-sharp fa-solid fa-caret-left" />
+                    </span>
+                    <span className="hex-button" title="next page" onClick={this.clickNext}>
+                        <i className="fa-sharp fa-solid fa-caret-right" />
+                    </span>
+                    <span className="hex-button" title="last page" onClick={this.clickLast}>
+                        <i className="fa-sharp fa-solid fa-forward-step" />
+                    </span>
+                    <span className="hex-status">
+                        {sprintf("0x%x-0x%x of 0x%x (%d bytes)", offset, Math.max(offset, pageEnd - 1), size, size)}
+                    </span>
+                    <input
+                        type="text"
+                        placeholder="goto offset"
+                        value={this.gotoText.get()}
+                        onChange={this.handleGotoChange}
+                        onKeyDown={this.handleGotoKeyDown}
+                    />
+                    <select value={this.searchMode.get()} onChange={this.handleModeChange}>
+                        <option value="hex">hex</option>
+                        <option value="text">text</option>
+                    </select>
+                    <input
+                        type="text"
+                        placeholder="find"
+                        value={this.searchText.get()}
+                        onChange={this.handleSearchChange}
+                        onKeyDown={this.handleSearchKeyDown}
+                    />
+                    <span className="hex-button" title="find next" onClick={this.doSearch}>
+                        <i className="fa-sharp fa-solid fa-magnifying-glass" />
+                    </span>
+                    <If condition={this.searchStatus.get() != null}>
+                        <span className="hex-status">{this.searchStatus.get()}</span>
+                    </If>
+                </div>
+                <div className="hex-rows">
+                    <If condition={rows.length == 0}>
+                        <div className="hex-status">(empty file)</div>
+                    </If>
+                    {rows}
+                </div>
+            </div>
+        );
+    }
+}
+
+export { HexViewRenderer };
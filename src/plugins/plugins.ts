@@ -7,6 +7,9 @@ import { SimpleMarkdownRenderer } from "./markdown/markdown";
 import { SourceCodeRenderer } from "./code/code";
 import { SimpleMustacheRenderer } from "./mustache/mustache";
 import { CSVRenderer } from "./csv/csv";
+import { HexViewRenderer } from "./hex/hex";
+import { ArchiveRenderer } from "./archive/archive";
+import { DiskUsageRenderer } from "./du/du";
 import { OpenAIRenderer, OpenAIRendererModel } from "./openai/openai";
 import { isBlank } from "../util/util";
 import { sprintf } from "sprintf-js";
@@ -79,6 +82,36 @@ const PluginConfigs: RendererPluginType[] = [
         mimeTypes: ["image/*"],
         simpleComponent: SimpleImageRenderer,
     },
+    {
+        name: "hex",
+        rendererType: "simple",
+        heightType: "pixels",
+        dataType: "blob",
+        collapseType: "hide",
+        globalCss: null,
+        mimeTypes: ["application/octet-stream"],
+        simpleComponent: HexViewRenderer,
+    },
+    {
+        name: "archive",
+        rendererType: "simple",
+        heightType: "pixels",
+        dataType: "blob",
+        collapseType: "hide",
+        globalCss: null,
+        mimeTypes: ["application/zip", "application/x-tar", "application/gzip"],
+        simpleComponent: ArchiveRenderer,
+    },
+    {
+        name: "du",
+        rendererType: "simple",
+        heightType: "pixels",
+        dataType: "blob",
+        collapseType: "hide",
+        globalCss: null,
+        mimeTypes: [],
+        simpleComponent: DiskUsageRenderer,
+    },
 ];
 
 class PluginModelClass {
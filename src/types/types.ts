@@ -265,6 +265,17 @@ type ModelUpdateType = {
     clientdata?: ClientDataType;
     historyviewdata?: HistoryViewDataType;
     remoteview?: RemoteViewType;
+    filechanged?: FileChangedType;
+    bookmarkprompt?: BookmarkPromptType;
+};
+
+type FileChangedType = {
+    screenid: string;
+    lineid: string;
+    path: string;
+    modts?: number;
+    size?: number;
+    deleted?: boolean;
 };
 
 type HistoryViewDataType = {
@@ -286,9 +297,23 @@ type BookmarkType = {
     description: string;
     cmds: string[];
     orderidx: number;
+    paramvalues?: { [key: string]: string };
+    library?: string;
     remove?: boolean;
 };
 
+type BookmarkParamType = {
+    name: string;
+    default?: string;
+    lastvalue?: string;
+};
+
+type BookmarkPromptType = {
+    bookmarkid: string;
+    cmdstr: string;
+    params: BookmarkParamType[];
+};
+
 type HistoryInfoType = {
     historytype: HistoryTypeStrs;
     sessionid: string;
@@ -633,13 +658,37 @@ type FileInfoType = {
     notfound: boolean;
 };
 
+type DiskUsageEntryType = {
+    path: string;
+    size: number;
+    filesize: number;
+    numfiles: number;
+    topfiles?: { name: string; size: number }[];
+};
+
+type DiskUsageSnapshotType = {
+    path: string;
+    info?: FileInfoType;
+    entries: DiskUsageEntryType[];
+    numfiles: number;
+    size: number;
+    numerrors?: number;
+    truncated?: boolean;
+    done: boolean;
+    error?: string;
+    startts: number;
+    endts?: number;
+};
+
 type ExtBlob = Blob & {
     notFound: boolean;
     name?: string;
+    fileInfo?: FileInfoType;
 };
 
 type ExtFile = File & {
     notFound: boolean;
+    fileInfo?: FileInfoType;
 };
 
 export type {
@@ -676,6 +725,8 @@ export type {
     RendererModel,
     PtyDataType,
     BookmarkType,
+    BookmarkParamType,
+    BookmarkPromptType,
     ClientDataType,
     PlaybookType,
     PlaybookEntryType,
@@ -708,9 +759,12 @@ export type {
     LineInterface,
     RendererContainerType,
     RemoteViewType,
+    FileChangedType,
     CommandRtnType,
     OpenAIPacketType,
     FileInfoType,
+    DiskUsageEntryType,
+    DiskUsageSnapshotType,
     ExtBlob,
     ExtFile,
 };
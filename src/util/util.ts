@@ -52,7 +52,12 @@ function fetchJsonData(resp: any, ctErr: boolean): Promise<any> {
                 throw rtnErr;
             }
             if (rtnData != null && rtnData.error) {
-                throw new Error(rtnData.error);
+                let rtnErr: any = new Error(rtnData.error);
+                if (rtnData.errorcode) {
+                    rtnErr.errorcode = rtnData.errorcode;
+                    rtnErr.data = rtnData.data;
+                }
+                throw rtnErr;
             }
             return rtnData;
         });
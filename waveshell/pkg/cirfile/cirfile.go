@@ -80,7 +80,6 @@ func (f *File) flock(ctx context.Context, lockType int) error {
 			return err
 		}
 	}
-	return fmt.Errorf("could not acquire lock")
 }
 
 func (f *File) unflock() {
@@ -453,6 +452,51 @@ func (f *File) WriteAt(ctx context.Context, buf []byte, writePos int64) error {
 	if err != nil {
 		return err
 	}
+	return f.internalWriteAt(buf, writePos)
+}
+
+// like WriteAt, but before writing, calls overflowFn with the data (and its offset) that the write
+// will push out of the front of the buffer.  overflowFn is called while holding the file lock.
+// if overflowFn returns an error, nothing is written.
+func (f *File) WriteAtWithOverflow(ctx context.Context, buf []byte, writePos int64, overflowFn func(offset int64, data []byte) error) error {
+	if writePos < 0 {
+		return fmt.Errorf("WriteAtWithOverflow got invalid writePos[%d]", writePos)
+	}
+	err := f.flock(ctx, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.unflock()
+	err = f.readMeta()
+	if err != nil {
+		return err
+	}
+	currentSize := totalChunksSize(f.getFileChunks())
+	newEnd := f.FileOffset + currentSize
+	if writePos+int64(len(buf)) > newEnd {
+		newEnd = writePos + int64(len(buf))
+	}
+	dropLen := newEnd - f.MaxSize - f.FileOffset
+	if dropLen > currentSize {
+		dropLen = currentSize
+	}
+	if dropLen > 0 {
+		dropBuf := make([]byte, dropLen)
+		realOffset, nr, err := f.internalReadNext(dropBuf, f.FileOffset)
+		if err != nil {
+			return err
+		}
+		err = overflowFn(realOffset, dropBuf[0:nr])
+		if err != nil {
+			return err
+		}
+	}
+	return f.internalWriteAt(buf, writePos)
+}
+
+// must hold LOCK_EX, and have read metadata
+func (f *File) internalWriteAt(buf []byte, writePos int64) error {
+	var err error
 	chunks := f.getFileChunks()
 	currentSize := totalChunksSize(chunks)
 	if writePos < f.FileOffset {
@@ -189,7 +189,8 @@ func TestFlock(t *testing.T) {
 	if err != syscall.EWOULDBLOCK {
 		t.Fatalf("append should fail with EWOULDBLOCK")
 	}
-	timeoutCtx, _ := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	timeoutCtx, cancelFn := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
 	startTs := time.Now()
 	err = f.ReadMeta(timeoutCtx)
 	if err != context.DeadlineExceeded {
@@ -283,3 +284,50 @@ func TestWriteAt(t *testing.T) {
 	}
 	dumpFile(f1Name)
 }
+
+func TestWriteAtWithOverflow(t *testing.T) {
+	tempDir := t.TempDir()
+	f1Name := path.Join(tempDir, "f1.cf")
+	f, err := CreateCirFile(f1Name, 20)
+	if err != nil {
+		t.Fatalf("cannot create cirfile: %v", err)
+	}
+	var overflow []byte
+	var nextOffset int64
+	overflowFn := func(offset int64, data []byte) error {
+		if offset != nextOffset {
+			t.Fatalf("overflow offset mismatch expected[%d] got[%d]", nextOffset, offset)
+		}
+		overflow = append(overflow, data...)
+		nextOffset = offset + int64(len(data))
+		return nil
+	}
+	dataStr := makeData(95)
+	for pos := 0; pos < len(dataStr); pos += 7 {
+		endPos := pos + 7
+		if endPos > len(dataStr) {
+			endPos = len(dataStr)
+		}
+		err = f.WriteAtWithOverflow(nil, []byte(dataStr[pos:endPos]), int64(pos), overflowFn)
+		if err != nil {
+			t.Fatalf("writeatwithoverflow error: %v", err)
+		}
+	}
+	realOffset, data, err := f.ReadAll(context.Background())
+	if err != nil {
+		t.Fatalf("readall error: %v", err)
+	}
+	if realOffset != int64(len(overflow)) || string(overflow)+string(data) != dataStr {
+		t.Fatalf("overflow+data does not match written data: realoffset[%d] overflow[%q] data[%q]", realOffset, string(overflow), string(data))
+	}
+	err = f.WriteAtWithOverflow(nil, []byte("x"), 200, func(offset int64, data []byte) error {
+		return fmt.Errorf("overflow error")
+	})
+	if err == nil {
+		t.Fatalf("writeatwithoverflow should return overflowFn error")
+	}
+	_, data2, _ := f.ReadAll(context.Background())
+	if string(data2) != string(data) {
+		t.Fatalf("data should not change when overflowFn fails")
+	}
+}
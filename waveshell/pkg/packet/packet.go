@@ -26,6 +26,12 @@ import (
 //
 // >streamfile, <streamfileresp, <filedata*
 // >writefile, <writefileready, >filedata*, <writefiledone
+// >watchfile, <resp, <filechanged*
+// >filelist, <filelistresp*
+// >fileblockhash, <fileblockhashresp
+// >fileop, <resp
+// >writefile(ackdata), <writefileready, >filedata*, <filedataack*, <writefiledone
+// >diskusage, <diskusageresp*, (>rpccancel)
 
 const MaxCompGenValues = 100
 
@@ -59,6 +65,20 @@ const (
 	WriteFileReadyPacketStr = "writefileready" // rpc-response
 	WriteFileDonePacketStr  = "writefiledone"  // rpc-response
 	FileDataPacketStr       = "filedata"
+	WatchFilePacketStr      = "watchfile"         // rpc
+	FileChangedPacketStr    = "filechanged"       // pushed (not an rpc-response), sent for watched files
+	FileDataAckPacketStr    = "filedataack"       // rpc-response (writefile with ackdata), sent to mshell for streamfile with ackdata
+	FileListPacketStr       = "filelist"          // rpc
+	FileListResponseStr     = "filelistresp"      // rpc-response
+	FileBlockHashPacketStr  = "fileblockhash"     // rpc
+	FileBlockHashRespStr    = "fileblockhashresp" // rpc-response
+	FileOpPacketStr         = "fileop"            // rpc
+	FileSearchPacketStr     = "filesearch"        // rpc
+	FileSearchRespStr       = "filesearchresp"    // rpc-response
+	ArchiveListPacketStr    = "archivelist"       // rpc (responds with filelistresp)
+	DiskUsagePacketStr      = "diskusage"         // rpc
+	DiskUsageRespStr        = "diskusageresp"     // rpc-response
+	RpcCancelPacketStr      = "rpccancel"         // cancels a running (cancelable) rpc
 
 	OpenAIPacketStr = "openai" // other
 )
@@ -97,6 +117,20 @@ func init() {
 	TypeStrToFactory[WriteFilePacketStr] = reflect.TypeOf(WriteFilePacketType{})
 	TypeStrToFactory[WriteFileReadyPacketStr] = reflect.TypeOf(WriteFileReadyPacketType{})
 	TypeStrToFactory[WriteFileDonePacketStr] = reflect.TypeOf(WriteFileDonePacketType{})
+	TypeStrToFactory[WatchFilePacketStr] = reflect.TypeOf(WatchFilePacketType{})
+	TypeStrToFactory[FileChangedPacketStr] = reflect.TypeOf(FileChangedPacketType{})
+	TypeStrToFactory[FileDataAckPacketStr] = reflect.TypeOf(FileDataAckPacketType{})
+	TypeStrToFactory[FileListPacketStr] = reflect.TypeOf(FileListPacketType{})
+	TypeStrToFactory[FileListResponseStr] = reflect.TypeOf(FileListResponseType{})
+	TypeStrToFactory[FileBlockHashPacketStr] = reflect.TypeOf(FileBlockHashPacketType{})
+	TypeStrToFactory[FileBlockHashRespStr] = reflect.TypeOf(FileBlockHashResponseType{})
+	TypeStrToFactory[FileSearchPacketStr] = reflect.TypeOf(FileSearchPacketType{})
+	TypeStrToFactory[FileSearchRespStr] = reflect.TypeOf(FileSearchResponseType{})
+	TypeStrToFactory[ArchiveListPacketStr] = reflect.TypeOf(ArchiveListPacketType{})
+	TypeStrToFactory[FileOpPacketStr] = reflect.TypeOf(FileOpPacketType{})
+	TypeStrToFactory[DiskUsagePacketStr] = reflect.TypeOf(DiskUsagePacketType{})
+	TypeStrToFactory[DiskUsageRespStr] = reflect.TypeOf(DiskUsageResponseType{})
+	TypeStrToFactory[RpcCancelPacketStr] = reflect.TypeOf(RpcCancelPacketType{})
 
 	var _ RpcPacketType = (*RunPacketType)(nil)
 	var _ RpcPacketType = (*GetCmdPacketType)(nil)
@@ -106,6 +140,13 @@ func init() {
 	var _ RpcPacketType = (*ReInitPacketType)(nil)
 	var _ RpcPacketType = (*StreamFilePacketType)(nil)
 	var _ RpcPacketType = (*WriteFilePacketType)(nil)
+	var _ RpcPacketType = (*WatchFilePacketType)(nil)
+	var _ RpcPacketType = (*FileListPacketType)(nil)
+	var _ RpcPacketType = (*FileBlockHashPacketType)(nil)
+	var _ RpcPacketType = (*FileOpPacketType)(nil)
+	var _ RpcPacketType = (*FileSearchPacketType)(nil)
+	var _ RpcPacketType = (*ArchiveListPacketType)(nil)
+	var _ RpcPacketType = (*DiskUsagePacketType)(nil)
 
 	var _ RpcResponsePacketType = (*CmdStartPacketType)(nil)
 	var _ RpcResponsePacketType = (*ResponsePacketType)(nil)
@@ -114,6 +155,11 @@ func init() {
 	var _ RpcResponsePacketType = (*FileDataPacketType)(nil)
 	var _ RpcResponsePacketType = (*WriteFileReadyPacketType)(nil)
 	var _ RpcResponsePacketType = (*WriteFileDonePacketType)(nil)
+	var _ RpcResponsePacketType = (*FileDataAckPacketType)(nil)
+	var _ RpcResponsePacketType = (*FileListResponseType)(nil)
+	var _ RpcResponsePacketType = (*FileBlockHashResponseType)(nil)
+	var _ RpcResponsePacketType = (*FileSearchResponseType)(nil)
+	var _ RpcResponsePacketType = (*DiskUsageResponseType)(nil)
 
 	var _ CommandPacketType = (*DataPacketType)(nil)
 	var _ CommandPacketType = (*DataAckPacketType)(nil)
@@ -177,10 +223,12 @@ func MakePingPacket() *PingPacketType {
 	return &PingPacketType{Type: PingPacketStr}
 }
 
+// Offset is only used for writefile in patch mode
 type FileDataPacketType struct {
 	Type   string `json:"type"`
 	RespId string `json:"respid"`
 	Data   []byte `json:"data"`
+	Offset int64  `json:"offset,omitempty"`
 	Eof    bool   `json:"eof,omitempty"`
 	Error  string `json:"error,omitempty"`
 }
@@ -393,12 +441,21 @@ func MakeReInitPacket() *ReInitPacketType {
 	return &ReInitPacketType{Type: ReInitPacketStr}
 }
 
+// when AckData is set, the client acks each filedata packet (filedataack, RespId=ReqId) as it is
+// consumed, and mshell stops sending when StreamFileAckWindow packets are un-acked (a slow reader can't
+// overflow the response queue).  the stream is cancelable with rpccancel.
+const StreamFileAckWindow = 64
+
 type StreamFilePacketType struct {
 	Type      string  `json:"type"`
 	ReqId     string  `json:"reqid"`
 	Path      string  `json:"path"`
 	ByteRange []int64 `json:"byterange"`          // works like the http "Range" header (multiple ranges are not allowed)
 	StatOnly  bool    `json:"statonly,omitempty"` // set if you just want the stat response (no data returned)
+	AckData   bool    `json:"ackdata,omitempty"`  // flow control, see StreamFileAckWindow
+
+	// if set, Path is an archive (tar/zip) and we stream this entry from it
+	ArchiveEntry string `json:"archiveentry,omitempty"`
 }
 
 func (*StreamFilePacketType) GetType() string {
@@ -422,6 +479,297 @@ type FileInfo struct {
 	NotFound bool   `json:"notfound,omitempty"` // when NotFound is set, Perm will be set to permission for directory
 }
 
+type FileDataAckPacketType struct {
+	Type   string `json:"type"`
+	RespId string `json:"respid"`
+	AckLen int    `json:"acklen"`
+}
+
+func (*FileDataAckPacketType) GetType() string {
+	return FileDataAckPacketStr
+}
+
+func (p *FileDataAckPacketType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *FileDataAckPacketType) GetResponseDone() bool {
+	return false
+}
+
+func MakeFileDataAckPacket(respId string, ackLen int) *FileDataAckPacketType {
+	return &FileDataAckPacketType{Type: FileDataAckPacketStr, RespId: respId, AckLen: ackLen}
+}
+
+// lists Path (recursively if Recursive is set).  entries are returned in batches (multiple
+// filelistresp packets), the final one has Done set.  entry names are relative to Path (using "/").
+type FileListPacketType struct {
+	Type      string `json:"type"`
+	ReqId     string `json:"reqid"`
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+func (*FileListPacketType) GetType() string {
+	return FileListPacketStr
+}
+
+func (p *FileListPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeFileListPacket() *FileListPacketType {
+	return &FileListPacketType{Type: FileListPacketStr}
+}
+
+// lists the entries in a tar or zip archive.  responds with FileListResponseType packets
+// (Info is the archive file, entry names are the full paths within the archive).
+type ArchiveListPacketType struct {
+	Type  string `json:"type"`
+	ReqId string `json:"reqid"`
+	Path  string `json:"path"`
+}
+
+func (*ArchiveListPacketType) GetType() string {
+	return ArchiveListPacketStr
+}
+
+func (p *ArchiveListPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeArchiveListPacket() *ArchiveListPacketType {
+	return &ArchiveListPacketType{Type: ArchiveListPacketStr}
+}
+
+// Info is the info for the root path (only set in the first response)
+type FileListResponseType struct {
+	Type    string      `json:"type"`
+	RespId  string      `json:"respid"`
+	Info    *FileInfo   `json:"info,omitempty"`
+	Entries []*FileInfo `json:"entries,omitempty"`
+	Done    bool        `json:"done,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func (*FileListResponseType) GetType() string {
+	return FileListResponseStr
+}
+
+func (p *FileListResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *FileListResponseType) GetResponseDone() bool {
+	return p.Done || p.Error != ""
+}
+
+func MakeFileListResponse(respId string) *FileListResponseType {
+	return &FileListResponseType{Type: FileListResponseStr, RespId: respId}
+}
+
+type FileBlockHashPacketType struct {
+	Type      string `json:"type"`
+	ReqId     string `json:"reqid"`
+	Path      string `json:"path"`
+	BlockSize int64  `json:"blocksize"`
+	MaxBlocks int    `json:"maxblocks,omitempty"` // only hash the first maxblocks blocks (0 for the whole file)
+}
+
+func (*FileBlockHashPacketType) GetType() string {
+	return FileBlockHashPacketStr
+}
+
+func (p *FileBlockHashPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeFileBlockHashPacket() *FileBlockHashPacketType {
+	return &FileBlockHashPacketType{Type: FileBlockHashPacketStr}
+}
+
+// Hashes are hex encoded sha256 hashes of each BlockSize block of the file (the last block may be short)
+type FileBlockHashResponseType struct {
+	Type   string    `json:"type"`
+	RespId string    `json:"respid"`
+	Info   *FileInfo `json:"info,omitempty"`
+	Hashes []string  `json:"hashes,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (*FileBlockHashResponseType) GetType() string {
+	return FileBlockHashRespStr
+}
+
+func (p *FileBlockHashResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *FileBlockHashResponseType) GetResponseDone() bool {
+	return true
+}
+
+func MakeFileBlockHashResponse(respId string) *FileBlockHashResponseType {
+	return &FileBlockHashResponseType{Type: FileBlockHashRespStr, RespId: respId}
+}
+
+// searches for a byte pattern in the file starting at Offset.  returns up to MaxResults match offsets.
+type FileSearchPacketType struct {
+	Type       string `json:"type"`
+	ReqId      string `json:"reqid"`
+	Path       string `json:"path"`
+	Pattern    []byte `json:"pattern"`
+	Offset     int64  `json:"offset,omitempty"`
+	MaxResults int    `json:"maxresults,omitempty"`
+}
+
+func (*FileSearchPacketType) GetType() string {
+	return FileSearchPacketStr
+}
+
+func (p *FileSearchPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeFileSearchPacket() *FileSearchPacketType {
+	return &FileSearchPacketType{Type: FileSearchPacketStr}
+}
+
+// Truncated is set if MaxResults was hit (there may be more matches after the last offset)
+type FileSearchResponseType struct {
+	Type      string    `json:"type"`
+	RespId    string    `json:"respid"`
+	Info      *FileInfo `json:"info,omitempty"`
+	Offsets   []int64   `json:"offsets,omitempty"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (*FileSearchResponseType) GetType() string {
+	return FileSearchRespStr
+}
+
+func (p *FileSearchResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *FileSearchResponseType) GetResponseDone() bool {
+	return true
+}
+
+func MakeFileSearchResponse(respId string) *FileSearchResponseType {
+	return &FileSearchResponseType{Type: FileSearchRespStr, RespId: respId}
+}
+
+// computes per-directory disk usage (like du) under Path.  diskusageresp packets are streamed as
+// directories complete, the final one has Done set.  can be cancelled with rpccancel.
+type DiskUsagePacketType struct {
+	Type     string `json:"type"`
+	ReqId    string `json:"reqid"`
+	Path     string `json:"path"`
+	MaxDepth int    `json:"maxdepth,omitempty"` // deeper dirs are counted but not reported (0 is no limit)
+	OneFs    bool   `json:"onefs,omitempty"`    // do not cross filesystem boundaries (like du -x)
+}
+
+func (*DiskUsagePacketType) GetType() string {
+	return DiskUsagePacketStr
+}
+
+func (p *DiskUsagePacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeDiskUsagePacket() *DiskUsagePacketType {
+	return &DiskUsagePacketType{Type: DiskUsagePacketStr}
+}
+
+type DiskUsageFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// sizes are disk usage (allocated blocks), hard links are only counted once.
+// Path is relative to the root ("" for the root itself, using "/").
+type DiskUsageEntry struct {
+	Path     string           `json:"path"`
+	Size     int64            `json:"size"`
+	FileSize int64            `json:"filesize"` // files directly in this dir
+	NumFiles int64            `json:"numfiles"`
+	TopFiles []*DiskUsageFile `json:"topfiles,omitempty"` // largest files directly in this dir
+}
+
+// Entries are the directories completed since the last response (children before their parents).
+// the totals are running totals for the whole scan.  Info is only set in the first response.
+type DiskUsageResponseType struct {
+	Type      string            `json:"type"`
+	RespId    string            `json:"respid"`
+	Info      *FileInfo         `json:"info,omitempty"`
+	Entries   []*DiskUsageEntry `json:"entries,omitempty"`
+	NumFiles  int64             `json:"numfiles"`
+	Size      int64             `json:"size"`
+	NumErrors int               `json:"numerrors,omitempty"` // unreadable entries (skipped)
+	Truncated bool              `json:"truncated,omitempty"` // too many dirs, only the first were reported
+	Done      bool              `json:"done,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+func (*DiskUsageResponseType) GetType() string {
+	return DiskUsageRespStr
+}
+
+func (p *DiskUsageResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *DiskUsageResponseType) GetResponseDone() bool {
+	return p.Done || p.Error != ""
+}
+
+func MakeDiskUsageResponse(respId string) *DiskUsageResponseType {
+	return &DiskUsageResponseType{Type: DiskUsageRespStr, RespId: respId}
+}
+
+// not an rpc (no response is sent).  the cancelled rpc finishes with an error response.
+type RpcCancelPacketType struct {
+	Type     string `json:"type"`
+	CancelId string `json:"cancelid"`
+}
+
+func (*RpcCancelPacketType) GetType() string {
+	return RpcCancelPacketStr
+}
+
+func MakeRpcCancelPacket(cancelId string) *RpcCancelPacketType {
+	return &RpcCancelPacketType{Type: RpcCancelPacketStr, CancelId: cancelId}
+}
+
+const (
+	FileOpMkdir  = "mkdir"
+	FileOpRemove = "remove" // files or empty directories (not recursive)
+	FileOpRename = "rename" // Paths is [oldpath, newpath]
+	FileOpCopy   = "copy"   // Paths is [srcpath, dstpath], keeps the mode and modtime of srcpath
+)
+
+type FileOpPacketType struct {
+	Type  string   `json:"type"`
+	ReqId string   `json:"reqid"`
+	Op    string   `json:"op"`
+	Paths []string `json:"paths"`
+	Perm  int      `json:"perm,omitempty"`
+}
+
+func (*FileOpPacketType) GetType() string {
+	return FileOpPacketStr
+}
+
+func (p *FileOpPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeFileOpPacket() *FileOpPacketType {
+	return &FileOpPacketType{Type: FileOpPacketStr}
+}
+
 type StreamFileResponseType struct {
 	Type   string    `json:"type"`
 	RespId string    `json:"respid"`
@@ -770,11 +1118,25 @@ func MakeCmdErrorPacket(ck base.CommandKey, err error) *CmdErrorPacketType {
 	return &CmdErrorPacketType{Type: CmdErrorPacketStr, CK: ck, Error: err.Error()}
 }
 
+// if ExpectedInfo or ExpectedSha256 is set, the write is rejected (with Conflict set) if the
+// file on disk no longer matches (ModTs+Size, or NotFound if the file should not exist yet)
+//
+// in Patch mode the file is not truncated, each filedata packet is written at its Offset,
+// and the file is truncated to Size at the end.  when AckData is set, a filedataack is sent
+// as each filedata packet is written (lets the sender do flow control).  ModTs and Perm
+// (when non-zero) are applied after the write completes.
 type WriteFilePacketType struct {
-	Type    string `json:"type"`
-	ReqId   string `json:"reqid"`
-	UseTemp bool   `json:"usetemp,omitempty"`
-	Path    string `json:"path"`
+	Type           string    `json:"type"`
+	ReqId          string    `json:"reqid"`
+	UseTemp        bool      `json:"usetemp,omitempty"`
+	Path           string    `json:"path"`
+	ExpectedInfo   *FileInfo `json:"expectedinfo,omitempty"`
+	ExpectedSha256 string    `json:"expectedsha256,omitempty"` // hex encoded
+	Patch          bool      `json:"patch,omitempty"`
+	Size           int64     `json:"size,omitempty"`
+	AckData        bool      `json:"ackdata,omitempty"`
+	ModTs          int64     `json:"modts,omitempty"`
+	Perm           int       `json:"perm,omitempty"`
 }
 
 func (*WriteFilePacketType) GetType() string {
@@ -789,10 +1151,13 @@ func MakeWriteFilePacket() *WriteFilePacketType {
 	return &WriteFilePacketType{Type: WriteFilePacketStr}
 }
 
+// on a conflict, Info is the current state of the file
 type WriteFileReadyPacketType struct {
-	Type   string `json:"type"`
-	RespId string `json:"reqid"`
-	Error  string `json:"error,omitempty"`
+	Type     string    `json:"type"`
+	RespId   string    `json:"reqid"`
+	Error    string    `json:"error,omitempty"`
+	Conflict bool      `json:"conflict,omitempty"`
+	Info     *FileInfo `json:"info,omitempty"`
 }
 
 func (*WriteFileReadyPacketType) GetType() string {
@@ -814,10 +1179,13 @@ func MakeWriteFileReadyPacket(reqId string) *WriteFileReadyPacketType {
 	}
 }
 
+// on success, Info is the state of the file after the write
 type WriteFileDonePacketType struct {
-	Type   string `json:"type"`
-	RespId string `json:"reqid"`
-	Error  string `json:"error,omitempty"`
+	Type     string    `json:"type"`
+	RespId   string    `json:"reqid"`
+	Error    string    `json:"error,omitempty"`
+	Conflict bool      `json:"conflict,omitempty"`
+	Info     *FileInfo `json:"info,omitempty"`
 }
 
 func (*WriteFileDonePacketType) GetType() string {
@@ -839,6 +1207,52 @@ func MakeWriteFileDonePacket(reqId string) *WriteFileDonePacketType {
 	}
 }
 
+// Paths are absolute.  if UnWatch is set, the paths are removed from the watch list
+type WatchFilePacketType struct {
+	Type    string   `json:"type"`
+	ReqId   string   `json:"reqid"`
+	Paths   []string `json:"paths"`
+	UnWatch bool     `json:"unwatch,omitempty"`
+}
+
+func (*WatchFilePacketType) GetType() string {
+	return WatchFilePacketStr
+}
+
+func (p *WatchFilePacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeWatchFilePacket() *WatchFilePacketType {
+	return &WatchFilePacketType{Type: WatchFilePacketStr}
+}
+
+const (
+	FileChangeOpWrite  = "write"
+	FileChangeOpRemove = "remove"
+)
+
+// Info is nil when Op is "remove"
+type FileChangedPacketType struct {
+	Type string    `json:"type"`
+	Path string    `json:"path"`
+	Op   string    `json:"op"`
+	Ts   int64     `json:"ts"`
+	Info *FileInfo `json:"info,omitempty"`
+}
+
+func (*FileChangedPacketType) GetType() string {
+	return FileChangedPacketStr
+}
+
+func (p *FileChangedPacketType) String() string {
+	return fmt.Sprintf("filechanged[%s %s]", p.Op, p.Path)
+}
+
+func MakeFileChangedPacket(path string, op string) *FileChangedPacketType {
+	return &FileChangedPacketType{Type: FileChangedPacketStr, Path: path, Op: op}
+}
+
 type PacketType interface {
 	GetType() string
 }
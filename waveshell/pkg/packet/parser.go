@@ -6,6 +6,7 @@ package packet
 import (
 	"bufio"
 	"context"
+	"errors"
 	"io"
 	"strconv"
 	"strings"
@@ -21,10 +22,15 @@ type PacketParser struct {
 }
 
 type RpcEntry struct {
-	ReqId  string
-	RespCh chan RpcResponsePacketType
+	ReqId    string
+	RespCh   chan RpcResponsePacketType
+	Overflow bool // a response was dropped because RespCh was full
 }
 
+// returned by RpcResponseIter.Next when a response had to be dropped (the iterator fell behind).
+// streaming rpcs cannot recover from a missing response, so the rpc is aborted.
+var ErrRpcOverflow = errors.New("rpc response queue overflow, responses were dropped")
+
 type RpcResponseIter struct {
 	ReqId  string
 	Parser *PacketParser
@@ -74,6 +80,7 @@ func CombinePacketParsers(p1 *PacketParser, p2 *PacketParser, rpcHandler bool) *
 	}()
 	go func() {
 		wg.Wait()
+		rtnParser.closeAllRpcs()
 		close(rtnParser.MainCh)
 	}()
 	return rtnParser
@@ -103,8 +110,16 @@ func (p *PacketParser) GetNextResponse(ctx context.Context, reqId string) (RpcRe
 	if entry == nil {
 		return nil, nil
 	}
+	if p.isRpcOverflow(entry) {
+		p.UnRegisterRpc(reqId)
+		return nil, ErrRpcOverflow
+	}
 	select {
-	case resp := <-entry.RespCh:
+	case resp, ok := <-entry.RespCh:
+		if !ok {
+			// rpc was unregistered (or the input was closed)
+			return nil, nil
+		}
 		if resp.GetResponseDone() {
 			p.UnRegisterRpc(reqId)
 		}
@@ -124,6 +139,16 @@ func (p *PacketParser) UnRegisterRpc(reqId string) {
 	}
 }
 
+// called when the input is closed so anyone waiting on an rpc is woken up
+func (p *PacketParser) closeAllRpcs() {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	for reqId, entry := range p.RpcMap {
+		close(entry.RespCh)
+		delete(p.RpcMap, reqId)
+	}
+}
+
 func (p *PacketParser) RegisterRpc(reqId string) chan RpcResponsePacketType {
 	return p.RegisterRpcSz(reqId, 2)
 }
@@ -144,6 +169,12 @@ func (p *PacketParser) getRpcEntry(reqId string) *RpcEntry {
 	return entry
 }
 
+func (p *PacketParser) isRpcOverflow(entry *RpcEntry) bool {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	return entry.Overflow
+}
+
 func (p *PacketParser) trySendRpcResponse(pk PacketType) bool {
 	respPk, ok := pk.(RpcResponsePacketType)
 	if !ok {
@@ -155,10 +186,11 @@ func (p *PacketParser) trySendRpcResponse(pk PacketType) bool {
 	if entry == nil {
 		return false
 	}
-	// nonblocking send
+	// nonblocking send, a dropped response fails the iterator (see ErrRpcOverflow)
 	select {
 	case entry.RespCh <- respPk:
 	default:
+		entry.Overflow = true
 	}
 	return true
 }
@@ -187,6 +219,7 @@ func MakePacketParser(input io.Reader, rpcHandler bool) *PacketParser {
 	bufReader := bufio.NewReader(input)
 	go func() {
 		defer func() {
+			parser.closeAllRpcs()
 			close(parser.MainCh)
 		}()
 		for {
@@ -0,0 +1,238 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+var errArchiveStop = errors.New("stop")
+
+// called for each entry in an archive, openFn returns a reader for the entry's data.
+// return errArchiveStop to stop walking.
+type archiveWalkFn func(info *packet.FileInfo, openFn func() (io.ReadCloser, error)) error
+
+func archiveEntryName(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	name = strings.TrimSuffix(name, "/")
+	return name
+}
+
+func walkArchive(path string, fn archiveWalkFn) error {
+	lowerPath := strings.ToLower(path)
+	if strings.HasSuffix(lowerPath, ".zip") {
+		return walkZipArchive(path, fn)
+	}
+	var decompressFn func(io.Reader) (io.Reader, error)
+	switch {
+	case strings.HasSuffix(lowerPath, ".tar"):
+		decompressFn = func(r io.Reader) (io.Reader, error) { return r, nil }
+	case strings.HasSuffix(lowerPath, ".tar.gz") || strings.HasSuffix(lowerPath, ".tgz"):
+		decompressFn = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case strings.HasSuffix(lowerPath, ".tar.bz2") || strings.HasSuffix(lowerPath, ".tbz2"):
+		decompressFn = func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }
+	default:
+		return fmt.Errorf("unsupported archive type (supported types: .tar, .tar.gz, .tgz, .tar.bz2, .zip)")
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	r, err := decompressFn(fd)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		info := &packet.FileInfo{
+			Name:  archiveEntryName(hdr.Name),
+			Size:  hdr.Size,
+			ModTs: hdr.ModTime.UnixMilli(),
+			IsDir: hdr.Typeflag == tar.TypeDir,
+			Perm:  int(hdr.FileInfo().Mode().Perm()),
+		}
+		if info.Name == "" {
+			continue
+		}
+		err = fn(info, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil })
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func walkZipArchive(path string, fn archiveWalkFn) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		finfo := zf.FileInfo()
+		if !finfo.Mode().IsRegular() && !finfo.IsDir() {
+			continue
+		}
+		info := &packet.FileInfo{
+			Name:  archiveEntryName(zf.Name),
+			Size:  int64(zf.UncompressedSize64),
+			ModTs: zf.Modified.UnixMilli(),
+			IsDir: finfo.IsDir(),
+			Perm:  int(finfo.Mode().Perm()),
+		}
+		if info.Name == "" {
+			continue
+		}
+		err = fn(info, zf.Open)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MServer) archiveList(pk *packet.ArchiveListPacketType) {
+	resp := packet.MakeFileListResponse(pk.ReqId)
+	if pk.Path == "" || !filepath.IsAbs(pk.Path) {
+		resp.Error = "invalid archivelist request, path must be absolute"
+		m.Sender.SendPacket(resp)
+		return
+	}
+	finfo, err := os.Stat(pk.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		resp.Info = &packet.FileInfo{Name: pk.Path, NotFound: true}
+		resp.Done = true
+		m.Sender.SendPacket(resp)
+		return
+	}
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot stat %q: %v", pk.Path, err)
+		m.Sender.SendPacket(resp)
+		return
+	}
+	resp.Info = makeFileInfo(pk.Path, finfo)
+	var numEntries int
+	walkErr := walkArchive(pk.Path, func(info *packet.FileInfo, openFn func() (io.ReadCloser, error)) error {
+		numEntries++
+		if numEntries > MaxFileListEntries {
+			return fmt.Errorf("too many entries (max %d)", MaxFileListEntries)
+		}
+		resp.Entries = append(resp.Entries, info)
+		if len(resp.Entries) >= FileListBatchSize {
+			m.Sender.SendPacket(resp)
+			resp = packet.MakeFileListResponse(pk.ReqId)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		resp.Entries = nil
+		resp.Error = fmt.Sprintf("error reading archive %q: %v", pk.Path, walkErr)
+		m.Sender.SendPacket(resp)
+		return
+	}
+	resp.Done = true
+	m.Sender.SendPacket(resp)
+}
+
+// compressed archives can't seek, so a range is served by discarding the data before it
+func (m *MServer) streamArchiveEntry(sender *streamSender, pk *packet.StreamFilePacketType) {
+	resp := packet.MakeStreamFileResponse(pk.ReqId)
+	entryName := archiveEntryName(pk.ArchiveEntry)
+	var sentResp bool
+	walkErr := walkArchive(pk.Path, func(info *packet.FileInfo, openFn func() (io.ReadCloser, error)) error {
+		if info.Name != entryName {
+			return nil
+		}
+		resp.Info = info
+		if info.IsDir {
+			resp.Error = fmt.Sprintf("archive entry %q is a directory", entryName)
+			return errArchiveStop
+		}
+		startByte, endByte, err := resolveByteRange(pk.ByteRange, info.Size)
+		if err != nil {
+			resp.Error = err.Error()
+			return errArchiveStop
+		}
+		if pk.StatOnly || startByte >= endByte {
+			resp.Done = true
+			return errArchiveStop
+		}
+		r, err := openFn()
+		if err != nil {
+			resp.Error = fmt.Sprintf("opening archive entry: %v", err)
+			return errArchiveStop
+		}
+		defer r.Close()
+		_, err = io.CopyN(io.Discard, r, startByte)
+		if err != nil {
+			resp.Error = fmt.Sprintf("reading archive entry: %v", err)
+			return errArchiveStop
+		}
+		m.Sender.SendPacket(resp)
+		sentResp = true
+		sendArchiveData(sender, pk.ReqId, r, endByte-startByte)
+		return errArchiveStop
+	})
+	if sentResp {
+		return
+	}
+	if walkErr != nil && walkErr != errArchiveStop {
+		resp.Error = fmt.Sprintf("error reading archive %q: %v", pk.Path, walkErr)
+	} else if resp.Info == nil {
+		resp.Info = &packet.FileInfo{Name: entryName, NotFound: true}
+		resp.Done = true
+	}
+	m.Sender.SendPacket(resp)
+}
+
+// numBytes must be > 0, the last packet is sent with Eof set
+func sendArchiveData(sender *streamSender, reqId string, r io.Reader, numBytes int64) {
+	var buffer [MaxFileDataPacketSize]byte
+	first := true
+	for numBytes > 0 {
+		if !first {
+			// same throttle as streamFile
+			time.Sleep(1 * time.Millisecond)
+		}
+		first = false
+		readLen := int64Min(MaxFileDataPacketSize, numBytes)
+		nr, err := io.ReadFull(r, buffer[0:readLen])
+		numBytes -= int64(nr)
+		dataPk := packet.MakeFileDataPacket(reqId)
+		dataPk.Data = make([]byte, nr)
+		copy(dataPk.Data, buffer[0:nr])
+		if err == io.EOF || err == io.ErrUnexpectedEOF || numBytes == 0 {
+			dataPk.Eof = true
+		} else if err != nil {
+			dataPk.Error = err.Error()
+		}
+		err = sender.send(dataPk)
+		if err != nil || dataPk.GetResponseDone() {
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+const MaxDiskUsageEntries = 100000
+const DiskUsageTopFiles = 10
+const diskUsageProgressInterval = 250 * time.Millisecond
+
+type duWalker struct {
+	Ctx        context.Context
+	M          *MServer
+	ReqId      string
+	RootDev    uint64
+	OneFs      bool
+	MaxDepth   int
+	SeenInodes map[[2]uint64]bool // hard links (nlink > 1) that were already counted
+	Resp       *packet.DiskUsageResponseType
+	NumFiles   int64
+	Size       int64
+	NumErrors  int
+	NumEntries int
+	Truncated  bool
+	LastSend   time.Time
+}
+
+// returns (disk usage, dev, inode, nlink).  falls back to the apparent size if there is no stat_t.
+func duStat(finfo fs.FileInfo) (int64, uint64, uint64, uint64) {
+	st, ok := finfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return finfo.Size(), 0, 0, 1
+	}
+	return int64(st.Blocks) * 512, uint64(st.Dev), uint64(st.Ino), uint64(st.Nlink)
+}
+
+func (w *duWalker) countFile(finfo fs.FileInfo) int64 {
+	size, dev, ino, nlink := duStat(finfo)
+	if nlink > 1 && !finfo.IsDir() {
+		key := [2]uint64{dev, ino}
+		if w.SeenInodes[key] {
+			return 0
+		}
+		w.SeenInodes[key] = true
+	}
+	return size
+}
+
+func (w *duWalker) sendResp() {
+	w.Resp.NumFiles = w.NumFiles
+	w.Resp.Size = w.Size
+	w.Resp.NumErrors = w.NumErrors
+	w.Resp.Truncated = w.Truncated
+	w.M.Sender.SendPacket(w.Resp)
+	w.Resp = packet.MakeDiskUsageResponse(w.ReqId)
+	w.LastSend = time.Now()
+}
+
+func (w *duWalker) maybeSend() {
+	if len(w.Resp.Entries) >= FileListBatchSize || time.Since(w.LastSend) >= diskUsageProgressInterval {
+		w.sendResp()
+	}
+}
+
+func (w *duWalker) addEntry(entry *packet.DiskUsageEntry, depth int) {
+	if w.MaxDepth > 0 && depth > w.MaxDepth {
+		return
+	}
+	if w.NumEntries >= MaxDiskUsageEntries {
+		w.Truncated = true
+		return
+	}
+	w.NumEntries++
+	w.Resp.Entries = append(w.Resp.Entries, entry)
+}
+
+// returns the entry for the directory (sizes include all subdirs)
+func (w *duWalker) walkDir(dirPath string, relPath string, dirInfo fs.FileInfo, depth int) (*packet.DiskUsageEntry, error) {
+	if err := w.Ctx.Err(); err != nil {
+		return nil, err
+	}
+	entry := &packet.DiskUsageEntry{Path: relPath}
+	entry.Size = w.countFile(dirInfo)
+	w.Size += entry.Size
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if depth == 0 {
+			return nil, err
+		}
+		// ReadDir can return partial results, count what we got
+		w.NumErrors++
+	}
+	var files []*packet.DiskUsageFile
+	for _, dirEntry := range dirEntries {
+		childPath := filepath.Join(dirPath, dirEntry.Name())
+		finfo, err := dirEntry.Info()
+		if err != nil {
+			w.NumErrors++
+			continue
+		}
+		if finfo.IsDir() {
+			_, dev, _, _ := duStat(finfo)
+			if w.OneFs && dev != w.RootDev {
+				continue
+			}
+			childEntry, err := w.walkDir(childPath, path.Join(relPath, dirEntry.Name()), finfo, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			entry.Size += childEntry.Size
+			entry.NumFiles += childEntry.NumFiles
+			continue
+		}
+		size := w.countFile(finfo)
+		w.NumFiles++
+		w.Size += size
+		entry.NumFiles++
+		entry.FileSize += size
+		entry.Size += size
+		files = append(files, &packet.DiskUsageFile{Name: dirEntry.Name(), Size: size})
+		w.maybeSend()
+	}
+	sort.Slice(files, func(i int, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > DiskUsageTopFiles {
+		files = files[0:DiskUsageTopFiles]
+	}
+	entry.TopFiles = files
+	w.addEntry(entry, depth)
+	w.maybeSend()
+	return entry, nil
+}
+
+func (m *MServer) diskUsage(ctx context.Context, pk *packet.DiskUsagePacketType) {
+	resp := packet.MakeDiskUsageResponse(pk.ReqId)
+	if pk.Path == "" || !filepath.IsAbs(pk.Path) {
+		resp.Error = "invalid diskusage request, path must be absolute"
+		m.Sender.SendPacket(resp)
+		return
+	}
+	rootInfo, err := os.Lstat(pk.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		resp.Info = &packet.FileInfo{Name: pk.Path, NotFound: true}
+		resp.Done = true
+		m.Sender.SendPacket(resp)
+		return
+	}
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot stat %q: %v", pk.Path, err)
+		m.Sender.SendPacket(resp)
+		return
+	}
+	resp.Info = makeFileInfo(pk.Path, rootInfo)
+	if !rootInfo.IsDir() {
+		resp.Error = fmt.Sprintf("%q is not a directory", pk.Path)
+		m.Sender.SendPacket(resp)
+		return
+	}
+	_, rootDev, _, _ := duStat(rootInfo)
+	w := &duWalker{
+		Ctx:        ctx,
+		M:          m,
+		ReqId:      pk.ReqId,
+		RootDev:    rootDev,
+		OneFs:      pk.OneFs,
+		MaxDepth:   pk.MaxDepth,
+		SeenInodes: make(map[[2]uint64]bool),
+		Resp:       resp,
+		LastSend:   time.Now(),
+	}
+	_, err = w.walkDir(pk.Path, "", rootInfo, 0)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = errors.New("canceled")
+		}
+		errResp := packet.MakeDiskUsageResponse(pk.ReqId)
+		errResp.Error = fmt.Sprintf("error computing disk usage for %q: %v", pk.Path, err)
+		m.Sender.SendPacket(errResp)
+		return
+	}
+	w.Resp.Done = true
+	w.sendResp()
+}
@@ -0,0 +1,333 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+const FileListBatchSize = 500
+const MaxFileListEntries = 200000
+const MinHashBlockSize = 4 * 1024
+const MaxHashBlocks = 64 * 1024
+const MaxSearchPatternSize = 4 * 1024
+const MaxSearchResults = 1000
+const searchBufSize = 1024 * 1024
+
+func (m *MServer) fileList(pk *packet.FileListPacketType) {
+	resp := packet.MakeFileListResponse(pk.ReqId)
+	if pk.Path == "" || !filepath.IsAbs(pk.Path) {
+		resp.Error = "invalid filelist request, path must be absolute"
+		m.Sender.SendPacket(resp)
+		return
+	}
+	rootInfo, err := os.Stat(pk.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		resp.Info = &packet.FileInfo{Name: pk.Path, NotFound: true}
+		resp.Done = true
+		m.Sender.SendPacket(resp)
+		return
+	}
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot stat %q: %v", pk.Path, err)
+		m.Sender.SendPacket(resp)
+		return
+	}
+	resp.Info = makeFileInfo(pk.Path, rootInfo)
+	if !rootInfo.IsDir() {
+		resp.Done = true
+		m.Sender.SendPacket(resp)
+		return
+	}
+	var numEntries int
+	walkErr := filepath.WalkDir(pk.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == pk.Path {
+				return err
+			}
+			// skip unreadable entries, the caller will only see what we could read
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == pk.Path {
+			return nil
+		}
+		// symlinks and special files are not listed (we only deal with regular files and dirs)
+		if !d.Type().IsRegular() && !d.IsDir() {
+			return nil
+		}
+		finfo, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(pk.Path, path)
+		if err != nil {
+			return err
+		}
+		numEntries++
+		if numEntries > MaxFileListEntries {
+			return fmt.Errorf("too many files (max %d)", MaxFileListEntries)
+		}
+		resp.Entries = append(resp.Entries, makeFileInfo(filepath.ToSlash(relPath), finfo))
+		if len(resp.Entries) >= FileListBatchSize {
+			m.Sender.SendPacket(resp)
+			resp = packet.MakeFileListResponse(pk.ReqId)
+		}
+		if d.IsDir() && !pk.Recursive {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if walkErr != nil {
+		resp.Entries = nil
+		resp.Error = fmt.Sprintf("error listing %q: %v", pk.Path, walkErr)
+		m.Sender.SendPacket(resp)
+		return
+	}
+	resp.Done = true
+	m.Sender.SendPacket(resp)
+}
+
+func (m *MServer) fileBlockHash(pk *packet.FileBlockHashPacketType) {
+	resp := packet.MakeFileBlockHashResponse(pk.ReqId)
+	defer func() {
+		m.Sender.SendPacket(resp)
+	}()
+	if pk.Path == "" || !filepath.IsAbs(pk.Path) {
+		resp.Error = "invalid fileblockhash request, path must be absolute"
+		return
+	}
+	if pk.BlockSize < MinHashBlockSize {
+		resp.Error = fmt.Sprintf("invalid fileblockhash request, blocksize must be at least %d", MinHashBlockSize)
+		return
+	}
+	fd, err := os.Open(pk.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		resp.Info = &packet.FileInfo{Name: pk.Path, NotFound: true}
+		return
+	}
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot open %q: %v", pk.Path, err)
+		return
+	}
+	defer fd.Close()
+	finfo, err := fd.Stat()
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot stat %q: %v", pk.Path, err)
+		return
+	}
+	if finfo.IsDir() {
+		resp.Error = fmt.Sprintf("cannot hash %q, it is a directory", pk.Path)
+		return
+	}
+	numBlocks := (finfo.Size() + pk.BlockSize - 1) / pk.BlockSize
+	if pk.MaxBlocks > 0 && numBlocks > int64(pk.MaxBlocks) {
+		numBlocks = int64(pk.MaxBlocks)
+	}
+	if numBlocks > MaxHashBlocks {
+		resp.Error = fmt.Sprintf("blocksize %d is too small for file size %d (max %d blocks)", pk.BlockSize, finfo.Size(), MaxHashBlocks)
+		return
+	}
+	resp.Info = makeFileInfo(pk.Path, finfo)
+	for int64(len(resp.Hashes)) < numBlocks {
+		hasher := sha256.New()
+		nw, err := io.CopyN(hasher, fd, pk.BlockSize)
+		if nw > 0 {
+			resp.Hashes = append(resp.Hashes, hex.EncodeToString(hasher.Sum(nil)))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			resp.Hashes = nil
+			resp.Error = fmt.Sprintf("error reading %q: %v", pk.Path, err)
+			return
+		}
+	}
+}
+
+// copies srcPath to dstPath (replacing it), keeping the mode and modtime of srcPath
+func copyLocalFile(srcPath string, dstPath string) error {
+	srcFd, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFd.Close()
+	finfo, err := srcFd.Stat()
+	if err != nil {
+		return err
+	}
+	if !finfo.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", srcPath)
+	}
+	dstFd, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, finfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dstFd, srcFd)
+	if err != nil {
+		dstFd.Close()
+		os.Remove(dstPath)
+		return err
+	}
+	err = dstFd.Close()
+	if err != nil {
+		os.Remove(dstPath)
+		return err
+	}
+	return os.Chtimes(dstPath, finfo.ModTime(), finfo.ModTime())
+}
+
+func (m *MServer) fileOp(pk *packet.FileOpPacketType) {
+	for _, path := range pk.Paths {
+		if path == "" || !filepath.IsAbs(path) {
+			m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("invalid fileop request, paths must be absolute"))
+			return
+		}
+	}
+	switch pk.Op {
+	case packet.FileOpMkdir:
+		perm := fs.FileMode(0o777)
+		if pk.Perm != 0 {
+			perm = fs.FileMode(pk.Perm).Perm()
+		}
+		for _, path := range pk.Paths {
+			err := os.MkdirAll(path, perm)
+			if err != nil {
+				m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("cannot create directory: %w", err))
+				return
+			}
+		}
+
+	case packet.FileOpRemove:
+		for _, path := range pk.Paths {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("cannot remove: %w", err))
+				return
+			}
+		}
+
+	case packet.FileOpRename:
+		if len(pk.Paths) != 2 {
+			m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("invalid rename fileop, requires 2 paths"))
+			return
+		}
+		err := os.Rename(pk.Paths[0], pk.Paths[1])
+		if err != nil {
+			m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("cannot rename: %w", err))
+			return
+		}
+
+	case packet.FileOpCopy:
+		if len(pk.Paths) != 2 {
+			m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("invalid copy fileop, requires 2 paths"))
+			return
+		}
+		err := copyLocalFile(pk.Paths[0], pk.Paths[1])
+		if err != nil {
+			m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("cannot copy: %w", err))
+			return
+		}
+
+	default:
+		m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("invalid fileop %q", pk.Op))
+		return
+	}
+	m.Sender.SendResponse(pk.ReqId, true)
+}
+
+func (m *MServer) fileSearch(pk *packet.FileSearchPacketType) {
+	resp := packet.MakeFileSearchResponse(pk.ReqId)
+	defer func() {
+		m.Sender.SendPacket(resp)
+	}()
+	if pk.Path == "" || !filepath.IsAbs(pk.Path) {
+		resp.Error = "invalid filesearch request, path must be absolute"
+		return
+	}
+	if len(pk.Pattern) == 0 || len(pk.Pattern) > MaxSearchPatternSize {
+		resp.Error = fmt.Sprintf("invalid filesearch request, pattern must be between 1 and %d bytes", MaxSearchPatternSize)
+		return
+	}
+	maxResults := pk.MaxResults
+	if maxResults <= 0 {
+		maxResults = 1
+	}
+	if maxResults > MaxSearchResults {
+		maxResults = MaxSearchResults
+	}
+	fd, err := os.Open(pk.Path)
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot open %q: %v", pk.Path, err)
+		return
+	}
+	defer fd.Close()
+	finfo, err := fd.Stat()
+	if err != nil {
+		resp.Error = fmt.Sprintf("cannot stat %q: %v", pk.Path, err)
+		return
+	}
+	if finfo.IsDir() {
+		resp.Error = fmt.Sprintf("cannot search %q, it is a directory", pk.Path)
+		return
+	}
+	resp.Info = makeFileInfo(pk.Path, finfo)
+	offsets, truncated, err := searchReader(fd, pk.Pattern, pk.Offset, maxResults)
+	if err != nil {
+		resp.Error = fmt.Sprintf("error reading %q: %v", pk.Path, err)
+		return
+	}
+	resp.Offsets = offsets
+	resp.Truncated = truncated
+}
+
+// reads in chunks, keeping the last len(pattern)-1 bytes so matches that span chunks are found
+func searchReader(r io.ReaderAt, pattern []byte, startOffset int64, maxResults int) ([]int64, bool, error) {
+	var rtn []int64
+	buf := make([]byte, searchBufSize+len(pattern)-1)
+	bufStart := startOffset // file offset of buf[0]
+	carry := 0
+	for {
+		nr, err := r.ReadAt(buf[carry:], bufStart+int64(carry))
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		data := buf[0 : carry+nr]
+		searchPos := 0
+		for {
+			idx := bytes.Index(data[searchPos:], pattern)
+			if idx == -1 {
+				break
+			}
+			if len(rtn) >= maxResults {
+				return rtn, true, nil
+			}
+			rtn = append(rtn, bufStart+int64(searchPos+idx))
+			searchPos += idx + 1
+		}
+		if err == io.EOF || nr == 0 {
+			return rtn, false, nil
+		}
+		// keep the tail (it could be the start of a match)
+		carry = len(pattern) - 1
+		if carry > len(data) {
+			carry = len(data)
+		}
+		copy(buf, data[len(data)-carry:])
+		bufStart += int64(len(data) - carry)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSearch(t *testing.T, name string, data []byte, pattern string, startOffset int64, maxResults int, expected []int64, expectedTruncated bool) {
+	offsets, truncated, err := searchReader(bytes.NewReader(data), []byte(pattern), startOffset, maxResults)
+	if err != nil {
+		t.Errorf("%s: unexpected error: %v", name, err)
+		return
+	}
+	if !reflect.DeepEqual(offsets, expected) || truncated != expectedTruncated {
+		t.Errorf("%s: got %v (truncated=%v), expected %v (truncated=%v)", name, offsets, truncated, expected, expectedTruncated)
+	}
+}
+
+func TestSearchReader(t *testing.T) {
+	data := []byte("abcabcxxabc")
+	testSearch(t, "basic", data, "abc", 0, 10, []int64{0, 3, 8}, false)
+	testSearch(t, "offset", data, "abc", 1, 10, []int64{3, 8}, false)
+	testSearch(t, "maxresults", data, "abc", 0, 2, []int64{0, 3}, true)
+	testSearch(t, "exact-max", data, "abc", 0, 3, []int64{0, 3, 8}, false)
+	testSearch(t, "nomatch", data, "abd", 0, 10, nil, false)
+	testSearch(t, "overlapping", []byte("aaaa"), "aa", 0, 10, []int64{0, 1, 2}, false)
+	testSearch(t, "binary", []byte{0x00, 0xff, 0x00, 0xff}, "\x00\xff", 0, 10, []int64{0, 2}, false)
+	testSearch(t, "empty", nil, "a", 0, 10, nil, false)
+	testSearch(t, "past-end", data, "abc", 100, 10, nil, false)
+
+	// matches that span the read chunks, and at the very start/end of a chunk
+	big := make([]byte, 3*searchBufSize)
+	copy(big[0:], "PAT")
+	copy(big[searchBufSize-2:], "PAT")
+	copy(big[searchBufSize+1:], "PAT")
+	copy(big[2*searchBufSize-3:], "PAT")
+	copy(big[3*searchBufSize-3:], "PAT")
+	expected := []int64{0, searchBufSize - 2, searchBufSize + 1, 2*searchBufSize - 3, 3*searchBufSize - 3}
+	testSearch(t, "chunks", big, "PAT", 0, 100, expected, false)
+	testSearch(t, "chunks-offset", big, "PAT", searchBufSize, 100, expected[2:], false)
+}
+
+func TestCopyLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	dstPath := filepath.Join(dir, "dst")
+	err := os.WriteFile(srcPath, []byte("new data"), 0o640)
+	if err != nil {
+		t.Fatalf("cannot write src: %v", err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(srcPath, modTime, modTime)
+	err = os.WriteFile(dstPath, []byte("old data that is longer"), 0o600)
+	if err != nil {
+		t.Fatalf("cannot write dst: %v", err)
+	}
+	err = copyLocalFile(srcPath, dstPath)
+	if err != nil {
+		t.Fatalf("copyLocalFile error: %v", err)
+	}
+	data, _ := os.ReadFile(dstPath)
+	finfo, err := os.Stat(dstPath)
+	if err != nil || string(data) != "new data" || !finfo.ModTime().Equal(modTime) {
+		t.Errorf("copyLocalFile got %q modtime %v (err %v), expected %q modtime %v", data, finfo.ModTime(), err, "new data", modTime)
+	}
+	err = copyLocalFile(dir, dstPath)
+	if err == nil {
+		t.Errorf("copyLocalFile should not copy a directory")
+	}
+}
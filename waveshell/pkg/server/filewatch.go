@@ -0,0 +1,217 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+const fileWatchDebounceTime = 200 * time.Millisecond
+const selfWriteSuppressTime = 1 * time.Second
+
+// we watch the parent directories (not the files themselves) so we can track
+// editors that save by renaming a temp file over the original.
+type FileWatcher struct {
+	Lock       *sync.Mutex
+	Watcher    *fsnotify.Watcher
+	Files      map[string]bool
+	DirRefs    map[string]int
+	Timers     map[string]*time.Timer
+	SelfWrites map[string]time.Time
+	Sender     *packet.PacketSender
+}
+
+func MakeFileWatcher(sender *packet.PacketSender) *FileWatcher {
+	return &FileWatcher{
+		Lock:       &sync.Mutex{},
+		Files:      make(map[string]bool),
+		DirRefs:    make(map[string]int),
+		Timers:     make(map[string]*time.Timer),
+		SelfWrites: make(map[string]time.Time),
+		Sender:     sender,
+	}
+}
+
+// must hold lock
+func (fw *FileWatcher) ensureWatcher_nolock() error {
+	if fw.Watcher != nil {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	fw.Watcher = watcher
+	go fw.run(watcher)
+	return nil
+}
+
+func (fw *FileWatcher) AddFile(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("watch path must be absolute: %q", path)
+	}
+	path = filepath.Clean(path)
+	fw.Lock.Lock()
+	defer fw.Lock.Unlock()
+	if fw.Files[path] {
+		return nil
+	}
+	err := fw.ensureWatcher_nolock()
+	if err != nil {
+		return fmt.Errorf("cannot create file watcher: %w", err)
+	}
+	dir := filepath.Dir(path)
+	if fw.DirRefs[dir] == 0 {
+		err = fw.Watcher.Add(dir)
+		if err != nil {
+			return fmt.Errorf("cannot watch directory %q: %w", dir, err)
+		}
+	}
+	fw.DirRefs[dir]++
+	fw.Files[path] = true
+	return nil
+}
+
+func (fw *FileWatcher) RemoveFile(path string) {
+	path = filepath.Clean(path)
+	fw.Lock.Lock()
+	defer fw.Lock.Unlock()
+	if !fw.Files[path] {
+		return
+	}
+	delete(fw.Files, path)
+	if timer := fw.Timers[path]; timer != nil {
+		timer.Stop()
+		delete(fw.Timers, path)
+	}
+	dir := filepath.Dir(path)
+	fw.DirRefs[dir]--
+	if fw.DirRefs[dir] <= 0 {
+		delete(fw.DirRefs, dir)
+		if fw.Watcher != nil {
+			fw.Watcher.Remove(dir)
+		}
+	}
+}
+
+// called after a writefile completes so we don't report our own writes back as remote changes
+func (fw *FileWatcher) NoteSelfWrite(path string) {
+	path = filepath.Clean(path)
+	fw.Lock.Lock()
+	defer fw.Lock.Unlock()
+	if !fw.Files[path] {
+		return
+	}
+	fw.SelfWrites[path] = time.Now()
+	if timer := fw.Timers[path]; timer != nil {
+		timer.Stop()
+		delete(fw.Timers, path)
+	}
+}
+
+func (fw *FileWatcher) Close() {
+	fw.Lock.Lock()
+	defer fw.Lock.Unlock()
+	for _, timer := range fw.Timers {
+		timer.Stop()
+	}
+	fw.Timers = make(map[string]*time.Timer)
+	if fw.Watcher != nil {
+		fw.Watcher.Close()
+		fw.Watcher = nil
+	}
+}
+
+func (fw *FileWatcher) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			fw.handleEvent(event)
+
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (fw *FileWatcher) handleEvent(event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+	path := filepath.Clean(event.Name)
+	fw.Lock.Lock()
+	defer fw.Lock.Unlock()
+	if !fw.Files[path] {
+		return
+	}
+	if writeTime, found := fw.SelfWrites[path]; found {
+		if time.Since(writeTime) < selfWriteSuppressTime {
+			return
+		}
+		delete(fw.SelfWrites, path)
+	}
+	// editors often do several operations per save (truncate+write, or write temp+rename), so debounce per path
+	if timer := fw.Timers[path]; timer != nil {
+		timer.Stop()
+	}
+	fw.Timers[path] = time.AfterFunc(fileWatchDebounceTime, func() {
+		fw.sendChange(path)
+	})
+}
+
+func (fw *FileWatcher) sendChange(path string) {
+	fw.Lock.Lock()
+	delete(fw.Timers, path)
+	watched := fw.Files[path]
+	fw.Lock.Unlock()
+	if !watched {
+		return
+	}
+	// stat at send time (after debounce) so we report the final state of the file
+	finfo, err := os.Stat(path)
+	if err != nil {
+		pk := packet.MakeFileChangedPacket(path, packet.FileChangeOpRemove)
+		pk.Ts = time.Now().UnixMilli()
+		fw.Sender.SendPacket(pk)
+		return
+	}
+	pk := packet.MakeFileChangedPacket(path, packet.FileChangeOpWrite)
+	pk.Ts = time.Now().UnixMilli()
+	pk.Info = makeFileInfo(path, finfo)
+	fw.Sender.SendPacket(pk)
+}
+
+func (m *MServer) watchFile(pk *packet.WatchFilePacketType) {
+	if len(pk.Paths) == 0 {
+		m.Sender.SendErrorResponse(pk.ReqId, fmt.Errorf("invalid watchfile request, no paths specified"))
+		return
+	}
+	if pk.UnWatch {
+		for _, path := range pk.Paths {
+			m.FileWatcher.RemoveFile(path)
+		}
+		m.Sender.SendResponse(pk.ReqId, true)
+		return
+	}
+	for _, path := range pk.Paths {
+		err := m.FileWatcher.AddFile(path)
+		if err != nil {
+			m.Sender.SendErrorResponse(pk.ReqId, err)
+			return
+		}
+	}
+	m.Sender.SendResponse(pk.ReqId, true)
+}
@@ -5,6 +5,8 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -27,6 +29,7 @@ const MaxFileDataPacketSize = 16 * 1024
 const WriteFileContextTimeout = 30 * time.Second
 const cleanLoopTime = 5 * time.Second
 const MaxWriteFileContextData = 100
+const StreamFileAckTimeout = 30 * time.Second
 
 // TODO create unblockable packet-sender (backed by an array) for clientproc
 type MServer struct {
@@ -40,6 +43,9 @@ type MServer struct {
 	WriteErrorCh        chan bool                     // closed if there is a I/O write error
 	WriteErrorChOnce    *sync.Once
 	WriteFileContextMap map[string]*WriteFileContext
+	RpcCancelMap        map[string]context.CancelFunc // reqid -> cancelfn (for cancelable rpcs)
+	StreamAckMap        map[string]chan int           // reqid -> acked packets (streamfile with ackdata)
+	FileWatcher         *FileWatcher
 	Done                bool
 }
 
@@ -52,6 +58,7 @@ type WriteFileContext struct {
 }
 
 func (m *MServer) Close() {
+	m.FileWatcher.Close()
 	m.Sender.Close()
 	m.Sender.WaitForDone()
 	m.Lock.Lock()
@@ -110,6 +117,91 @@ func (wfc *WriteFileContext) setDone() {
 	wfc.CVar.Broadcast()
 }
 
+// the returned ctx is cancelled by an rpccancel packet.  call the returned func when the rpc is done.
+func (m *MServer) makeCancelableRpcCtx(reqId string) (context.Context, func()) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	m.RpcCancelMap[reqId] = cancelFn
+	return ctx, func() {
+		m.Lock.Lock()
+		delete(m.RpcCancelMap, reqId)
+		m.Lock.Unlock()
+		cancelFn()
+	}
+}
+
+// sends the filedata packets of a streamfile rpc.  with ackdata, send blocks while StreamFileAckWindow
+// packets are un-acked.
+type streamSender struct {
+	M        *MServer
+	ReqId    string
+	Ctx      context.Context
+	AckCh    chan int // nil if the client does not ack
+	NumSent  int
+	NumAcked int
+}
+
+func (m *MServer) makeStreamSender(ctx context.Context, pk *packet.StreamFilePacketType) (*streamSender, func()) {
+	sender := &streamSender{M: m, ReqId: pk.ReqId, Ctx: ctx}
+	if !pk.AckData {
+		return sender, func() {}
+	}
+	sender.AckCh = make(chan int, packet.StreamFileAckWindow)
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	m.StreamAckMap[pk.ReqId] = sender.AckCh
+	return sender, func() {
+		m.Lock.Lock()
+		defer m.Lock.Unlock()
+		delete(m.StreamAckMap, pk.ReqId)
+	}
+}
+
+func (m *MServer) addStreamAck(pk *packet.FileDataAckPacketType) {
+	m.Lock.Lock()
+	ackCh := m.StreamAckMap[pk.RespId]
+	m.Lock.Unlock()
+	if ackCh == nil {
+		return
+	}
+	select {
+	case ackCh <- pk.AckLen:
+	default:
+		// more acks than packets sent, ignore
+	}
+}
+
+// returns an error if the client canceled the stream or stopped acking
+func (s *streamSender) send(dataPk *packet.FileDataPacketType) error {
+	for s.AckCh != nil && s.NumSent-s.NumAcked >= packet.StreamFileAckWindow {
+		select {
+		case <-s.AckCh:
+			s.NumAcked++
+		case <-s.Ctx.Done():
+			return s.Ctx.Err()
+		case <-time.After(StreamFileAckTimeout):
+			// the error packet goes past the window (the client's queue leaves room for it)
+			errPk := packet.MakeFileDataPacket(s.ReqId)
+			errPk.Error = "timeout waiting for filedata acks"
+			s.M.Sender.SendPacket(errPk)
+			return errors.New(errPk.Error)
+		}
+	}
+	s.M.Sender.SendPacket(dataPk)
+	s.NumSent++
+	return nil
+}
+
+func (m *MServer) cancelRpc(reqId string) {
+	m.Lock.Lock()
+	cancelFn := m.RpcCancelMap[reqId]
+	m.Lock.Unlock()
+	if cancelFn != nil {
+		cancelFn()
+	}
+}
+
 func (m *MServer) cleanWriteFileContexts() {
 	now := time.Now()
 	var staleWfcs []*WriteFileContext
@@ -302,6 +394,84 @@ func checkFileWritable(path string) error {
 	}
 }
 
+var errWriteConflict = errors.New("write conflict, file has been modified since it was read")
+
+func makeFileInfo(path string, finfo fs.FileInfo) *packet.FileInfo {
+	return &packet.FileInfo{
+		Name:  path,
+		Size:  finfo.Size(),
+		ModTs: finfo.ModTime().UnixMilli(),
+		IsDir: finfo.IsDir(),
+		Perm:  int(finfo.Mode().Perm()),
+	}
+}
+
+func fileSha256(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, fd)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// returns the current file info, and errWriteConflict if the file does not match what the client expects
+func checkWriteConflict(pk *packet.WriteFilePacketType) (*packet.FileInfo, error) {
+	if pk.ExpectedInfo == nil && pk.ExpectedSha256 == "" {
+		return nil, nil
+	}
+	finfo, err := os.Stat(pk.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		curInfo := &packet.FileInfo{Name: pk.Path, NotFound: true}
+		if pk.ExpectedInfo != nil && pk.ExpectedInfo.NotFound {
+			return curInfo, nil
+		}
+		return curInfo, errWriteConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat: %w", err)
+	}
+	curInfo := makeFileInfo(pk.Path, finfo)
+	if pk.ExpectedInfo != nil {
+		if pk.ExpectedInfo.NotFound || pk.ExpectedInfo.ModTs != curInfo.ModTs || pk.ExpectedInfo.Size != curInfo.Size {
+			return curInfo, errWriteConflict
+		}
+	}
+	if pk.ExpectedSha256 != "" {
+		curHash, err := fileSha256(pk.Path)
+		if err != nil {
+			return curInfo, fmt.Errorf("cannot compute file hash: %w", err)
+		}
+		if curHash != pk.ExpectedSha256 {
+			return curInfo, errWriteConflict
+		}
+	}
+	return curInfo, nil
+}
+
+// perm and modTs are ignored when 0
+func setFileAttrs(path string, perm int, modTs int64) error {
+	if perm != 0 {
+		err := os.Chmod(path, fs.FileMode(perm).Perm())
+		if err != nil {
+			return fmt.Errorf("error setting file permissions: %v", err)
+		}
+	}
+	if modTs != 0 {
+		modTime := time.UnixMilli(modTs)
+		err := os.Chtimes(path, time.Now(), modTime)
+		if err != nil {
+			return fmt.Errorf("error setting file modtime: %v", err)
+		}
+	}
+	return nil
+}
+
 func copyFile(dstName string, srcName string) error {
 	srcFd, err := os.Open(srcName)
 	if err != nil {
@@ -329,6 +499,12 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 		m.Sender.SendPacket(resp)
 		return
 	}
+	if pk.Patch && pk.UseTemp {
+		resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
+		resp.Error = "invalid write-file request, cannot use patch mode with a temp file"
+		m.Sender.SendPacket(resp)
+		return
+	}
 	err := checkFileWritable(pk.Path)
 	if err != nil {
 		resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
@@ -336,6 +512,15 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 		m.Sender.SendPacket(resp)
 		return
 	}
+	curInfo, err := checkWriteConflict(pk)
+	if err != nil {
+		resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
+		resp.Error = err.Error()
+		resp.Conflict = errors.Is(err, errWriteConflict)
+		resp.Info = curInfo
+		m.Sender.SendPacket(resp)
+		return
+	}
 	var writeFd *os.File
 	if pk.UseTemp {
 		writeFd, err = os.CreateTemp("", "mshell.writefile.*") // "" means make this file in standard TempDir
@@ -346,7 +531,11 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 			return
 		}
 	} else {
-		writeFd, err = os.OpenFile(pk.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666) // use 666 because OpenFile respects umask
+		openFlags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if pk.Patch {
+			openFlags = os.O_CREATE | os.O_WRONLY
+		}
+		writeFd, err = os.OpenFile(pk.Path, openFlags, 0o666) // use 666 because OpenFile respects umask
 		if err != nil {
 			resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
 			resp.Error = fmt.Sprintf("write-file could not open file: %v", err)
@@ -383,35 +572,64 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 			break
 		}
 		if len(dataPk.Data) > 0 {
-			_, err := writeFd.Write(dataPk.Data)
+			var err error
+			if pk.Patch {
+				_, err = writeFd.WriteAt(dataPk.Data, dataPk.Offset)
+			} else {
+				_, err = writeFd.Write(dataPk.Data)
+			}
 			if err != nil {
 				doneErr = fmt.Errorf("error writing data to file: %v", err)
 				break
 			}
+			if pk.AckData {
+				m.Sender.SendPacket(packet.MakeFileDataAckPacket(pk.ReqId, len(dataPk.Data)))
+			}
 		}
 		if dataPk.Eof {
 			break
 		}
 	}
+	if pk.Patch && doneErr == nil {
+		err = writeFd.Truncate(pk.Size)
+		if err != nil {
+			doneErr = fmt.Errorf("error truncating file: %v", err)
+		}
+	}
 	closeErr := writeFd.Close()
 	if doneErr == nil && closeErr != nil {
 		doneErr = fmt.Errorf("error closing file: %v", closeErr)
 	}
+	var conflictInfo *packet.FileInfo
 	if pk.UseTemp {
-		if doneErr != nil {
-			os.Remove(writeFd.Name())
-		} else {
+		if doneErr == nil {
+			// the file could have changed while we were receiving data, check again before overwriting
+			conflictInfo, doneErr = checkWriteConflict(pk)
+		}
+		if doneErr == nil {
 			// copy file between writeFd.Name() and pk.Path
 			copyErr := copyFile(pk.Path, writeFd.Name())
-			if err != nil {
+			if copyErr != nil {
 				doneErr = fmt.Errorf("error writing file: %v", copyErr)
 			}
-			os.Remove(writeFd.Name())
 		}
+		os.Remove(writeFd.Name())
+	}
+	if doneErr == nil {
+		doneErr = setFileAttrs(pk.Path, pk.Perm, pk.ModTs)
 	}
 	donePk := packet.MakeWriteFileDonePacket(pk.ReqId)
 	if doneErr != nil {
 		donePk.Error = doneErr.Error()
+		if errors.Is(doneErr, errWriteConflict) {
+			donePk.Conflict = true
+			donePk.Info = conflictInfo
+		}
+	} else {
+		m.FileWatcher.NoteSelfWrite(pk.Path)
+		if finfo, err := os.Stat(pk.Path); err == nil {
+			donePk.Info = makeFileInfo(pk.Path, finfo)
+		}
 	}
 	m.Sender.SendPacket(donePk)
 }
@@ -446,7 +664,11 @@ func (m *MServer) returnStreamFileNewFileResponse(pk *packet.StreamFilePacketTyp
 	return
 }
 
-func (m *MServer) streamFile(pk *packet.StreamFilePacketType) {
+func (m *MServer) streamFile(sender *streamSender, pk *packet.StreamFilePacketType) {
+	if pk.ArchiveEntry != "" {
+		m.streamArchiveEntry(sender, pk)
+		return
+	}
 	resp := packet.MakeStreamFileResponse(pk.ReqId)
 	finfo, err := os.Stat(pk.Path)
 	if errors.Is(err, fs.ErrNotExist) {
@@ -471,30 +693,12 @@ func (m *MServer) streamFile(pk *packet.StreamFilePacketType) {
 		m.Sender.SendPacket(resp)
 		return
 	}
-	// like the http Range header.  range header is end inclusive.  for us, endByte is non-inclusive (so we add 1)
-	var startByte, endByte int64
-	if len(pk.ByteRange) == 0 {
-		endByte = finfo.Size()
-	} else if len(pk.ByteRange) == 1 && pk.ByteRange[0] >= 0 {
-		startByte = pk.ByteRange[0]
-		endByte = finfo.Size()
-	} else if len(pk.ByteRange) == 1 && pk.ByteRange[0] < 0 {
-		startByte = finfo.Size() + pk.ByteRange[0] // "+" since ByteRange[0] is less than 0
-		endByte = finfo.Size()
-	} else if len(pk.ByteRange) == 2 {
-		startByte = pk.ByteRange[0]
-		endByte = pk.ByteRange[1] + 1
-	} else {
-		resp.Error = fmt.Sprintf("invalid byte range (%d entries)", len(pk.ByteRange))
+	startByte, endByte, err := resolveByteRange(pk.ByteRange, finfo.Size())
+	if err != nil {
+		resp.Error = err.Error()
 		m.Sender.SendPacket(resp)
 		return
 	}
-	if startByte < 0 {
-		startByte = 0
-	}
-	if endByte > finfo.Size() {
-		endByte = finfo.Size()
-	}
 	if startByte >= endByte {
 		resp.Done = true
 		m.Sender.SendPacket(resp)
@@ -528,7 +732,10 @@ func (m *MServer) streamFile(pk *packet.StreamFilePacketType) {
 		} else if err != nil {
 			dataPk.Error = err.Error()
 		}
-		m.Sender.SendPacket(dataPk)
+		err = sender.send(dataPk)
+		if err != nil {
+			return
+		}
 		if dataPk.GetResponseDone() {
 			sentDone = true
 			break
@@ -537,11 +744,37 @@ func (m *MServer) streamFile(pk *packet.StreamFilePacketType) {
 	if !sentDone {
 		dataPk := packet.MakeFileDataPacket(pk.ReqId)
 		dataPk.Eof = true
-		m.Sender.SendPacket(dataPk)
+		sender.send(dataPk)
 	}
 	return
 }
 
+// like the http Range header.  range header is end inclusive.  for us, endByte is non-inclusive (so we add 1)
+func resolveByteRange(byteRange []int64, size int64) (int64, int64, error) {
+	var startByte, endByte int64
+	if len(byteRange) == 0 {
+		endByte = size
+	} else if len(byteRange) == 1 && byteRange[0] >= 0 {
+		startByte = byteRange[0]
+		endByte = size
+	} else if len(byteRange) == 1 && byteRange[0] < 0 {
+		startByte = size + byteRange[0] // "+" since byteRange[0] is less than 0
+		endByte = size
+	} else if len(byteRange) == 2 {
+		startByte = byteRange[0]
+		endByte = byteRange[1] + 1
+	} else {
+		return 0, 0, fmt.Errorf("invalid byte range (%d entries)", len(byteRange))
+	}
+	if startByte < 0 {
+		startByte = 0
+	}
+	if endByte > size {
+		endByte = size
+	}
+	return startByte, endByte, nil
+}
+
 func int64Min(v1 int64, v2 int64) int64 {
 	if v1 < v2 {
 		return v1
@@ -569,7 +802,14 @@ func (m *MServer) ProcessRpcPacket(pk packet.RpcPacketType) {
 		return
 	}
 	if streamPk, ok := pk.(*packet.StreamFilePacketType); ok {
-		go m.streamFile(streamPk)
+		// registered here (not in the goroutine) so an rpccancel that follows is never missed
+		ctx, doneFn := m.makeCancelableRpcCtx(streamPk.ReqId)
+		sender, unregisterFn := m.makeStreamSender(ctx, streamPk)
+		go func() {
+			defer doneFn()
+			defer unregisterFn()
+			m.streamFile(sender, streamPk)
+		}()
 		return
 	}
 	if writePk, ok := pk.(*packet.WriteFilePacketType); ok {
@@ -577,6 +817,39 @@ func (m *MServer) ProcessRpcPacket(pk packet.RpcPacketType) {
 		go m.writeFile(writePk, wfc)
 		return
 	}
+	if watchPk, ok := pk.(*packet.WatchFilePacketType); ok {
+		m.watchFile(watchPk)
+		return
+	}
+	if listPk, ok := pk.(*packet.FileListPacketType); ok {
+		go m.fileList(listPk)
+		return
+	}
+	if hashPk, ok := pk.(*packet.FileBlockHashPacketType); ok {
+		go m.fileBlockHash(hashPk)
+		return
+	}
+	if opPk, ok := pk.(*packet.FileOpPacketType); ok {
+		go m.fileOp(opPk)
+		return
+	}
+	if searchPk, ok := pk.(*packet.FileSearchPacketType); ok {
+		go m.fileSearch(searchPk)
+		return
+	}
+	if archivePk, ok := pk.(*packet.ArchiveListPacketType); ok {
+		go m.archiveList(archivePk)
+		return
+	}
+	if duPk, ok := pk.(*packet.DiskUsagePacketType); ok {
+		// registered here (not in the goroutine) so an rpccancel that follows is never missed
+		ctx, doneFn := m.makeCancelableRpcCtx(duPk.ReqId)
+		go func() {
+			defer doneFn()
+			m.diskUsage(ctx, duPk)
+		}()
+		return
+	}
 	m.Sender.SendErrorResponse(reqId, fmt.Errorf("invalid rpc type '%s'", pk.GetType()))
 	return
 }
@@ -686,6 +959,14 @@ func (server *MServer) runReadLoop() {
 			server.addFileDataPacket(fileDataPk)
 			continue
 		}
+		if ackPk, ok := pk.(*packet.FileDataAckPacketType); ok {
+			server.addStreamAck(ackPk)
+			continue
+		}
+		if cancelPk, ok := pk.(*packet.RpcCancelPacketType); ok {
+			server.cancelRpc(cancelPk.CancelId)
+			continue
+		}
 		server.Sender.SendMessageFmt("invalid packet '%s' sent to mshell server", packet.AsString(pk))
 		continue
 	}
@@ -704,6 +985,8 @@ func RunServer() (int, error) {
 		WriteErrorCh:        make(chan bool),
 		WriteErrorChOnce:    &sync.Once{},
 		WriteFileContextMap: make(map[string]*WriteFileContext),
+		RpcCancelMap:        make(map[string]context.CancelFunc),
+		StreamAckMap:        make(map[string]chan int),
 	}
 	go func() {
 		for {
@@ -719,6 +1002,7 @@ func RunServer() (int, error) {
 	}
 	server.MainInput = packet.MakePacketParser(os.Stdin, false)
 	server.Sender = packet.MakePacketSender(os.Stdout, server.packetSenderErrorHandler)
+	server.FileWatcher = MakeFileWatcher(server.Sender)
 	defer server.Close()
 	var err error
 	initPacket, err := shexec.MakeServerInitPacket()
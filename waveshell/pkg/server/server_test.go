@@ -0,0 +1,67 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+func TestStreamSenderAckWindow(t *testing.T) {
+	m := &MServer{Lock: &sync.Mutex{}, StreamAckMap: make(map[string]chan int)}
+	m.Sender = packet.MakePacketSender(io.Discard, nil)
+	defer m.Sender.Close()
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = "stream-test"
+	streamPk.AckData = true
+	sender, unregisterFn := m.makeStreamSender(ctx, streamPk)
+	defer unregisterFn()
+
+	for idx := 0; idx < packet.StreamFileAckWindow; idx++ {
+		err := sender.send(packet.MakeFileDataPacket(streamPk.ReqId))
+		if err != nil {
+			t.Fatalf("send %d within the window: %v", idx, err)
+		}
+	}
+	// the window is full, the next send waits for an ack
+	sendErrCh := make(chan error, 1)
+	go func() {
+		sendErrCh <- sender.send(packet.MakeFileDataPacket(streamPk.ReqId))
+	}()
+	select {
+	case err := <-sendErrCh:
+		t.Fatalf("send should block with a full window, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.addStreamAck(packet.MakeFileDataAckPacket(streamPk.ReqId, MaxFileDataPacketSize))
+	select {
+	case err := <-sendErrCh:
+		if err != nil {
+			t.Fatalf("send after ack: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("send should continue after an ack")
+	}
+
+	// canceling the rpc stops a blocked send
+	go func() {
+		sendErrCh <- sender.send(packet.MakeFileDataPacket(streamPk.ReqId))
+	}()
+	cancelFn()
+	select {
+	case err := <-sendErrCh:
+		if err == nil {
+			t.Fatalf("send should fail after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("send should return after cancel")
+	}
+}
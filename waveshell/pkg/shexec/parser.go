@@ -455,7 +455,6 @@ func ParseShellStateOutput(outputBytes []byte) (*packet.ShellState, error) {
 	if strings.Index(rtn.Version, "bash") == -1 {
 		return nil, fmt.Errorf("invalid shell state output, only bash is supported")
 	}
-	rtn.Version = rtn.Version
 	cwdStr := string(fields[1])
 	if strings.HasSuffix(cwdStr, "\r\n") {
 		cwdStr = cwdStr[0 : len(cwdStr)-2]
@@ -794,7 +794,6 @@ func RunInstallFromCmd(ctx context.Context, ecmd *exec.Cmd, tryDetect bool, mshe
 		}
 		return fmt.Errorf("invalid response packet '%s' received from client", pk.GetType())
 	}
-	return fmt.Errorf("did not receive version string from client, install not successful")
 }
 
 func RunInstallFromOpts(opts *InstallOpts) error {
@@ -6,12 +6,15 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"math"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -62,6 +65,8 @@ const TelemetryInterval = 8 * time.Hour
 
 const MaxWriteFileMemSize = 20 * (1024 * 1024) // 20M
 
+const ErrCodeWriteConflict = "conflict"
+
 var GlobalLock = &sync.Mutex{}
 var WSStateMap = make(map[string]*scws.WSState) // clientid -> WsState
 var GlobalAuthKey string
@@ -311,7 +316,36 @@ func HandleGetPtyOut(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(fmt.Sprintf("invalid lineid: %v", err)))
 		return
 	}
-	realOffset, data, err := sstore.ReadFullPtyOutFile(r.Context(), screenId, lineId)
+	// optional time range (ms): startts/endts for output written in the range, atts for the output as of that time
+	var tsVals [3]int64
+	for idx, name := range []string{"startts", "endts", "atts"} {
+		if qvals.Get(name) == "" {
+			continue
+		}
+		tsVal, err := strconv.ParseInt(qvals.Get(name), 10, 64)
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(fmt.Sprintf("invalid %s: %v", name, err)))
+			return
+		}
+		tsVals[idx] = tsVal
+	}
+	if qvals.Get("atts") == "" && qvals.Get("startts") == "" && qvals.Get("endts") == "" {
+		writeFullPtyOut(w, r, screenId, lineId)
+		return
+	}
+	var realOffset int64
+	var data []byte
+	var err error
+	if qvals.Get("atts") != "" {
+		realOffset, data, err = sstore.ReadPtyOutAtTime(r.Context(), screenId, lineId, tsVals[2])
+	} else {
+		endTs := tsVals[1]
+		if qvals.Get("endts") == "" {
+			endTs = math.MaxInt64
+		}
+		realOffset, data, err = sstore.ReadPtyOutBetween(r.Context(), screenId, lineId, tsVals[0], endTs)
+	}
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			w.WriteHeader(http.StatusOK)
@@ -321,16 +355,68 @@ func HandleGetPtyOut(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(fmt.Sprintf("error reading ptyout file: %v", err)))
 		return
 	}
+	if realOffset == sstore.PtyOutNoData {
+		// no output in the requested time range
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	w.Header().Set("X-PtyDataOffset", strconv.FormatInt(realOffset, 10))
 	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 }
 
+// streams all of the saved output (the overflow files are decompressed as they are sent, so this can be large)
+func writeFullPtyOut(w http.ResponseWriter, r *http.Request, screenId string, lineId string) {
+	realOffset, rc, err := sstore.ReadFullPtyOutFile(r.Context(), screenId, lineId)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(500)
+		w.Write([]byte(fmt.Sprintf("error reading ptyout file: %v", err)))
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("X-PtyDataOffset", strconv.FormatInt(realOffset, 10))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		log.Printf("error writing ptyout %s/%s: %v\n", screenId, lineId, err)
+	}
+}
+
+// returns the write timestamps for a line's pty output (used for replay)
+func HandleGetPtyTiming(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	lineId := qvals.Get("lineid")
+	if _, err := uuid.Parse(screenId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid screenid: %v", err))
+		return
+	}
+	if _, err := uuid.Parse(lineId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid lineid: %v", err))
+		return
+	}
+	entries, err := sstore.ReadPtyTiming(screenId, lineId)
+	if err != nil {
+		WriteJsonError(w, fmt.Errorf("error reading ptytiming file: %v", err))
+		return
+	}
+	if entries == nil {
+		entries = []sstore.PtyTimingEntry{}
+	}
+	WriteJsonSuccess(w, entries)
+}
+
 type writeFileParamsType struct {
-	ScreenId string `json:"screenid"`
-	LineId   string `json:"lineid"`
-	Path     string `json:"path"`
-	UseTemp  bool   `json:"usetemp,omitempty"`
+	ScreenId       string           `json:"screenid"`
+	LineId         string           `json:"lineid"`
+	Path           string           `json:"path"`
+	UseTemp        bool             `json:"usetemp,omitempty"`
+	ExpectedInfo   *packet.FileInfo `json:"expectedinfo,omitempty"`
+	ExpectedSha256 string           `json:"expectedsha256,omitempty"`
 }
 
 func parseWriteFileParams(r *http.Request) (*writeFileParamsType, multipart.File, error) {
@@ -416,6 +502,8 @@ func HandleWriteFile(w http.ResponseWriter, r *http.Request) {
 	writePk := packet.MakeWriteFilePacket()
 	writePk.ReqId = uuid.New().String()
 	writePk.UseTemp = params.UseTemp
+	writePk.ExpectedInfo = params.ExpectedInfo
+	writePk.ExpectedSha256 = params.ExpectedSha256
 	if filepath.IsAbs(fullPath) {
 		writePk.Path = fullPath
 	} else {
@@ -437,6 +525,10 @@ func HandleWriteFile(w http.ResponseWriter, r *http.Request) {
 		WriteJsonError(w, fmt.Errorf("bad ready packet received: %T", readyIf))
 		return
 	}
+	if readyPk.Conflict {
+		WriteJsonErrorWithCode(w, errors.New(readyPk.Error), ErrCodeWriteConflict, fileInfoData(readyPk.Info))
+		return
+	}
 	if readyPk.Error != "" {
 		WriteJsonError(w, fmt.Errorf("ready error: %s", readyPk.Error))
 		return
@@ -476,23 +568,53 @@ func HandleWriteFile(w http.ResponseWriter, r *http.Request) {
 		WriteJsonError(w, fmt.Errorf("bad done packet received: %T", doneIf))
 		return
 	}
+	if donePk.Conflict {
+		WriteJsonErrorWithCode(w, errors.New(donePk.Error), ErrCodeWriteConflict, fileInfoData(donePk.Info))
+		return
+	}
 	if donePk.Error != "" {
 		WriteJsonError(w, fmt.Errorf("dne error: %s", donePk.Error))
 		return
 	}
-	WriteJsonSuccess(w, nil)
+	WriteJsonSuccess(w, fileInfoData(donePk.Info))
 	return
 }
 
+// resolves path (relative to the line's cwd) on the line's remote
+func resolveLineFilePath(ctx context.Context, screenId string, lineId string, path string) (*remote.MShellProc, string, error) {
+	_, cmd, err := sstore.GetLineCmdByLineId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid lineid: %v", err)
+	}
+	if cmd == nil {
+		return nil, "", fmt.Errorf("invalid line, no cmd")
+	}
+	if cmd.Remote.RemoteId == "" {
+		return nil, "", fmt.Errorf("invalid line, no remote")
+	}
+	msh := remote.GetRemoteById(cmd.Remote.RemoteId)
+	if msh == nil {
+		return nil, "", fmt.Errorf("invalid line, cannot resolve remote")
+	}
+	rrState := msh.GetRemoteRuntimeState()
+	fullPath, err := rrState.ExpandHomeDir(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error expanding homedir: %v", err)
+	}
+	cwd := cmd.FeState["cwd"]
+	if !filepath.IsAbs(fullPath) {
+		fullPath = filepath.Join(cwd, fullPath)
+	}
+	return msh, fullPath, nil
+}
+
 func HandleReadFile(w http.ResponseWriter, r *http.Request) {
 	qvals := r.URL.Query()
 	screenId := qvals.Get("screenid")
 	lineId := qvals.Get("lineid")
-	path := qvals.Get("path") // validate path?
-	contentType := qvals.Get("mimetype")
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
+	path := qvals.Get("path")            // validate path?
+	contentType := qvals.Get("mimetype") // if not set, we sniff the content type
+	entry := qvals.Get("entry")          // if set, path is an archive and we read this entry from it
 	if screenId == "" || lineId == "" {
 		w.WriteHeader(500)
 		w.Write([]byte(fmt.Sprintf("must specify sessionid, screenid, and lineid")))
@@ -513,95 +635,350 @@ func HandleReadFile(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(fmt.Sprintf("invalid lineid: %v", err)))
 		return
 	}
-	if !ContentTypeHeaderValidRe.MatchString(contentType) {
+	if contentType != "" && !ContentTypeHeaderValidRe.MatchString(contentType) {
 		w.WriteHeader(500)
 		w.Write([]byte(fmt.Sprintf("invalid mimetype specified")))
 		return
 	}
-	_, cmd, err := sstore.GetLineCmdByLineId(r.Context(), screenId, lineId)
+	msh, fullPath, err := resolveLineFilePath(r.Context(), screenId, lineId, path)
 	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("invalid lineid: %v", err)))
+		w.Write([]byte(err.Error()))
 		return
 	}
-	if cmd == nil {
+	finfo, err := msh.StatArchiveEntry(r.Context(), fullPath, entry)
+	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("invalid line, no cmd")))
+		w.Write([]byte(fmt.Sprintf("error trying to stat file: %v", err)))
 		return
 	}
-	if cmd.Remote.RemoteId == "" {
+	if finfo.IsDir {
 		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("invalid line, no remote")))
+		w.Write([]byte(fmt.Sprintf("cannot read file, %q is a directory", fullPath)))
 		return
 	}
-	msh := remote.GetRemoteById(cmd.Remote.RemoteId)
-	if msh == nil {
-		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("invalid line, cannot resolve remote")))
+	infoJson, _ := json.Marshal(finfo)
+	w.Header().Set("X-FileInfo", base64.StdEncoding.EncodeToString(infoJson))
+	if finfo.NotFound {
+		// not an error, the frontend uses this to create new files
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	rrState := msh.GetRemoteRuntimeState()
-	fullPath, err := rrState.ExpandHomeDir(path)
-	if err != nil {
-		WriteJsonError(w, fmt.Errorf("error expanding homedir: %v", err))
-		return
+	etag := makeFileETag(finfo)
+	if entry != "" {
+		// the entry's mtime comes from the archive, so also tie the etag to the archive file itself
+		archiveInfo, err := msh.StatFile(r.Context(), fullPath)
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(fmt.Sprintf("error trying to stat file: %v", err)))
+			return
+		}
+		etag = fmt.Sprintf("W/\"%x-%x-%x-%x\"", archiveInfo.ModTs, archiveInfo.Size, finfo.ModTs, finfo.Size)
 	}
-	streamPk := packet.MakeStreamFilePacket()
-	streamPk.ReqId = uuid.New().String()
-	cwd := cmd.FeState["cwd"]
-	if filepath.IsAbs(fullPath) {
-		streamPk.Path = fullPath
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	// the file can change at any time, so caches must always revalidate
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	startByte, endByte := int64(0), finfo.Size
+	isRange := false
+	rangeHeader := r.Header.Get("Range")
+	// our etags are weak and we don't send Last-Modified, so an If-Range validator can never match
+	// (RFC 7233 3.2 requires a strong comparison).  send the full file whenever If-Range is present.
+	if rangeHeader != "" && r.Header.Get("If-Range") == "" {
+		var satisfiable bool
+		startByte, endByte, isRange, satisfiable = parseRangeHeader(rangeHeader, finfo.Size)
+		if !satisfiable {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", finfo.Size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+	// a single rpc for the whole range (archive entries are only decompressed once)
+	var firstChunk []byte
+	var stream *remote.FileStream
+	if startByte < endByte {
+		stream, err = msh.OpenFileStream(r.Context(), fullPath, entry, startByte, endByte)
+		if err == nil {
+			defer stream.Close()
+			firstChunk, err = stream.Next(r.Context())
+		}
+		if err == nil && len(firstChunk) == 0 {
+			err = fmt.Errorf("file changed while reading (short read at offset %d)", startByte)
+		}
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(fmt.Sprintf("error reading file: %v", err)))
+			return
+		}
+	}
+	if contentType == "" {
+		sniffPath := fullPath
+		if entry != "" {
+			sniffPath = entry
+		}
+		contentType = sniffContentType(sniffPath, firstChunk, startByte == 0)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(endByte-startByte, 10))
+	if isRange {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, endByte-1, finfo.Size))
+		w.WriteHeader(http.StatusPartialContent)
 	} else {
-		streamPk.Path = filepath.Join(cwd, fullPath)
+		w.WriteHeader(http.StatusOK)
+	}
+	if stream == nil {
+		return
+	}
+	// write each chunk as it arrives so a large file doesn't need to be buffered.  chunks are acked as they
+	// are read (see FileStream.Next), so a slow client holds back mshell instead of overflowing the rpc.
+	pos := startByte
+	for chunk := firstChunk; ; {
+		_, err = w.Write(chunk)
+		if err != nil {
+			log.Printf("error in read-file while writing data: %v\n", err)
+			return
+		}
+		pos += int64(len(chunk))
+		if pos >= endByte {
+			break
+		}
+		chunk, err = stream.Next(r.Context())
+		if err == io.EOF {
+			err = fmt.Errorf("file changed while reading (short read at offset %d)", pos)
+		}
+		if err != nil {
+			log.Printf("error in read-file while getting data: %v\n", err)
+			return
+		}
 	}
-	iter, err := msh.StreamFile(r.Context(), streamPk)
+	return
+}
+
+// weak etag from modtime and size (same as nginx)
+func makeFileETag(finfo *packet.FileInfo) string {
+	return fmt.Sprintf("W/\"%x-%x\"", finfo.ModTs, finfo.Size)
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	// weak comparison
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, val := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(val), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// only single ranges are supported (multiple ranges are served as the full file, which the RFC allows).
+// returns [start, end) (end is non-inclusive), isRange, and satisfiable.
+func parseRangeHeader(rangeHeader string, size int64) (int64, int64, bool, bool) {
+	if !strings.HasPrefix(rangeHeader, "bytes=") || strings.Contains(rangeHeader, ",") {
+		return 0, size, false, true
+	}
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(rangeHeader[len("bytes="):]), "-")
+	if !found {
+		return 0, size, false, true
+	}
+	if startStr == "" {
+		// suffix range, "bytes=-500" is the last 500 bytes
+		suffixLen, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffixLen < 0 {
+			return 0, size, false, true
+		}
+		if suffixLen == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		if suffixLen > size {
+			suffixLen = size
+		}
+		return size - suffixLen, size, true, true
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, true
+	}
+	end := size
+	if endStr != "" {
+		lastByte, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || lastByte < start {
+			return 0, size, false, true
+		}
+		if lastByte+1 < size {
+			end = lastByte + 1
+		}
+	}
+	if start >= size {
+		return 0, 0, true, false
+	}
+	return start, end, true, true
+}
+
+// prefers the extension, then sniffs the data (only valid from the start of the file)
+func sniffContentType(path string, data []byte, isFileStart bool) string {
+	if extType := mime.TypeByExtension(filepath.Ext(path)); extType != "" {
+		return extType
+	}
+	if isFileStart && len(data) > 0 {
+		return http.DetectContentType(data)
+	}
+	return "application/octet-stream"
+}
+
+func HandleListArchive(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	lineId := qvals.Get("lineid")
+	path := qvals.Get("path")
+	if _, err := uuid.Parse(screenId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid screenid: %v", err))
+		return
+	}
+	if _, err := uuid.Parse(lineId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid lineid: %v", err))
+		return
+	}
+	if path == "" {
+		WriteJsonError(w, fmt.Errorf("must specify path"))
+		return
+	}
+	msh, fullPath, err := resolveLineFilePath(r.Context(), screenId, lineId, path)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("error trying to stream file: %v", err)))
+		WriteJsonError(w, err)
 		return
 	}
-	defer iter.Close()
-	respIf, err := iter.Next(r.Context())
+	info, entries, err := msh.ListArchive(r.Context(), fullPath)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("error getting streamfile response: %v", err)))
+		WriteJsonError(w, err)
 		return
 	}
-	resp, ok := respIf.(*packet.StreamFileResponseType)
-	if !ok {
-		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("bad response packet type: %T", respIf)))
+	rtn := map[string]interface{}{
+		"info":    info,
+		"entries": entries,
+	}
+	WriteJsonSuccess(w, rtn)
+	return
+}
+
+// starts (or returns the running) disk usage scan for the line.  the scan runs in the background and is
+// polled, "from" is the number of entries the caller already has.  "restart" starts a new scan, "cancel" cancels it.
+func HandleDiskUsage(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	lineId := qvals.Get("lineid")
+	path := qvals.Get("path")
+	if _, err := uuid.Parse(screenId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid screenid: %v", err))
 		return
 	}
-	if resp.Error != "" {
-		w.WriteHeader(500)
-		w.Write([]byte(fmt.Sprintf("error response: %s", resp.Error)))
+	if _, err := uuid.Parse(lineId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid lineid: %v", err))
 		return
 	}
-	infoJson, _ := json.Marshal(resp.Info)
-	w.Header().Set("X-FileInfo", base64.StdEncoding.EncodeToString(infoJson))
-	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(http.StatusOK)
-	for {
-		dataPkIf, err := iter.Next(r.Context())
+	if path == "" {
+		WriteJsonError(w, fmt.Errorf("must specify path"))
+		return
+	}
+	var fromEntry int
+	if qvals.Get("from") != "" {
+		var err error
+		fromEntry, err = strconv.Atoi(qvals.Get("from"))
 		if err != nil {
-			log.Printf("error in read-file while getting data: %v\n", err)
-			break
+			WriteJsonError(w, fmt.Errorf("invalid from: %v", err))
+			return
 		}
-		if dataPkIf == nil {
-			break
+	}
+	scanKey := screenId + "/" + lineId
+	if qvals.Get("cancel") == "1" {
+		scan := remote.GetDiskUsageScan(scanKey)
+		if scan == nil {
+			WriteJsonError(w, fmt.Errorf("no disk usage scan found"))
+			return
 		}
-		dataPk, ok := dataPkIf.(*packet.FileDataPacketType)
-		if !ok {
-			log.Printf("error in read-file, invalid data packet type: %T", dataPkIf)
-			break
+		scan.Cancel()
+		WriteJsonSuccess(w, nil)
+		return
+	}
+	msh, fullPath, err := resolveLineFilePath(r.Context(), screenId, lineId, path)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	opts := remote.DiskUsageOpts{OneFs: qvals.Get("onefs") == "1"}
+	scan := remote.StartDiskUsageScan(msh, scanKey, fullPath, opts, qvals.Get("restart") == "1")
+	WriteJsonSuccess(w, scan.GetSnapshot(fromEntry))
+	return
+}
+
+// pattern is hex encoded.  returns the offsets of the matches at or after offset.
+func HandleSearchFile(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	lineId := qvals.Get("lineid")
+	path := qvals.Get("path")
+	if _, err := uuid.Parse(screenId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid screenid: %v", err))
+		return
+	}
+	if _, err := uuid.Parse(lineId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid lineid: %v", err))
+		return
+	}
+	if path == "" {
+		WriteJsonError(w, fmt.Errorf("must specify path"))
+		return
+	}
+	pattern, err := hex.DecodeString(qvals.Get("pattern"))
+	if err != nil || len(pattern) == 0 {
+		WriteJsonError(w, fmt.Errorf("invalid pattern, must be a non-empty hex string"))
+		return
+	}
+	var offset int64
+	if qvals.Get("offset") != "" {
+		offset, err = strconv.ParseInt(qvals.Get("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			WriteJsonError(w, fmt.Errorf("invalid offset"))
+			return
 		}
-		if dataPk.Error != "" {
-			log.Printf("in read-file, data packet error: %s", dataPk.Error)
-			break
+	}
+	var maxResults int
+	if qvals.Get("maxresults") != "" {
+		maxResults, err = strconv.Atoi(qvals.Get("maxresults"))
+		if err != nil {
+			WriteJsonError(w, fmt.Errorf("invalid maxresults"))
+			return
 		}
-		w.Write(dataPk.Data)
 	}
+	msh, fullPath, err := resolveLineFilePath(r.Context(), screenId, lineId, path)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	resp, err := msh.SearchFile(r.Context(), fullPath, pattern, offset, maxResults)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	rtn := map[string]interface{}{
+		"offsets":   resp.Offsets,
+		"truncated": resp.Truncated,
+		"info":      resp.Info,
+	}
+	WriteJsonSuccess(w, rtn)
 	return
 }
 
@@ -615,6 +992,29 @@ func WriteJsonError(w http.ResponseWriter, errVal error) {
 	return
 }
 
+// errCode lets the client distinguish errors it can act on (e.g. ErrCodeWriteConflict)
+func WriteJsonErrorWithCode(w http.ResponseWriter, errVal error, errCode string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	errMap := make(map[string]interface{})
+	errMap["error"] = errVal.Error()
+	errMap["errorcode"] = errCode
+	if data != nil {
+		errMap["data"] = data
+	}
+	barr, _ := json.Marshal(errMap)
+	w.Write(barr)
+	return
+}
+
+// a nil *FileInfo inside an interface{} is not == nil, so it would be written as "data": null
+func fileInfoData(info *packet.FileInfo) interface{} {
+	if info == nil {
+		return nil
+	}
+	return info
+}
+
 func WriteJsonSuccess(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	rtnMap := make(map[string]interface{})
@@ -810,6 +1210,11 @@ func main() {
 		return
 	}
 	GlobalAuthKey = authKey
+	_, err = sstore.ApplyStagedDBRestore()
+	if err != nil {
+		log.Printf("[error] restoring db backup: %v\n", err)
+		return
+	}
 	err = sstore.TryMigrateUp()
 	if err != nil {
 		log.Printf("[error] migrate up: %v\n", err)
@@ -821,6 +1226,7 @@ func main() {
 		return
 	}
 	log.Printf("userid = %s\n", clientData.UserId)
+	sstore.SetPtyOverflowBudget(clientData.ClientOpts.PtyOverflowBudget)
 	err = sstore.EnsureLocalRemote(context.Background())
 	if err != nil {
 		log.Printf("[error] ensuring local remote: %v\n", err)
@@ -837,6 +1243,9 @@ func main() {
 		return
 	}
 
+	go sstore.RunOutputIndexFlusher()
+	go cmdrunner.RunRetentionLoop()
+	go cmdrunner.RunDBBackupLoop()
 	err = sstore.HangupAllRunningCmds(context.Background())
 	if err != nil {
 		log.Printf("[error] calling HUP on all running commands: %v\n", err)
@@ -861,6 +1270,7 @@ func main() {
 	}()
 	gr := mux.NewRouter()
 	gr.HandleFunc("/api/ptyout", AuthKeyWrap(HandleGetPtyOut))
+	gr.HandleFunc("/api/ptytiming", AuthKeyWrap(HandleGetPtyTiming))
 	gr.HandleFunc("/api/remote-pty", AuthKeyWrap(HandleRemotePty))
 	gr.HandleFunc("/api/rtnstate", AuthKeyWrap(HandleRtnState))
 	gr.HandleFunc("/api/get-screen-lines", AuthKeyWrap(HandleGetScreenLines))
@@ -869,6 +1279,9 @@ func main() {
 	gr.HandleFunc("/api/set-winsize", AuthKeyWrap(HandleSetWinSize))
 	gr.HandleFunc("/api/log-active-state", AuthKeyWrap(HandleLogActiveState))
 	gr.HandleFunc("/api/read-file", AuthKeyWrap(HandleReadFile))
+	gr.HandleFunc("/api/search-file", AuthKeyWrap(HandleSearchFile))
+	gr.HandleFunc("/api/list-archive", AuthKeyWrap(HandleListArchive))
+	gr.HandleFunc("/api/disk-usage", AuthKeyWrap(HandleDiskUsage))
 	gr.HandleFunc("/api/write-file", AuthKeyWrap(HandleWriteFile)).Methods("POST")
 	serverAddr := MainServerAddr
 	if scbase.IsDevMode() {
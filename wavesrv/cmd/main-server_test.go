@@ -0,0 +1,52 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		header      string
+		size        int64
+		start       int64
+		end         int64
+		isRange     bool
+		satisfiable bool
+	}{
+		{"bytes=0-99", 1000, 0, 100, true, true},
+		{"bytes=100-199", 1000, 100, 200, true, true},
+		{"bytes=900-5000", 1000, 900, 1000, true, true},
+		{"bytes=999-999", 1000, 999, 1000, true, true},
+		// open-ended
+		{"bytes=500-", 1000, 500, 1000, true, true},
+		{"bytes=0-", 1000, 0, 1000, true, true},
+		{"bytes=1000-", 1000, 0, 0, true, false},
+		{"bytes=2000-3000", 1000, 0, 0, true, false},
+		// suffix
+		{"bytes=-100", 1000, 900, 1000, true, true},
+		{"bytes=-5000", 1000, 0, 1000, true, true},
+		{"bytes=-0", 1000, 0, 0, true, false},
+		{"bytes=-10", 0, 0, 0, true, false},
+		// multi-range is served as the full file
+		{"bytes=0-10,20-30", 1000, 0, 1000, false, true},
+		{"bytes=0-10, -5", 1000, 0, 1000, false, true},
+		// invalid headers are ignored (full file)
+		{"", 1000, 0, 1000, false, true},
+		{"items=0-10", 1000, 0, 1000, false, true},
+		{"bytes=10", 1000, 0, 1000, false, true},
+		{"bytes=abc-def", 1000, 0, 1000, false, true},
+		{"bytes=50-10", 1000, 0, 1000, false, true},
+		{"bytes=-abc", 1000, 0, 1000, false, true},
+		{"bytes=--5", 1000, 0, 1000, false, true},
+	}
+	for _, test := range tests {
+		start, end, isRange, satisfiable := parseRangeHeader(test.header, test.size)
+		if start != test.start || end != test.end || isRange != test.isRange || satisfiable != test.satisfiable {
+			t.Errorf("parseRangeHeader(%q, %d) = (%d, %d, %v, %v), expected (%d, %d, %v, %v)", test.header, test.size,
+				start, end, isRange, satisfiable, test.start, test.end, test.isRange, test.satisfiable)
+		}
+	}
+}
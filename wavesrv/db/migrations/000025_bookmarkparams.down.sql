@@ -0,0 +1 @@
+ALTER TABLE bookmark DROP COLUMN paramvalues;
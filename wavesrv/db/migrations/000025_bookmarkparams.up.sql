@@ -0,0 +1 @@
+ALTER TABLE bookmark ADD COLUMN paramvalues json NOT NULL DEFAULT '{}';
@@ -0,0 +1 @@
+DROP TABLE bookmark_library;
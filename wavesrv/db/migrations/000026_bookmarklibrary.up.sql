@@ -0,0 +1,5 @@
+CREATE TABLE bookmark_library (
+    name varchar(50) PRIMARY KEY,
+    path text NOT NULL,
+    createdts bigint NOT NULL
+);
@@ -0,0 +1,10 @@
+CREATE TABLE trash (
+    trashid varchar(36) PRIMARY KEY,
+    trashtype varchar(10) NOT NULL,
+    name varchar(300) NOT NULL,
+    sessionid varchar(36) NOT NULL,
+    screenid varchar(36) NOT NULL,
+    numlines int NOT NULL,
+    trashts bigint NOT NULL,
+    data json NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE cmd_output_idx;
+DROP TABLE cmd_output_fts;
@@ -0,0 +1,9 @@
+CREATE VIRTUAL TABLE cmd_output_fts USING fts4(output, tokenize=unicode61);
+
+CREATE TABLE cmd_output_idx (
+    ftsrowid integer PRIMARY KEY,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    ts bigint NOT NULL
+);
+CREATE INDEX idx_cmd_output_idx_line ON cmd_output_idx (screenid, lineid);
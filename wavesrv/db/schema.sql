@@ -88,7 +88,7 @@ CREATE TABLE bookmark (
     alias varchar(50) NOT NULL,
     tags json NOT NULL,
     description text NOT NULL
-);
+, paramvalues json NOT NULL DEFAULT '{}');
 CREATE TABLE bookmark_order (
     tag varchar(50) NOT NULL,
     bookmarkid varchar(36) NOT NULL,
@@ -217,3 +217,26 @@ CREATE TABLE cmd_migrate20 (
     cmdid varchar(36) NOT NULL,
     PRIMARY KEY (screenid, lineid)
 );
+CREATE TABLE bookmark_library (
+    name varchar(50) PRIMARY KEY,
+    path text NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE TABLE trash (
+    trashid varchar(36) PRIMARY KEY,
+    trashtype varchar(10) NOT NULL,
+    name varchar(300) NOT NULL,
+    sessionid varchar(36) NOT NULL,
+    screenid varchar(36) NOT NULL,
+    numlines int NOT NULL,
+    trashts bigint NOT NULL,
+    data json NOT NULL
+);
+CREATE VIRTUAL TABLE cmd_output_fts USING fts4(output, tokenize=unicode61);
+CREATE TABLE cmd_output_idx (
+    ftsrowid integer PRIMARY KEY,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    ts bigint NOT NULL
+);
+CREATE INDEX idx_cmd_output_idx_line ON cmd_output_idx (screenid, lineid);
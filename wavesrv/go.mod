@@ -1,6 +1,6 @@
 module github.com/wavetermdev/waveterm/wavesrv
 
-go 1.18
+go 1.22
 
 require (
 	github.com/alessio/shellescape v1.4.1
@@ -11,6 +11,7 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.5.0
 	github.com/jmoiron/sqlx v1.3.5
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/sashabaranov/go-openai v1.9.0
 	github.com/sawka/txwrap v0.1.2
@@ -18,6 +19,7 @@ require (
 	golang.org/x/crypto v0.7.0
 	golang.org/x/mod v0.10.0
 	golang.org/x/sys v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 	mvdan.cc/sh/v3 v3.7.0
 )
 
@@ -0,0 +1,150 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// returns an error for library bookmarks (they can only be changed in the library file)
+func checkBookmarkNotLibrary(ctx context.Context, bookmarkArg string) error {
+	libBm, err := sstore.GetLibraryBookmarkByArg(ctx, bookmarkArg)
+	if err != nil {
+		return fmt.Errorf("error trying to resolve bookmark: %v", err)
+	}
+	if libBm != nil {
+		return fmt.Errorf("bookmark is read-only (from bookmark library %q)", libBm.Library)
+	}
+	return nil
+}
+
+func BookmarkExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /bookmark:export [tag=tag] [path]")
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:export %v", err)
+	}
+	bmFile, err := sstore.ExportBookmarks(ctx, pk.Kwargs["tag"])
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:export cannot retrieve bookmarks: %v", err)
+	}
+	barr, err := sstore.MarshalBookmarkFile(bmFile, sstore.IsYamlBookmarkFile(fullPath))
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:export cannot serialize bookmarks: %v", err)
+	}
+	err = os.WriteFile(fullPath, barr, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:export cannot write %q: %v", fullPath, err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("exported %d bookmarks to %q", len(bmFile.Bookmarks), fullPath),
+			TimeoutMs: 5000,
+		},
+	}
+	return update, nil
+}
+
+func BookmarkImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /bookmark:import [tag=tag] [path]")
+	}
+	extraTag := pk.Kwargs["tag"]
+	if extraTag != "" {
+		if err := validateName(extraTag, "tag"); err != nil {
+			return nil, fmt.Errorf("/bookmark:import %v", err)
+		}
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:import %v", err)
+	}
+	bmFile, err := sstore.ReadBookmarkFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:import cannot read %q: %v", fullPath, err)
+	}
+	numImported, err := sstore.ImportBookmarks(ctx, bmFile, extraTag)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:import error inserting bookmarks: %v", err)
+	}
+	bms, err := sstore.GetBookmarks(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:import cannot retrieve bookmarks: %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("imported %d bookmarks from %q, %d duplicates skipped", numImported, fullPath, len(bmFile.Bookmarks)-numImported),
+			TimeoutMs: 5000,
+		},
+		Bookmarks: bms,
+	}
+	return update, nil
+}
+
+// /bookmark:library lists libraries, /bookmark:library [name] [path] adds (or updates) a library,
+// and /bookmark:library remove=1 [name] removes one
+func BookmarkLibraryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		libs, err := sstore.GetBookmarkLibraries(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("/bookmark:library error: %v", err)
+		}
+		if len(libs) == 0 {
+			return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: "no bookmark libraries (add one with /bookmark:library [name] [path])"}}, nil
+		}
+		var buf bytes.Buffer
+		for _, lib := range libs {
+			bms, err := sstore.ReadBookmarkLibrary(lib)
+			if err != nil {
+				buf.WriteString(fmt.Sprintf("%-20s  %s  (error: %v)\n", lib.Name, lib.Path, err))
+				continue
+			}
+			buf.WriteString(fmt.Sprintf("%-20s  %s  (%d bookmarks)\n", lib.Name, lib.Path, len(bms)))
+		}
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoTitle: "bookmark libraries", InfoLines: splitLinesForInfo(buf.String())}}, nil
+	}
+	libName := pk.Args[0]
+	if resolveBool(pk.Kwargs["remove"], false) {
+		if len(pk.Args) != 1 {
+			return nil, fmt.Errorf("usage: /bookmark:library remove=1 [name]")
+		}
+		err := sstore.RemoveBookmarkLibrary(ctx, libName)
+		if err != nil {
+			return nil, fmt.Errorf("/bookmark:library %v", err)
+		}
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: fmt.Sprintf("bookmark library %q removed", libName)}}, nil
+	}
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /bookmark:library [name] [path]")
+	}
+	if err := validateName(libName, "library"); err != nil {
+		return nil, fmt.Errorf("/bookmark:library %v", err)
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:library %v", err)
+	}
+	bmFile, err := sstore.ReadBookmarkFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:library cannot read %q: %v", fullPath, err)
+	}
+	err = sstore.SetBookmarkLibrary(ctx, libName, fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmark:library error saving library: %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg: fmt.Sprintf("bookmark library %q set to %q (%d bookmarks)", libName, fullPath, len(bmFile.Bookmarks)),
+		},
+	}
+	return update, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestFrecencyScore(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		Age      time.Duration
+		Expected float64
+	}{
+		{time.Minute, 40},
+		{2 * time.Hour, 20},
+		{3 * 24 * time.Hour, 5},
+		{30 * 24 * time.Hour, 2.5},
+	}
+	for _, test := range tests {
+		stat := &sstore.HistoryDirStats{Cwd: "/tmp", NumCmds: 10, LastTs: now.Add(-test.Age).UnixMilli()}
+		if score := frecencyScore(stat, now); score != test.Expected {
+			t.Errorf("frecencyScore(age %v) = %v, expected %v", test.Age, score, test.Expected)
+		}
+	}
+}
+
+func getCdJumpTestDirs(stats []*sstore.HistoryDirStats, fragments []string, curDir string) []string {
+	var rtn []string
+	for _, candidate := range matchCdJumpDirs(stats, fragments, curDir) {
+		rtn = append(rtn, candidate.Dir)
+	}
+	return rtn
+}
+
+func TestMatchCdJumpDirs(t *testing.T) {
+	now := time.Now()
+	makeStat := func(cwd string, numCmds int64, age time.Duration) *sstore.HistoryDirStats {
+		return &sstore.HistoryDirStats{Cwd: cwd, NumCmds: numCmds, LastTs: now.Add(-age).UnixMilli()}
+	}
+	stats := []*sstore.HistoryDirStats{
+		makeStat("/home/user/src/waveterm", 5, 2*time.Hour),
+		makeStat("/home/user/src/waveterm/wavesrv", 50, time.Minute),
+		makeStat("/home/user/wave/src", 10, time.Minute),
+		makeStat("/home/user/Docs", 1, 30*24*time.Hour),
+		makeStat("/home/user/docs", 1, 30*24*time.Hour),
+		makeStat("/tmp/axb", 1, time.Minute),
+		makeStat("/tmp/a.b", 1, time.Minute),
+		makeStat("relative/waveterm", 100, time.Minute),
+	}
+	tests := []struct {
+		Name      string
+		Fragments []string
+		CurDir    string
+		Expected  []string
+	}{
+		// dirs whose last component matches the last fragment come first, even with a lower score
+		{"base preferred", []string{"waveterm"}, "", []string{"/home/user/src/waveterm", "/home/user/src/waveterm/wavesrv"}},
+		{"base by score", []string{"wave"}, "", []string{"/home/user/src/waveterm/wavesrv", "/home/user/src/waveterm", "/home/user/wave/src"}},
+		// src/waveterm has both fragments, but not in order
+		{"in order", []string{"wave", "src"}, "", []string{"/home/user/wave/src"}},
+		{"current dir skipped", []string{"waveterm"}, "/home/user/src/waveterm", []string{"/home/user/src/waveterm/wavesrv"}},
+		{"case insensitive", []string{"docs"}, "", []string{"/home/user/Docs", "/home/user/docs"}},
+		{"case sensitive", []string{"Docs"}, "", []string{"/home/user/Docs"}},
+		{"literal fragments", []string{"a.b"}, "", []string{"/tmp/a.b"}},
+		{"no match", []string{"nothing"}, "", nil},
+		// with no fragments everything (absolute) matches, sorted by score
+		{"all", nil, "", []string{
+			"/home/user/src/waveterm/wavesrv", "/home/user/wave/src", "/home/user/src/waveterm",
+			"/tmp/a.b", "/tmp/axb", "/home/user/Docs", "/home/user/docs",
+		}},
+	}
+	for _, test := range tests {
+		dirs := getCdJumpTestDirs(stats, test.Fragments, test.CurDir)
+		if !reflect.DeepEqual(dirs, test.Expected) {
+			t.Errorf("%s: got %v, expected %v", test.Name, dirs, test.Expected)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package cmdrunner
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -20,6 +21,7 @@ import (
 	"syscall"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
@@ -158,6 +160,8 @@ func init() {
 	registerCmdFn("session:showall", SessionShowAllCommand)
 	registerCmdFn("session:show", SessionShowCommand)
 	registerCmdFn("session:openshared", SessionOpenSharedCommand)
+	registerCmdFn("session:export", SessionExportCommand)
+	registerCmdFn("session:import", SessionImportCommand)
 
 	registerCmdFn("screen", ScreenCommand)
 	registerCmdFn("screen:archive", ScreenArchiveCommand)
@@ -168,6 +172,7 @@ func init() {
 	registerCmdFn("screen:showall", ScreenShowAllCommand)
 	registerCmdFn("screen:reset", ScreenResetCommand)
 	registerCmdFn("screen:webshare", ScreenWebShareCommand)
+	registerCmdFn("screen:export", ScreenExportCommand)
 
 	registerCmdAlias("remote", RemoteCommand)
 	registerCmdFn("remote:show", RemoteShowCommand)
@@ -193,13 +198,25 @@ func init() {
 	registerCmdFn("line:setheight", LineSetHeightCommand)
 	registerCmdFn("line:view", LineViewCommand)
 	registerCmdFn("line:set", LineSetCommand)
+	registerCmdFn("line:export", LineExportCommand)
+	registerCmdFn("line:import", LineImportCommand)
 
 	registerCmdFn("client", ClientCommand)
 	registerCmdFn("client:show", ClientShowCommand)
 	registerCmdFn("client:set", ClientSetCommand)
+	registerCmdFn("client:retention", ClientRetentionCommand)
+	registerCmdFn("client:backup", ClientBackupCommand)
+	registerCmdFn("client:vacuum", ClientVacuumCommand)
+	registerCmdFn("client:dbcheck", ClientDBCheckCommand)
+	registerCmdFn("client:restorebackup", ClientRestoreBackupCommand)
 	registerCmdFn("client:notifyupdatewriter", ClientNotifyUpdateWriterCommand)
 	registerCmdFn("client:accepttos", ClientAcceptTosCommand)
 
+	registerCmdFn("trash", TrashShowCommand)
+	registerCmdFn("trash:show", TrashShowCommand)
+	registerCmdFn("trash:restore", TrashRestoreCommand)
+	registerCmdFn("trash:empty", TrashEmptyCommand)
+
 	registerCmdFn("telemetry", TelemetryCommand)
 	registerCmdFn("telemetry:on", TelemetryOnCommand)
 	registerCmdFn("telemetry:off", TelemetryOffCommand)
@@ -209,11 +226,26 @@ func init() {
 	registerCmdFn("history", HistoryCommand)
 	registerCmdFn("history:viewall", HistoryViewAllCommand)
 	registerCmdFn("history:purge", HistoryPurgeCommand)
+	registerCmdFn("history:search", HistorySearchCommand)
+	registerCmdFn("history:import", HistoryImportCommand)
+	registerCmdFn("history:stats", HistoryStatsCommand)
+	registerCmdFn("cd-jump", CdJumpCommand)
 
 	registerCmdFn("bookmarks:show", BookmarksShowCommand)
 
 	registerCmdFn("bookmark:set", BookmarkSetCommand)
 	registerCmdFn("bookmark:delete", BookmarkDeleteCommand)
+	registerCmdFn("bookmark:run", BookmarkRunCommand)
+	registerCmdFn("bookmark:export", BookmarkExportCommand)
+	registerCmdFn("bookmark:import", BookmarkImportCommand)
+	registerCmdFn("bookmark:library", BookmarkLibraryCommand)
+
+	registerCmdFn("playbook:new", PlaybookNewCommand)
+	registerCmdFn("playbook:add", PlaybookAddCommand)
+	registerCmdFn("playbook:show", PlaybookShowCommand)
+	registerCmdFn("playbook:remove", PlaybookRemoveCommand)
+	registerCmdFn("playbook:run", PlaybookRunCommand)
+	registerCmdFn("playbook:cancel", PlaybookCancelCommand)
 
 	registerCmdFn("chat", OpenAICommand)
 
@@ -223,6 +255,9 @@ func init() {
 
 	registerCmdFn("view:stat", ViewStatCommand)
 	registerCmdFn("view:test", ViewTestCommand)
+	registerCmdFn("view:hex", ViewHexCommand)
+	registerCmdFn("view:archive", ViewArchiveCommand)
+	registerCmdFn("view:du", ViewDuCommand)
 
 	registerCmdFn("edit:test", EditTestCommand)
 
@@ -235,6 +270,9 @@ func init() {
 	registerCmdFn("markdownview", MarkdownViewCommand)
 
 	registerCmdFn("csvview", CSVViewCommand)
+
+	registerCmdFn("file:sync", FileSyncCommand)
+	registerCmdFn("file:copy", FileCopyCommand)
 }
 
 func getValidCommands() []string {
@@ -371,6 +409,34 @@ func resolveNonNegInt(arg string, def int) (int, error) {
 	return ival, nil
 }
 
+// parses a size like "512", "100k", "20m" or "2g" (binary units)
+func resolveByteSize(arg string) (int64, error) {
+	arg = strings.ToLower(strings.TrimSpace(arg))
+	arg = strings.TrimSuffix(arg, "b")
+	var mult int64 = 1
+	if len(arg) > 0 {
+		switch arg[len(arg)-1] {
+		case 'k':
+			mult = 1024
+		case 'm':
+			mult = 1024 * 1024
+		case 'g':
+			mult = 1024 * 1024 * 1024
+		}
+	}
+	if mult != 1 {
+		arg = arg[:len(arg)-1]
+	}
+	ival, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size (use a number with an optional k, m, or g suffix)")
+	}
+	if ival < 0 {
+		return 0, fmt.Errorf("cannot be negative")
+	}
+	return ival * mult, nil
+}
+
 var histExpansionRe = regexp.MustCompile(`^!(\d+)$`)
 
 func doCmdHistoryExpansion(ctx context.Context, ids resolvedIds, cmdStr string) (string, error) {
@@ -603,11 +669,18 @@ func addToHistory(ctx context.Context, pk *scpacket.FeCommandPacketType, history
 }
 
 func EvalCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	update, _, err := evalCommandWithHistoryContext(ctx, pk)
+	return update, err
+}
+
+// like EvalCommand, but also returns the history context (which has the line that the command created, if any)
+func evalCommandWithHistoryContext(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, historyContextType, error) {
+	var historyContext historyContextType
 	if len(pk.Args) == 0 {
-		return nil, fmt.Errorf("usage: /eval [command], no command passed to eval")
+		return nil, historyContext, fmt.Errorf("usage: /eval [command], no command passed to eval")
 	}
 	if len(pk.Args[0]) > MaxCommandLen {
-		return nil, fmt.Errorf("command length too long len:%d, max:%d", len(pk.Args[0]), MaxCommandLen)
+		return nil, historyContext, fmt.Errorf("command length too long len:%d, max:%d", len(pk.Args[0]), MaxCommandLen)
 	}
 	evalDepth := getEvalDepth(ctx)
 	if pk.Interactive && evalDepth == 0 {
@@ -618,9 +691,8 @@ func EvalCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.
 		}
 	}
 	if evalDepth > MaxEvalDepth {
-		return nil, fmt.Errorf("alias/history expansion max-depth exceeded")
+		return nil, historyContext, fmt.Errorf("alias/history expansion max-depth exceeded")
 	}
-	var historyContext historyContextType
 	ctxWithHistory := context.WithValue(ctx, historyContextKey, &historyContext)
 	var update sstore.UpdatePacket
 	newPk, rtnErr := EvalMetaCommand(ctxWithHistory, pk)
@@ -634,7 +706,7 @@ func EvalCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.
 			// fall through (non-fatal error)
 		}
 	}
-	return update, rtnErr
+	return update, historyContext, rtnErr
 }
 
 func ScreenArchiveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
@@ -701,6 +773,9 @@ func ScreenPurgeCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 	if err != nil {
 		return nil, err
 	}
+	if modelUpdate, ok := update.(*sstore.ModelUpdate); ok && modelUpdate != nil {
+		modelUpdate.Info = trashUndoInfo("screen")
+	}
 	return update, nil
 }
 
@@ -1591,7 +1666,7 @@ func CrCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.Up
 	if noHist {
 		screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
 		if err != nil {
-			return nil, fmt.Errorf("/% error: cannot resolve screen for update: %w", err)
+			return nil, fmt.Errorf("/%s error: cannot resolve screen for update: %w", GetCmdStr(pk), err)
 		}
 		update := &sstore.ModelUpdate{
 			Screens:     []*sstore.ScreenType{screen},
@@ -2056,6 +2131,9 @@ func SessionDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	if err != nil {
 		return nil, fmt.Errorf("cannot delete session: %v", err)
 	}
+	if modelUpdate, ok := update.(*sstore.ModelUpdate); ok && modelUpdate != nil {
+		modelUpdate.Info = trashUndoInfo("session")
+	}
 	return update, nil
 }
 
@@ -2383,23 +2461,17 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 			opts.RemoteId = rptr.RemoteId
 		}
 	}
-	if pk.Kwargs["fromts"] != "" {
-		fromTs, err := resolvePosInt(pk.Kwargs["fromts"], 0)
-		if err != nil {
-			return nil, fmt.Errorf("invalid fromts (must be unixtime (milliseconds): %v", err)
-		}
-		if fromTs > 0 {
-			opts.FromTs = int64(fromTs)
-		}
-	}
-	if pk.Kwargs["meta"] != "" {
+	if pk.Kwargs["meta"] == "only" {
+		opts.OnlyMeta = true
+	} else if pk.Kwargs["meta"] != "" {
 		opts.NoMeta = !resolveBool(pk.Kwargs["meta"], true)
 	}
 	if resolveBool(pk.Kwargs["filter"], false) {
 		opts.FilterFn = historyCmdFilter
 	}
+	err = resolveHistoryFilters(pk, &opts)
 	if err != nil {
-		return nil, fmt.Errorf("invalid meta arg (must be boolean): %v", err)
+		return nil, err
 	}
 	hresult, err := sstore.GetHistoryItems(ctx, opts)
 	if err != nil {
@@ -2425,6 +2497,67 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 	return update, nil
 }
 
+// before, after, exitcode, minduration, and cwd kwargs (run as SQL filters in the history query).
+// before and after are inclusive unixtime (milliseconds) bounds.
+func resolveHistoryFilters(pk *scpacket.FeCommandPacketType, opts *sstore.HistoryQueryOpts) error {
+	if pk.Kwargs["before"] != "" {
+		maxTs, err := resolvePosInt(pk.Kwargs["before"], 0)
+		if err != nil {
+			return fmt.Errorf("invalid before (must be unixtime (milliseconds)): %v", err)
+		}
+		opts.MaxTs = int64(maxTs)
+	}
+	if pk.Kwargs["after"] != "" {
+		minTs, err := resolvePosInt(pk.Kwargs["after"], 0)
+		if err != nil {
+			return fmt.Errorf("invalid after (must be unixtime (milliseconds)): %v", err)
+		}
+		opts.MinTs = int64(minTs)
+	}
+	exitArg := pk.Kwargs["exitcode"]
+	if exitArg == sstore.HistoryExitSuccess || exitArg == sstore.HistoryExitFailure {
+		opts.ExitStatus = exitArg
+	} else if exitArg != "" {
+		exitCode, err := strconv.Atoi(exitArg)
+		if err != nil {
+			return fmt.Errorf("invalid exitcode '%s' (must be %q, %q, or a number)", exitArg, sstore.HistoryExitSuccess, sstore.HistoryExitFailure)
+		}
+		opts.ExitCode = &exitCode
+	}
+	if pk.Kwargs["minduration"] != "" {
+		minDurationMs, err := resolveDurationMs(pk.Kwargs["minduration"])
+		if err != nil {
+			return fmt.Errorf("invalid minduration: %v", err)
+		}
+		opts.MinDurationMs = minDurationMs
+	}
+	if pk.Kwargs["cwd"] != "" {
+		if !strings.HasPrefix(pk.Kwargs["cwd"], "/") {
+			return fmt.Errorf("invalid cwd '%s' (must be an absolute path)", pk.Kwargs["cwd"])
+		}
+		opts.CwdPrefix = pk.Kwargs["cwd"]
+	}
+	return nil
+}
+
+// accepts a go duration ("1m30s") or a plain number of milliseconds
+func resolveDurationMs(arg string) (int64, error) {
+	if ms, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		if ms < 0 {
+			return 0, fmt.Errorf("cannot be negative")
+		}
+		return ms, nil
+	}
+	dur, err := time.ParseDuration(arg)
+	if err != nil {
+		return 0, fmt.Errorf("must be a duration (e.g. 30s, 5m) or milliseconds")
+	}
+	if dur < 0 {
+		return 0, fmt.Errorf("cannot be negative")
+	}
+	return dur.Milliseconds(), nil
+}
+
 const DefaultMaxHistoryItems = 10000
 
 func HistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
@@ -2480,6 +2613,370 @@ func HistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (ssto
 	return update, nil
 }
 
+const DefaultMaxOutputSearchItems = 20
+
+// searches command output.  jump=N jumps to the Nth result (1 is the most recent).
+func HistorySearchCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	outputQuery := pk.Kwargs["output"]
+	if outputQuery == "" {
+		return nil, fmt.Errorf("usage: /history:search output=\"[text]\" [type=global|session|screen] [maxitems=N] [jump=N]")
+	}
+	maxItems, err := resolvePosInt(pk.Kwargs["maxitems"], DefaultMaxOutputSearchItems)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxitems value '%s' (must be a number): %v", pk.Kwargs["maxitems"], err)
+	}
+	jumpNum, err := resolveNonNegInt(pk.Kwargs["jump"], 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jump value '%s' (must be a number): %v", pk.Kwargs["jump"], err)
+	}
+	opts := sstore.OutputSearchOpts{Query: outputQuery, MaxItems: maxItems}
+	htype := HistoryTypeGlobal
+	if pk.Kwargs["type"] != "" {
+		htype = pk.Kwargs["type"]
+	}
+	switch htype {
+	case HistoryTypeGlobal:
+	case HistoryTypeSession:
+		opts.SessionId = ids.SessionId
+	case HistoryTypeScreen:
+		opts.ScreenId = ids.ScreenId
+	default:
+		return nil, fmt.Errorf("invalid history type '%s', valid types: %s", htype, formatStrs([]string{HistoryTypeScreen, HistoryTypeSession, HistoryTypeGlobal}, "or", false))
+	}
+	results, err := sstore.SearchCmdOutput(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("/history:search error: %v", err)
+	}
+	infoTitle := fmt.Sprintf("output matching %q", outputQuery)
+	if len(results) == 0 {
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoTitle: infoTitle, InfoMsg: "no matches found"}}, nil
+	}
+	if jumpNum > len(results) {
+		return nil, fmt.Errorf("/history:search cannot jump to result %d, only %d results", jumpNum, len(results))
+	}
+	var buf bytes.Buffer
+	for idx, result := range results {
+		tsStr := time.UnixMilli(result.Ts).Format("2006-01-02 15:04")
+		buf.WriteString(fmt.Sprintf("%2d) %s  [%s/%s] line %d  %s\n", idx+1, tsStr, result.SessionName, result.ScreenName, result.LineNum, strings.TrimSpace(result.CmdStr)))
+		buf.WriteString(fmt.Sprintf("      %s\n", result.Snippet))
+	}
+	update := &sstore.ModelUpdate{}
+	if jumpNum > 0 {
+		result := results[jumpNum-1]
+		update, err = makeLineViewUpdate(ctx, result.SessionId, result.ScreenId, int(result.LineNum))
+		if err != nil {
+			return nil, err
+		}
+	}
+	update.Info = &sstore.InfoMsgType{
+		InfoTitle: infoTitle,
+		InfoLines: splitLinesForInfo(buf.String()),
+	}
+	return update, nil
+}
+
+const DefaultMaxHistoryStatsItems = 50
+
+const (
+	HistoryStatsByCmd    = "cmd"
+	HistoryStatsByRemote = "remote"
+	HistoryStatsByHour   = "hour"
+)
+
+func formatStatsPercent(num int64, total int64) string {
+	if total == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(100*float64(num)/float64(total), 'f', 1, 64)
+}
+
+func formatStatsTs(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.UnixMilli(ts).Format("2006-01-02 15:04")
+}
+
+// aggregates history into a csv table line.  by=cmd (default) shows the top commands (sort=count|failrate|duration),
+// by=remote shows commands per remote, by=hour shows activity by hour of day.
+func HistoryStatsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := resolveHistoryStatsOpts(pk, ids)
+	if err != nil {
+		return nil, err
+	}
+	statsBy := HistoryStatsByCmd
+	if pk.Kwargs["by"] != "" {
+		statsBy = pk.Kwargs["by"]
+	}
+	rows, err := getHistoryStatsRows(ctx, statsBy, opts)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	if len(rows) == 1 {
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: "no history items found", TimeoutMs: 2000}}, nil
+	}
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	csvWriter.WriteAll(rows)
+	if err := csvWriter.Error(); err != nil {
+		return nil, fmt.Errorf("/%s error writing csv: %v", GetCmdStr(pk), err)
+	}
+	cmd, err := makeStaticCmd(ctx, GetCmdStr(pk), ids, pk.GetRawStr(), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	update, err := addLineForCmd(ctx, "/"+GetCmdStr(pk), true, ids, cmd, "csv", nil)
+	if err != nil {
+		return nil, err
+	}
+	update.Interactive = pk.Interactive
+	return update, nil
+}
+
+// type, remote, since, sort, and maxitems kwargs
+func resolveHistoryStatsOpts(pk *scpacket.FeCommandPacketType, ids resolvedIds) (sstore.HistoryStatsOpts, error) {
+	maxItems, err := resolvePosInt(pk.Kwargs["maxitems"], DefaultMaxHistoryStatsItems)
+	if err != nil {
+		return sstore.HistoryStatsOpts{}, fmt.Errorf("invalid maxitems value '%s' (must be a number): %v", pk.Kwargs["maxitems"], err)
+	}
+	opts := sstore.HistoryStatsOpts{SortBy: pk.Kwargs["sort"], MaxItems: maxItems}
+	htype := HistoryTypeGlobal
+	if pk.Kwargs["type"] != "" {
+		htype = pk.Kwargs["type"]
+	}
+	switch htype {
+	case HistoryTypeGlobal:
+	case HistoryTypeSession:
+		opts.SessionId = ids.SessionId
+	case HistoryTypeScreen:
+		opts.SessionId = ids.SessionId
+		opts.ScreenId = ids.ScreenId
+	default:
+		return opts, fmt.Errorf("invalid history type '%s', valid types: %s", htype, formatStrs([]string{HistoryTypeScreen, HistoryTypeSession, HistoryTypeGlobal}, "or", false))
+	}
+	if pk.Kwargs["remote"] != "" {
+		rptr, err := resolveRemoteArg(pk.Kwargs["remote"])
+		if err != nil {
+			return opts, fmt.Errorf("invalid remote: %v", err)
+		}
+		if rptr == nil {
+			return opts, fmt.Errorf("remote '%s' not found", pk.Kwargs["remote"])
+		}
+		opts.RemoteId = rptr.RemoteId
+	}
+	if pk.Kwargs["since"] != "" {
+		sinceMs, err := resolveDurationMs(pk.Kwargs["since"])
+		if err != nil {
+			return opts, fmt.Errorf("invalid since value '%s': %v", pk.Kwargs["since"], err)
+		}
+		opts.MinTs = time.Now().UnixMilli() - sinceMs
+	}
+	return opts, nil
+}
+
+// header row plus one row per stat
+func getHistoryStatsRows(ctx context.Context, statsBy string, opts sstore.HistoryStatsOpts) ([][]string, error) {
+	var rows [][]string
+	switch statsBy {
+	case HistoryStatsByCmd:
+		stats, err := sstore.GetHistoryCmdStats(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []string{"command", "runs", "failed", "fail %", "avg ms", "max ms", "last run"})
+		for _, stat := range stats {
+			rows = append(rows, []string{
+				stat.CmdStr,
+				strconv.FormatInt(stat.NumRuns, 10),
+				strconv.FormatInt(stat.NumFailed, 10),
+				strconv.FormatFloat(100*stat.FailRate, 'f', 1, 64),
+				strconv.FormatInt(stat.AvgDurationMs, 10),
+				strconv.FormatInt(stat.MaxDurationMs, 10),
+				formatStatsTs(stat.LastTs),
+			})
+		}
+	case HistoryStatsByRemote:
+		stats, err := sstore.GetHistoryRemoteStats(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []string{"remote", "runs", "failed", "fail %", "last run"})
+		for _, stat := range stats {
+			rows = append(rows, []string{
+				stat.RemoteName,
+				strconv.FormatInt(stat.NumRuns, 10),
+				strconv.FormatInt(stat.NumFailed, 10),
+				formatStatsPercent(stat.NumFailed, stat.NumRuns),
+				formatStatsTs(stat.LastTs),
+			})
+		}
+	case HistoryStatsByHour:
+		stats, err := sstore.GetHistoryHourStats(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []string{"hour", "runs", "failed", "fail %"})
+		for _, stat := range stats {
+			rows = append(rows, []string{
+				fmt.Sprintf("%02d:00", stat.Hour),
+				strconv.FormatInt(stat.NumRuns, 10),
+				strconv.FormatInt(stat.NumFailed, 10),
+				formatStatsPercent(stat.NumFailed, stat.NumRuns),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("invalid by value '%s', valid values: %s", statsBy, formatStrs([]string{HistoryStatsByCmd, HistoryStatsByRemote, HistoryStatsByHour}, "or", false))
+	}
+	return rows, nil
+}
+
+const MaxCdJumpCandidates = 10
+const DefaultCdJumpListItems = 20
+
+type cdJumpCandidate struct {
+	Dir   string
+	Score float64
+}
+
+// z style "frecency", the number of commands run in the dir weighted by how recently it was used
+func frecencyScore(stat *sstore.HistoryDirStats, now time.Time) float64 {
+	age := now.Sub(time.UnixMilli(stat.LastTs))
+	weight := 0.25
+	if age < time.Hour {
+		weight = 4
+	} else if age < 24*time.Hour {
+		weight = 2
+	} else if age < 7*24*time.Hour {
+		weight = 0.5
+	}
+	return float64(stat.NumCmds) * weight
+}
+
+// fragments must match the dir in order (case-insensitive unless a fragment has an uppercase letter).
+// dirs where the last fragment matches the last path component are preferred.  sorted by score.
+func matchCdJumpDirs(stats []*sstore.HistoryDirStats, fragments []string, curDir string) []cdJumpCandidate {
+	caseInsensitive := true
+	var reParts []string
+	for _, frag := range fragments {
+		if strings.ToLower(frag) != frag {
+			caseInsensitive = false
+		}
+		reParts = append(reParts, regexp.QuoteMeta(frag))
+	}
+	reStr := strings.Join(reParts, ".*")
+	lastFrag := ""
+	if len(fragments) > 0 {
+		lastFrag = regexp.QuoteMeta(fragments[len(fragments)-1])
+	}
+	if caseInsensitive {
+		reStr = "(?i)" + reStr
+		lastFrag = "(?i)" + lastFrag
+	}
+	matchRe := regexp.MustCompile(reStr)
+	lastRe := regexp.MustCompile(lastFrag)
+	now := time.Now()
+	var baseMatches, otherMatches []cdJumpCandidate
+	for _, stat := range stats {
+		if stat.Cwd == curDir || !filepath.IsAbs(stat.Cwd) || !matchRe.MatchString(stat.Cwd) {
+			continue
+		}
+		candidate := cdJumpCandidate{Dir: stat.Cwd, Score: frecencyScore(stat, now)}
+		if lastRe.MatchString(filepath.Base(stat.Cwd)) {
+			baseMatches = append(baseMatches, candidate)
+		} else {
+			otherMatches = append(otherMatches, candidate)
+		}
+	}
+	byScore := func(arr []cdJumpCandidate) {
+		sort.SliceStable(arr, func(i int, j int) bool {
+			if arr[i].Score != arr[j].Score {
+				return arr[i].Score > arr[j].Score
+			}
+			return arr[i].Dir < arr[j].Dir
+		})
+	}
+	byScore(baseMatches)
+	byScore(otherMatches)
+	return append(baseMatches, otherMatches...)
+}
+
+// jumps to the best matching directory from the remote's history (like z or autojump).  the cd is run as a
+// regular command so the new cwd is returned through the normal state path.  with no fragments (or list=1)
+// the matching directories are listed instead.  only commands run in wave are considered, imported history
+// has no cwd (see sstore.GetHistoryDirStats).
+func CdJumpCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	var fragments []string
+	for _, arg := range pk.Args {
+		fragments = append(fragments, strings.Fields(arg)...)
+	}
+	stats, err := sstore.GetHistoryDirStats(ctx, ids.Remote.RemotePtr.RemoteId)
+	if err != nil {
+		return nil, fmt.Errorf("/cd-jump error reading history: %v", err)
+	}
+	candidates := matchCdJumpDirs(stats, fragments, ids.Remote.FeState["cwd"])
+	if len(fragments) == 0 || resolveBool(pk.Kwargs["list"], false) {
+		infoTitle := fmt.Sprintf("frecent directories on [%s]", ids.Remote.DisplayName)
+		if len(candidates) == 0 {
+			return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoTitle: infoTitle, InfoMsg: "no matching directories"}}, nil
+		}
+		var buf bytes.Buffer
+		for idx, candidate := range candidates {
+			if idx >= DefaultCdJumpListItems {
+				break
+			}
+			buf.WriteString(fmt.Sprintf("%8.2f  %s\n", candidate.Score, candidate.Dir))
+		}
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoTitle: infoTitle, InfoLines: splitLinesForInfo(buf.String())}}, nil
+	}
+	// dirs may have been removed since they were recorded, use the best one that still exists
+	jumpDir := ""
+	for idx, candidate := range candidates {
+		if idx >= MaxCdJumpCandidates {
+			break
+		}
+		finfo, err := ids.Remote.MShell.StatFile(ctx, candidate.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("/cd-jump cannot stat %q: %v", candidate.Dir, err)
+		}
+		if !finfo.NotFound && finfo.IsDir {
+			jumpDir = candidate.Dir
+			break
+		}
+	}
+	if jumpDir == "" {
+		return nil, fmt.Errorf("/cd-jump no directory matching %q found in history for [%s]", strings.Join(fragments, " "), ids.Remote.DisplayName)
+	}
+	newPk := scpacket.MakeFeCommandPacket()
+	newPk.MetaCmd = "run"
+	newPk.Args = []string{"cd " + utilfn.ShellQuote(jumpDir, false, MaxCommandLen)}
+	newPk.Kwargs = make(map[string]string)
+	newPk.RawStr = pk.RawStr
+	newPk.UIContext = pk.UIContext
+	newPk.Interactive = pk.Interactive
+	return RunCommand(ctx, newPk)
+}
+
+// for files that are read or written on the wavesrv host (not on a remote)
+func resolveLocalFilePath(pathArg string) (string, error) {
+	fullPath := base.ExpandHomeDir(pathArg)
+	if !filepath.IsAbs(fullPath) {
+		return "", fmt.Errorf("path %q must be absolute (or start with ~/)", pathArg)
+	}
+	return filepath.Clean(fullPath), nil
+}
+
 func splitLinesForInfo(str string) []string {
 	rtn := strings.Split(str, "\n")
 	if rtn[len(rtn)-1] == "" {
@@ -2620,6 +3117,9 @@ func LineSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (ssto
 		if err != nil {
 			return nil, fmt.Errorf("cannot update linestate: %v", err)
 		}
+		if closed, _ := stateMap[sstore.LineState_PromptClosed].(bool); closed {
+			go remote.UnWatchLineAllRemotes(ids.ScreenId, lineId)
+		}
 		varsUpdated = append(varsUpdated, KwArgState)
 	}
 	if len(varsUpdated) == 0 {
@@ -2668,16 +3168,25 @@ func LineViewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sst
 	if err != nil {
 		return nil, fmt.Errorf("/line:view invalid line arg: %v", err)
 	}
-	update, err := sstore.SwitchScreenById(ctx, sessionId, screenRItem.Id)
+	var lineNum int
+	if lineRItem != nil {
+		lineNum = lineRItem.Num
+	}
+	return makeLineViewUpdate(ctx, sessionId, screenRItem.Id, lineNum)
+}
+
+// switches to the screen and selects lineNum (if lineNum is 0, just switches to the screen)
+func makeLineViewUpdate(ctx context.Context, sessionId string, screenId string, lineNum int) (*sstore.ModelUpdate, error) {
+	update, err := sstore.SwitchScreenById(ctx, sessionId, screenId)
 	if err != nil {
 		return nil, err
 	}
-	if lineRItem != nil {
+	if lineNum > 0 {
 		updateMap := make(map[string]interface{})
-		updateMap[sstore.ScreenField_SelectedLine] = lineRItem.Num
-		updateMap[sstore.ScreenField_AnchorLine] = lineRItem.Num
+		updateMap[sstore.ScreenField_SelectedLine] = lineNum
+		updateMap[sstore.ScreenField_AnchorLine] = lineNum
 		updateMap[sstore.ScreenField_AnchorOffset] = 0
-		screen, err = sstore.UpdateScreen(ctx, screenRItem.Id, updateMap)
+		screen, err := sstore.UpdateScreen(ctx, screenId, updateMap)
 		if err != nil {
 			return nil, err
 		}
@@ -2717,6 +3226,9 @@ func BookmarkSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 		return nil, fmt.Errorf("error trying to resolve bookmark: %v", err)
 	}
 	if bookmarkId == "" {
+		if err := checkBookmarkNotLibrary(ctx, bookmarkArg); err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("bookmark not found")
 	}
 	editMap := make(map[string]interface{})
@@ -2755,6 +3267,9 @@ func BookmarkDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 		return nil, fmt.Errorf("error trying to resolve bookmark: %v", err)
 	}
 	if bookmarkId == "" {
+		if err := checkBookmarkNotLibrary(ctx, bookmarkArg); err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("bookmark not found")
 	}
 	err = sstore.DeleteBookmark(ctx, bookmarkId)
@@ -2770,6 +3285,99 @@ func BookmarkDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 	}, nil
 }
 
+// matches {{name}} or {{name:default}}
+var bookmarkParamRe = regexp.MustCompile(`\{\{([a-zA-Z_][a-zA-Z0-9_]*)(?::([^}]*))?\}\}`)
+
+// returns the params in the order they first appear (the first default given for a param wins)
+func getBookmarkParams(bm *sstore.BookmarkType) []*sstore.BookmarkParamType {
+	var rtn []*sstore.BookmarkParamType
+	seen := make(map[string]bool)
+	for _, m := range bookmarkParamRe.FindAllStringSubmatch(bm.CmdStr, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		rtn = append(rtn, &sstore.BookmarkParamType{Name: m[1], Default: m[2], LastValue: bm.ParamValues[m[1]]})
+	}
+	return rtn
+}
+
+// values are quoted for wherever the placeholder appears (unquoted, or inside single/double quotes)
+func substituteBookmarkParams(cmdStr string, values map[string]string) string {
+	var buf strings.Builder
+	lastPos := 0
+	for _, m := range bookmarkParamRe.FindAllStringSubmatchIndex(cmdStr, -1) {
+		buf.WriteString(cmdStr[lastPos:m[0]])
+		name := cmdStr[m[2]:m[3]]
+		buf.WriteString(comp.QuoteInContext(values[name], comp.QuoteContextAt(cmdStr, m[0])))
+		lastPos = m[1]
+	}
+	buf.WriteString(cmdStr[lastPos:])
+	return buf.String()
+}
+
+func BookmarkRunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /bookmark:run [bookmark] [name=value...]")
+	}
+	bookmarkId, err := sstore.GetBookmarkIdByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("error trying to resolve bookmark: %v", err)
+	}
+	var bm *sstore.BookmarkType
+	if bookmarkId != "" {
+		bm, err = sstore.GetBookmarkById(ctx, bookmarkId, "")
+	} else {
+		bm, err = sstore.GetLibraryBookmarkByArg(ctx, pk.Args[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving bookmark: %v", err)
+	}
+	if bm == nil {
+		return nil, fmt.Errorf("bookmark not found")
+	}
+	params := getBookmarkParams(bm)
+	if len(params) == 0 {
+		// nothing to fill in, same as using a bookmark without params (the command is edited, not run)
+		return &sstore.ModelUpdate{
+			CmdLine: &sstore.CmdLineType{CmdLine: bm.CmdStr, CursorPos: utf8.RuneCountInString(bm.CmdStr)},
+		}, nil
+	}
+	values := make(map[string]string)
+	for _, param := range params {
+		value, found := pk.Kwargs[param.Name]
+		if !found {
+			// ask the user for the values (frontend runs /bookmark:run again with all of them set)
+			return &sstore.ModelUpdate{
+				BookmarkPrompt: &sstore.BookmarkPromptType{
+					BookmarkId: bm.BookmarkId,
+					CmdStr:     bm.CmdStr,
+					Params:     params,
+				},
+			}, nil
+		}
+		values[param.Name] = value
+	}
+	cmdStr := substituteBookmarkParams(bm.CmdStr, values)
+	if len(cmdStr) > MaxCommandLen {
+		return nil, fmt.Errorf("command too long (max %d bytes)", MaxCommandLen)
+	}
+	if len(values) > 0 && bm.Library == "" {
+		err = sstore.UpdateBookmarkParamValues(ctx, bm.BookmarkId, values)
+		if err != nil {
+			return nil, fmt.Errorf("error saving bookmark values: %v", err)
+		}
+	}
+	evalPk := scpacket.MakeFeCommandPacket()
+	evalPk.MetaCmd = "eval"
+	evalPk.Args = []string{cmdStr}
+	evalPk.Kwargs = make(map[string]string)
+	evalPk.RawStr = cmdStr
+	evalPk.UIContext = pk.UIContext
+	evalPk.Interactive = pk.Interactive
+	return EvalCommand(ctx, evalPk)
+}
+
 func LineBookmarkCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
 	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
 	if err != nil {
@@ -2935,7 +3543,9 @@ func LinePurgeCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (ss
 			Remove:   true,
 		}
 		update.Lines = append(update.Lines, lineObj)
+		go remote.UnWatchLineAllRemotes(ids.ScreenId, lineId)
 	}
+	update.Info = trashUndoInfo(pluralize("line", len(lineIds)))
 	return update, nil
 }
 
@@ -3002,6 +3612,12 @@ func LineShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sst
 			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "file", stat.Location))
 			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "file-data", fileDataStr))
 		}
+		timing, _ := sstore.ReadPtyTiming(cmd.ScreenId, cmd.LineId)
+		if len(timing) > 0 {
+			firstTs := time.UnixMilli(timing[0].Ts)
+			lastTs := time.UnixMilli(timing[len(timing)-1].Ts)
+			buf.WriteString(fmt.Sprintf("  %-15s %s - %s (%d writes)\n", "output-ts", firstTs.Format(TsFormatStr), lastTs.Format(TsFormatStr), len(timing)))
+		}
 		if cmd.DoneTs != 0 {
 			doneTs := time.UnixMilli(cmd.DoneTs)
 			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "donets", doneTs.Format(TsFormatStr)))
@@ -3219,10 +3835,33 @@ func CodeEditCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sst
 		// TODO tricky error since the command was a success, but we can't show the output
 		return nil, err
 	}
+	if lineState[sstore.LineState_Mode] == "edit" {
+		// watch the file so the editor can warn when it is changed underneath it
+		// not fatal (the editor still works without change notifications)
+		fullPath, err := resolveRemoteFilePath(ids.Remote, pk.Args[0])
+		if err == nil {
+			err = ids.Remote.MShell.WatchFile(ctx, fullPath, ids.ScreenId, update.Line.LineId)
+		}
+		if err != nil {
+			log.Printf("/%s cannot watch file %q: %v\n", GetCmdStr(pk), pk.Args[0], err)
+		}
+	}
 	update.Interactive = pk.Interactive
 	return update, nil
 }
 
+// resolves a path the same way /api/read-file and /api/write-file do (homedir expansion, relative to cwd)
+func resolveRemoteFilePath(rptr *ResolvedRemote, path string) (string, error) {
+	fullPath, err := rptr.RState.ExpandHomeDir(path)
+	if err != nil {
+		return "", fmt.Errorf("error expanding homedir: %v", err)
+	}
+	if filepath.IsAbs(fullPath) {
+		return fullPath, nil
+	}
+	return filepath.Join(rptr.FeState["cwd"], fullPath), nil
+}
+
 func CSVViewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
 	if len(pk.Args) == 0 {
 		return nil, fmt.Errorf("%s requires an argument (file name)", GetCmdStr(pk))
@@ -3285,6 +3924,50 @@ func ImageViewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (ss
 	return update, nil
 }
 
+func ViewHexCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	return makeRangedFileViewLine(ctx, pk, "hex")
+}
+
+func ViewArchiveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	return makeRangedFileViewLine(ctx, pk, "archive")
+}
+
+// the renderer runs the scan itself (see /api/disk-usage), defaults to the current directory
+func ViewDuCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		pk.Args = []string{"."}
+	}
+	return makeRangedFileViewLine(ctx, pk, "du")
+}
+
+// "ranged" source, the renderer reads the parts of the file it needs itself (instead of loading it all)
+func makeRangedFileViewLine(ctx context.Context, pk *scpacket.FeCommandPacketType, renderer string) (sstore.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("%s requires an argument (file name)", GetCmdStr(pk))
+	}
+	if pk.Args[0] == "" {
+		return nil, fmt.Errorf("%s argument cannot be empty", GetCmdStr(pk))
+	}
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	outputStr := fmt.Sprintf("%s %q", GetCmdStr(pk), pk.Args[0])
+	cmd, err := makeStaticCmd(ctx, GetCmdStr(pk), ids, pk.GetRawStr(), []byte(outputStr))
+	if err != nil {
+		return nil, err
+	}
+	lineState := make(map[string]any)
+	lineState[sstore.LineState_Source] = "ranged"
+	lineState[sstore.LineState_File] = pk.Args[0]
+	update, err := addLineForCmd(ctx, "/"+GetCmdStr(pk), false, ids, cmd, renderer, lineState)
+	if err != nil {
+		return nil, err
+	}
+	update.Interactive = pk.Interactive
+	return update, nil
+}
+
 func MarkdownViewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
 	if len(pk.Args) == 0 {
 		return nil, fmt.Errorf("%s requires an argument (file name)", GetCmdStr(pk))
@@ -3629,8 +4312,68 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (ss
 			return nil, fmt.Errorf("error updating client openai maxchoices: %v", err)
 		}
 	}
+	if overflowStr, found := pk.Kwargs["ptyoverflow"]; found {
+		budget, err := resolveByteSize(overflowStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ptyoverflow: %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.PtyOverflowBudget = budget
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client ptyoverflow: %v", err)
+		}
+		clientData.ClientOpts = clientOpts
+		sstore.SetPtyOverflowBudget(budget)
+		varsUpdated = append(varsUpdated, "ptyoverflow")
+	}
+	if expireStr, found := pk.Kwargs["trashexpire"]; found {
+		expireDays, err := resolvePosInt(strings.TrimSuffix(expireStr, "d"), sstore.DefaultTrashExpireDays)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trashexpire (number of days): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.TrashExpireDays = expireDays
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client trashexpire: %v", err)
+		}
+		clientData.ClientOpts = clientOpts
+		varsUpdated = append(varsUpdated, "trashexpire")
+	}
+	if intervalStr, found := pk.Kwargs["backupinterval"]; found {
+		backupHours := -1
+		if intervalStr != "off" {
+			backupHours, err = resolvePosInt(strings.TrimSuffix(intervalStr, "h"), sstore.DefaultDBBackupHours)
+			if err != nil {
+				return nil, fmt.Errorf("invalid backupinterval (number of hours or off): %v", err)
+			}
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.DBBackupHours = backupHours
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client backupinterval: %v", err)
+		}
+		clientData.ClientOpts = clientOpts
+		varsUpdated = append(varsUpdated, "backupinterval")
+	}
+	if keepStr, found := pk.Kwargs["backupkeep"]; found {
+		backupKeep, err := resolvePosInt(keepStr, sstore.DefaultDBBackupKeep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backupkeep: %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.DBBackupKeep = backupKeep
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client backupkeep: %v", err)
+		}
+		clientData.ClientOpts = clientOpts
+		varsUpdated = append(varsUpdated, "backupkeep")
+	}
 	if len(varsUpdated) == 0 {
-		return nil, fmt.Errorf("/client:set requires a value to set: %s", formatStrs([]string{"termfontsize", "openaiapitoken", "openaimodel", "openaimaxtokens", "openaimaxchoices"}, "or", false))
+		return nil, fmt.Errorf("/client:set requires a value to set: %s", formatStrs([]string{"termfontsize", "openaiapitoken", "openaimodel", "openaimaxtokens", "openaimaxchoices", "ptyoverflow", "trashexpire", "backupinterval", "backupkeep"}, "or", false))
 	}
 	clientData, err = sstore.EnsureClientData(ctx)
 	if err != nil {
@@ -3663,6 +4406,13 @@ func ClientShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (s
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "userid", clientData.UserId))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "clientid", clientData.ClientId))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "telemetry", boolToStr(clientData.ClientOpts.NoTelemetry, "off", "on")))
+	if clientData.ClientOpts.PtyOverflowBudget > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s per command\n", "ptyoverflow", scbase.NumFormatB2(clientData.ClientOpts.PtyOverflowBudget)))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "ptyoverflow", "off"))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %d days\n", "trashexpire", clientData.ClientOpts.GetTrashExpireDays()))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "dbbackup", formatDBBackupOpts(clientData.ClientOpts)))
 	buf.WriteString(fmt.Sprintf("  %-15s %d\n", "db-version", dbVersion))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "client-version", clientVersion))
 	buf.WriteString(fmt.Sprintf("  %-15s %s %s\n", "server-version", scbase.WaveVersion, scbase.BuildTime))
@@ -0,0 +1,202 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const DBBackupInitialDelay = 10 * time.Minute
+const DBBackupCheckInterval = 10 * time.Minute
+const DBCheckMaxProblems = 20
+
+func formatDBBackupOpts(clientOpts sstore.ClientOptsType) string {
+	interval := clientOpts.GetDBBackupInterval()
+	if interval == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("every %dh, keep %d", int(interval.Hours()), clientOpts.GetDBBackupKeep())
+}
+
+func formatDBBackups(backups []*sstore.DBBackupInfo) string {
+	var buf bytes.Buffer
+	for _, backup := range backups {
+		ts := time.UnixMilli(backup.Ts).Format(TsFormatStr)
+		buf.WriteString(fmt.Sprintf("  %s  %-30s %8s\n", ts, backup.Name, scbase.NumFormatB2(backup.Size)))
+	}
+	return buf.String()
+}
+
+func formatDBProblems(problems []string) string {
+	var buf bytes.Buffer
+	for idx, problem := range problems {
+		if idx >= DBCheckMaxProblems {
+			buf.WriteString(fmt.Sprintf("  ... and %d more\n", len(problems)-idx))
+			break
+		}
+		buf.WriteString(fmt.Sprintf("  %s\n", problem))
+	}
+	return buf.String()
+}
+
+func dbCorruptMsg() string {
+	backups, _ := sstore.ListDBBackups()
+	if len(backups) == 0 {
+		return "database integrity check failed (no backups available), see /client:dbcheck"
+	}
+	return fmt.Sprintf("database integrity check failed, run /client:restorebackup to restore the latest good backup (%s)", backups[0].Name)
+}
+
+// backs up the db now and shows the backups
+func ClientBackupCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	problems, err := sstore.CheckDBIntegrity(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("/client:backup cannot check database: %v", err)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("/client:backup not backing up, %s", dbCorruptMsg())
+	}
+	backup, err := sstore.BackupDB(ctx, clientData.ClientOpts.GetDBBackupKeep())
+	if err != nil {
+		return nil, fmt.Errorf("/client:backup %v", err)
+	}
+	backups, err := sstore.ListDBBackups()
+	if err != nil {
+		return nil, fmt.Errorf("/client:backup cannot list backups: %v", err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-15s %s (%s)\n", "backup", backup.Name, scbase.NumFormatB2(backup.Size)))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "dir", sstore.GetDBBackupDir()))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "schedule", formatDBBackupOpts(clientData.ClientOpts)))
+	buf.WriteString("\n")
+	buf.WriteString(formatDBBackups(backups))
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoTitle: "db backup",
+			InfoLines: splitLinesForInfo(buf.String()),
+		},
+	}
+	return update, nil
+}
+
+func ClientVacuumCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	sizeBefore, sizeAfter, err := sstore.VacuumDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/client:vacuum %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("database vacuumed, %s => %s", scbase.NumFormatB2(sizeBefore), scbase.NumFormatB2(sizeAfter)),
+			TimeoutMs: 5000,
+		},
+	}
+	return update, nil
+}
+
+// runs a full integrity check (quick=1 for a quick check)
+func ClientDBCheckCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	quick := resolveBool(pk.Kwargs["quick"], false)
+	problems, err := sstore.CheckDBIntegrity(ctx, quick)
+	if err != nil {
+		return nil, fmt.Errorf("/client:dbcheck cannot check database: %v", err)
+	}
+	if len(problems) == 0 {
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: "database integrity check ok", TimeoutMs: 3000}}, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(dbCorruptMsg() + "\n\n")
+	buf.WriteString(formatDBProblems(problems))
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoTitle: "db check",
+			InfoError: fmt.Sprintf("database integrity check found %d problems", len(problems)),
+			InfoLines: splitLinesForInfo(buf.String()),
+		},
+	}
+	return update, nil
+}
+
+// stages the latest good backup (or the named backup), it replaces the db when Wave is restarted.  the
+// current db is kept next to it.
+func ClientRestoreBackupCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /client:restorebackup [backup-name]")
+	}
+	var name string
+	if len(pk.Args) == 1 {
+		name = pk.Args[0]
+	}
+	restoredName, err := sstore.RestoreDBBackup(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("/client:restorebackup %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg: fmt.Sprintf("database will be restored from %s when Wave is restarted", restoredName),
+		},
+	}
+	return update, nil
+}
+
+// backs up the db when the last backup is older than the backup interval.  the db is checked first,
+// a corrupt db is reported (once) instead of being backed up, so the good backups are not rotated out.
+func RunDBBackupLoop() {
+	time.Sleep(DBBackupInitialDelay)
+	var reportedCorrupt bool
+	for {
+		runDBBackup(context.Background(), &reportedCorrupt)
+		time.Sleep(DBBackupCheckInterval)
+	}
+}
+
+func runDBBackup(ctx context.Context, reportedCorrupt *bool) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		log.Printf("[db] backup cannot retrieve client data: %v\n", err)
+		return
+	}
+	interval := clientData.ClientOpts.GetDBBackupInterval()
+	if interval == 0 {
+		return
+	}
+	backups, err := sstore.ListDBBackups()
+	if err != nil {
+		log.Printf("[db] backup cannot list backups: %v\n", err)
+		return
+	}
+	if len(backups) > 0 && time.Since(time.UnixMilli(backups[0].Ts)) < interval {
+		return
+	}
+	problems, err := sstore.CheckDBIntegrity(ctx, true)
+	if err != nil {
+		log.Printf("[db] backup cannot check database: %v\n", err)
+		return
+	}
+	if len(problems) > 0 {
+		log.Printf("[db] integrity check failed, not backing up: %v\n", problems)
+		if !*reportedCorrupt {
+			*reportedCorrupt = true
+			sstore.MainBus.SendUpdate(&sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoError: dbCorruptMsg()}})
+		}
+		return
+	}
+	backup, err := sstore.BackupDB(ctx, clientData.ClientOpts.GetDBBackupKeep())
+	if err != nil {
+		log.Printf("[db] backup error: %v\n", err)
+		return
+	}
+	log.Printf("[db] backed up database to %s (%s)\n", backup.Name, scbase.NumFormatB2(backup.Size))
+}
@@ -0,0 +1,255 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const FileCmdTimeout = 12 * time.Hour
+const fileProgressInterval = 250 * time.Millisecond
+
+func writeStringToPty(ctx context.Context, cmd *sstore.CmdType, str string, outputPos *int64) error {
+	// terminal output needs CRLF
+	outBytes := []byte(strings.ReplaceAll(str, "\n", "\r\n"))
+	update, err := sstore.AppendToCmdPtyBlob(ctx, cmd.ScreenId, cmd.LineId, outBytes, *outputPos)
+	if err != nil {
+		return err
+	}
+	*outputPos += int64(len(outBytes))
+	sstore.MainBus.SendScreenUpdate(cmd.ScreenId, update)
+	return nil
+}
+
+func markDynCmdDone(cmd *sstore.CmdType, startTime time.Time, exitCode int) {
+	cmdStatus := sstore.CmdStatusDone
+	if exitCode != 0 {
+		cmdStatus = sstore.CmdStatusError
+	}
+	ck := base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
+	donePk := packet.MakeCmdDonePacket(ck)
+	donePk.Ts = time.Now().UnixMilli()
+	donePk.ExitCode = exitCode
+	donePk.DurationMs = time.Since(startTime).Milliseconds()
+	update, err := sstore.UpdateCmdDoneInfo(context.Background(), ck, donePk, cmdStatus)
+	if err != nil {
+		log.Printf("error updating cmddoneinfo (in %s): %v\n", cmd.CmdStr, err)
+		return
+	}
+	sstore.MainBus.SendScreenUpdate(cmd.ScreenId, update)
+}
+
+func makeFileCmd(ctx context.Context, pk *scpacket.FeCommandPacketType, ids resolvedIds) (*sstore.CmdType, error) {
+	ptermVal := defaultStr(pk.Kwargs["wterm"], DefaultPTERM)
+	pkTermOpts, err := GetUITermOpts(pk.UIContext.WinSize, ptermVal)
+	if err != nil {
+		return nil, fmt.Errorf("/%s invalid 'wterm' value %q: %v", GetCmdStr(pk), ptermVal, err)
+	}
+	termOpts := convertTermOpts(pkTermOpts)
+	cmd, err := makeDynCmd(ctx, GetCmdStr(pk), ids, pk.GetRawStr(), *termOpts)
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func formatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func FileSyncCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /file:sync [dryrun=1] [delete=1] [remote]:src [remote]:dst")
+	}
+	srcRemote, srcPath, err := resolveRemotePathArg(ctx, ids, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/file:sync invalid src: %v", err)
+	}
+	dstRemote, dstPath, err := resolveRemotePathArg(ctx, ids, pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/file:sync invalid dst: %v", err)
+	}
+	if srcRemote.RemotePtr.RemoteId == dstRemote.RemotePtr.RemoteId && srcPath == dstPath {
+		return nil, fmt.Errorf("/file:sync src and dst are the same")
+	}
+	src := remote.FileSyncEndpoint{MShell: srcRemote.MShell, Path: srcPath}
+	dst := remote.FileSyncEndpoint{MShell: dstRemote.MShell, Path: dstPath}
+	opts := remote.FileSyncOpts{
+		DryRun: resolveBool(pk.Kwargs["dryrun"], false),
+		Delete: resolveBool(pk.Kwargs["delete"], false),
+	}
+	cmd, err := makeFileCmd(ctx, pk, ids)
+	if err != nil {
+		return nil, err
+	}
+	update, err := addLineForCmd(ctx, "/"+GetCmdStr(pk), true, ids, cmd, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	go doFileSync(cmd, src, dst, opts)
+	return update, nil
+}
+
+func doFileSync(cmd *sstore.CmdType, src remote.FileSyncEndpoint, dst remote.FileSyncEndpoint, opts remote.FileSyncOpts) {
+	var outputPos int64
+	exitCode := 1
+	startTime := time.Now()
+	ctx, cancelFn := context.WithTimeout(context.Background(), FileCmdTimeout)
+	defer cancelFn()
+	defer func() {
+		r := recover()
+		if r != nil {
+			log.Printf("panic in doFileSync: %v\n", r)
+			writeStringToPty(ctx, cmd, fmt.Sprintf("\npanic: %v\n", r), &outputPos)
+			exitCode = 1
+		}
+		markDynCmdDone(cmd, startTime, exitCode)
+	}()
+	writeStringToPty(ctx, cmd, fmt.Sprintf("syncing [%s]:%s => [%s]:%s\n", src.MShell.GetDisplayName(), src.Path, dst.MShell.GetDisplayName(), dst.Path), &outputPos)
+	var lastProgress time.Time
+	var hasProgressLine bool
+	opts.ProgressFn = func(progress remote.FileSyncProgress) {
+		if opts.DryRun || time.Since(lastProgress) < fileProgressInterval {
+			return
+		}
+		lastProgress = time.Now()
+		hasProgressLine = true
+		progressStr := fmt.Sprintf("\r\x1b[K[%d/%d] %s sent, %s", progress.ActionsDone, progress.NumActions, formatByteSize(progress.BytesSent), progress.CurPath)
+		writeStringToPty(ctx, cmd, progressStr, &outputPos)
+	}
+	result, err := remote.SyncFiles(ctx, src, dst, opts)
+	if hasProgressLine {
+		writeStringToPty(ctx, cmd, "\r\x1b[K", &outputPos)
+	}
+	if err != nil {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("error: %v\n", err), &outputPos)
+		return
+	}
+	if opts.DryRun {
+		for _, action := range result.Actions {
+			writeStringToPty(ctx, cmd, action.String()+"\n", &outputPos)
+		}
+		writeStringToPty(ctx, cmd, fmt.Sprintf("dry run, %d actions, %d files up to date\n", len(result.Actions), result.Skipped), &outputPos)
+	} else {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("done, %d actions, %s sent, %d files up to date (%v)\n", len(result.Actions), formatByteSize(result.BytesSent), result.Skipped, time.Since(startTime).Round(time.Millisecond)), &outputPos)
+	}
+	exitCode = 0
+}
+
+func FileCopyCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /file:copy [force=1] [remote]:src [remote]:dst")
+	}
+	srcRemote, srcPath, err := resolveRemotePathArg(ctx, ids, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/file:copy invalid src: %v", err)
+	}
+	dstRemote, dstPath, err := resolveRemotePathArg(ctx, ids, pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/file:copy invalid dst: %v", err)
+	}
+	if srcRemote.RemotePtr.RemoteId == dstRemote.RemotePtr.RemoteId && srcPath == dstPath {
+		return nil, fmt.Errorf("/file:copy src and dst are the same")
+	}
+	src := remote.FileSyncEndpoint{MShell: srcRemote.MShell, Path: srcPath}
+	dst := remote.FileSyncEndpoint{MShell: dstRemote.MShell, Path: dstPath}
+	opts := remote.FileCopyOpts{
+		Force: resolveBool(pk.Kwargs["force"], false),
+	}
+	cmd, err := makeFileCmd(ctx, pk, ids)
+	if err != nil {
+		return nil, err
+	}
+	update, err := addLineForCmd(ctx, "/"+GetCmdStr(pk), true, ids, cmd, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	go doFileCopy(cmd, src, dst, opts)
+	return update, nil
+}
+
+func doFileCopy(cmd *sstore.CmdType, src remote.FileSyncEndpoint, dst remote.FileSyncEndpoint, opts remote.FileCopyOpts) {
+	var outputPos int64
+	exitCode := 1
+	startTime := time.Now()
+	ctx, cancelFn := context.WithTimeout(context.Background(), FileCmdTimeout)
+	defer cancelFn()
+	defer func() {
+		r := recover()
+		if r != nil {
+			log.Printf("panic in doFileCopy: %v\n", r)
+			writeStringToPty(ctx, cmd, fmt.Sprintf("\npanic: %v\n", r), &outputPos)
+			exitCode = 1
+		}
+		markDynCmdDone(cmd, startTime, exitCode)
+	}()
+	writeStringToPty(ctx, cmd, fmt.Sprintf("copying [%s]:%s => [%s]:%s\n", src.MShell.GetDisplayName(), src.Path, dst.MShell.GetDisplayName(), dst.Path), &outputPos)
+	var lastProgress time.Time
+	var hasProgressLine bool
+	opts.ProgressFn = func(progress remote.FileCopyProgress) {
+		if time.Since(lastProgress) < fileProgressInterval && progress.BytesDone < progress.Size {
+			return
+		}
+		lastProgress = time.Now()
+		hasProgressLine = true
+		var pct int64 = 100
+		if progress.Size > 0 {
+			pct = progress.BytesDone * 100 / progress.Size
+		}
+		progressStr := fmt.Sprintf("\r\x1b[K%3d%% %s/%s", pct, formatByteSize(progress.BytesDone), formatByteSize(progress.Size))
+		writeStringToPty(ctx, cmd, progressStr, &outputPos)
+	}
+	opts.StatusFn = func(status string) {
+		if hasProgressLine {
+			writeStringToPty(ctx, cmd, "\r\x1b[K", &outputPos)
+			hasProgressLine = false
+		}
+		writeStringToPty(ctx, cmd, status+"\n", &outputPos)
+	}
+	result, err := remote.CopyFile(ctx, src, dst, opts)
+	if hasProgressLine {
+		writeStringToPty(ctx, cmd, "\n", &outputPos)
+	}
+	if err != nil {
+		if result != nil && result.BytesSent > 0 {
+			writeStringToPty(ctx, cmd, fmt.Sprintf("partial data left in %s%s\n", result.DstPath, remote.FileCopyPartialSuffix), &outputPos)
+		}
+		writeStringToPty(ctx, cmd, fmt.Sprintf("error: %v\n", err), &outputPos)
+		return
+	}
+	duration := time.Since(startTime)
+	summaryStr := fmt.Sprintf("copied %s to %s in %v", formatByteSize(result.Size), result.DstPath, duration.Round(time.Millisecond))
+	if result.NumRetries > 0 {
+		summaryStr += fmt.Sprintf(" (%d retries)", result.NumRetries)
+	}
+	writeStringToPty(ctx, cmd, summaryStr+"\n", &outputPos)
+	exitCode = 0
+}
@@ -0,0 +1,231 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxHistoryImportFileSize = 50 * 1024 * 1024
+
+const (
+	HistoryFormatBash = "bash"
+	HistoryFormatZsh  = "zsh"
+)
+
+var bashHistoryTsRe = regexp.MustCompile(`^#([0-9]+)$`)
+var zshHistoryLineRe = regexp.MustCompile(`^: *([0-9]+):[0-9]+;`)
+
+type importedHistoryCmd struct {
+	Ts     int64 // ms, 0 if the history file has no timestamp for this command
+	CmdStr string
+}
+
+func splitHistoryLines(data []byte) []string {
+	str := strings.ReplaceAll(string(data), "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+}
+
+// bash writes a "#[epoch]" line before each command when HISTTIMEFORMAT is set.  in that case everything
+// up to the next timestamp belongs to the command (so multi-line commands stay together).
+func parseBashHistory(data []byte) []importedHistoryCmd {
+	var rtn []importedHistoryCmd
+	var curTs int64
+	var curLines []string
+	flush := func() {
+		cmdStr := strings.TrimSpace(strings.Join(curLines, "\n"))
+		if cmdStr != "" {
+			rtn = append(rtn, importedHistoryCmd{Ts: curTs, CmdStr: cmdStr})
+		}
+		curLines = nil
+	}
+	for _, line := range splitHistoryLines(data) {
+		if m := bashHistoryTsRe.FindStringSubmatch(line); m != nil {
+			flush()
+			tsSec, _ := strconv.ParseInt(m[1], 10, 64)
+			curTs = tsSec * 1000
+			continue
+		}
+		if curTs == 0 {
+			// no timestamps (yet), one command per line
+			curLines = []string{line}
+			flush()
+			continue
+		}
+		curLines = append(curLines, line)
+	}
+	flush()
+	return rtn
+}
+
+// zsh escapes some bytes in its history file, 0x83 means the next byte was xor'ed with 32
+func unmetafyZsh(data []byte) []byte {
+	if bytes.IndexByte(data, 0x83) == -1 {
+		return data
+	}
+	rtn := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x83 && i+1 < len(data) {
+			i++
+			rtn = append(rtn, data[i]^32)
+			continue
+		}
+		rtn = append(rtn, data[i])
+	}
+	return rtn
+}
+
+// handles both extended (": [epoch]:[duration];[cmd]") and plain zsh history.  lines ending with a
+// backslash are continued on the next line.
+func parseZshHistory(data []byte) []importedHistoryCmd {
+	var rtn []importedHistoryCmd
+	var cur *importedHistoryCmd
+	for _, line := range splitHistoryLines(unmetafyZsh(data)) {
+		if cur == nil {
+			cur = &importedHistoryCmd{}
+			if m := zshHistoryLineRe.FindStringSubmatch(line); m != nil {
+				tsSec, _ := strconv.ParseInt(m[1], 10, 64)
+				cur.Ts = tsSec * 1000
+				line = line[len(m[0]):]
+			}
+		}
+		if strings.HasSuffix(line, "\\") {
+			cur.CmdStr += line[0:len(line)-1] + "\n"
+			continue
+		}
+		cur.CmdStr = strings.TrimSpace(cur.CmdStr + line)
+		if cur.CmdStr != "" {
+			rtn = append(rtn, *cur)
+		}
+		cur = nil
+	}
+	if cur != nil && strings.TrimSpace(cur.CmdStr) != "" {
+		cur.CmdStr = strings.TrimSpace(cur.CmdStr)
+		rtn = append(rtn, *cur)
+	}
+	return rtn
+}
+
+func detectHistoryFormat(data []byte) string {
+	for _, line := range splitHistoryLines(data) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if zshHistoryLineRe.MatchString(line) {
+			return HistoryFormatZsh
+		}
+		return HistoryFormatBash
+	}
+	return HistoryFormatBash
+}
+
+func HistoryImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /history:import [format=bash|zsh] [[remote]:path]")
+	}
+	format := pk.Kwargs["format"]
+	if format != "" && format != HistoryFormatBash && format != HistoryFormatZsh {
+		return nil, fmt.Errorf("invalid format '%s', valid formats: %s", format, formatStrs([]string{HistoryFormatBash, HistoryFormatZsh}, "or", false))
+	}
+	pathArg := "~/.bash_history"
+	if format == HistoryFormatZsh {
+		pathArg = "~/.zsh_history"
+	}
+	if len(pk.Args) > 0 {
+		pathArg = pk.Args[0]
+	}
+	rr, fullPath, err := resolveRemotePathArg(ctx, ids, pathArg)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import %v", err)
+	}
+	data, finfo, err := rr.MShell.ReadFile(ctx, fullPath, MaxHistoryImportFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import cannot read %q on [%s]: %v", fullPath, rr.DisplayName, err)
+	}
+	if finfo.NotFound {
+		return nil, fmt.Errorf("/history:import %q not found on [%s]", fullPath, rr.DisplayName)
+	}
+	if format == "" {
+		format = detectHistoryFormat(data)
+	}
+	var cmds []importedHistoryCmd
+	if format == HistoryFormatZsh {
+		cmds = parseZshHistory(data)
+	} else {
+		cmds = parseBashHistory(data)
+	}
+	// commands without a timestamp are older than the first timestamped command (or the file's modtime).
+	// they get consecutive timestamps before that so they keep their order.  only the last occurrence
+	// of each is kept (they are de-duplicated by command since their timestamps are not stable).
+	var tsItems, noTsItems []*sstore.HistoryItemType
+	baseTs := finfo.ModTs
+	for _, cmd := range cmds {
+		if cmd.Ts != 0 {
+			baseTs = cmd.Ts
+			break
+		}
+	}
+	lastNoTsIdx := make(map[string]int)
+	for idx, cmd := range cmds {
+		if cmd.Ts == 0 {
+			lastNoTsIdx[cmd.CmdStr] = idx
+		}
+	}
+	numTooLong := 0
+	for idx, cmd := range cmds {
+		if len(cmd.CmdStr) > MaxCommandLen {
+			numTooLong++
+			continue
+		}
+		hitem := &sstore.HistoryItemType{
+			HistoryId: scbase.GenWaveUUID(),
+			Ts:        cmd.Ts,
+			UserId:    DefaultUserId,
+			CmdStr:    cmd.CmdStr,
+			Remote:    rr.RemotePtr,
+		}
+		if cmd.Ts != 0 {
+			tsItems = append(tsItems, hitem)
+			continue
+		}
+		if lastNoTsIdx[cmd.CmdStr] != idx {
+			continue
+		}
+		hitem.Ts = baseTs - int64(len(cmds)-idx)
+		noTsItems = append(noTsItems, hitem)
+	}
+	numNoTs, err := sstore.ImportHistoryItems(ctx, rr.RemotePtr.RemoteId, noTsItems, false)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import error inserting history items: %v", err)
+	}
+	numTs, err := sstore.ImportHistoryItems(ctx, rr.RemotePtr.RemoteId, tsItems, true)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import error inserting history items: %v", err)
+	}
+	numImported := numNoTs + numTs
+	infoMsg := fmt.Sprintf("imported %d commands from %s history %q, %d duplicates skipped", numImported, format, fullPath, len(cmds)-numTooLong-numImported)
+	if numTooLong > 0 {
+		infoMsg += fmt.Sprintf(", %d commands too long", numTooLong)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   infoMsg,
+			TimeoutMs: 5000,
+		},
+	}
+	return update, nil
+}
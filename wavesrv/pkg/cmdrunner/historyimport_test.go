@@ -0,0 +1,81 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testParseHistory(t *testing.T, name string, parseFn func([]byte) []importedHistoryCmd, data string, expected []importedHistoryCmd) {
+	rtn := parseFn([]byte(data))
+	if !reflect.DeepEqual(rtn, expected) {
+		t.Errorf("%s: got %#v, expected %#v", name, rtn, expected)
+	}
+}
+
+func TestParseBashHistory(t *testing.T) {
+	testParseHistory(t, "plain", parseBashHistory, "ls -l\ncd /tmp\n\necho hi\n", []importedHistoryCmd{
+		{CmdStr: "ls -l"},
+		{CmdStr: "cd /tmp"},
+		{CmdStr: "echo hi"},
+	})
+	testParseHistory(t, "crlf", parseBashHistory, "ls\r\npwd\r\n", []importedHistoryCmd{
+		{CmdStr: "ls"},
+		{CmdStr: "pwd"},
+	})
+	testParseHistory(t, "timestamps", parseBashHistory, "#1700000000\nls -l\n#1700000060\ngit status\n", []importedHistoryCmd{
+		{Ts: 1700000000000, CmdStr: "ls -l"},
+		{Ts: 1700000060000, CmdStr: "git status"},
+	})
+	// with timestamps, everything up to the next timestamp is one command
+	testParseHistory(t, "multiline", parseBashHistory, "#1700000000\nfor x in a b; do\n  echo $x\ndone\n#1700000010\npwd\n", []importedHistoryCmd{
+		{Ts: 1700000000000, CmdStr: "for x in a b; do\n  echo $x\ndone"},
+		{Ts: 1700000010000, CmdStr: "pwd"},
+	})
+	// commands before the first timestamp have no ts
+	testParseHistory(t, "mixed", parseBashHistory, "old1\nold2\n#1700000000\nnew\n", []importedHistoryCmd{
+		{CmdStr: "old1"},
+		{CmdStr: "old2"},
+		{Ts: 1700000000000, CmdStr: "new"},
+	})
+	testParseHistory(t, "empty", parseBashHistory, "", nil)
+}
+
+func TestParseZshHistory(t *testing.T) {
+	testParseHistory(t, "plain", parseZshHistory, "ls -l\ncd /tmp\n", []importedHistoryCmd{
+		{CmdStr: "ls -l"},
+		{CmdStr: "cd /tmp"},
+	})
+	testParseHistory(t, "extended", parseZshHistory, ": 1700000000:0;ls -l\n: 1700000005:12;make build\n", []importedHistoryCmd{
+		{Ts: 1700000000000, CmdStr: "ls -l"},
+		{Ts: 1700000005000, CmdStr: "make build"},
+	})
+	testParseHistory(t, "multiline", parseZshHistory, ": 1700000000:0;for x in a b; do\\\n  echo $x\\\ndone\n: 1700000010:0;pwd\n", []importedHistoryCmd{
+		{Ts: 1700000000000, CmdStr: "for x in a b; do\n  echo $x\ndone"},
+		{Ts: 1700000010000, CmdStr: "pwd"},
+	})
+	// "é" is 0xc3 0xa9, zsh metafies 0xa9 (>= 0x83) as 0x83 0x89
+	testParseHistory(t, "metafied", parseZshHistory, ": 1700000000:0;echo caf\xc3\x83\x89\n", []importedHistoryCmd{
+		{Ts: 1700000000000, CmdStr: "echo café"},
+	})
+	testParseHistory(t, "unterminated", parseZshHistory, ": 1700000000:0;echo a\\", []importedHistoryCmd{
+		{Ts: 1700000000000, CmdStr: "echo a"},
+	})
+}
+
+func TestDetectHistoryFormat(t *testing.T) {
+	tests := map[string]string{
+		"":                            HistoryFormatBash,
+		"ls\npwd\n":                   HistoryFormatBash,
+		"#1700000000\nls\n":           HistoryFormatBash,
+		"\n: 1700000000:0;ls\n":       HistoryFormatZsh,
+		": 1700000000:0;ls\nfoo\nbar": HistoryFormatZsh,
+	}
+	for data, expected := range tests {
+		if format := detectHistoryFormat([]byte(data)); format != expected {
+			t.Errorf("detectHistoryFormat(%q) = %s, expected %s", data, format, expected)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// creates a fresh db (in a temp WAVETERM_HOME) with the local remote and one session, returns the session and screen ids
+func initCmdRunnerTestDB(t *testing.T) resolvedIds {
+	sstore.CloseDB()
+	os.Setenv("WAVETERM_HOME", t.TempDir())
+	t.Cleanup(sstore.CloseDB)
+	err := sstore.TryMigrateUp()
+	if err != nil {
+		t.Fatalf("cannot migrate db: %v", err)
+	}
+	ctx := context.Background()
+	_, err = sstore.EnsureClientData(ctx)
+	if err != nil {
+		t.Fatalf("cannot create client data: %v", err)
+	}
+	err = sstore.EnsureLocalRemote(ctx)
+	if err != nil {
+		t.Fatalf("cannot create local remote: %v", err)
+	}
+	err = sstore.EnsureOneSession(ctx)
+	if err != nil {
+		t.Fatalf("cannot create session: %v", err)
+	}
+	sessions, err := sstore.GetBareSessions(ctx)
+	if err != nil || len(sessions) == 0 {
+		t.Fatalf("cannot get session: %v", err)
+	}
+	screens, err := sstore.GetSessionScreens(ctx, sessions[0].SessionId)
+	if err != nil || len(screens) == 0 {
+		t.Fatalf("cannot get screen: %v", err)
+	}
+	return resolvedIds{SessionId: sessions[0].SessionId, ScreenId: screens[0].ScreenId}
+}
+
+// adds a done cmd (and its history item) to the screen
+func addCmdRunnerTestHistory(t *testing.T, ids resolvedIds, cmdStr string, ts time.Time, exitCode int, durationMs int) {
+	ctx := context.Background()
+	localRemote, err := sstore.GetLocalRemote(ctx)
+	if err != nil {
+		t.Fatalf("cannot get local remote: %v", err)
+	}
+	remotePtr := sstore.RemotePtrType{RemoteId: localRemote.RemoteId}
+	cmd := &sstore.CmdType{
+		ScreenId:   ids.ScreenId,
+		LineId:     uuid.New().String(),
+		Remote:     remotePtr,
+		CmdStr:     cmdStr,
+		Status:     sstore.CmdStatusDone,
+		ExitCode:   exitCode,
+		DurationMs: durationMs,
+	}
+	_, err = sstore.AddCmdLine(ctx, ids.ScreenId, "user", cmd, "", nil)
+	if err != nil {
+		t.Fatalf("cannot add cmd line: %v", err)
+	}
+	hitem := &sstore.HistoryItemType{
+		HistoryId: uuid.New().String(),
+		Ts:        ts.UnixMilli(),
+		SessionId: ids.SessionId,
+		ScreenId:  ids.ScreenId,
+		LineId:    cmd.LineId,
+		CmdStr:    cmdStr,
+		Remote:    remotePtr,
+	}
+	err = sstore.InsertHistoryItem(ctx, hitem)
+	if err != nil {
+		t.Fatalf("cannot insert history item: %v", err)
+	}
+}
+
+func TestResolveHistoryStatsOpts(t *testing.T) {
+	ids := resolvedIds{SessionId: uuid.New().String(), ScreenId: uuid.New().String()}
+	makePk := func(kwargs map[string]string) *scpacket.FeCommandPacketType {
+		return &scpacket.FeCommandPacketType{MetaCmd: "history", MetaSubCmd: "stats", Kwargs: kwargs}
+	}
+	opts, err := resolveHistoryStatsOpts(makePk(map[string]string{}), ids)
+	if err != nil {
+		t.Fatalf("error resolving default opts: %v", err)
+	}
+	if !reflect.DeepEqual(opts, sstore.HistoryStatsOpts{MaxItems: DefaultMaxHistoryStatsItems}) {
+		t.Errorf("default opts: got %+v", opts)
+	}
+	opts, err = resolveHistoryStatsOpts(makePk(map[string]string{"type": "session", "sort": "failrate", "maxitems": "5"}), ids)
+	if err != nil {
+		t.Fatalf("error resolving session opts: %v", err)
+	}
+	if !reflect.DeepEqual(opts, sstore.HistoryStatsOpts{SessionId: ids.SessionId, SortBy: sstore.HistoryStatsSortFailRate, MaxItems: 5}) {
+		t.Errorf("session opts: got %+v", opts)
+	}
+	opts, err = resolveHistoryStatsOpts(makePk(map[string]string{"type": "screen", "since": "1h"}), ids)
+	if err != nil {
+		t.Fatalf("error resolving screen opts: %v", err)
+	}
+	expectedMinTs := time.Now().Add(-time.Hour).UnixMilli()
+	if opts.SessionId != ids.SessionId || opts.ScreenId != ids.ScreenId || opts.MinTs < expectedMinTs-1000 || opts.MinTs > expectedMinTs {
+		t.Errorf("screen opts: got %+v, expected mints ~%d", opts, expectedMinTs)
+	}
+	for _, kwargs := range []map[string]string{{"type": "bogus"}, {"since": "soon"}, {"maxitems": "x"}} {
+		_, err = resolveHistoryStatsOpts(makePk(kwargs), ids)
+		if err == nil {
+			t.Errorf("kwargs %v should return an error", kwargs)
+		}
+	}
+}
+
+func TestGetHistoryStatsRows(t *testing.T) {
+	ids := initCmdRunnerTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	addCmdRunnerTestHistory(t, ids, "make", now.Add(-3*time.Hour), 0, 1000)
+	addCmdRunnerTestHistory(t, ids, "make", now.Add(-2*time.Hour), 2, 3000)
+	addCmdRunnerTestHistory(t, ids, "ls", now.Add(-time.Minute), 0, 10)
+
+	rows, err := getHistoryStatsRows(ctx, HistoryStatsByCmd, sstore.HistoryStatsOpts{MaxItems: 10})
+	if err != nil {
+		t.Fatalf("cannot get cmd stats rows: %v", err)
+	}
+	expected := [][]string{
+		{"command", "runs", "failed", "fail %", "avg ms", "max ms", "last run"},
+		{"make", "2", "1", "50.0", "2000", "3000", formatStatsTs(now.Add(-2 * time.Hour).UnixMilli())},
+		{"ls", "1", "0", "0.0", "10", "10", formatStatsTs(now.Add(-time.Minute).UnixMilli())},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("cmd stats rows: got %v, expected %v", rows, expected)
+	}
+
+	// since=1h only counts ls
+	rows, err = getHistoryStatsRows(ctx, HistoryStatsByRemote, sstore.HistoryStatsOpts{MinTs: now.Add(-time.Hour).UnixMilli(), MaxItems: 10})
+	if err != nil {
+		t.Fatalf("cannot get remote stats rows: %v", err)
+	}
+	expected = [][]string{
+		{"remote", "runs", "failed", "fail %", "last run"},
+		{sstore.LocalRemoteAlias, "1", "0", "0.0", formatStatsTs(now.Add(-time.Minute).UnixMilli())},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("remote stats rows: got %v, expected %v", rows, expected)
+	}
+
+	rows, err = getHistoryStatsRows(ctx, HistoryStatsByHour, sstore.HistoryStatsOpts{ScreenId: ids.ScreenId})
+	if err != nil {
+		t.Fatalf("cannot get hour stats rows: %v", err)
+	}
+	if len(rows) != 25 {
+		t.Fatalf("hour stats: got %d rows, expected 25", len(rows))
+	}
+	var totalRuns, totalFailed int
+	for _, row := range rows[1:] {
+		numRuns, _ := strconv.Atoi(row[1])
+		numFailed, _ := strconv.Atoi(row[2])
+		totalRuns += numRuns
+		totalFailed += numFailed
+	}
+	if rows[1][0] != "00:00" || rows[24][0] != "23:00" || totalRuns != 3 || totalFailed != 1 {
+		t.Errorf("hour stats: got %v", rows)
+	}
+
+	_, err = getHistoryStatsRows(ctx, "bogus", sstore.HistoryStatsOpts{MaxItems: 10})
+	if err == nil {
+		t.Errorf("invalid by should return an error")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const LineFormatCast = "cast"
+
+func getLineFormat(formatArg string, fullPath string) (string, error) {
+	if formatArg == "" {
+		if strings.ToLower(filepath.Ext(fullPath)) == ".cast" {
+			return LineFormatCast, nil
+		}
+		return "", fmt.Errorf("cannot determine format from %q, set format=%s", filepath.Base(fullPath), LineFormatCast)
+	}
+	if formatArg != LineFormatCast {
+		return "", fmt.Errorf("invalid format %q, valid formats: %s", formatArg, LineFormatCast)
+	}
+	return formatArg, nil
+}
+
+func LineExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /line:export [format=cast] [line] [path]")
+	}
+	lineArg := pk.Args[0]
+	fullPath, err := resolveLocalFilePath(pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/line:export %v", err)
+	}
+	_, err = getLineFormat(pk.Kwargs["format"], fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/line:export %v", err)
+	}
+	lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, lineArg)
+	if err != nil {
+		return nil, fmt.Errorf("/line:export error looking up lineid: %v", err)
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("/line:export line %q not found", lineArg)
+	}
+	line, cmd, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("/line:export error getting line: %v", err)
+	}
+	if line == nil || cmd == nil || line.LineType != sstore.LineTypeCmd {
+		return nil, fmt.Errorf("/line:export line %q is not a command line", lineArg)
+	}
+	dataOffset, data, err := sstore.ReadFullPtyOutData(ctx, ids.ScreenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("/line:export cannot read output: %v", err)
+	}
+	timing, err := sstore.ReadPtyTiming(ids.ScreenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("/line:export cannot read output timing: %v", err)
+	}
+	startTs := line.Ts
+	if len(timing) > 0 && timing[0].Ts < startTs {
+		startTs = timing[0].Ts
+	}
+	events := sstore.MakeAsciicastEvents(startTs, dataOffset, data, timing)
+	header := &sstore.AsciicastHeader{
+		Width:     int(cmd.TermOpts.Cols),
+		Height:    int(cmd.TermOpts.Rows),
+		Timestamp: startTs / 1000,
+		Command:   cmd.CmdStr,
+		Title:     cmd.CmdStr,
+	}
+	if len(events) > 0 {
+		header.Duration = events[len(events)-1].Time
+	}
+	fd, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("/line:export cannot create %q: %v", fullPath, err)
+	}
+	err = sstore.WriteAsciicast(fd, header, events)
+	closeErr := fd.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("/line:export cannot write %q: %v", fullPath, err)
+	}
+	infoMsg := fmt.Sprintf("exported line %d to %q (%d events)", line.LineNum, fullPath, len(events))
+	if len(timing) == 0 {
+		infoMsg += ", no timing information recorded for this line"
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   infoMsg,
+			TimeoutMs: 5000,
+		},
+	}
+	return update, nil
+}
+
+// creates a new (done) command line that holds the output of a .cast recording
+func LineImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /line:import [format=cast] [path]")
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/line:import %v", err)
+	}
+	_, err = getLineFormat(pk.Kwargs["format"], fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/line:import %v", err)
+	}
+	fd, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/line:import cannot open %q: %v", fullPath, err)
+	}
+	header, events, err := sstore.ReadAsciicast(fd)
+	fd.Close()
+	if err != nil {
+		return nil, fmt.Errorf("/line:import cannot read %q: %v", fullPath, err)
+	}
+	cmdStr := header.Command
+	if cmdStr == "" {
+		cmdStr = header.Title
+	}
+	if cmdStr == "" {
+		cmdStr = "asciinema play " + filepath.Base(fullPath)
+	}
+	var durationMs int
+	if len(events) > 0 {
+		durationMs = int(events[len(events)-1].Time * 1000)
+	}
+	rows, cols := header.Height, header.Width
+	if rows == 0 {
+		rows = shexec.DefaultTermRows
+	}
+	if cols == 0 {
+		cols = shexec.DefaultTermCols
+	}
+	maxPtySize := base.BoundInt64(sstore.AsciicastOutputSize(events), remote.DefaultMaxPtySize, shexec.MaxMaxPtySize)
+	cmd := &sstore.CmdType{
+		ScreenId:   ids.ScreenId,
+		LineId:     scbase.GenWaveUUID(),
+		CmdStr:     cmdStr,
+		RawCmdStr:  cmdStr,
+		Remote:     ids.Remote.RemotePtr,
+		TermOpts:   sstore.TermOpts{Rows: int64(base.BoundInt(rows, shexec.MinTermRows, shexec.MaxTermRows)), Cols: int64(base.BoundInt(cols, shexec.MinTermCols, shexec.MaxTermCols)), FlexRows: true, MaxPtySize: maxPtySize},
+		Status:     sstore.CmdStatusDone,
+		DoneTs:     time.Now().UnixMilli(),
+		DurationMs: durationMs,
+	}
+	cmd.OrigTermOpts = cmd.TermOpts
+	if ids.Remote.StatePtr != nil {
+		cmd.StatePtr = *ids.Remote.StatePtr
+	}
+	if ids.Remote.FeState != nil {
+		cmd.FeState = ids.Remote.FeState
+	}
+	err = sstore.CreateCmdPtyFile(ctx, cmd.ScreenId, cmd.LineId, cmd.TermOpts.MaxPtySize)
+	if err != nil {
+		return nil, fmt.Errorf("/line:import cannot create ptyout file: %v", err)
+	}
+	startTs := header.Timestamp * 1000
+	if startTs == 0 {
+		startTs = time.Now().UnixMilli()
+	}
+	err = sstore.WriteAsciicastPtyOut(ctx, cmd.ScreenId, cmd.LineId, startTs, events)
+	if err != nil {
+		sstore.DeletePtyOutFile(ctx, cmd.ScreenId, cmd.LineId)
+		return nil, fmt.Errorf("/line:import cannot write output: %v", err)
+	}
+	update, err := addLineForCmd(ctx, "/line:import", false, ids, cmd, "", nil)
+	if err != nil {
+		sstore.DeletePtyOutFile(ctx, cmd.ScreenId, cmd.LineId)
+		return nil, err
+	}
+	update.Info = &sstore.InfoMsgType{
+		InfoMsg:   fmt.Sprintf("imported %q (%d events)", filepath.Base(fullPath), len(events)),
+		TimeoutMs: 3000,
+	}
+	return update, nil
+}
@@ -0,0 +1,429 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// default per-entry timeout (override with /playbook:run timeout=)
+const PlaybookCmdTimeout = 12 * time.Hour
+
+// only one playbook can run in a screen at a time
+type playbookRun struct {
+	PlaybookName string
+	CancelFn     context.CancelFunc
+}
+
+var playbookRunsLock = &sync.Mutex{}
+var playbookRuns = make(map[string]*playbookRun) // screenid -> run
+
+func resolvePlaybookArg(ctx context.Context, playbookArg string) (*sstore.PlaybookType, error) {
+	playbookId, err := sstore.GetPlaybookIdByArg(ctx, playbookArg)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to resolve playbook: %v", err)
+	}
+	if playbookId == "" {
+		return nil, fmt.Errorf("playbook %q not found", playbookArg)
+	}
+	playbook, err := sstore.GetPlaybookById(ctx, playbookId)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving playbook: %v", err)
+	}
+	if playbook == nil {
+		return nil, fmt.Errorf("playbook %q not found", playbookArg)
+	}
+	return playbook, nil
+}
+
+// entryArg is a 1-based entry number or an entryid
+func resolvePlaybookEntryArg(playbook *sstore.PlaybookType, entryArg string) (*sstore.PlaybookEntry, error) {
+	if entryNum, err := strconv.Atoi(entryArg); err == nil {
+		if entryNum < 1 || entryNum > len(playbook.Entries) {
+			return nil, fmt.Errorf("playbook %q has no entry %d (it has %d entries)", playbook.PlaybookName, entryNum, len(playbook.Entries))
+		}
+		return playbook.Entries[entryNum-1], nil
+	}
+	for _, entry := range playbook.Entries {
+		if entry.EntryId == entryArg || (len(entryArg) == 8 && entry.EntryId[0:8] == entryArg) {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("playbook %q has no entry %q", playbook.PlaybookName, entryArg)
+}
+
+func PlaybookNewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /playbook:new [name] [desc=description]")
+	}
+	name := pk.Args[0]
+	err := validateName(name, "playbook")
+	if err != nil {
+		return nil, err
+	}
+	playbook, err := sstore.CreatePlaybook(ctx, name, pk.Kwargs["desc"])
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:new error: %v", err)
+	}
+	return &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("playbook %q created", playbook.PlaybookName),
+			TimeoutMs: 2000,
+		},
+	}, nil
+}
+
+// the command comes from line=[line], bookmark=[bookmark], or cmd=[cmdstr]
+func PlaybookAddCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /playbook:add [playbook] (line=[line] | bookmark=[bookmark] | cmd=[command]) [desc=description]")
+	}
+	playbook, err := resolvePlaybookArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	var cmdStr string
+	description := pk.Kwargs["desc"]
+	if lineArg := pk.Kwargs["line"]; lineArg != "" {
+		ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+		if err != nil {
+			return nil, err
+		}
+		lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, lineArg)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up lineid: %v", err)
+		}
+		if lineId == "" {
+			return nil, fmt.Errorf("line %q not found", lineArg)
+		}
+		_, cmdObj, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineId)
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:add error getting line: %v", err)
+		}
+		if cmdObj == nil {
+			return nil, fmt.Errorf("cannot add non-cmd line to a playbook")
+		}
+		cmdStr = cmdObj.CmdStr
+	} else if bookmarkArg := pk.Kwargs["bookmark"]; bookmarkArg != "" {
+		bookmarkId, err := sstore.GetBookmarkIdByArg(ctx, bookmarkArg)
+		if err != nil {
+			return nil, fmt.Errorf("error trying to resolve bookmark: %v", err)
+		}
+		if bookmarkId == "" {
+			return nil, fmt.Errorf("bookmark not found")
+		}
+		bm, err := sstore.GetBookmarkById(ctx, bookmarkId, "")
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving bookmark: %v", err)
+		}
+		cmdStr = bm.CmdStr
+		if description == "" {
+			description = bm.Description
+		}
+	} else {
+		cmdStr = pk.Kwargs["cmd"]
+	}
+	if cmdStr == "" {
+		return nil, fmt.Errorf("/playbook:add requires line=[line], bookmark=[bookmark], or cmd=[command]")
+	}
+	if len(cmdStr) > MaxCommandLen {
+		return nil, fmt.Errorf("command length too long len:%d, max:%d", len(cmdStr), MaxCommandLen)
+	}
+	nowTs := time.Now().UnixMilli()
+	entry := &sstore.PlaybookEntry{
+		PlaybookId:  playbook.PlaybookId,
+		EntryId:     uuid.New().String(),
+		Alias:       pk.Kwargs["alias"],
+		CmdStr:      cmdStr,
+		CreatedTs:   nowTs,
+		UpdatedTs:   nowTs,
+		Description: description,
+	}
+	err = sstore.AddPlaybookEntry(ctx, entry)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:add error: %v", err)
+	}
+	return &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("added entry %d to playbook %q", len(playbook.Entries)+1, playbook.PlaybookName),
+			TimeoutMs: 2000,
+		},
+	}, nil
+}
+
+// with no arguments lists all of the playbooks
+func PlaybookShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	var buf bytes.Buffer
+	if len(pk.Args) == 0 {
+		playbooks, err := sstore.GetAllPlaybooks(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:show error: %v", err)
+		}
+		if len(playbooks) == 0 {
+			return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: "no playbooks (create one with /playbook:new)"}}, nil
+		}
+		for _, playbook := range playbooks {
+			buf.WriteString(fmt.Sprintf("%-20s  %3d entries  %s\n", playbook.PlaybookName, len(playbook.EntryIds), playbook.Description))
+		}
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoTitle: "playbooks", InfoLines: splitLinesForInfo(buf.String())}}, nil
+	}
+	playbook, err := resolvePlaybookArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	infoTitle := fmt.Sprintf("playbook %q", playbook.PlaybookName)
+	if playbook.Description != "" {
+		buf.WriteString(playbook.Description + "\n")
+	}
+	if len(playbook.Entries) == 0 {
+		buf.WriteString("(no entries, add one with /playbook:add)\n")
+	}
+	for idx, entry := range playbook.Entries {
+		buf.WriteString(fmt.Sprintf("%3d) %s\n", idx+1, entry.CmdStr))
+		if entry.Description != "" {
+			buf.WriteString(fmt.Sprintf("       # %s\n", entry.Description))
+		}
+	}
+	return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoTitle: infoTitle, InfoLines: splitLinesForInfo(buf.String())}}, nil
+}
+
+func PlaybookRemoveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /playbook:remove [playbook] [entry number or id]")
+	}
+	playbook, err := resolvePlaybookArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	entry, err := resolvePlaybookEntryArg(playbook, pk.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = sstore.RemovePlaybookEntry(ctx, playbook.PlaybookId, entry.EntryId)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:remove error: %v", err)
+	}
+	return &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("removed %q from playbook %q", entry.CmdStr, playbook.PlaybookName),
+			TimeoutMs: 2000,
+		},
+	}, nil
+}
+
+// runs the entries in order in the current screen (in the background, each entry waits for the previous one
+// to finish).  stops on the first failure unless continue=1 is set.  each entry is interrupted (SIGINT) if it
+// runs longer than timeout (a duration or milliseconds).  /playbook:cancel stops the run.
+func PlaybookRunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /playbook:run [playbook] [continue=1] [timeout=duration]")
+	}
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	playbook, err := resolvePlaybookArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(playbook.Entries) == 0 {
+		return nil, fmt.Errorf("playbook %q has no entries", playbook.PlaybookName)
+	}
+	continueOnError := resolveBool(pk.Kwargs["continue"], false)
+	stepTimeout := PlaybookCmdTimeout
+	if timeoutArg, found := pk.Kwargs["timeout"]; found {
+		timeoutMs, err := resolveDurationMs(timeoutArg)
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:run invalid timeout: %v", err)
+		}
+		if timeoutMs == 0 {
+			return nil, fmt.Errorf("/playbook:run invalid timeout: must be greater than 0")
+		}
+		stepTimeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	runCtx, cancelFn := context.WithCancel(context.Background())
+	playbookRunsLock.Lock()
+	if oldRun := playbookRuns[ids.ScreenId]; oldRun != nil {
+		playbookRunsLock.Unlock()
+		cancelFn()
+		return nil, fmt.Errorf("playbook %q is already running in this screen (stop it with /playbook:cancel)", oldRun.PlaybookName)
+	}
+	run := &playbookRun{PlaybookName: playbook.PlaybookName, CancelFn: cancelFn}
+	playbookRuns[ids.ScreenId] = run
+	playbookRunsLock.Unlock()
+	go func() {
+		defer func() {
+			playbookRunsLock.Lock()
+			if playbookRuns[ids.ScreenId] == run {
+				delete(playbookRuns, ids.ScreenId)
+			}
+			playbookRunsLock.Unlock()
+			cancelFn()
+		}()
+		runPlaybook(runCtx, playbook, ids.ScreenId, pk.UIContext, continueOnError, stepTimeout)
+	}()
+	return &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("running playbook %q (%d entries)", playbook.PlaybookName, len(playbook.Entries)),
+			TimeoutMs: 2000,
+		},
+	}, nil
+}
+
+// stops the playbook running in the current screen (the running entry is interrupted)
+func PlaybookCancelCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	playbookRunsLock.Lock()
+	run := playbookRuns[ids.ScreenId]
+	playbookRunsLock.Unlock()
+	if run == nil {
+		return nil, fmt.Errorf("no playbook is running in this screen")
+	}
+	run.CancelFn()
+	return &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("canceling playbook %q", run.PlaybookName),
+			TimeoutMs: 2000,
+		},
+	}, nil
+}
+
+func sendPlaybookInfo(infoMsg string) {
+	sstore.MainBus.SendUpdate(&sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: infoMsg}})
+}
+
+func runPlaybook(ctx context.Context, playbook *sstore.PlaybookType, screenId string, uiContext *scpacket.UIContextType, continueOnError bool, stepTimeout time.Duration) {
+	numFailed := 0
+	for idx, entry := range playbook.Entries {
+		if ctx.Err() != nil {
+			sendPlaybookInfo(fmt.Sprintf("playbook %q canceled before entry %d (%s)", playbook.PlaybookName, idx+1, entry.CmdStr))
+			return
+		}
+		errStr := runPlaybookEntry(ctx, entry, screenId, uiContext, stepTimeout)
+		if errStr == "" {
+			continue
+		}
+		numFailed++
+		if !continueOnError || ctx.Err() != nil {
+			sendPlaybookInfo(fmt.Sprintf("playbook %q stopped at entry %d (%s): %s", playbook.PlaybookName, idx+1, entry.CmdStr, errStr))
+			return
+		}
+	}
+	if numFailed > 0 {
+		sendPlaybookInfo(fmt.Sprintf("playbook %q done, %d of %d entries failed", playbook.PlaybookName, numFailed, len(playbook.Entries)))
+		return
+	}
+	sendPlaybookInfo(fmt.Sprintf("playbook %q done (%d entries)", playbook.PlaybookName, len(playbook.Entries)))
+}
+
+// runs the entry through eval (like it was typed in) and waits for its command to finish.
+// returns an error string if the entry failed (or was canceled / timed out).
+func runPlaybookEntry(runCtx context.Context, entry *sstore.PlaybookEntry, screenId string, uiContext *scpacket.UIContextType, stepTimeout time.Duration) string {
+	ctx, cancelFn := context.WithTimeout(runCtx, stepTimeout)
+	defer cancelFn()
+	evalPk := scpacket.MakeFeCommandPacket()
+	evalPk.MetaCmd = "eval"
+	evalPk.Args = []string{entry.CmdStr}
+	evalPk.Kwargs = make(map[string]string)
+	evalPk.RawStr = entry.CmdStr
+	evalPk.UIContext = uiContext
+	update, historyContext, err := evalCommandWithHistoryContext(ctx, evalPk)
+	if err != nil {
+		return err.Error()
+	}
+	if modelUpdate, ok := update.(*sstore.ModelUpdate); ok && modelUpdate != nil {
+		sstore.MainBus.SendUpdate(modelUpdate)
+	}
+	if historyContext.LineId == "" {
+		// metacommands that do not create a line are done when eval returns
+		return ""
+	}
+	cmd, err := waitForCmdDone(ctx, screenId, historyContext.LineId)
+	if ctx.Err() != nil {
+		interruptPlaybookCmd(screenId, historyContext.LineId)
+		if runCtx.Err() != nil {
+			return "canceled"
+		}
+		return fmt.Sprintf("timed out after %v", stepTimeout)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if cmd == nil {
+		return ""
+	}
+	if cmd.Status == sstore.CmdStatusError || cmd.Status == sstore.CmdStatusHangup {
+		return fmt.Sprintf("command %s", cmd.Status)
+	}
+	if cmd.ExitCode != 0 {
+		return fmt.Sprintf("exit code %d", cmd.ExitCode)
+	}
+	return ""
+}
+
+// sends SIGINT to the entry's command if it is still running
+func interruptPlaybookCmd(screenId string, lineId string) {
+	cmd, err := sstore.GetCmdByScreenId(context.Background(), screenId, lineId)
+	if err != nil || cmd == nil || cmd.Status != sstore.CmdStatusRunning {
+		return
+	}
+	msh := remote.GetRemoteById(cmd.Remote.RemoteId)
+	if msh == nil || !msh.IsConnected() {
+		return
+	}
+	siPk := packet.MakeSpecialInputPacket()
+	siPk.CK = base.MakeCommandKey(screenId, lineId)
+	siPk.SigName = "SIGINT"
+	err = msh.SendSpecialInput(siPk)
+	if err != nil {
+		log.Printf("[playbook] error interrupting cmd %s/%s: %v\n", screenId, lineId, err)
+	}
+}
+
+// waits for the cmd to be removed from its remote's running cmds.  that happens after the done (or hangup)
+// is processed, so any returned state is applied before the next entry runs.
+func waitForCmdDone(ctx context.Context, screenId string, lineId string) (*sstore.CmdType, error) {
+	cmd, err := sstore.GetCmdByScreenId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cmd: %v", err)
+	}
+	if cmd == nil {
+		return nil, nil
+	}
+	msh := remote.GetRemoteById(cmd.Remote.RemoteId)
+	if msh != nil {
+		rct := msh.GetRunningCmd(base.MakeCommandKey(screenId, lineId))
+		if rct != nil && rct.DoneCh != nil {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-rct.DoneCh:
+			}
+		}
+	}
+	cmd, err = sstore.GetCmdByScreenId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cmd: %v", err)
+	}
+	if cmd != nil && (cmd.Status == sstore.CmdStatusRunning || cmd.Status == sstore.CmdStatusDetached) {
+		return nil, fmt.Errorf("command is %s, but is not running on its remote", cmd.Status)
+	}
+	return cmd, nil
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -517,3 +518,52 @@ func resolveRemote(ctx context.Context, fullRemoteRef string, sessionId string,
 	}
 	return rname, &rptr, rstate, nil
 }
+
+// parses "[remote]:path", "remote:path", or a plain path (on the current remote).
+// returns the (connected) remote and the absolute path on that remote.
+func resolveRemotePathArg(ctx context.Context, ids resolvedIds, arg string) (*ResolvedRemote, string, error) {
+	remoteRef := ""
+	pathStr := arg
+	if strings.HasPrefix(arg, "[") {
+		closeIdx := strings.Index(arg, "]:")
+		if closeIdx == -1 {
+			return nil, "", fmt.Errorf("invalid remote path %q, expected [remote]:path", arg)
+		}
+		remoteRef = arg[1:closeIdx]
+		pathStr = arg[closeIdx+2:]
+	} else if colonIdx := strings.Index(arg, ":"); colonIdx > 0 && remote.GetRemoteByArg(arg[0:colonIdx]) != nil {
+		remoteRef = arg[0:colonIdx]
+		pathStr = arg[colonIdx+1:]
+	}
+	if pathStr == "" {
+		return nil, "", fmt.Errorf("invalid remote path %q, path is empty", arg)
+	}
+	rr := ids.Remote
+	if remoteRef != "" {
+		rptr, err := resolveRemoteArg(remoteRef)
+		if err != nil {
+			return nil, "", err
+		}
+		if rptr == nil {
+			return nil, "", fmt.Errorf("remote %q not found", remoteRef)
+		}
+		rr, err = ResolveRemoteFromPtr(ctx, rptr, ids.SessionId, ids.ScreenId)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if rr == nil {
+		return nil, "", fmt.Errorf("no remote")
+	}
+	if !rr.RState.IsConnected() {
+		return nil, "", fmt.Errorf("remote [%s] is not connected", rr.DisplayName)
+	}
+	fullPath, err := resolveRemoteFilePath(rr, pathStr)
+	if err != nil {
+		return nil, "", err
+	}
+	if !filepath.IsAbs(fullPath) {
+		return nil, "", fmt.Errorf("cannot resolve path %q on [%s], no current directory", pathStr, rr.DisplayName)
+	}
+	return rr, fullPath, nil
+}
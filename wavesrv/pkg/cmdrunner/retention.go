@@ -0,0 +1,244 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const RetentionInitialDelay = 5 * time.Minute
+const RetentionInterval = time.Hour
+const RetentionPreviewMaxLines = 20
+
+func formatRetentionOpts(opts *sstore.RetentionOptsType) string {
+	if !opts.IsEnabled() {
+		return "off"
+	}
+	var parts []string
+	if opts.MaxAgeDays > 0 {
+		parts = append(parts, fmt.Sprintf("maxage=%dd", opts.MaxAgeDays))
+	}
+	if opts.MaxPtyBytes > 0 {
+		parts = append(parts, fmt.Sprintf("maxptysize=%s", scbase.NumFormatB2(opts.MaxPtyBytes)))
+	}
+	if opts.MaxLinesPerScreen > 0 {
+		parts = append(parts, fmt.Sprintf("maxlines=%d", opts.MaxLinesPerScreen))
+	}
+	if opts.KeepStarred {
+		parts = append(parts, "keepstarred")
+	}
+	if opts.KeepBookmarked {
+		parts = append(parts, "keepbookmarked")
+	}
+	parts = append(parts, "action="+opts.GetAction())
+	return strings.Join(parts, " ")
+}
+
+// updates opts from the command kwargs, returns true if anything was set
+func setRetentionOptsFromKwargs(opts *sstore.RetentionOptsType, kwargs map[string]string) (bool, error) {
+	var updated bool
+	if maxAgeStr, found := kwargs["maxage"]; found {
+		maxAge, err := resolveNonNegInt(strings.TrimSuffix(maxAgeStr, "d"), 0)
+		if err != nil {
+			return false, fmt.Errorf("invalid maxage (number of days): %v", err)
+		}
+		opts.MaxAgeDays = maxAge
+		updated = true
+	}
+	if maxSizeStr, found := kwargs["maxptysize"]; found {
+		maxSize, err := resolveByteSize(maxSizeStr)
+		if err != nil {
+			return false, fmt.Errorf("invalid maxptysize: %v", err)
+		}
+		opts.MaxPtyBytes = maxSize
+		updated = true
+	}
+	if maxLinesStr, found := kwargs["maxlines"]; found {
+		maxLines, err := resolveNonNegInt(maxLinesStr, 0)
+		if err != nil {
+			return false, fmt.Errorf("invalid maxlines: %v", err)
+		}
+		opts.MaxLinesPerScreen = maxLines
+		updated = true
+	}
+	if keepStr, found := kwargs["keepstarred"]; found {
+		opts.KeepStarred = resolveBool(keepStr, true)
+		updated = true
+	}
+	if keepStr, found := kwargs["keepbookmarked"]; found {
+		opts.KeepBookmarked = resolveBool(keepStr, true)
+		updated = true
+	}
+	if action, found := kwargs["action"]; found {
+		if action != sstore.RetentionActionPurge && action != sstore.RetentionActionArchive {
+			return false, fmt.Errorf("invalid action %q, must be %s", action, formatStrs([]string{sstore.RetentionActionPurge, sstore.RetentionActionArchive}, "or", false))
+		}
+		opts.Action = action
+		updated = true
+	}
+	err := opts.Validate()
+	if err != nil {
+		return false, err
+	}
+	return updated, nil
+}
+
+func formatRetentionPreview(opts *sstore.RetentionOptsType, lines []*sstore.RetentionLineType) string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "rules", formatRetentionOpts(opts)))
+	if !opts.IsEnabled() {
+		return buf.String()
+	}
+	var totalBytes int64
+	reasonCounts := make(map[string]int)
+	screenCounts := make(map[string]int)
+	var screenNames []string
+	for _, line := range lines {
+		totalBytes += line.PtyBytes
+		reasonCounts[line.Reason]++
+		screenName := line.SessionName + "/" + line.ScreenName
+		if screenCounts[screenName] == 0 {
+			screenNames = append(screenNames, screenName)
+		}
+		screenCounts[screenName]++
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %d lines would be %sd (%s of output)\n", "preview", len(lines), opts.GetAction(), scbase.NumFormatB2(totalBytes)))
+	if len(lines) == 0 {
+		return buf.String()
+	}
+	for _, reason := range []string{sstore.RetentionReasonAge, sstore.RetentionReasonLines, sstore.RetentionReasonPtyBytes} {
+		if reasonCounts[reason] == 0 {
+			continue
+		}
+		if reason == sstore.RetentionReasonPtyBytes {
+			buf.WriteString(fmt.Sprintf("  %-15s %d lines (output deleted, not moved to the trash)\n", "  "+reason, reasonCounts[reason]))
+		} else {
+			buf.WriteString(fmt.Sprintf("  %-15s %d lines\n", "  "+reason, reasonCounts[reason]))
+		}
+	}
+	buf.WriteString("\n")
+	for _, screenName := range screenNames {
+		buf.WriteString(fmt.Sprintf("  %-30s %d lines\n", screenName, screenCounts[screenName]))
+	}
+	buf.WriteString("\n")
+	for idx, line := range lines {
+		if idx >= RetentionPreviewMaxLines {
+			buf.WriteString(fmt.Sprintf("  ... and %d more\n", len(lines)-idx))
+			break
+		}
+		cmdStr := line.CmdStr
+		if len(cmdStr) > 40 {
+			cmdStr = cmdStr[0:37] + "..."
+		}
+		ts := time.UnixMilli(line.Ts).Format(TsFormatStr)
+		buf.WriteString(fmt.Sprintf("  %s  %-20s %-5s %-10s %8s  %s\n", ts, line.ScreenName, "#"+strconv.FormatInt(line.LineNum, 10), line.Reason, scbase.NumFormatB2(line.PtyBytes), cmdStr))
+	}
+	return buf.String()
+}
+
+func applyRetention(ctx context.Context, opts *sstore.RetentionOptsType) (int, error) {
+	lines, err := sstore.GetRetentionCandidates(ctx, opts, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+	update, err := sstore.ApplyRetention(ctx, opts, lines)
+	if update != nil && len(update.Lines) > 0 {
+		if opts.GetAction() == sstore.RetentionActionPurge {
+			for _, line := range update.Lines {
+				go remote.UnWatchLineAllRemotes(line.ScreenId, line.LineId)
+			}
+		}
+		sstore.MainBus.SendUpdate(update)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(lines), nil
+}
+
+// /client:retention shows the rules and a preview of what they would remove,
+// kwargs set the rules, and apply=1 enforces them now (otherwise they run in the background)
+func ClientRetentionCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	opts := &sstore.RetentionOptsType{}
+	if clientData.ClientOpts.Retention != nil {
+		*opts = *clientData.ClientOpts.Retention
+	}
+	updated, err := setRetentionOptsFromKwargs(opts, pk.Kwargs)
+	if err != nil {
+		return nil, fmt.Errorf("/client:retention %v", err)
+	}
+	if updated {
+		clientOpts := clientData.ClientOpts
+		clientOpts.Retention = opts
+		if !opts.IsEnabled() {
+			clientOpts.Retention = nil
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("/client:retention error saving rules: %v", err)
+		}
+	}
+	if resolveBool(pk.Kwargs["apply"], false) {
+		if !opts.IsEnabled() {
+			return nil, fmt.Errorf("/client:retention no retention rules set (maxage, maxptysize, or maxlines)")
+		}
+		numLines, err := applyRetention(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("/client:retention error applying rules: %v", err)
+		}
+		return &sstore.ModelUpdate{Info: &sstore.InfoMsgType{InfoMsg: fmt.Sprintf("retention: %d lines %sd", numLines, opts.GetAction()), TimeoutMs: 5000}}, nil
+	}
+	lines, err := sstore.GetRetentionCandidates(ctx, opts, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("/client:retention error computing preview: %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoTitle: "retention",
+			InfoLines: splitLinesForInfo(formatRetentionPreview(opts, lines)),
+		},
+	}
+	return update, nil
+}
+
+// enforces the retention rules and deletes expired trash items periodically
+func RunRetentionLoop() {
+	time.Sleep(RetentionInitialDelay)
+	for {
+		clientData, err := sstore.EnsureClientData(context.Background())
+		if err != nil {
+			log.Printf("[retention] cannot retrieve client data: %v\n", err)
+			time.Sleep(RetentionInterval)
+			continue
+		}
+		expireTrash(context.Background(), clientData)
+		if clientData.ClientOpts.Retention.IsEnabled() {
+			numLines, err := applyRetention(context.Background(), clientData.ClientOpts.Retention)
+			if err != nil {
+				log.Printf("[retention] error: %v\n", err)
+			} else if numLines > 0 {
+				log.Printf("[retention] %d lines %sd\n", numLines, clientData.ClientOpts.Retention.GetAction())
+			}
+		}
+		time.Sleep(RetentionInterval)
+	}
+}
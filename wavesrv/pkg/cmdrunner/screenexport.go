@@ -0,0 +1,327 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const (
+	TranscriptFormatHtml = "html"
+	TranscriptFormatMd   = "md"
+	TranscriptFormatTxt  = "txt"
+)
+
+const transcriptTimeFormat = "2006-01-02 15:04:05"
+
+type transcriptItem struct {
+	Line       *sstore.LineType
+	Cmd        *sstore.CmdType
+	RemoteName string
+	Output     []byte // raw pty output (cmd lines)
+	AIText     string // response text (openai lines)
+}
+
+type screenTranscript struct {
+	SessionName string
+	ScreenName  string
+	ExportTs    int64
+	Items       []*transcriptItem
+}
+
+// openai lines store their output as packets (see writePacketToPty)
+func getOpenAIOutputText(output []byte) string {
+	var rtn strings.Builder
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(line, "##") {
+			continue
+		}
+		jsonStart := strings.IndexByte(line, '{')
+		if jsonStart == -1 {
+			continue
+		}
+		pk, err := packet.ParseJsonPacket([]byte(line[jsonStart:]))
+		if err != nil {
+			continue
+		}
+		if aiPk, ok := pk.(*packet.OpenAIPacketType); ok {
+			rtn.WriteString(aiPk.Text)
+			if aiPk.Error != "" {
+				rtn.WriteString(fmt.Sprintf("\n[error] %s\n", aiPk.Error))
+			}
+		}
+	}
+	return rtn.String()
+}
+
+func getScreenTranscript(ctx context.Context, screenId string, includeArchived bool) (*screenTranscript, error) {
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		return nil, err
+	}
+	session, err := sstore.GetBareSessionById(ctx, screen.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	screenLines, err := sstore.GetScreenLinesById(ctx, screenId)
+	if err != nil {
+		return nil, err
+	}
+	remotes, err := sstore.GetAllRemotes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remoteNames := make(map[string]string)
+	for _, r := range remotes {
+		remoteNames[r.RemoteId] = r.GetName()
+	}
+	cmdMap := make(map[string]*sstore.CmdType)
+	for _, cmd := range screenLines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	rtn := &screenTranscript{ScreenName: screen.Name, ExportTs: time.Now().UnixMilli()}
+	if session != nil {
+		rtn.SessionName = session.Name
+	}
+	for _, line := range screenLines.Lines {
+		if line.Archived && !includeArchived {
+			continue
+		}
+		item := &transcriptItem{Line: line, Cmd: cmdMap[line.LineId]}
+		if item.Cmd != nil {
+			item.RemoteName = remoteNames[item.Cmd.Remote.RemoteId]
+			if item.RemoteName == "" {
+				item.RemoteName = item.Cmd.Remote.Name
+			}
+			_, output, err := sstore.ReadFullPtyOutData(ctx, screenId, line.LineId)
+			if err == nil {
+				item.Output = output
+			}
+			if line.LineType == sstore.LineTypeOpenAI {
+				item.AIText = getOpenAIOutputText(item.Output)
+			}
+		}
+		rtn.Items = append(rtn.Items, item)
+	}
+	return rtn, nil
+}
+
+// e.g. "[local] ~/work  exit 0  1.2s  2023-10-01 10:00:00"
+func (item *transcriptItem) metaStr() string {
+	parts := []string{}
+	cmd := item.Cmd
+	if cmd != nil && item.Line.LineType == sstore.LineTypeCmd {
+		if item.RemoteName != "" {
+			parts = append(parts, "["+item.RemoteName+"]")
+		}
+		if cmd.FeState["cwd"] != "" {
+			parts = append(parts, cmd.FeState["cwd"])
+		}
+		switch cmd.Status {
+		case sstore.CmdStatusDone:
+			parts = append(parts, fmt.Sprintf("exit %d", cmd.ExitCode))
+		default:
+			parts = append(parts, cmd.Status)
+		}
+		if cmd.Status == sstore.CmdStatusDone || cmd.DurationMs > 0 {
+			parts = append(parts, (time.Duration(cmd.DurationMs) * time.Millisecond).String())
+		}
+	}
+	parts = append(parts, time.UnixMilli(item.Line.Ts).Format(transcriptTimeFormat))
+	return strings.Join(parts, "  ")
+}
+
+func (t *screenTranscript) title() string {
+	if t.SessionName == "" {
+		return fmt.Sprintf("screen %q", t.ScreenName)
+	}
+	return fmt.Sprintf("%s / %s", t.SessionName, t.ScreenName)
+}
+
+func (t *screenTranscript) renderTxt() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s (exported %s)\n\n", t.title(), time.UnixMilli(t.ExportTs).Format(transcriptTimeFormat)))
+	for _, item := range t.Items {
+		buf.WriteString(fmt.Sprintf("[%d] %s\n", item.Line.LineNum, item.metaStr()))
+		switch {
+		case item.Line.LineType == sstore.LineTypeText:
+			buf.WriteString(item.Line.Text + "\n")
+		case item.Line.LineType == sstore.LineTypeOpenAI && item.Cmd != nil:
+			buf.WriteString("AI> " + item.Cmd.CmdStr + "\n")
+			buf.WriteString(strings.TrimRight(item.AIText, "\n") + "\n")
+		case item.Cmd != nil:
+			buf.WriteString("$ " + item.Cmd.CmdStr + "\n")
+			output := sstore.StripAnsiOutput(item.Output)
+			if output != "" {
+				buf.WriteString(strings.TrimRight(output, "\n") + "\n")
+			}
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// returns a code fence that is longer than any run of backticks in str
+func mdFence(str string) string {
+	maxRun, curRun := 0, 0
+	for _, ch := range str {
+		if ch == '`' {
+			curRun++
+			if curRun > maxRun {
+				maxRun = curRun
+			}
+			continue
+		}
+		curRun = 0
+	}
+	if maxRun < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", maxRun+1)
+}
+
+func writeMdCodeBlock(buf *bytes.Buffer, lang string, str string) {
+	str = strings.TrimRight(str, "\n")
+	fence := mdFence(str)
+	buf.WriteString(fence + lang + "\n" + str + "\n" + fence + "\n\n")
+}
+
+func (t *screenTranscript) renderMd() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("# %s\n\n", t.title()))
+	buf.WriteString(fmt.Sprintf("_exported %s_\n\n", time.UnixMilli(t.ExportTs).Format(transcriptTimeFormat)))
+	for _, item := range t.Items {
+		buf.WriteString(fmt.Sprintf("**[%d]** `%s`\n\n", item.Line.LineNum, strings.ReplaceAll(item.metaStr(), "`", "'")))
+		switch {
+		case item.Line.LineType == sstore.LineTypeText:
+			buf.WriteString(item.Line.Text + "\n\n")
+		case item.Line.LineType == sstore.LineTypeOpenAI && item.Cmd != nil:
+			buf.WriteString("> " + strings.ReplaceAll(item.Cmd.CmdStr, "\n", "\n> ") + "\n\n")
+			buf.WriteString(strings.TrimRight(item.AIText, "\n") + "\n\n")
+		case item.Cmd != nil:
+			writeMdCodeBlock(&buf, "sh", "$ "+item.Cmd.CmdStr)
+			output := sstore.StripAnsiOutput(item.Output)
+			if strings.TrimSpace(output) != "" {
+				writeMdCodeBlock(&buf, "", output)
+			}
+		}
+		buf.WriteString("---\n\n")
+	}
+	return buf.Bytes()
+}
+
+const transcriptHtmlStyle = `
+:root { --ansi-fg: #d3d7cf; --ansi-bg: #000000; }
+body { background: var(--ansi-bg); color: var(--ansi-fg); font-family: sans-serif; margin: 20px; }
+h1 { font-size: 1.3em; }
+.exported { color: #8a8a8a; font-size: 0.85em; margin-bottom: 20px; }
+.line { border-top: 1px solid #333; padding: 10px 0; }
+.meta { color: #8a8a8a; font-size: 0.85em; margin-bottom: 4px; }
+.linenum { color: #58c142; margin-right: 8px; }
+pre { font-family: monospace; margin: 4px 0; white-space: pre-wrap; word-break: break-all; }
+.cmdstr { color: #ffffff; font-weight: bold; }
+.comment, .ai-text { white-space: pre-wrap; }
+.ai-prompt { color: #ffffff; font-style: italic; }
+`
+
+func (t *screenTranscript) renderHtml() []byte {
+	var buf bytes.Buffer
+	esc := html.EscapeString
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	buf.WriteString("<title>" + esc(t.title()) + "</title>\n")
+	buf.WriteString("<style>" + transcriptHtmlStyle + "</style>\n</head>\n<body>\n")
+	buf.WriteString("<h1>" + esc(t.title()) + "</h1>\n")
+	buf.WriteString("<div class=\"exported\">exported " + esc(time.UnixMilli(t.ExportTs).Format(transcriptTimeFormat)) + "</div>\n")
+	for _, item := range t.Items {
+		buf.WriteString(fmt.Sprintf("<div class=\"line line-%s\">\n", esc(item.Line.LineType)))
+		buf.WriteString(fmt.Sprintf("<div class=\"meta\"><span class=\"linenum\">[%d]</span>%s</div>\n", item.Line.LineNum, esc(item.metaStr())))
+		switch {
+		case item.Line.LineType == sstore.LineTypeText:
+			buf.WriteString("<div class=\"comment\">" + esc(item.Line.Text) + "</div>\n")
+		case item.Line.LineType == sstore.LineTypeOpenAI && item.Cmd != nil:
+			buf.WriteString("<div class=\"ai-prompt\">" + esc(item.Cmd.CmdStr) + "</div>\n")
+			buf.WriteString("<div class=\"ai-text\">" + esc(strings.TrimRight(item.AIText, "\n")) + "</div>\n")
+		case item.Cmd != nil:
+			buf.WriteString("<pre class=\"cmdstr\">$ " + esc(item.Cmd.CmdStr) + "</pre>\n")
+			output := strings.TrimRight(sstore.AnsiOutputToHtml(item.Output), "\n")
+			if output != "" {
+				buf.WriteString("<pre class=\"output\">" + output + "</pre>\n")
+			}
+		}
+		buf.WriteString("</div>\n")
+	}
+	buf.WriteString("</body>\n</html>\n")
+	return buf.Bytes()
+}
+
+func getTranscriptFormat(formatArg string, fullPath string) (string, error) {
+	if formatArg == "" {
+		switch strings.ToLower(filepath.Ext(fullPath)) {
+		case ".html", ".htm":
+			return TranscriptFormatHtml, nil
+		case ".md", ".markdown":
+			return TranscriptFormatMd, nil
+		case ".txt":
+			return TranscriptFormatTxt, nil
+		}
+		return "", fmt.Errorf("cannot determine format from %q, set format=html|md|txt", filepath.Base(fullPath))
+	}
+	if formatArg != TranscriptFormatHtml && formatArg != TranscriptFormatMd && formatArg != TranscriptFormatTxt {
+		return "", fmt.Errorf("invalid format %q, valid formats: %s", formatArg, formatStrs([]string{TranscriptFormatHtml, TranscriptFormatMd, TranscriptFormatTxt}, "or", false))
+	}
+	return formatArg, nil
+}
+
+func ScreenExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /screen:export [format=html|md|txt] [archived=1] [path]")
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/screen:export %v", err)
+	}
+	format, err := getTranscriptFormat(pk.Kwargs["format"], fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:export %v", err)
+	}
+	transcript, err := getScreenTranscript(ctx, ids.ScreenId, resolveBool(pk.Kwargs["archived"], false))
+	if err != nil {
+		return nil, fmt.Errorf("/screen:export error reading screen: %v", err)
+	}
+	var data []byte
+	switch format {
+	case TranscriptFormatHtml:
+		data = transcript.renderHtml()
+	case TranscriptFormatMd:
+		data = transcript.renderMd()
+	default:
+		data = transcript.renderTxt()
+	}
+	err = os.WriteFile(fullPath, data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:export cannot write %q: %v", fullPath, err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("exported %d lines to %q", len(transcript.Items), fullPath),
+			TimeoutMs: 5000,
+		},
+	}
+	return update, nil
+}
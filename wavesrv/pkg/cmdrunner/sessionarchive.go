@@ -0,0 +1,107 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func SessionExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, 0) // don't force R_Session
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /session:export [path] (exports the current session, or use session=[session])")
+	}
+	sessionId := ids.SessionId
+	if sessionArg, found := pk.Kwargs["session"]; found {
+		ritem, err := resolveSession(ctx, sessionArg, ids.SessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/session:export error resolving session %q: %w", sessionArg, err)
+		}
+		if ritem == nil {
+			return nil, fmt.Errorf("/session:export session %q not found", sessionArg)
+		}
+		sessionId = ritem.Id
+	}
+	if sessionId == "" {
+		return nil, fmt.Errorf("/session:export no sessionid found")
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/session:export %v", err)
+	}
+	fd, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("/session:export cannot create %q: %v", fullPath, err)
+	}
+	archive, err := sstore.WriteSessionArchive(ctx, sessionId, fd)
+	closeErr := fd.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fullPath)
+		return nil, fmt.Errorf("/session:export error writing archive: %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("exported session %q (%d screens, %d lines) to %q", archive.Session.Name, len(archive.Screens), len(archive.Lines), fullPath),
+			TimeoutMs: 5000,
+		},
+	}
+	return update, nil
+}
+
+func SessionImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /session:import [path]")
+	}
+	fullPath, err := resolveLocalFilePath(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/session:import %v", err)
+	}
+	fd, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("/session:import cannot open %q: %v", fullPath, err)
+	}
+	defer fd.Close()
+	newSessionId, err := sstore.ImportSessionArchive(ctx, fd)
+	if err != nil {
+		return nil, fmt.Errorf("/session:import %v", err)
+	}
+	activate := resolveBool(pk.Kwargs["activate"], true)
+	if activate {
+		err = sstore.SetActiveSessionId(ctx, newSessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/session:import cannot switch to imported session: %v", err)
+		}
+	}
+	session, err := sstore.GetSessionById(ctx, newSessionId)
+	if err != nil {
+		return nil, fmt.Errorf("/session:import cannot get imported session: %v", err)
+	}
+	screens, err := sstore.GetSessionScreens(ctx, newSessionId)
+	if err != nil {
+		return nil, fmt.Errorf("/session:import cannot get imported screens: %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Sessions: []*sstore.SessionType{session},
+		Screens:  screens,
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("imported session %q (%d screens)", session.Name, len(screens)),
+			TimeoutMs: 5000,
+		},
+	}
+	if activate {
+		update.ActiveSessionId = newSessionId
+	}
+	return update, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const TrashIdPrefixLen = 8
+
+// arg is an item number from /trash:show (1 is the most recent) or a trashid (prefix)
+func resolveTrashItem(items []*sstore.TrashItemType, arg string) (*sstore.TrashItemType, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("trash is empty")
+	}
+	if arg == "" {
+		return items[0], nil
+	}
+	if itemNum, err := strconv.Atoi(arg); err == nil {
+		if itemNum < 1 || itemNum > len(items) {
+			return nil, fmt.Errorf("trash item %d not found (%d items)", itemNum, len(items))
+		}
+		return items[itemNum-1], nil
+	}
+	var rtn *sstore.TrashItemType
+	for _, item := range items {
+		if strings.HasPrefix(item.TrashId, arg) {
+			if rtn != nil {
+				return nil, fmt.Errorf("trash id %q is ambiguous", arg)
+			}
+			rtn = item
+		}
+	}
+	if rtn == nil {
+		return nil, fmt.Errorf("trash item %q not found", arg)
+	}
+	return rtn, nil
+}
+
+func formatTrashItemName(item *sstore.TrashItemType) string {
+	if item.TrashType == sstore.TrashTypeLine {
+		return fmt.Sprintf("%d %s from %s", item.NumLines, pluralize("line", item.NumLines), item.Name)
+	}
+	return fmt.Sprintf("%s (%d %s)", item.Name, item.NumLines, pluralize("line", item.NumLines))
+}
+
+func pluralize(word string, num int) string {
+	if num == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+func trashUndoInfo(what string) *sstore.InfoMsgType {
+	return &sstore.InfoMsgType{
+		InfoMsg:   fmt.Sprintf("%s moved to trash, /trash:restore to undo", what),
+		TimeoutMs: 5000,
+	}
+}
+
+func getTrashExpireDays(ctx context.Context) int {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return sstore.DefaultTrashExpireDays
+	}
+	return clientData.ClientOpts.GetTrashExpireDays()
+}
+
+func TrashShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	items, err := sstore.GetTrashItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:show error getting trash: %v", err)
+	}
+	expireDays := getTrashExpireDays(ctx)
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-15s %d (%s)\n", "items", len(items), scbase.NumFormatB2(sstore.GetTrashDiskSize())))
+	buf.WriteString(fmt.Sprintf("  %-15s %d days\n", "expire", expireDays))
+	if len(items) > 0 {
+		buf.WriteString("\n")
+	}
+	for idx, item := range items {
+		ts := time.UnixMilli(item.TrashTs)
+		expireTs := ts.AddDate(0, 0, expireDays)
+		buf.WriteString(fmt.Sprintf("  %-3s %-8s %-7s %s  expires %s  %s\n", strconv.Itoa(idx+1), item.TrashId[0:TrashIdPrefixLen], item.TrashType, ts.Format(TsFormatStr), expireTs.Format("2006-01-02"), formatTrashItemName(item)))
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoTitle: "trash",
+			InfoLines: splitLinesForInfo(buf.String()),
+		},
+	}
+	return update, nil
+}
+
+// restores the most recent trash item, or the item given by number or id
+func TrashRestoreCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /trash:restore [item-number or trashid]")
+	}
+	items, err := sstore.GetTrashItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:restore error getting trash: %v", err)
+	}
+	var arg string
+	if len(pk.Args) == 1 {
+		arg = pk.Args[0]
+	}
+	item, err := resolveTrashItem(items, arg)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:restore %v", err)
+	}
+	_, err = sstore.RestoreTrashItem(ctx, item.TrashId)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:restore cannot restore %s: %v", formatTrashItemName(item), err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("restored %s %s", item.TrashType, formatTrashItemName(item)),
+			TimeoutMs: 3000,
+		},
+	}
+	switch item.TrashType {
+	case sstore.TrashTypeSession:
+		if resolveBool(pk.Kwargs["activate"], true) {
+			err = sstore.SetActiveSessionId(ctx, item.SessionId)
+			if err != nil {
+				return nil, fmt.Errorf("/trash:restore cannot switch to restored session: %v", err)
+			}
+			update.ActiveSessionId = item.SessionId
+		}
+		session, err := sstore.GetSessionById(ctx, item.SessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/trash:restore cannot get restored session: %v", err)
+		}
+		screens, err := sstore.GetSessionScreens(ctx, item.SessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/trash:restore cannot get restored screens: %v", err)
+		}
+		update.Sessions = []*sstore.SessionType{session}
+		update.Screens = screens
+	case sstore.TrashTypeScreen:
+		screen, err := sstore.GetScreenById(ctx, item.ScreenId)
+		if err != nil {
+			return nil, fmt.Errorf("/trash:restore cannot get restored screen: %v", err)
+		}
+		update.Screens = []*sstore.ScreenType{screen}
+	case sstore.TrashTypeLine:
+		screenLines, err := sstore.GetScreenLinesById(ctx, item.ScreenId)
+		if err != nil {
+			return nil, fmt.Errorf("/trash:restore cannot get restored lines: %v", err)
+		}
+		update.ScreenLines = screenLines
+	}
+	return update, nil
+}
+
+// empties the trash, or deletes the items given by number or id
+func TrashEmptyCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sstore.UpdatePacket, error) {
+	var trashIds []string
+	if len(pk.Args) > 0 {
+		items, err := sstore.GetTrashItems(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("/trash:empty error getting trash: %v", err)
+		}
+		for _, arg := range pk.Args {
+			item, err := resolveTrashItem(items, arg)
+			if err != nil {
+				return nil, fmt.Errorf("/trash:empty %v", err)
+			}
+			trashIds = append(trashIds, item.TrashId)
+		}
+	}
+	numItems, err := sstore.DeleteTrashItems(ctx, trashIds)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:empty error: %v", err)
+	}
+	update := &sstore.ModelUpdate{
+		Info: &sstore.InfoMsgType{
+			InfoMsg:   fmt.Sprintf("deleted %d trash %s", numItems, pluralize("item", numItems)),
+			TimeoutMs: 3000,
+		},
+	}
+	return update, nil
+}
+
+func expireTrash(ctx context.Context, clientData *sstore.ClientData) {
+	numItems, err := sstore.ExpireTrash(ctx, clientData.ClientOpts.GetTrashExpireDays(), time.Now())
+	if err != nil {
+		log.Printf("[trash] error expiring items: %v\n", err)
+	} else if numItems > 0 {
+		log.Printf("[trash] %d expired items deleted\n", numItems)
+	}
+}
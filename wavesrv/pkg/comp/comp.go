@@ -170,7 +170,7 @@ func compQuoteLiteralString(s string) string {
 			continue
 		}
 		if specialEsc[bch] != "" {
-			buf.WriteString(specialEsc[bch])
+			buf.WriteString("$'" + specialEsc[bch] + "'")
 			continue
 		}
 		if !unicode.IsPrint(ch) {
@@ -200,7 +200,7 @@ func compQuoteSQString(s string) string {
 		if ch > unicode.MaxASCII || !unicode.IsPrint(ch) {
 			buf.WriteByte('\'')
 			if bch != 0 && specialEsc[bch] != "" {
-				buf.WriteString(specialEsc[bch])
+				buf.WriteString("$'" + specialEsc[bch] + "'")
 			} else {
 				writeUtf8Literal(&buf, ch)
 			}
@@ -252,6 +252,61 @@ func compQuoteString(s string, quoteType string, close bool) string {
 	return compQuoteDQString(s, close)
 }
 
+// quotes s so it can be inserted into a command at a position where quoteType is already open
+// (QuoteTypeLiteral for an unquoted position).  the result neither opens nor closes the quote.
+func QuoteInContext(s string, quoteType string) string {
+	switch quoteType {
+	case QuoteTypeDQ:
+		return compQuoteDQString(s, false)[1:]
+	case QuoteTypeSQ:
+		return compQuoteSQString(s)
+	case QuoteTypeANSI:
+		rtn := strconv.QuoteToASCII(s)
+		return strings.ReplaceAll(rtn[1:len(rtn)-1], "'", "\\'")
+	}
+	if s == "" {
+		return "''"
+	}
+	rtn := compQuoteLiteralString(s)
+	// compQuoteLiteralString leaves a leading ~ alone (completions want it expanded), values should not expand
+	if strings.HasPrefix(rtn, "~") {
+		rtn = "\\" + rtn
+	}
+	return rtn
+}
+
+// returns the quote type that is open at byte offset pos in cmdStr (QuoteTypeLiteral if none)
+func QuoteContextAt(cmdStr string, pos int) string {
+	quoteType := QuoteTypeLiteral
+	for i := 0; i < pos && i < len(cmdStr); i++ {
+		ch := cmdStr[i]
+		switch quoteType {
+		case QuoteTypeSQ:
+			if ch == '\'' {
+				quoteType = QuoteTypeLiteral
+			}
+		case QuoteTypeDQ, QuoteTypeANSI:
+			if ch == '\\' {
+				i++
+			} else if (quoteType == QuoteTypeDQ && ch == '"') || (quoteType == QuoteTypeANSI && ch == '\'') {
+				quoteType = QuoteTypeLiteral
+			}
+		default:
+			if ch == '\\' {
+				i++
+			} else if ch == '"' {
+				quoteType = QuoteTypeDQ
+			} else if ch == '\'' {
+				quoteType = QuoteTypeSQ
+			} else if ch == '$' && i+1 < len(cmdStr) && cmdStr[i+1] == '\'' {
+				quoteType = QuoteTypeANSI
+				i++
+			}
+		}
+	}
+	return quoteType
+}
+
 func (p *CompPoint) wordAsStr(w ParsedWord) string {
 	if w.Word != nil {
 		return p.StmtStr[w.Word.Pos().Offset():w.Word.End().Offset()]
@@ -482,8 +537,8 @@ func splitCompWord(p *CompPoint) {
 
 	w1 := ParsedWord{Offset: w.Offset, Prefix: w.Prefix[:prefixPos]}
 	w2 := ParsedWord{Offset: w.Offset + prefixPos, Prefix: w.Prefix[prefixPos:], Word: w.Word, PartialWord: w.PartialWord}
-	p.CompWord = p.CompWord // the same (w1)
-	p.CompWordPos = 0       // will be at 0 since w1 has a word length of 0
+	// p.CompWord stays the same (w1)
+	p.CompWordPos = 0 // will be at 0 since w1 has a word length of 0
 	var newWords []ParsedWord
 	if p.CompWord > 0 {
 		newWords = append(newWords, p.Words[0:p.CompWord]...)
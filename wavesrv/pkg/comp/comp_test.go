@@ -7,19 +7,22 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/utilfn"
 )
 
-func parseToSP(s string) StrWithPos {
+func parseToSP(s string) utilfn.StrWithPos {
 	idx := strings.Index(s, "[*]")
 	if idx == -1 {
-		return StrWithPos{Str: s}
+		return utilfn.StrWithPos{Str: s}
 	}
-	return StrWithPos{Str: s[0:idx] + s[idx+3:], Pos: idx}
+	return utilfn.StrWithPos{Str: s[0:idx] + s[idx+3:], Pos: idx}
 }
 
 func testParse(cmdStr string, pos int) {
-	fmt.Printf("cmd: %s\n", strWithCursor(cmdStr, pos))
-	p := ParseCompPoint(StrWithPos{Str: cmdStr, Pos: pos})
+	sp := utilfn.StrWithPos{Str: cmdStr, Pos: pos}
+	fmt.Printf("cmd: %s\n", sp)
+	p := ParseCompPoint(sp)
 	p.dump()
 }
 
@@ -54,19 +57,19 @@ func Test2(t *testing.T) {
 	p := ParseCompPoint(parseToSP("ls f[*]"))
 	testMiniExtend(t, p, "foo", false, "foo[*]")
 	testMiniExtend(t, p, "foo", true, "foo [*]")
-	testMiniExtend(t, p, "foo bar", true, "'foo bar' [*]")
-	testMiniExtend(t, p, "foo'bar", true, `$'foo\'bar' [*]`)
+	testMiniExtend(t, p, "foo bar", true, `foo\ bar [*]`)
+	testMiniExtend(t, p, "foo'bar", true, `foo\'bar [*]`)
 
 	p = ParseCompPoint(parseToSP("ls f[*]more"))
 	testMiniExtend(t, p, "foo", false, "foo[*]more")
-	testMiniExtend(t, p, "foo bar", false, `'foo bar[*]more`)
-	testMiniExtend(t, p, "foo bar", true, `'foo bar[*]more`)
-	testMiniExtend(t, p, "foo's", true, `$'foo\'s[*]more`)
+	testMiniExtend(t, p, "foo bar", false, `foo\ bar[*]more`)
+	testMiniExtend(t, p, "foo bar", true, `foo\ bar[*]more`)
+	testMiniExtend(t, p, "foo's", true, `foo\'s[*]more`)
 }
 
-func testParseRT(t *testing.T, origSP StrWithPos) {
+func testParseRT(t *testing.T, origSP utilfn.StrWithPos) {
 	p := ParseCompPoint(origSP)
-	newSP := StrWithPos{Str: p.getOrigStr(), Pos: p.getOrigPos()}
+	newSP := utilfn.StrWithPos{Str: p.getOrigStr(), Pos: p.getOrigPos()}
 	if origSP != newSP {
 		t.Fatalf("not equal: [%s] != [%s]", origSP, newSP)
 	}
@@ -102,8 +105,66 @@ func Test4(t *testing.T) {
 	testExtend(t, `ls 'f[*]`, []string{"foo"}, `ls 'foo' [*]`)
 	testExtend(t, `ls $'f[*]`, []string{"foo"}, `ls $'foo' [*]`)
 	testExtend(t, `ls f[*]`, []string{"foo/"}, `ls foo/[*]`)
-	testExtend(t, `ls f[*]`, []string{"foo bar"}, `ls 'foo bar' [*]`)
-	testExtend(t, `ls f[*]`, []string{"f\x01\x02"}, `ls $'f\x01\x02' [*]`)
+	testExtend(t, `ls f[*]`, []string{"foo bar"}, `ls foo\ bar [*]`)
+	testExtend(t, `ls f[*]`, []string{"f\x01\x02"}, `ls f$'\x01'$'\x02' [*]`)
 	testExtend(t, `ls "foo [*]`, []string{"foo bar"}, `ls "foo bar" [*]`)
-	testExtend(t, `ls f[*]`, []string{"foo's"}, `ls $'foo\'s' [*]`)
+	testExtend(t, `ls f[*]`, []string{"foo's"}, `ls foo\'s [*]`)
+}
+
+func TestQuoteInContext(t *testing.T) {
+	tests := []struct {
+		input     string
+		quoteType string
+		expected  string
+	}{
+		{"foo", QuoteTypeLiteral, "foo"},
+		{"", QuoteTypeLiteral, "''"},
+		{"foo bar", QuoteTypeLiteral, `foo\ bar`},
+		{"a'b", QuoteTypeLiteral, `a\'b`},
+		{"$HOME", QuoteTypeLiteral, `\$HOME`},
+		{"~/x", QuoteTypeLiteral, `\~/x`},
+		{"a~b", QuoteTypeLiteral, `a\~b`},
+		{"a\nb", QuoteTypeLiteral, `a$'\n'b`},
+		{"a\tb", QuoteTypeLiteral, `a$'\t'b`},
+		{"café", QuoteTypeLiteral, `caf$'\xc3\xa9'`},
+		{`a"b$c`, QuoteTypeDQ, `a\"b\$c`},
+		{"~/x", QuoteTypeDQ, "~/x"},
+		{"a\nb", QuoteTypeDQ, "a\nb"},
+		{"a'b", QuoteTypeSQ, `a'\''b`},
+		{"a\nb", QuoteTypeSQ, `a'$'\n''b`},
+		{"a'b\n", QuoteTypeANSI, `a\'b\n`},
+	}
+	for _, test := range tests {
+		rtn := QuoteInContext(test.input, test.quoteType)
+		if rtn != test.expected {
+			t.Errorf("QuoteInContext(%q, %q) = %s, expected %s", test.input, test.quoteType, rtn, test.expected)
+		}
+	}
+}
+
+func TestQuoteContextAt(t *testing.T) {
+	tests := []struct {
+		cmdStr   string
+		expected string
+	}{
+		{"echo [*]", QuoteTypeLiteral},
+		{`echo "[*]`, QuoteTypeDQ},
+		{`echo "a[*]" b`, QuoteTypeDQ},
+		{`echo "a" [*]`, QuoteTypeLiteral},
+		{`echo "a\"[*]`, QuoteTypeDQ},
+		{`echo 'a[*]`, QuoteTypeSQ},
+		{`echo 'a\'[*]`, QuoteTypeLiteral},
+		{`echo "it's [*]`, QuoteTypeDQ},
+		{`echo $'a[*]`, QuoteTypeANSI},
+		{`echo $'a\'[*]`, QuoteTypeANSI},
+		{`echo \"[*]`, QuoteTypeLiteral},
+		{`echo \'[*]`, QuoteTypeLiteral},
+	}
+	for _, test := range tests {
+		sp := parseToSP(test.cmdStr)
+		rtn := QuoteContextAt(sp.Str, sp.Pos)
+		if rtn != test.expected {
+			t.Errorf("QuoteContextAt(%q, %d) = %s, expected %s", sp.Str, sp.Pos, rtn, test.expected)
+		}
+	}
 }
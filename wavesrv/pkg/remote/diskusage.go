@@ -0,0 +1,210 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+// scans are run in the background and polled by the frontend.  a running scan that is not polled
+// for DiskUsageAbandonTime is cancelled, finished scans are removed after DiskUsageKeepTime.
+const DiskUsageAbandonTime = 1 * time.Minute
+const DiskUsageKeepTime = 10 * time.Minute
+const DiskUsageScanTimeout = 1 * time.Hour
+
+type DiskUsageOpts struct {
+	MaxDepth int
+	OneFs    bool
+}
+
+// respFn is called for every response (including the final one).  cancelling ctx cancels the scan on mshell.
+// if a response is dropped (respFn fell behind) the scan is cancelled and an error is returned.
+func (msh *MShellProc) DiskUsage(ctx context.Context, path string, opts DiskUsageOpts, respFn func(resp *packet.DiskUsageResponseType)) error {
+	duPk := packet.MakeDiskUsagePacket()
+	duPk.ReqId = uuid.New().String()
+	duPk.Path = path
+	duPk.MaxDepth = opts.MaxDepth
+	duPk.OneFs = opts.OneFs
+	iter, err := msh.PacketRpcIterSz(ctx, duPk, 100)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		respIf, err := iter.Next(ctx)
+		if err != nil {
+			// stop the scan on mshell (it will not stop on its own if we fell behind)
+			msh.CancelRpc(duPk.ReqId)
+			if errors.Is(err, packet.ErrRpcOverflow) {
+				// the entries are incremental, a dropped response leaves a gap in the results
+				return fmt.Errorf("diskusage responses were dropped, results would be incomplete")
+			}
+			return err
+		}
+		if respIf == nil {
+			return fmt.Errorf("diskusage response channel closed")
+		}
+		resp, ok := respIf.(*packet.DiskUsageResponseType)
+		if !ok {
+			return fmt.Errorf("invalid diskusage response packet: %s", packet.AsString(respIf))
+		}
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		respFn(resp)
+		if resp.Done {
+			return nil
+		}
+	}
+}
+
+type DiskUsageScan struct {
+	Lock       *sync.Mutex
+	ScanKey    string
+	Path       string
+	Opts       DiskUsageOpts
+	Info       *packet.FileInfo
+	Entries    []*packet.DiskUsageEntry
+	NumFiles   int64
+	Size       int64
+	NumErrors  int
+	Truncated  bool
+	Done       bool
+	Error      string
+	StartTs    int64
+	EndTs      int64
+	LastAccess time.Time
+	CancelFn   context.CancelFunc
+}
+
+// the entries are incremental (starting at the FromEntry passed to GetSnapshot)
+type DiskUsageSnapshot struct {
+	Path      string                   `json:"path"`
+	Info      *packet.FileInfo         `json:"info,omitempty"`
+	Entries   []*packet.DiskUsageEntry `json:"entries"`
+	NumFiles  int64                    `json:"numfiles"`
+	Size      int64                    `json:"size"`
+	NumErrors int                      `json:"numerrors,omitempty"`
+	Truncated bool                     `json:"truncated,omitempty"`
+	Done      bool                     `json:"done"`
+	Error     string                   `json:"error,omitempty"`
+	StartTs   int64                    `json:"startts"`
+	EndTs     int64                    `json:"endts,omitempty"`
+}
+
+var diskUsageLock = &sync.Mutex{}
+var diskUsageScans = make(map[string]*DiskUsageScan)
+
+func cleanDiskUsageScans_nolock() {
+	for scanKey, scan := range diskUsageScans {
+		scan.Lock.Lock()
+		stale := scan.Done && time.Since(scan.LastAccess) > DiskUsageKeepTime
+		scan.Lock.Unlock()
+		if stale {
+			delete(diskUsageScans, scanKey)
+		}
+	}
+}
+
+// returns the existing scan for scanKey (if it has the same path and opts), otherwise starts a new one.
+// if restart is set, any existing scan is cancelled and a new one is started.
+func StartDiskUsageScan(msh *MShellProc, scanKey string, path string, opts DiskUsageOpts, restart bool) *DiskUsageScan {
+	diskUsageLock.Lock()
+	defer diskUsageLock.Unlock()
+	cleanDiskUsageScans_nolock()
+	oldScan := diskUsageScans[scanKey]
+	if oldScan != nil {
+		if !restart && oldScan.Path == path && oldScan.Opts == opts {
+			oldScan.touch()
+			return oldScan
+		}
+		oldScan.Cancel()
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), DiskUsageScanTimeout)
+	scan := &DiskUsageScan{
+		Lock:       &sync.Mutex{},
+		ScanKey:    scanKey,
+		Path:       path,
+		Opts:       opts,
+		StartTs:    time.Now().UnixMilli(),
+		LastAccess: time.Now(),
+		CancelFn:   cancelFn,
+	}
+	diskUsageScans[scanKey] = scan
+	go scan.run(ctx, msh)
+	return scan
+}
+
+func GetDiskUsageScan(scanKey string) *DiskUsageScan {
+	diskUsageLock.Lock()
+	defer diskUsageLock.Unlock()
+	return diskUsageScans[scanKey]
+}
+
+func (scan *DiskUsageScan) run(ctx context.Context, msh *MShellProc) {
+	defer scan.CancelFn()
+	err := msh.DiskUsage(ctx, scan.Path, scan.Opts, func(resp *packet.DiskUsageResponseType) {
+		scan.Lock.Lock()
+		defer scan.Lock.Unlock()
+		if resp.Info != nil {
+			scan.Info = resp.Info
+		}
+		scan.Entries = append(scan.Entries, resp.Entries...)
+		scan.NumFiles = resp.NumFiles
+		scan.Size = resp.Size
+		scan.NumErrors = resp.NumErrors
+		scan.Truncated = resp.Truncated
+		if time.Since(scan.LastAccess) > DiskUsageAbandonTime {
+			scan.CancelFn()
+		}
+	})
+	scan.Lock.Lock()
+	defer scan.Lock.Unlock()
+	scan.Done = true
+	scan.EndTs = time.Now().UnixMilli()
+	if err == context.Canceled {
+		scan.Error = "canceled"
+	} else if err != nil {
+		scan.Error = err.Error()
+	}
+}
+
+func (scan *DiskUsageScan) touch() {
+	scan.Lock.Lock()
+	defer scan.Lock.Unlock()
+	scan.LastAccess = time.Now()
+}
+
+func (scan *DiskUsageScan) Cancel() {
+	scan.CancelFn()
+}
+
+func (scan *DiskUsageScan) GetSnapshot(fromEntry int) *DiskUsageSnapshot {
+	scan.Lock.Lock()
+	defer scan.Lock.Unlock()
+	scan.LastAccess = time.Now()
+	if fromEntry < 0 || fromEntry > len(scan.Entries) {
+		fromEntry = len(scan.Entries)
+	}
+	return &DiskUsageSnapshot{
+		Path:      scan.Path,
+		Info:      scan.Info,
+		Entries:   scan.Entries[fromEntry:],
+		NumFiles:  scan.NumFiles,
+		Size:      scan.Size,
+		NumErrors: scan.NumErrors,
+		Truncated: scan.Truncated,
+		Done:      scan.Done,
+		Error:     scan.Error,
+		StartTs:   scan.StartTs,
+		EndTs:     scan.EndTs,
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+// data is written to dst+FileCopyPartialSuffix and renamed into place once the copy is complete,
+// so a failed copy can be resumed (and never leaves a truncated file at dst)
+const FileCopyPartialSuffix = ".wavepart"
+const fileCopyMaxRetries = 5
+const fileCopyReconnectWait = 2 * time.Minute
+const fileCopyAbortWait = 5 * time.Second
+
+type FileCopyOpts struct {
+	Force      bool // overwrite dst if it exists
+	ProgressFn func(progress FileCopyProgress)
+	StatusFn   func(status string) // called with retry / reconnect messages
+}
+
+type FileCopyProgress struct {
+	Size       int64
+	BytesDone  int64 // includes data resumed from previous attempts
+	NumRetries int
+}
+
+type FileCopyResult struct {
+	DstPath    string
+	Size       int64
+	BytesSent  int64
+	NumRetries int
+}
+
+// copies a single file from src to dst (mshell to mshell, data goes through wavesrv).
+// if dst is a directory the file is copied into it.  transfer errors are retried, resuming
+// from the end of the partial file.
+func CopyFile(ctx context.Context, src FileSyncEndpoint, dst FileSyncEndpoint, opts FileCopyOpts) (*FileCopyResult, error) {
+	srcInfo, err := src.MShell.StatFile(ctx, src.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat source: %w", err)
+	}
+	if srcInfo.NotFound {
+		return nil, fmt.Errorf("source %q does not exist", src.Path)
+	}
+	if srcInfo.IsDir {
+		return nil, fmt.Errorf("source %q is a directory", src.Path)
+	}
+	dstPath := dst.Path
+	dstInfo, err := dst.MShell.StatFile(ctx, dstPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat destination: %w", err)
+	}
+	if dstInfo.IsDir {
+		dstPath = path.Join(dstPath, path.Base(src.Path))
+		dstInfo, err = dst.MShell.StatFile(ctx, dstPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot stat destination: %w", err)
+		}
+	}
+	if !dstInfo.NotFound {
+		if dstInfo.IsDir {
+			return nil, fmt.Errorf("destination %q is a directory", dstPath)
+		}
+		if !opts.Force {
+			return nil, fmt.Errorf("destination %q already exists", dstPath)
+		}
+	}
+	partPath := dstPath + FileCopyPartialSuffix
+	rtn := &FileCopyResult{DstPath: dstPath, Size: srcInfo.Size}
+	var partInfo *packet.FileInfo // nil means start from scratch
+	for {
+		sentBefore := rtn.BytesSent
+		err = copyToPartial(ctx, src, dst.MShell, partPath, srcInfo, partInfo, rtn, opts)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrWriteConflict) || rtn.NumRetries >= fileCopyMaxRetries {
+			return rtn, err
+		}
+		// errors before any data was sent (bad path, permissions) are not going to fix themselves
+		remoteDropped := !src.MShell.IsConnected() || !dst.MShell.IsConnected()
+		if !remoteDropped && rtn.BytesSent == sentBefore {
+			return rtn, err
+		}
+		rtn.NumRetries++
+		if opts.StatusFn != nil {
+			opts.StatusFn(fmt.Sprintf("transfer error: %v (retry %d/%d)", err, rtn.NumRetries, fileCopyMaxRetries))
+		}
+		err = waitForRemotes(ctx, rtn.NumRetries, opts, src.MShell, dst.MShell)
+		if err != nil {
+			return rtn, err
+		}
+		srcInfo, partInfo, err = getCopyResumeInfo(ctx, src, dst.MShell, partPath, srcInfo)
+		if err != nil {
+			return rtn, err
+		}
+		rtn.Size = srcInfo.Size
+	}
+	err = dst.MShell.FileOp(ctx, packet.FileOpRename, []string{partPath, dstPath}, 0)
+	if err != nil {
+		return rtn, err
+	}
+	return rtn, nil
+}
+
+// partInfo is the state of the partial file to resume from (nil to write from the beginning)
+func copyToPartial(ctx context.Context, src FileSyncEndpoint, dstMsh *MShellProc, partPath string, srcInfo *packet.FileInfo, partInfo *packet.FileInfo, rtn *FileCopyResult, opts FileCopyOpts) error {
+	var startPos int64
+	writePk := packet.MakeWriteFilePacket()
+	writePk.Path = partPath
+	writePk.ModTs = srcInfo.ModTs
+	writePk.Perm = srcInfo.Perm
+	if partInfo != nil {
+		startPos = partInfo.Size
+		writePk.Patch = true
+		writePk.Size = srcInfo.Size
+		writePk.ExpectedInfo = partInfo
+	}
+	fw, err := dstMsh.OpenFileWriter(ctx, writePk)
+	if err != nil {
+		return err
+	}
+	sentBefore := rtn.BytesSent
+	progressFn := func() {
+		if opts.ProgressFn != nil {
+			bytesDone := startPos + (rtn.BytesSent - sentBefore)
+			opts.ProgressFn(FileCopyProgress{Size: srcInfo.Size, BytesDone: bytesDone, NumRetries: rtn.NumRetries})
+		}
+	}
+	progressFn()
+	err = copyFileRange(ctx, src.MShell, src.Path, fw, startPos, srcInfo.Size, &rtn.BytesSent, progressFn)
+	if err != nil {
+		fw.Abort(err.Error())
+		// wait for mshell to finish the aborted write, so the partial file is stable before we stat it
+		waitCtx, cancelFn := context.WithTimeout(ctx, fileCopyAbortWait)
+		defer cancelFn()
+		fw.wait(waitCtx)
+		return err
+	}
+	_, err = fw.Close(ctx)
+	return err
+}
+
+// returns the (current) source info, and the partial file info to resume from (nil to restart)
+func getCopyResumeInfo(ctx context.Context, src FileSyncEndpoint, dstMsh *MShellProc, partPath string, origSrcInfo *packet.FileInfo) (*packet.FileInfo, *packet.FileInfo, error) {
+	srcInfo, err := src.MShell.StatFile(ctx, src.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot stat source: %w", err)
+	}
+	if srcInfo.NotFound || srcInfo.IsDir {
+		return nil, nil, fmt.Errorf("source %q was removed", src.Path)
+	}
+	if srcInfo.Size != origSrcInfo.Size || srcInfo.ModTs != origSrcInfo.ModTs {
+		// source changed, partial data is no good
+		return srcInfo, nil, nil
+	}
+	partInfo, err := dstMsh.StatFile(ctx, partPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot stat partial file: %w", err)
+	}
+	if partInfo.NotFound || partInfo.IsDir || partInfo.Size > srcInfo.Size {
+		return srcInfo, nil, nil
+	}
+	return srcInfo, partInfo, nil
+}
+
+// backs off, then waits (up to fileCopyReconnectWait) for the remotes to be connected
+func waitForRemotes(ctx context.Context, retryNum int, opts FileCopyOpts, mshs ...*MShellProc) error {
+	backoff := time.Duration(retryNum) * time.Second
+	select {
+	case <-time.After(backoff):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	deadline := time.Now().Add(fileCopyReconnectWait)
+	for _, msh := range mshs {
+		if msh.IsConnected() {
+			continue
+		}
+		if opts.StatusFn != nil {
+			opts.StatusFn(fmt.Sprintf("waiting for [%s] to reconnect", msh.GetDisplayName()))
+		}
+		for !msh.IsConnected() {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("[%s] did not reconnect", msh.GetDisplayName())
+			}
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+const (
+	FileSyncActionMkdir  = "mkdir"
+	FileSyncActionCopy   = "copy"   // file does not exist on dst
+	FileSyncActionUpdate = "update" // file exists on dst, send changed blocks
+	FileSyncActionDelete = "delete"
+)
+
+const syncBaseBlockSize = 64 * 1024
+const syncMaxBlocks = 16 * 1024
+
+// a location for a sync, remote paths are posix and must be absolute
+type FileSyncEndpoint struct {
+	MShell *MShellProc
+	Path   string
+}
+
+type FileSyncOpts struct {
+	DryRun     bool
+	Delete     bool // delete files/dirs on dst that are not on src
+	ProgressFn func(progress FileSyncProgress)
+}
+
+type FileSyncAction struct {
+	Action  string
+	RelPath string
+	Size    int64
+	IsDir   bool
+}
+
+type FileSyncProgress struct {
+	NumActions  int
+	ActionsDone int
+	BytesSent   int64
+	CurPath     string
+}
+
+type FileSyncResult struct {
+	Actions   []FileSyncAction
+	BytesSent int64
+	Skipped   int // files that were already up to date
+}
+
+func (a FileSyncAction) String() string {
+	if a.Action == FileSyncActionCopy || a.Action == FileSyncActionUpdate {
+		return fmt.Sprintf("%-6s %s (%d bytes)", a.Action, a.RelPath, a.Size)
+	}
+	return fmt.Sprintf("%-6s %s", a.Action, a.RelPath)
+}
+
+// picks a block size so the number of hashes stays bounded
+func syncBlockSize(size int64) int64 {
+	blockSize := int64(syncBaseBlockSize)
+	for blockSize*syncMaxBlocks < size {
+		blockSize *= 2
+	}
+	return blockSize
+}
+
+func depth(relPath string) int {
+	return strings.Count(relPath, "/")
+}
+
+// makes dst a mirror of src (if src is a directory, its contents are synced into dst).
+// files with the same size and modtime are assumed to be identical.  changed files only
+// have their changed blocks sent.
+func SyncFiles(ctx context.Context, src FileSyncEndpoint, dst FileSyncEndpoint, opts FileSyncOpts) (*FileSyncResult, error) {
+	if !path.IsAbs(src.Path) || !path.IsAbs(dst.Path) {
+		return nil, fmt.Errorf("sync paths must be absolute")
+	}
+	srcInfo, srcEntries, err := src.MShell.ListFiles(ctx, src.Path, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list source: %w", err)
+	}
+	if srcInfo.NotFound {
+		return nil, fmt.Errorf("source %q does not exist", src.Path)
+	}
+	// only list dst recursively when syncing a directory
+	dstInfo, dstEntries, err := dst.MShell.ListFiles(ctx, dst.Path, srcInfo.IsDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list destination: %w", err)
+	}
+	if !srcInfo.IsDir {
+		// single file, sync into dst (or dst/basename if dst is a directory)
+		if dstInfo.IsDir {
+			dst.Path = path.Join(dst.Path, path.Base(src.Path))
+			dstInfo, _, err = dst.MShell.ListFiles(ctx, dst.Path, false)
+			if err != nil {
+				return nil, fmt.Errorf("cannot stat destination: %w", err)
+			}
+			if dstInfo.IsDir {
+				return nil, fmt.Errorf("destination %q is a directory", dst.Path)
+			}
+		}
+		return syncSingleFile(ctx, src, dst, srcInfo, dstInfo, opts)
+	}
+	if !dstInfo.NotFound && !dstInfo.IsDir {
+		return nil, fmt.Errorf("destination %q is not a directory", dst.Path)
+	}
+	return runSync(ctx, src, dst, srcEntries, dstEntries, dstInfo.NotFound, opts)
+}
+
+func syncSingleFile(ctx context.Context, src FileSyncEndpoint, dst FileSyncEndpoint, srcInfo *packet.FileInfo, dstInfo *packet.FileInfo, opts FileSyncOpts) (*FileSyncResult, error) {
+	action := FileSyncAction{Action: FileSyncActionUpdate, RelPath: path.Base(dst.Path), Size: srcInfo.Size}
+	if dstInfo.NotFound {
+		action.Action = FileSyncActionCopy
+	} else if dstInfo.Size == srcInfo.Size && dstInfo.ModTs == srcInfo.ModTs {
+		return &FileSyncResult{Skipped: 1}, nil
+	}
+	rtn := &FileSyncResult{Actions: []FileSyncAction{action}}
+	if opts.DryRun {
+		return rtn, nil
+	}
+	if opts.ProgressFn != nil {
+		opts.ProgressFn(FileSyncProgress{NumActions: 1, CurPath: action.RelPath})
+	}
+	var err error
+	if action.Action == FileSyncActionCopy {
+		err = copyFileData(ctx, src.MShell, src.Path, dst.MShell, dst.Path, srcInfo, nil, &rtn.BytesSent)
+	} else {
+		err = syncFileBlocks(ctx, src.MShell, src.Path, dst.MShell, dst.Path, srcInfo, dstInfo, &rtn.BytesSent)
+	}
+	if err != nil {
+		return rtn, fmt.Errorf("%s %q: %w", action.Action, action.RelPath, err)
+	}
+	if opts.ProgressFn != nil {
+		opts.ProgressFn(FileSyncProgress{NumActions: 1, ActionsDone: 1, BytesSent: rtn.BytesSent})
+	}
+	return rtn, nil
+}
+
+func computeSyncActions(srcEntries []*packet.FileInfo, dstEntries []*packet.FileInfo, opts FileSyncOpts) ([]FileSyncAction, int, error) {
+	dstMap := make(map[string]*packet.FileInfo)
+	for _, entry := range dstEntries {
+		dstMap[entry.Name] = entry
+	}
+	srcMap := make(map[string]*packet.FileInfo)
+	var mkdirs, copies, deletes []FileSyncAction
+	var skipped int
+	for _, srcEntry := range srcEntries {
+		srcMap[srcEntry.Name] = srcEntry
+		dstEntry := dstMap[srcEntry.Name]
+		if dstEntry != nil && dstEntry.IsDir != srcEntry.IsDir {
+			return nil, 0, fmt.Errorf("cannot sync %q, it is a file on one side and a directory on the other", srcEntry.Name)
+		}
+		if srcEntry.IsDir {
+			if dstEntry == nil {
+				mkdirs = append(mkdirs, FileSyncAction{Action: FileSyncActionMkdir, RelPath: srcEntry.Name, IsDir: true})
+			}
+			continue
+		}
+		if dstEntry == nil {
+			copies = append(copies, FileSyncAction{Action: FileSyncActionCopy, RelPath: srcEntry.Name, Size: srcEntry.Size})
+			continue
+		}
+		if dstEntry.Size == srcEntry.Size && dstEntry.ModTs == srcEntry.ModTs {
+			skipped++
+			continue
+		}
+		copies = append(copies, FileSyncAction{Action: FileSyncActionUpdate, RelPath: srcEntry.Name, Size: srcEntry.Size})
+	}
+	if opts.Delete {
+		for _, dstEntry := range dstEntries {
+			if srcMap[dstEntry.Name] != nil {
+				continue
+			}
+			deletes = append(deletes, FileSyncAction{Action: FileSyncActionDelete, RelPath: dstEntry.Name, IsDir: dstEntry.IsDir})
+		}
+	}
+	// parents before children for mkdir, children before parents for delete
+	sort.SliceStable(mkdirs, func(i, j int) bool { return depth(mkdirs[i].RelPath) < depth(mkdirs[j].RelPath) })
+	sort.SliceStable(deletes, func(i, j int) bool { return depth(deletes[i].RelPath) > depth(deletes[j].RelPath) })
+	var rtn []FileSyncAction
+	rtn = append(rtn, mkdirs...)
+	rtn = append(rtn, copies...)
+	rtn = append(rtn, deletes...)
+	return rtn, skipped, nil
+}
+
+func runSync(ctx context.Context, src FileSyncEndpoint, dst FileSyncEndpoint, srcEntries []*packet.FileInfo, dstEntries []*packet.FileInfo, createDstRoot bool, opts FileSyncOpts) (*FileSyncResult, error) {
+	actions, skipped, err := computeSyncActions(srcEntries, dstEntries, opts)
+	if err != nil {
+		return nil, err
+	}
+	rtn := &FileSyncResult{Actions: actions, Skipped: skipped}
+	if opts.DryRun {
+		return rtn, nil
+	}
+	srcMap := make(map[string]*packet.FileInfo)
+	for _, entry := range srcEntries {
+		srcMap[entry.Name] = entry
+	}
+	dstMap := make(map[string]*packet.FileInfo)
+	for _, entry := range dstEntries {
+		dstMap[entry.Name] = entry
+	}
+	progress := FileSyncProgress{NumActions: len(actions)}
+	reportProgress := func() {
+		if opts.ProgressFn != nil {
+			progress.BytesSent = rtn.BytesSent
+			opts.ProgressFn(progress)
+		}
+	}
+	if createDstRoot {
+		err = dst.MShell.FileOp(ctx, packet.FileOpMkdir, []string{dst.Path}, 0)
+		if err != nil {
+			return rtn, fmt.Errorf("cannot create %q: %w", dst.Path, err)
+		}
+	}
+	for _, action := range actions {
+		progress.CurPath = action.RelPath
+		reportProgress()
+		srcPath := path.Join(src.Path, action.RelPath)
+		dstPath := path.Join(dst.Path, action.RelPath)
+		switch action.Action {
+		case FileSyncActionMkdir:
+			err = dst.MShell.FileOp(ctx, packet.FileOpMkdir, []string{dstPath}, srcMap[action.RelPath].Perm)
+
+		case FileSyncActionDelete:
+			err = dst.MShell.FileOp(ctx, packet.FileOpRemove, []string{dstPath}, 0)
+
+		case FileSyncActionCopy:
+			err = copyFileData(ctx, src.MShell, srcPath, dst.MShell, dstPath, srcMap[action.RelPath], nil, &rtn.BytesSent)
+
+		case FileSyncActionUpdate:
+			err = syncFileBlocks(ctx, src.MShell, srcPath, dst.MShell, dstPath, srcMap[action.RelPath], dstMap[action.RelPath], &rtn.BytesSent)
+		}
+		if err != nil {
+			return rtn, fmt.Errorf("%s %q: %w", action.Action, action.RelPath, err)
+		}
+		progress.ActionsDone++
+	}
+	progress.CurPath = ""
+	reportProgress()
+	return rtn, nil
+}
+
+// copies the whole file from src to dst.  expectedInfo (if non-nil) is the dst file state we expect
+// (to avoid clobbering concurrent changes), otherwise dst must not exist.
+func copyFileData(ctx context.Context, srcMsh *MShellProc, srcPath string, dstMsh *MShellProc, dstPath string, srcInfo *packet.FileInfo, expectedInfo *packet.FileInfo, bytesSent *int64) error {
+	writePk := packet.MakeWriteFilePacket()
+	writePk.Path = dstPath
+	writePk.ExpectedInfo = expectedInfo
+	if writePk.ExpectedInfo == nil {
+		writePk.ExpectedInfo = &packet.FileInfo{NotFound: true}
+	}
+	writePk.ModTs = srcInfo.ModTs
+	writePk.Perm = srcInfo.Perm
+	fw, err := dstMsh.OpenFileWriter(ctx, writePk)
+	if err != nil {
+		return err
+	}
+	err = copyFileRange(ctx, srcMsh, srcPath, fw, 0, srcInfo.Size, bytesSent, nil)
+	if err != nil {
+		fw.Abort(err.Error())
+		return err
+	}
+	_, err = fw.Close(ctx)
+	return err
+}
+
+// progressFn (if non-nil) is called after each chunk is written
+func copyFileRange(ctx context.Context, srcMsh *MShellProc, srcPath string, fw *FileWriter, start int64, end int64, bytesSent *int64, progressFn func()) error {
+	for pos := start; pos < end; {
+		readEnd := pos + MaxReadRangeSize
+		if readEnd > end {
+			readEnd = end
+		}
+		data, err := srcMsh.ReadFileRange(ctx, srcPath, pos, readEnd)
+		if err != nil {
+			return fmt.Errorf("reading source: %w", err)
+		}
+		if int64(len(data)) != readEnd-pos {
+			return fmt.Errorf("source file changed while reading (short read at offset %d)", pos)
+		}
+		err = fw.WriteAt(ctx, data, pos)
+		if err != nil {
+			return err
+		}
+		*bytesSent += int64(len(data))
+		pos = readEnd
+		if progressFn != nil {
+			progressFn()
+		}
+	}
+	return nil
+}
+
+// compares block hashes on both sides and only sends the blocks that differ
+func syncFileBlocks(ctx context.Context, srcMsh *MShellProc, srcPath string, dstMsh *MShellProc, dstPath string, srcInfo *packet.FileInfo, dstInfo *packet.FileInfo, bytesSent *int64) error {
+	blockSize := syncBlockSize(srcInfo.Size)
+	srcHashes, err := srcMsh.FileBlockHashes(ctx, srcPath, blockSize, 0)
+	if err != nil {
+		return fmt.Errorf("hashing source: %w", err)
+	}
+	// dst can be much larger than src, blocks past the end of src are truncated away so don't hash them
+	// (at least 1 block, maxblocks=0 would hash the whole file)
+	dstMaxBlocks := len(srcHashes.Hashes)
+	if dstMaxBlocks == 0 {
+		dstMaxBlocks = 1
+	}
+	dstHashes, err := dstMsh.FileBlockHashes(ctx, dstPath, blockSize, dstMaxBlocks)
+	if err != nil {
+		return fmt.Errorf("hashing destination: %w", err)
+	}
+	if srcHashes.Info == nil || srcHashes.Info.Size != srcInfo.Size || srcHashes.Info.ModTs != srcInfo.ModTs {
+		return fmt.Errorf("source file changed during sync")
+	}
+	if dstHashes.Info == nil || dstHashes.Info.NotFound {
+		return copyFileData(ctx, srcMsh, srcPath, dstMsh, dstPath, srcInfo, nil, bytesSent)
+	}
+	// the changed blocks are patched into a copy of dst, which replaces dst once the sync is complete (a failed
+	// sync never leaves a half-updated file at dst)
+	partPath := dstPath + FileCopyPartialSuffix
+	err = dstMsh.FileOp(ctx, packet.FileOpCopy, []string{dstPath, partPath}, 0)
+	if err != nil {
+		return fmt.Errorf("copying destination: %w", err)
+	}
+	err = patchFileBlocks(ctx, srcMsh, srcPath, dstMsh, partPath, srcInfo, srcHashes, dstHashes, blockSize, bytesSent)
+	if err == nil {
+		// dst could have been written to while the blocks were sent
+		var curInfo *packet.FileInfo
+		curInfo, err = dstMsh.StatFile(ctx, dstPath)
+		if err == nil && (curInfo.NotFound || curInfo.Size != dstHashes.Info.Size || curInfo.ModTs != dstHashes.Info.ModTs) {
+			err = fmt.Errorf("%w: %s changed during sync", ErrWriteConflict, dstPath)
+		}
+	}
+	if err == nil {
+		err = dstMsh.FileOp(ctx, packet.FileOpRename, []string{partPath, dstPath}, 0)
+	}
+	if err != nil {
+		// ctx can be canceled, still try to clean up
+		cleanupCtx, cancelFn := context.WithTimeout(context.Background(), fileCopyAbortWait)
+		defer cancelFn()
+		removeErr := dstMsh.FileOp(cleanupCtx, packet.FileOpRemove, []string{partPath}, 0)
+		if removeErr != nil {
+			log.Printf("[filesync] cannot remove %s: %v\n", partPath, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// writes the blocks of src that differ from dst into partPath (a copy of dst)
+func patchFileBlocks(ctx context.Context, srcMsh *MShellProc, srcPath string, dstMsh *MShellProc, partPath string, srcInfo *packet.FileInfo, srcHashes *packet.FileBlockHashResponseType, dstHashes *packet.FileBlockHashResponseType, blockSize int64, bytesSent *int64) error {
+	writePk := packet.MakeWriteFilePacket()
+	writePk.Path = partPath
+	writePk.Patch = true
+	writePk.Size = srcInfo.Size
+	writePk.ModTs = srcInfo.ModTs
+	writePk.Perm = srcInfo.Perm
+	fw, err := dstMsh.OpenFileWriter(ctx, writePk)
+	if err != nil {
+		return err
+	}
+	numBlocks := len(srcHashes.Hashes)
+	for idx := 0; idx < numBlocks; idx++ {
+		if idx < len(dstHashes.Hashes) && srcHashes.Hashes[idx] == dstHashes.Hashes[idx] {
+			continue
+		}
+		// coalesce runs of changed blocks into a single range
+		endIdx := idx + 1
+		for endIdx < numBlocks && (endIdx >= len(dstHashes.Hashes) || srcHashes.Hashes[endIdx] != dstHashes.Hashes[endIdx]) {
+			endIdx++
+		}
+		start := int64(idx) * blockSize
+		end := int64(endIdx) * blockSize
+		if end > srcInfo.Size {
+			end = srcInfo.Size
+		}
+		err = copyFileRange(ctx, srcMsh, srcPath, fw, start, end, bytesSent, nil)
+		if err != nil {
+			fw.Abort(err.Error())
+			// wait for mshell to finish the aborted write before the partial file is removed
+			waitCtx, cancelFn := context.WithTimeout(ctx, fileCopyAbortWait)
+			defer cancelFn()
+			fw.wait(waitCtx)
+			return err
+		}
+		idx = endIdx
+	}
+	_, err = fw.Close(ctx)
+	return err
+}
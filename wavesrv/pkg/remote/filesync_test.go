@@ -0,0 +1,76 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+func fileEntry(name string, size int64, modTs int64) *packet.FileInfo {
+	return &packet.FileInfo{Name: name, Size: size, ModTs: modTs}
+}
+
+func dirEntry(name string) *packet.FileInfo {
+	return &packet.FileInfo{Name: name, IsDir: true}
+}
+
+func actionsStr(actions []FileSyncAction) string {
+	var rtn string
+	for _, action := range actions {
+		rtn += fmt.Sprintf("%s:%s ", action.Action, action.RelPath)
+	}
+	return rtn
+}
+
+func testSyncActions(t *testing.T, name string, src []*packet.FileInfo, dst []*packet.FileInfo, opts FileSyncOpts, expected string, expectedSkipped int) {
+	actions, skipped, err := computeSyncActions(src, dst, opts)
+	if err != nil {
+		t.Errorf("%s: unexpected error: %v", name, err)
+		return
+	}
+	if actionsStr(actions) != expected {
+		t.Errorf("%s: actions [%s] expected [%s]", name, actionsStr(actions), expected)
+	}
+	if skipped != expectedSkipped {
+		t.Errorf("%s: skipped %d expected %d", name, skipped, expectedSkipped)
+	}
+}
+
+func TestComputeSyncActions(t *testing.T) {
+	src := []*packet.FileInfo{
+		dirEntry("a"),
+		dirEntry("a/b"),
+		fileEntry("a/b/new.txt", 10, 1000),
+		fileEntry("same.txt", 5, 1000),
+		fileEntry("changed.txt", 5, 2000),
+		fileEntry("resized.txt", 8, 1000),
+	}
+	dst := []*packet.FileInfo{
+		fileEntry("same.txt", 5, 1000),
+		fileEntry("changed.txt", 5, 1000),
+		fileEntry("resized.txt", 4, 1000),
+		dirEntry("old"),
+		fileEntry("old/gone.txt", 1, 1000),
+		fileEntry("gone.txt", 1, 1000),
+	}
+	changes := "mkdir:a mkdir:a/b copy:a/b/new.txt update:changed.txt update:resized.txt "
+	testSyncActions(t, "nodelete", src, dst, FileSyncOpts{}, changes, 1)
+	// children are deleted before their parents
+	testSyncActions(t, "delete", src, dst, FileSyncOpts{Delete: true}, changes+"delete:old/gone.txt delete:old delete:gone.txt ", 1)
+	testSyncActions(t, "unchanged", dst, dst, FileSyncOpts{Delete: true}, "", 5)
+	testSyncActions(t, "emptydst", src[3:], nil, FileSyncOpts{Delete: true}, "copy:same.txt copy:changed.txt copy:resized.txt ", 0)
+	testSyncActions(t, "emptysrc", nil, dst[0:2], FileSyncOpts{Delete: true}, "delete:same.txt delete:changed.txt ", 0)
+}
+
+func TestComputeSyncActionsTypeMismatch(t *testing.T) {
+	src := []*packet.FileInfo{dirEntry("x")}
+	dst := []*packet.FileInfo{fileEntry("x", 1, 1000)}
+	_, _, err := computeSyncActions(src, dst, FileSyncOpts{})
+	if err == nil {
+		t.Errorf("expected error syncing a directory over a file")
+	}
+}
@@ -0,0 +1,587 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/server"
+)
+
+// max bytes sent to a FileWriter that have not been acked by mshell.
+// mshell buffers up to server.MaxWriteFileContextData packets, so this must stay well below that.
+const FileWriterWindowSize = 32 * server.MaxFileDataPacketSize
+
+// max bytes requested in a single ReadFileRange call
+const MaxReadRangeSize = 64 * server.MaxFileDataPacketSize
+
+// like PacketRpcIter, but with a larger response queue.  responses are not blocked on, if the queue
+// fills up the iterator fails with packet.ErrRpcOverflow, so size the queue for the responses in flight.
+func (msh *MShellProc) PacketRpcIterSz(ctx context.Context, pk packet.RpcPacketType, queueSize int) (*packet.RpcResponseIter, error) {
+	if !msh.IsConnected() {
+		return nil, fmt.Errorf("remote is not connected")
+	}
+	if pk == nil {
+		return nil, fmt.Errorf("PacketRpc passed nil packet")
+	}
+	reqId := pk.GetReqId()
+	msh.ServerProc.Output.RegisterRpcSz(reqId, queueSize)
+	err := msh.ServerProc.Input.SendPacketCtx(ctx, pk)
+	if err != nil {
+		msh.ServerProc.Output.UnRegisterRpc(reqId)
+		return nil, err
+	}
+	return msh.ServerProc.Output.GetResponseIter(reqId), nil
+}
+
+// returns (rootinfo, entries, err).  if the path does not exist rootinfo.NotFound will be set.
+func (msh *MShellProc) ListFiles(ctx context.Context, path string, recursive bool) (*packet.FileInfo, []*packet.FileInfo, error) {
+	listPk := packet.MakeFileListPacket()
+	listPk.ReqId = uuid.New().String()
+	listPk.Path = path
+	listPk.Recursive = recursive
+	return msh.readFileListRpc(ctx, listPk, path)
+}
+
+// like ListFiles, but lists the entries inside a tar or zip archive
+func (msh *MShellProc) ListArchive(ctx context.Context, path string) (*packet.FileInfo, []*packet.FileInfo, error) {
+	archivePk := packet.MakeArchiveListPacket()
+	archivePk.ReqId = uuid.New().String()
+	archivePk.Path = path
+	return msh.readFileListRpc(ctx, archivePk, path)
+}
+
+func (msh *MShellProc) readFileListRpc(ctx context.Context, pk packet.RpcPacketType, path string) (*packet.FileInfo, []*packet.FileInfo, error) {
+	iter, err := msh.PacketRpcIterSz(ctx, pk, 100)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+	var rootInfo *packet.FileInfo
+	var entries []*packet.FileInfo
+	for {
+		respIf, err := iter.Next(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if respIf == nil {
+			return nil, nil, fmt.Errorf("%s response channel closed", pk.GetType())
+		}
+		resp, ok := respIf.(*packet.FileListResponseType)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid %s response packet: %s", pk.GetType(), packet.AsString(respIf))
+		}
+		if resp.Error != "" {
+			return nil, nil, errors.New(resp.Error)
+		}
+		if resp.Info != nil {
+			rootInfo = resp.Info
+		}
+		entries = append(entries, resp.Entries...)
+		if resp.Done {
+			break
+		}
+	}
+	if rootInfo == nil {
+		return nil, nil, fmt.Errorf("%s did not return info for %q", pk.GetType(), path)
+	}
+	return rootInfo, entries, nil
+}
+
+// maxBlocks limits how many blocks (from the start of the file) are hashed, 0 hashes the whole file
+func (msh *MShellProc) FileBlockHashes(ctx context.Context, path string, blockSize int64, maxBlocks int) (*packet.FileBlockHashResponseType, error) {
+	hashPk := packet.MakeFileBlockHashPacket()
+	hashPk.ReqId = uuid.New().String()
+	hashPk.Path = path
+	hashPk.BlockSize = blockSize
+	hashPk.MaxBlocks = maxBlocks
+	respIf, err := msh.PacketRpcRaw(ctx, hashPk)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := respIf.(*packet.FileBlockHashResponseType)
+	if !ok {
+		return nil, fmt.Errorf("invalid fileblockhash response packet: %s", packet.AsString(respIf))
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (msh *MShellProc) FileOp(ctx context.Context, op string, paths []string, perm int) error {
+	opPk := packet.MakeFileOpPacket()
+	opPk.ReqId = uuid.New().String()
+	opPk.Op = op
+	opPk.Paths = paths
+	opPk.Perm = perm
+	respPk, err := msh.PacketRpc(ctx, opPk)
+	if err != nil {
+		return err
+	}
+	if respPk.Error != "" {
+		return errors.New(respPk.Error)
+	}
+	return nil
+}
+
+// returns an info with NotFound set if the file does not exist
+func (msh *MShellProc) StatFile(ctx context.Context, path string) (*packet.FileInfo, error) {
+	return msh.StatArchiveEntry(ctx, path, "")
+}
+
+// entry is a path inside the archive at path (if entry is empty, this stats path itself)
+func (msh *MShellProc) StatArchiveEntry(ctx context.Context, path string, entry string) (*packet.FileInfo, error) {
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = uuid.New().String()
+	streamPk.Path = path
+	streamPk.ArchiveEntry = entry
+	streamPk.StatOnly = true
+	respIf, err := msh.PacketRpcRaw(ctx, streamPk)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := respIf.(*packet.StreamFileResponseType)
+	if !ok {
+		return nil, fmt.Errorf("invalid streamfile response packet: %s", packet.AsString(respIf))
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Info == nil {
+		return nil, fmt.Errorf("streamfile did not return info for %q", path)
+	}
+	return resp.Info, nil
+}
+
+// reads [start, end) from path.  the range is capped at MaxReadRangeSize.
+// the response queue is sized to hold the whole range, so no data packets can be dropped.
+func (msh *MShellProc) ReadFileRange(ctx context.Context, path string, start int64, end int64) ([]byte, error) {
+	return msh.ReadArchiveEntryRange(ctx, path, "", start, end)
+}
+
+// reads the whole file (in MaxReadRangeSize chunks).  returns an info with NotFound set (and no data) if
+// the file does not exist, and an error if the file is larger than maxSize.
+func (msh *MShellProc) ReadFile(ctx context.Context, path string, maxSize int64) ([]byte, *packet.FileInfo, error) {
+	finfo, err := msh.StatFile(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if finfo.NotFound {
+		return nil, finfo, nil
+	}
+	if finfo.IsDir {
+		return nil, nil, fmt.Errorf("%q is a directory", path)
+	}
+	if finfo.Size > maxSize {
+		return nil, nil, fmt.Errorf("%q is too large (%d bytes, max %d)", path, finfo.Size, maxSize)
+	}
+	data := make([]byte, 0, finfo.Size)
+	for pos := int64(0); pos < finfo.Size; {
+		readEnd := pos + MaxReadRangeSize
+		if readEnd > finfo.Size {
+			readEnd = finfo.Size
+		}
+		chunk, err := msh.ReadFileRange(ctx, path, pos, readEnd)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(chunk) == 0 {
+			// file was truncated while reading
+			break
+		}
+		data = append(data, chunk...)
+		pos += int64(len(chunk))
+	}
+	return data, finfo, nil
+}
+
+// like ReadFileRange, but reads from an entry inside the archive at path (if entry is non-empty).
+// compressed archives are read from the start of the entry, so use OpenFileStream for sequential reads.
+func (msh *MShellProc) ReadArchiveEntryRange(ctx context.Context, path string, entry string, start int64, end int64) ([]byte, error) {
+	if end <= start {
+		return nil, nil
+	}
+	if end-start > MaxReadRangeSize {
+		return nil, fmt.Errorf("read range too large (%d bytes, max %d)", end-start, MaxReadRangeSize)
+	}
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = uuid.New().String()
+	streamPk.Path = path
+	streamPk.ArchiveEntry = entry
+	streamPk.ByteRange = []int64{start, end - 1}
+	numPackets := int((end-start)/server.MaxFileDataPacketSize) + 1
+	iter, err := msh.PacketRpcIterSz(ctx, streamPk, numPackets+3)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	respIf, err := iter.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := respIf.(*packet.StreamFileResponseType)
+	if !ok {
+		return nil, fmt.Errorf("invalid streamfile response packet: %s", packet.AsString(respIf))
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Done {
+		return nil, nil
+	}
+	rtn := make([]byte, 0, end-start)
+	for {
+		dataIf, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if dataIf == nil {
+			return nil, fmt.Errorf("streamfile response channel closed")
+		}
+		dataPk, ok := dataIf.(*packet.FileDataPacketType)
+		if !ok {
+			return nil, fmt.Errorf("invalid filedata packet: %s", packet.AsString(dataIf))
+		}
+		if dataPk.Error != "" {
+			return nil, errors.New(dataPk.Error)
+		}
+		rtn = append(rtn, dataPk.Data...)
+		if dataPk.Eof {
+			break
+		}
+	}
+	return rtn, nil
+}
+
+// max filedata packets buffered for a FileStream.  each packet is acked as it is read, and mshell never
+// has more than packet.StreamFileAckWindow packets un-acked, so a slow reader can't overflow the queue.
+const FileStreamQueueSize = packet.StreamFileAckWindow + 4
+
+// FileStream reads a byte range of a file (or archive entry) with a single streamfile rpc, so a
+// compressed archive entry is only decompressed once no matter how large the range is.
+type FileStream struct {
+	MShell *MShellProc
+	ReqId  string
+	Iter   *packet.RpcResponseIter
+	Info   *packet.FileInfo
+	Eof    bool
+}
+
+// reads [start, end) from path (or from entry inside the archive at path if entry is non-empty).
+// the caller must Close() the stream.
+func (msh *MShellProc) OpenFileStream(ctx context.Context, path string, entry string, start int64, end int64) (*FileStream, error) {
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = uuid.New().String()
+	streamPk.Path = path
+	streamPk.ArchiveEntry = entry
+	if end > start {
+		streamPk.ByteRange = []int64{start, end - 1}
+		streamPk.AckData = true
+	} else {
+		streamPk.StatOnly = true
+	}
+	iter, err := msh.PacketRpcIterSz(ctx, streamPk, FileStreamQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	respIf, err := iter.Next(ctx)
+	if err == nil && respIf == nil {
+		err = fmt.Errorf("streamfile response channel closed")
+	}
+	if err != nil {
+		iter.Close()
+		return nil, err
+	}
+	resp, ok := respIf.(*packet.StreamFileResponseType)
+	if !ok {
+		iter.Close()
+		return nil, fmt.Errorf("invalid streamfile response packet: %s", packet.AsString(respIf))
+	}
+	if resp.Error != "" {
+		iter.Close()
+		return nil, errors.New(resp.Error)
+	}
+	return &FileStream{MShell: msh, ReqId: streamPk.ReqId, Iter: iter, Info: resp.Info, Eof: resp.Done}, nil
+}
+
+// returns the next chunk of data, or io.EOF when the range has been read
+func (stream *FileStream) Next(ctx context.Context) ([]byte, error) {
+	if stream.Eof {
+		return nil, io.EOF
+	}
+	dataIf, err := stream.Iter.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dataIf == nil {
+		return nil, fmt.Errorf("streamfile response channel closed")
+	}
+	dataPk, ok := dataIf.(*packet.FileDataPacketType)
+	if !ok {
+		return nil, fmt.Errorf("invalid filedata packet: %s", packet.AsString(dataIf))
+	}
+	if dataPk.Error != "" {
+		return nil, errors.New(dataPk.Error)
+	}
+	if dataPk.Eof {
+		stream.Eof = true
+		if len(dataPk.Data) == 0 {
+			return nil, io.EOF
+		}
+		return dataPk.Data, nil
+	}
+	// the packet is consumed, let mshell send the next one
+	err = stream.MShell.ServerProc.Input.SendPacket(packet.MakeFileDataAckPacket(stream.ReqId, len(dataPk.Data)))
+	if err != nil {
+		return nil, err
+	}
+	return dataPk.Data, nil
+}
+
+// cancels the rpc if the stream was not read to the end (mshell stops sending once the ack window is full)
+func (stream *FileStream) Close() {
+	if !stream.Eof {
+		stream.MShell.CancelRpc(stream.ReqId)
+	}
+	stream.Iter.Close()
+}
+
+// FileWriter sends data to a writefile rpc with flow control.  mshell acks each filedata packet
+// as it is written and we never have more than FileWriterWindowSize bytes un-acked.
+type FileWriter struct {
+	CVar     *sync.Cond
+	MShell   *MShellProc
+	ReqId    string
+	Patch    bool
+	Iter     *packet.RpcResponseIter
+	InFlight int
+	Done     *packet.WriteFileDonePacketType
+	Err      error
+}
+
+// writePk.AckData is always set.  returns an error if mshell rejects the write (check for a
+// conflict with errors.Is(err, ErrWriteConflict)).
+func (msh *MShellProc) OpenFileWriter(ctx context.Context, writePk *packet.WriteFilePacketType) (*FileWriter, error) {
+	if writePk.ReqId == "" {
+		writePk.ReqId = uuid.New().String()
+	}
+	writePk.AckData = true
+	numAcks := FileWriterWindowSize / server.MaxFileDataPacketSize
+	iter, err := msh.PacketRpcIterSz(ctx, writePk, numAcks+5)
+	if err != nil {
+		return nil, err
+	}
+	readyIf, err := iter.Next(ctx)
+	if err != nil {
+		iter.Close()
+		return nil, fmt.Errorf("error while getting ready response: %w", err)
+	}
+	readyPk, ok := readyIf.(*packet.WriteFileReadyPacketType)
+	if !ok {
+		iter.Close()
+		return nil, fmt.Errorf("bad ready packet received: %T", readyIf)
+	}
+	if readyPk.Conflict {
+		iter.Close()
+		return nil, fmt.Errorf("%w: %s", ErrWriteConflict, readyPk.Error)
+	}
+	if readyPk.Error != "" {
+		iter.Close()
+		return nil, fmt.Errorf("ready error: %s", readyPk.Error)
+	}
+	fw := &FileWriter{
+		CVar:   sync.NewCond(&sync.Mutex{}),
+		MShell: msh,
+		ReqId:  writePk.ReqId,
+		Patch:  writePk.Patch,
+		Iter:   iter,
+	}
+	go fw.readAcks()
+	return fw, nil
+}
+
+var ErrWriteConflict = errors.New("write conflict")
+
+func (fw *FileWriter) readAcks() {
+	defer fw.Iter.Close()
+	for {
+		// no timeout here, Close() (or the remote disconnecting) ends the rpc
+		respIf, err := fw.Iter.Next(context.Background())
+		fw.CVar.L.Lock()
+		if err == nil && respIf == nil {
+			err = fmt.Errorf("writefile response channel closed")
+		}
+		if err != nil {
+			if fw.Err == nil {
+				fw.Err = err
+			}
+			fw.CVar.Broadcast()
+			fw.CVar.L.Unlock()
+			return
+		}
+		switch resp := respIf.(type) {
+		case *packet.FileDataAckPacketType:
+			fw.InFlight -= resp.AckLen
+			if fw.InFlight < 0 {
+				fw.InFlight = 0
+			}
+
+		case *packet.WriteFileDonePacketType:
+			fw.Done = resp
+			if resp.Error != "" && fw.Err == nil {
+				if resp.Conflict {
+					fw.Err = fmt.Errorf("%w: %s", ErrWriteConflict, resp.Error)
+				} else {
+					fw.Err = errors.New(resp.Error)
+				}
+			}
+			if fw.Err == nil && fw.InFlight > 0 {
+				fw.Err = fmt.Errorf("writefile done with %d bytes unacked", fw.InFlight)
+			}
+
+		default:
+			if fw.Err == nil {
+				fw.Err = fmt.Errorf("invalid writefile response packet: %s", packet.AsString(respIf))
+			}
+		}
+		isDone := fw.Done != nil
+		fw.CVar.Broadcast()
+		fw.CVar.L.Unlock()
+		if isDone {
+			return
+		}
+	}
+}
+
+// cond vars can't select on ctx, so wake any waiters when ctx is done.  call the returned func when done waiting.
+func (fw *FileWriter) wakeOnCtxDone(ctx context.Context) func() {
+	doneCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			fw.CVar.L.Lock()
+			defer fw.CVar.L.Unlock()
+			fw.CVar.Broadcast()
+
+		case <-doneCh:
+		}
+	}()
+	return func() { close(doneCh) }
+}
+
+// waits until there is room in the window for dataLen bytes (or an error)
+func (fw *FileWriter) waitForWindow(ctx context.Context, dataLen int) error {
+	stopFn := fw.wakeOnCtxDone(ctx)
+	defer stopFn()
+	fw.CVar.L.Lock()
+	defer fw.CVar.L.Unlock()
+	for {
+		if fw.Err != nil {
+			return fw.Err
+		}
+		if fw.Done != nil {
+			return fmt.Errorf("writefile already done")
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if fw.InFlight+dataLen <= FileWriterWindowSize {
+			fw.InFlight += dataLen
+			return nil
+		}
+		fw.CVar.Wait()
+	}
+}
+
+// offset is only used in patch mode (otherwise data is appended)
+func (fw *FileWriter) WriteAt(ctx context.Context, data []byte, offset int64) error {
+	for len(data) > 0 {
+		chunkLen := len(data)
+		if chunkLen > server.MaxFileDataPacketSize {
+			chunkLen = server.MaxFileDataPacketSize
+		}
+		err := fw.waitForWindow(ctx, chunkLen)
+		if err != nil {
+			return err
+		}
+		dataPk := packet.MakeFileDataPacket(fw.ReqId)
+		dataPk.Data = make([]byte, chunkLen)
+		copy(dataPk.Data, data[0:chunkLen])
+		if fw.Patch {
+			dataPk.Offset = offset
+		}
+		err = fw.MShell.SendFileData(dataPk)
+		if err != nil {
+			return err
+		}
+		data = data[chunkLen:]
+		offset += int64(chunkLen)
+	}
+	return nil
+}
+
+// sends eof and waits for writefiledone.  returns the file info after the write.
+func (fw *FileWriter) Close(ctx context.Context) (*packet.FileInfo, error) {
+	dataPk := packet.MakeFileDataPacket(fw.ReqId)
+	dataPk.Eof = true
+	err := fw.MShell.SendFileData(dataPk)
+	if err != nil {
+		return nil, err
+	}
+	return fw.wait(ctx)
+}
+
+// aborts the write (mshell stops writing, data already written is left in place)
+func (fw *FileWriter) Abort(errStr string) {
+	dataPk := packet.MakeFileDataPacket(fw.ReqId)
+	dataPk.Error = errStr
+	fw.MShell.SendFileData(dataPk)
+}
+
+func (fw *FileWriter) wait(ctx context.Context) (*packet.FileInfo, error) {
+	stopFn := fw.wakeOnCtxDone(ctx)
+	defer stopFn()
+	fw.CVar.L.Lock()
+	defer fw.CVar.L.Unlock()
+	for {
+		if fw.Err != nil {
+			return nil, fw.Err
+		}
+		if fw.Done != nil {
+			return fw.Done.Info, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		fw.CVar.Wait()
+	}
+}
+
+func (msh *MShellProc) SearchFile(ctx context.Context, path string, pattern []byte, offset int64, maxResults int) (*packet.FileSearchResponseType, error) {
+	searchPk := packet.MakeFileSearchPacket()
+	searchPk.ReqId = uuid.New().String()
+	searchPk.Path = path
+	searchPk.Pattern = pattern
+	searchPk.Offset = offset
+	searchPk.MaxResults = maxResults
+	respIf, err := msh.PacketRpcRaw(ctx, searchPk)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := respIf.(*packet.FileSearchResponseType)
+	if !ok {
+		return nil, fmt.Errorf("invalid filesearch response packet: %s", packet.AsString(respIf))
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const FileWatchRpcTimeout = 5 * time.Second
+
+// registers the (screenid, lineid) as interested in changes to path.
+// the watch is only sent to mshell the first time a path is watched.
+func (msh *MShellProc) WatchFile(ctx context.Context, path string, screenId string, lineId string) error {
+	var isNew bool
+	msh.WithLock(func() {
+		lines := msh.FileWatches[path]
+		if lines == nil {
+			lines = make(map[sstore.CmdPtr]bool)
+			msh.FileWatches[path] = lines
+			isNew = true
+		}
+		lines[sstore.CmdPtr{ScreenId: screenId, LineId: lineId}] = true
+	})
+	if !isNew {
+		return nil
+	}
+	err := msh.sendWatchFile(ctx, []string{path}, false)
+	if err != nil {
+		// other lines may have started watching this path while the rpc was in flight, only remove ours
+		msh.WithLock(func() {
+			lines := msh.FileWatches[path]
+			delete(lines, sstore.CmdPtr{ScreenId: screenId, LineId: lineId})
+			if len(lines) == 0 {
+				delete(msh.FileWatches, path)
+			}
+		})
+		return err
+	}
+	return nil
+}
+
+// removes all file watches for the given line, mshell is told to stop watching paths that no longer have any lines
+func (msh *MShellProc) UnWatchLine(screenId string, lineId string) {
+	ptr := sstore.CmdPtr{ScreenId: screenId, LineId: lineId}
+	var unwatchPaths []string
+	msh.WithLock(func() {
+		for path, lines := range msh.FileWatches {
+			if !lines[ptr] {
+				continue
+			}
+			delete(lines, ptr)
+			if len(lines) == 0 {
+				delete(msh.FileWatches, path)
+				unwatchPaths = append(unwatchPaths, path)
+			}
+		}
+	})
+	if len(unwatchPaths) == 0 || !msh.IsConnected() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), FileWatchRpcTimeout)
+	defer cancelFn()
+	err := msh.sendWatchFile(ctx, unwatchPaths, true)
+	if err != nil {
+		log.Printf("[error] unwatching files on remote %s: %v\n", msh.GetRemoteName(), err)
+	}
+}
+
+// UnWatchLine across all remotes (used when lines are purged/closed and we don't know the remote)
+func UnWatchLineAllRemotes(screenId string, lineId string) {
+	for _, msh := range GetRemoteMap() {
+		msh.UnWatchLine(screenId, lineId)
+	}
+}
+
+func (msh *MShellProc) sendWatchFile(ctx context.Context, paths []string, unwatch bool) error {
+	watchPk := packet.MakeWatchFilePacket()
+	watchPk.ReqId = uuid.New().String()
+	watchPk.Paths = paths
+	watchPk.UnWatch = unwatch
+	respPk, err := msh.PacketRpc(ctx, watchPk)
+	if err != nil {
+		return err
+	}
+	if respPk.Error != "" {
+		return errors.New(respPk.Error)
+	}
+	return nil
+}
+
+// mshell loses its watches when it restarts, so re-send them after a (re)connect
+func (msh *MShellProc) resendFileWatches() {
+	var paths []string
+	msh.WithLock(func() {
+		for path := range msh.FileWatches {
+			paths = append(paths, path)
+		}
+	})
+	if len(paths) == 0 {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), FileWatchRpcTimeout)
+	defer cancelFn()
+	err := msh.sendWatchFile(ctx, paths, false)
+	if err != nil {
+		msh.WriteToPtyBuffer("*error re-watching files: %v\n", err)
+	}
+}
+
+func (msh *MShellProc) handleFileChangedPacket(pk *packet.FileChangedPacketType) {
+	var lines []sstore.CmdPtr
+	msh.WithLock(func() {
+		for ptr := range msh.FileWatches[pk.Path] {
+			lines = append(lines, ptr)
+		}
+	})
+	for _, ptr := range lines {
+		fcUpdate := &sstore.FileChangedType{
+			ScreenId: ptr.ScreenId,
+			LineId:   ptr.LineId,
+			Path:     pk.Path,
+			Deleted:  pk.Op == packet.FileChangeOpRemove,
+		}
+		if pk.Info != nil {
+			fcUpdate.ModTs = pk.Info.ModTs
+			fcUpdate.Size = pk.Info.Size
+		}
+		sstore.MainBus.SendScreenUpdate(ptr.ScreenId, &sstore.ModelUpdate{FileChanged: fcUpdate})
+	}
+}
@@ -124,6 +124,7 @@ type MShellProc struct {
 	RunningCmds      map[base.CommandKey]RunCmdType
 	WaitingCmds      []RunCmdType
 	PendingStateCmds map[pendingStateKey]base.CommandKey // key=[remoteinstance name]
+	FileWatches      map[string]map[sstore.CmdPtr]bool   // path -> lines (codeedit) watching that path
 }
 
 type RunCmdType struct {
@@ -131,6 +132,7 @@ type RunCmdType struct {
 	ScreenId  string
 	RemotePtr sstore.RemotePtrType
 	RunPacket *packet.RunPacketType
+	DoneCh    chan bool // closed when the cmd is removed from RunningCmds (after its done or hangup is processed)
 }
 
 type RemoteRuntimeState struct {
@@ -685,6 +687,7 @@ func MakeMShell(r *sstore.RemoteType) *MShellProc {
 		RunningCmds:      make(map[base.CommandKey]RunCmdType),
 		PendingStateCmds: make(map[pendingStateKey]base.CommandKey),
 		StateMap:         make(map[string]*packet.ShellState),
+		FileWatches:      make(map[string]map[sstore.CmdPtr]bool),
 	}
 	rtn.WriteToPtyBuffer("console for connection [%s]\n", r.GetName())
 	return rtn
@@ -1319,6 +1322,7 @@ func (msh *MShellProc) Launch(interactive bool) {
 		msh.WriteToPtyBuffer("*disconnected exitcode=%d\n", exitCode)
 	}()
 	go msh.ProcessPackets()
+	go msh.resendFileWatches()
 	return
 }
 
@@ -1393,6 +1397,14 @@ func (msh *MShellProc) SendFileData(dataPk *packet.FileDataPacketType) error {
 	return msh.ServerProc.Input.SendPacket(dataPk)
 }
 
+// tells mshell to cancel a running rpc (only cancelable rpcs respond to this)
+func (msh *MShellProc) CancelRpc(reqId string) error {
+	if !msh.IsConnected() {
+		return fmt.Errorf("remote is not connected, cannot cancel rpc")
+	}
+	return msh.ServerProc.Input.SendPacket(packet.MakeRpcCancelPacket(reqId))
+}
+
 func makeTermOpts(runPk *packet.RunPacketType) sstore.TermOpts {
 	return sstore.TermOpts{Rows: int64(runPk.TermOpts.Rows), Cols: int64(runPk.TermOpts.Cols), FlexRows: true, MaxPtySize: DefaultMaxPtySize}
 }
@@ -1432,6 +1444,7 @@ func RunCommand(ctx context.Context, sessionId string, screenId string, remotePt
 		ScreenId:  screenId,
 		RemotePtr: remotePtr,
 		RunPacket: runPacket,
+		DoneCh:    make(chan bool),
 	}
 	if remotePtr.OwnerId != "" {
 		return nil, nil, fmt.Errorf("cannot run command against another user's remote '%s'", remotePtr.MakeFullRemoteRef())
@@ -1590,6 +1603,10 @@ func (msh *MShellProc) GetRunningCmd(ck base.CommandKey) *RunCmdType {
 func (msh *MShellProc) RemoveRunningCmd(ck base.CommandKey) {
 	msh.Lock.Lock()
 	defer msh.Lock.Unlock()
+	rct, found := msh.RunningCmds[ck]
+	if found && rct.DoneCh != nil {
+		close(rct.DoneCh)
+	}
 	delete(msh.RunningCmds, ck)
 	for key, pendingCk := range msh.PendingStateCmds {
 		if pendingCk == ck {
@@ -1630,7 +1647,10 @@ func (msh *MShellProc) PacketRpcRaw(ctx context.Context, pk packet.RpcPacketType
 	}
 	rtnPk := msh.ServerProc.Output.WaitForResponse(ctx, reqId)
 	if rtnPk == nil {
-		return nil, ctx.Err()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("no response received (remote disconnected)")
 	}
 	return rtnPk, nil
 }
@@ -1672,6 +1692,11 @@ func (msh *MShellProc) notifyHangups_nolock() {
 		update := &sstore.ModelUpdate{Cmd: cmd}
 		sstore.MainBus.SendScreenUpdate(ck.GetGroupId(), update)
 	}
+	for _, rct := range msh.RunningCmds {
+		if rct.DoneCh != nil {
+			close(rct.DoneCh)
+		}
+	}
 	msh.RunningCmds = make(map[base.CommandKey]RunCmdType)
 	msh.PendingStateCmds = make(map[pendingStateKey]base.CommandKey)
 	msh.WaitingCmds = nil
@@ -1893,6 +1918,10 @@ func (msh *MShellProc) ProcessPackets() {
 			msh.WriteToPtyBuffer("stderr> [remote %s] %s\n", msh.GetRemoteName(), rawPacket.Data)
 			continue
 		}
+		if pk.GetType() == packet.FileChangedPacketStr {
+			msh.handleFileChangedPacket(pk.(*packet.FileChangedPacketType))
+			continue
+		}
 		if pk.GetType() == packet.CmdStartPacketStr {
 			startPk := pk.(*packet.CmdStartPacketType)
 			msh.WriteToPtyBuffer("start> [remote %s] reqid=%s (%p)\n", msh.GetRemoteName(), startPk.RespId, msh.ServerProc.Output)
@@ -2053,7 +2082,7 @@ func (msh *MShellProc) getFullState(stateDiff *packet.ShellStateDiff) (*packet.S
 		}
 		return &newState, nil
 	} else {
-		fullState, err := sstore.GetFullState(context.Background(), sstore.ShellStatePtr{stateDiff.BaseHash, stateDiff.DiffHashArr})
+		fullState, err := sstore.GetFullState(context.Background(), sstore.ShellStatePtr{BaseHash: stateDiff.BaseHash, DiffHashArr: stateDiff.DiffHashArr})
 		if err != nil {
 			return nil, err
 		}
@@ -2072,7 +2101,7 @@ func (msh *MShellProc) getFeStateFromDiff(stateDiff *packet.ShellStateDiff) (map
 		}
 		return sstore.FeStateFromShellState(&newState), nil
 	} else {
-		fullState, err := sstore.GetFullState(context.Background(), sstore.ShellStatePtr{stateDiff.BaseHash, stateDiff.DiffHashArr})
+		fullState, err := sstore.GetFullState(context.Background(), sstore.ShellStatePtr{BaseHash: stateDiff.BaseHash, DiffHashArr: stateDiff.DiffHashArr})
 		if err != nil {
 			return nil, err
 		}
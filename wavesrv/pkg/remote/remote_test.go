@@ -0,0 +1,41 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestRunningCmdDoneCh(t *testing.T) {
+	msh := MakeMShell(&sstore.RemoteType{RemoteId: uuid.New().String(), RemoteAlias: "test"})
+	ck := base.MakeCommandKey(uuid.New().String(), uuid.New().String())
+	runPk := packet.MakeRunPacket()
+	runPk.CK = ck
+	msh.AddRunningCmd(RunCmdType{RunPacket: runPk, DoneCh: make(chan bool)})
+	rct := msh.GetRunningCmd(ck)
+	if rct == nil {
+		t.Fatalf("cmd should be running")
+	}
+	select {
+	case <-rct.DoneCh:
+		t.Fatalf("donech should not be closed while the cmd is running")
+	default:
+	}
+	msh.RemoveRunningCmd(ck)
+	select {
+	case <-rct.DoneCh:
+	default:
+		t.Fatalf("donech should be closed when the cmd is removed")
+	}
+	// done and final packets both remove the cmd, the second remove is a no-op
+	msh.RemoveRunningCmd(ck)
+	if msh.GetRunningCmd(ck) != nil {
+		t.Errorf("cmd should not be running")
+	}
+}
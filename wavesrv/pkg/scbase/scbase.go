@@ -32,6 +32,7 @@ const WaveHomeVarName = "WAVETERM_HOME"
 const WaveDevVarName = "WAVETERM_DEV"
 const SessionsDirBaseName = "sessions"
 const ScreensDirBaseName = "screens"
+const TrashDirBaseName = "trash"
 const WaveLockFile = "waveterm.lock"
 const WaveDirName = ".waveterm"        // must match emain.ts
 const WaveDevDirName = ".waveterm-dev" // must match emain.ts
@@ -227,6 +228,31 @@ func GetScreensDir() string {
 	return sdir
 }
 
+func GetTrashDir() string {
+	waveHome := GetWaveHomeDir()
+	return path.Join(waveHome, TrashDirBaseName)
+}
+
+// directory that holds the files of one trash item
+func EnsureTrashItemDir(trashId string) (string, error) {
+	if trashId == "" {
+		return "", fmt.Errorf("cannot get trash dir for blank trashid")
+	}
+	tdir := path.Join(GetTrashDir(), trashId)
+	err := ensureDir(tdir)
+	if err != nil {
+		return "", err
+	}
+	return tdir, nil
+}
+
+// the screen dir is cached by EnsureScreenDir, clear the cache when it is moved or removed
+func ClearScreenDirCache(screenId string) {
+	BaseLock.Lock()
+	defer BaseLock.Unlock()
+	delete(ScreenDirCache, screenId)
+}
+
 func ensureDir(dirName string) error {
 	info, err := os.Stat(dirName)
 	if errors.Is(err, fs.ErrNotExist) {
@@ -275,6 +301,35 @@ func PtyOutFile(screenId string, lineId string) (string, error) {
 	return fmt.Sprintf("%s/%s.ptyout.cf", sdir, lineId), nil
 }
 
+func ptyOutSidecarFile(screenId string, lineId string, ext string) (string, error) {
+	sdir, err := EnsureScreenDir(screenId)
+	if err != nil {
+		return "", err
+	}
+	if screenId == "" {
+		return "", fmt.Errorf("cannot get %s file for blank screenid", ext)
+	}
+	if lineId == "" {
+		return "", fmt.Errorf("cannot get %s file for blank lineid", ext)
+	}
+	return fmt.Sprintf("%s/%s.ptyout.%s", sdir, lineId, ext), nil
+}
+
+// sidecar file for the ptyout file, holds the timestamp of each chunk of output
+func PtyTimingFile(screenId string, lineId string) (string, error) {
+	return ptyOutSidecarFile(screenId, lineId, "ts")
+}
+
+// compressed output that was pushed out of the ptyout file (see sstore/ptyoverflow.go)
+func PtyOverflowFile(screenId string, lineId string) (string, error) {
+	return ptyOutSidecarFile(screenId, lineId, "ovf")
+}
+
+// overflow output that has not been compressed yet
+func PtyOverflowPendingFile(screenId string, lineId string) (string, error) {
+	return ptyOutSidecarFile(screenId, lineId, "ovp")
+}
+
 func GenWaveUUID() string {
 	for {
 		rtn := uuid.New().String()
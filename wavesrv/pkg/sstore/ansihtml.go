@@ -0,0 +1,235 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// xterm default palette for the 16 basic colors
+var ansiBasicColors = []string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+type ansiStyle struct {
+	Fg        string
+	Bg        string
+	Bold      bool
+	Dim       bool
+	Italic    bool
+	Underline bool
+	Inverse   bool
+	Strike    bool
+}
+
+func (s ansiStyle) css() string {
+	var parts []string
+	fg, bg := s.Fg, s.Bg
+	if s.Inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = "var(--ansi-bg)"
+		}
+		if bg == "" {
+			bg = "var(--ansi-fg)"
+		}
+	}
+	if fg != "" {
+		parts = append(parts, "color:"+fg)
+	}
+	if bg != "" {
+		parts = append(parts, "background-color:"+bg)
+	}
+	if s.Bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if s.Dim {
+		parts = append(parts, "opacity:0.7")
+	}
+	if s.Italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if s.Underline && s.Strike {
+		parts = append(parts, "text-decoration:underline line-through")
+	} else if s.Underline {
+		parts = append(parts, "text-decoration:underline")
+	} else if s.Strike {
+		parts = append(parts, "text-decoration:line-through")
+	}
+	return strings.Join(parts, ";")
+}
+
+func ansi256Color(n int) string {
+	if n < 0 || n > 255 {
+		return ""
+	}
+	if n < 16 {
+		return ansiBasicColors[n]
+	}
+	if n >= 232 {
+		gray := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+	n -= 16
+	levels := []int{0, 95, 135, 175, 215, 255}
+	return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[(n/6)%6], levels[n%6])
+}
+
+// parses an extended color (38/48 params), returns the color and the number of extra params used
+func parseExtendedColor(params []int) (string, int) {
+	if len(params) >= 2 && params[0] == 5 {
+		return ansi256Color(params[1]), 2
+	}
+	if len(params) >= 4 && params[0] == 2 {
+		return fmt.Sprintf("#%02x%02x%02x", params[1]&0xff, params[2]&0xff, params[3]&0xff), 4
+	}
+	return "", len(params)
+}
+
+func (s *ansiStyle) applySGR(paramStr string) {
+	var params []int
+	for _, p := range strings.FieldsFunc(paramStr, func(r rune) bool { return r == ';' || r == ':' }) {
+		val, _ := strconv.Atoi(p)
+		params = append(params, val)
+	}
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch {
+		case p == 0:
+			*s = ansiStyle{}
+		case p == 1:
+			s.Bold = true
+		case p == 2:
+			s.Dim = true
+		case p == 3:
+			s.Italic = true
+		case p == 4:
+			s.Underline = true
+		case p == 7:
+			s.Inverse = true
+		case p == 9:
+			s.Strike = true
+		case p == 22:
+			s.Bold = false
+			s.Dim = false
+		case p == 23:
+			s.Italic = false
+		case p == 24:
+			s.Underline = false
+		case p == 27:
+			s.Inverse = false
+		case p == 29:
+			s.Strike = false
+		case p >= 30 && p <= 37:
+			s.Fg = ansiBasicColors[p-30]
+		case p == 38:
+			color, used := parseExtendedColor(params[i+1:])
+			s.Fg = color
+			i += used
+		case p == 39:
+			s.Fg = ""
+		case p >= 40 && p <= 47:
+			s.Bg = ansiBasicColors[p-40]
+		case p == 48:
+			color, used := parseExtendedColor(params[i+1:])
+			s.Bg = color
+			i += used
+		case p == 49:
+			s.Bg = ""
+		case p >= 90 && p <= 97:
+			s.Fg = ansiBasicColors[p-90+8]
+		case p >= 100 && p <= 107:
+			s.Bg = ansiBasicColors[p-100+8]
+		}
+	}
+}
+
+type ansiCell struct {
+	Ch    rune
+	Style ansiStyle
+}
+
+// converts raw terminal output to html (escaped text with styled spans, no surrounding <pre>).
+// SGR sequences (colors and text attributes) are converted, other escape sequences are dropped.
+// control chars are handled like StripAnsiOutput.
+func AnsiOutputToHtml(data []byte) string {
+	var rtn strings.Builder
+	var style ansiStyle
+	var line []ansiCell
+	flushLine := func() {
+		var curStyle ansiStyle
+		var spanOpen bool
+		var text strings.Builder
+		writeSpan := func() {
+			if text.Len() == 0 {
+				return
+			}
+			css := curStyle.css()
+			if css != "" {
+				rtn.WriteString(`<span style="` + css + `">`)
+				spanOpen = true
+			}
+			rtn.WriteString(html.EscapeString(text.String()))
+			if spanOpen {
+				rtn.WriteString("</span>")
+				spanOpen = false
+			}
+			text.Reset()
+		}
+		for _, cell := range line {
+			if cell.Style != curStyle {
+				writeSpan()
+				curStyle = cell.Style
+			}
+			text.WriteRune(cell.Ch)
+		}
+		writeSpan()
+		line = line[:0]
+	}
+	for i := 0; i < len(data); {
+		ch := data[i]
+		switch {
+		case ch == 0x1b:
+			end := skipEscapeSeq(data, i)
+			if i+1 < len(data) && data[i+1] == '[' && data[end] == 'm' {
+				style.applySGR(string(data[i+2 : end]))
+			}
+			i = end + 1
+			continue
+		case ch == '\n':
+			flushLine()
+			rtn.WriteByte('\n')
+		case ch == '\r':
+			if i+1 >= len(data) || data[i+1] != '\n' {
+				line = line[:0]
+			}
+		case ch == '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case ch == '\t':
+			line = append(line, ansiCell{Ch: '\t', Style: style})
+		case ch < 0x20 || ch == 0x7f:
+			// other control chars are dropped
+		default:
+			r, size := utf8.DecodeRune(data[i:])
+			if r != utf8.RuneError || size > 1 {
+				line = append(line, ansiCell{Ch: r, Style: style})
+			}
+			i += size
+			continue
+		}
+		i++
+	}
+	flushLine()
+	return rtn.String()
+}
@@ -0,0 +1,43 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"testing"
+)
+
+func TestAnsiOutputToHtml(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain", "hello\nworld", "hello\nworld"},
+		{"escape", "<b>&\"x\"</b>\n", "&lt;b&gt;&amp;&#34;x&#34;&lt;/b&gt;\n"},
+		{"sgr", "\x1b[31mred\x1b[0m ok", `<span style="color:#cd0000">red</span> ok`},
+		{"sgr-escape", "\x1b[1m<x>", `<span style="font-weight:bold">&lt;x&gt;</span>`},
+		{"sgr-256", "\x1b[1;38;5;196mx", `<span style="color:#ff0000;font-weight:bold">x</span>`},
+		{"sgr-gray", "\x1b[38;5;232mx", `<span style="color:#080808">x</span>`},
+		{"sgr-truecolor", "\x1b[48;2;1;2;3mx", `<span style="background-color:#010203">x</span>`},
+		{"sgr-bright", "\x1b[92;104mx", `<span style="color:#00ff00;background-color:#5c5cff">x</span>`},
+		{"sgr-inverse", "\x1b[7mx", `<span style="color:var(--ansi-bg);background-color:var(--ansi-fg)">x</span>`},
+		{"sgr-attrs", "\x1b[3;4;9mx\x1b[23;24;29my", `<span style="font-style:italic;text-decoration:underline line-through">x</span>y`},
+		{"sgr-reset-fg", "\x1b[31ma\x1b[39mb", `<span style="color:#cd0000">a</span>b`},
+		{"sgr-multiline", "\x1b[31ma\nb\x1b[0m", "<span style=\"color:#cd0000\">a</span>\n<span style=\"color:#cd0000\">b</span>"},
+		{"osc", "\x1b]0;title\x07a\x1b]8;;http://x\x1b\\b", "ab"},
+		{"cursor", "a\x1b[2Cb\x1b[Kc\x1b[10;5H", "abc"},
+		{"progress", "abc\rd\n", "d\n"},
+		{"backspace", "ab\bc", "ac"},
+		{"crlf", "a\r\nb", "a\nb"},
+		{"utf8", "\x1b[1mcafé", `<span style="font-weight:bold">café</span>`},
+		{"invalid-utf8", "a\xffb", "ab"},
+		{"truncated-csi", "abc\x1b[3", "abc"},
+	}
+	for _, test := range tests {
+		rtn := AnsiOutputToHtml([]byte(test.input))
+		if rtn != test.expected {
+			t.Errorf("%s: AnsiOutputToHtml(%q) = %q, expected %q", test.name, test.input, rtn, test.expected)
+		}
+	}
+}
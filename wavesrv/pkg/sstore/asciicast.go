@@ -0,0 +1,224 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// asciinema v2 file format (https://docs.asciinema.org/manual/asciicast/v2/)
+// first line is the header, followed by one json array per event: [time, code, data]
+const AsciicastVersion = 2
+const AsciicastEventOutput = "o"
+const MaxAsciicastLineSize = 10 * 1024 * 1024
+
+type AsciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Duration  float64           `json:"duration,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type AsciicastEvent struct {
+	Time float64
+	Code string
+	Data string
+}
+
+func (e AsciicastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time, e.Code, e.Data})
+}
+
+func (e *AsciicastEvent) UnmarshalJSON(data []byte) error {
+	var arr []json.RawMessage
+	err := json.Unmarshal(data, &arr)
+	if err != nil {
+		return err
+	}
+	if len(arr) != 3 {
+		return fmt.Errorf("invalid event, expected 3 elements got %d", len(arr))
+	}
+	err = json.Unmarshal(arr[0], &e.Time)
+	if err != nil {
+		return fmt.Errorf("invalid event time: %v", err)
+	}
+	err = json.Unmarshal(arr[1], &e.Code)
+	if err != nil {
+		return fmt.Errorf("invalid event code: %v", err)
+	}
+	err = json.Unmarshal(arr[2], &e.Data)
+	if err != nil {
+		return fmt.Errorf("invalid event data: %v", err)
+	}
+	return nil
+}
+
+// returns the length of buf without a trailing incomplete utf8 sequence
+func completeUtf8Len(buf []byte) int {
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(buf[i]) {
+			continue
+		}
+		if !utf8.FullRune(buf[i:]) {
+			return i
+		}
+		break
+	}
+	return len(buf)
+}
+
+// converts pty output into output events.  dataOffset is the cirfile offset of data (data may have
+// been truncated by the circular buffer).  event times are relative to startTs (ms).  output that
+// is not covered by the timing entries (lines without a timing file) is emitted with the last time.
+func MakeAsciicastEvents(startTs int64, dataOffset int64, data []byte, timing []PtyTimingEntry) []AsciicastEvent {
+	var rtn []AsciicastEvent
+	var pending []byte
+	var lastTime float64
+	dataEnd := dataOffset + int64(len(data))
+	emitted := dataOffset
+	emit := func(eventTime float64, chunk []byte) {
+		pending = append(pending, chunk...)
+		cut := completeUtf8Len(pending)
+		if cut == 0 {
+			return
+		}
+		rtn = append(rtn, AsciicastEvent{Time: eventTime, Code: AsciicastEventOutput, Data: string(pending[:cut])})
+		pending = append([]byte(nil), pending[cut:]...)
+	}
+	for _, entry := range timing {
+		end := entry.EndPos()
+		if end > dataEnd {
+			end = dataEnd
+		}
+		if end <= emitted {
+			continue
+		}
+		eventTime := float64(entry.Ts-startTs) / 1000
+		if eventTime < lastTime {
+			eventTime = lastTime
+		}
+		lastTime = eventTime
+		// any gap before entry.Pos is included in this event
+		emit(eventTime, data[emitted-dataOffset:end-dataOffset])
+		emitted = end
+	}
+	if emitted < dataEnd {
+		emit(lastTime, data[emitted-dataOffset:])
+	}
+	if len(pending) > 0 {
+		rtn = append(rtn, AsciicastEvent{Time: lastTime, Code: AsciicastEventOutput, Data: string(pending)})
+	}
+	return rtn
+}
+
+func WriteAsciicast(w io.Writer, header *AsciicastHeader, events []AsciicastEvent) error {
+	header.Version = AsciicastVersion
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := enc.Encode(header)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		err = enc.Encode(event)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func ReadAsciicast(r io.Reader) (*AsciicastHeader, []AsciicastEvent, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxAsciicastLineSize)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return nil, nil, scanner.Err()
+		}
+		return nil, nil, fmt.Errorf("empty cast file")
+	}
+	var header AsciicastHeader
+	err := json.Unmarshal(scanner.Bytes(), &header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cast header: %v", err)
+	}
+	if header.Version != AsciicastVersion {
+		return nil, nil, fmt.Errorf("unsupported asciicast version %d (only v%d is supported)", header.Version, AsciicastVersion)
+	}
+	var events []AsciicastEvent
+	lineNum := 1
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var event AsciicastEvent
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		events = append(events, event)
+	}
+	if scanner.Err() != nil {
+		return nil, nil, scanner.Err()
+	}
+	return &header, events, nil
+}
+
+func AsciicastOutputSize(events []AsciicastEvent) int64 {
+	var rtn int64
+	for _, event := range events {
+		if event.Code == AsciicastEventOutput {
+			rtn += int64(len(event.Data))
+		}
+	}
+	return rtn
+}
+
+// writes the output events into an (already created, empty) ptyout file, along with their timing.
+// startTs (ms) is the wall-clock time of event time 0.
+func WriteAsciicastPtyOut(ctx context.Context, screenId string, lineId string, startTs int64, events []AsciicastEvent) error {
+	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	f, err := cirfile.OpenCirFile(ptyOutFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var data []byte
+	var timing []PtyTimingEntry
+	for _, event := range events {
+		if event.Code != AsciicastEventOutput || event.Data == "" {
+			continue
+		}
+		ts := startTs + int64(event.Time*1000)
+		timing = append(timing, PtyTimingEntry{Ts: ts, Pos: int64(len(data)), Len: int64(len(event.Data))})
+		data = append(data, event.Data...)
+	}
+	err = f.AppendData(ctx, data)
+	if err != nil {
+		return err
+	}
+	err = appendPtyTiming(screenId, lineId, timing)
+	if err != nil {
+		return err
+	}
+	indexCmdOutput(ctx, screenId, lineId, data)
+	return FlushCmdOutputIndex(ctx, screenId, lineId)
+}
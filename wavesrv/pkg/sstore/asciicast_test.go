@@ -0,0 +1,81 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// "ö" is 0xc3 0xb6, the last two writes split it
+var testPtyData = []byte("hello wörld\n\xc3\xb6")
+var testPtyTiming = []PtyTimingEntry{
+	{Ts: 1000, Pos: 0, Len: 6},
+	{Ts: 1500, Pos: 6, Len: 7},
+	{Ts: 2000, Pos: 13, Len: 1},
+	{Ts: 2100, Pos: 14, Len: 1},
+}
+
+// timing records => timing file => compact => asciicast events => cast file => events
+func testAsciicastRoundTrip(t *testing.T, name string, fileOffset int64, maxEntries int, expected []AsciicastEvent) {
+	timing := compactPtyTiming(parsePtyTiming(formatPtyTiming(testPtyTiming)), fileOffset, maxEntries)
+	events := MakeAsciicastEvents(0, fileOffset, testPtyData[fileOffset:], timing)
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("%s: got events %v, expected %v", name, events, expected)
+		return
+	}
+	var buf bytes.Buffer
+	err := WriteAsciicast(&buf, &AsciicastHeader{Width: 80, Height: 24}, events)
+	if err != nil {
+		t.Errorf("%s: error writing cast: %v", name, err)
+		return
+	}
+	header, readEvents, err := ReadAsciicast(&buf)
+	if err != nil {
+		t.Errorf("%s: error reading cast: %v", name, err)
+		return
+	}
+	if header.Width != 80 || header.Height != 24 || !reflect.DeepEqual(readEvents, expected) {
+		t.Errorf("%s: cast round trip got %v %v", name, header, readEvents)
+	}
+	if AsciicastOutputSize(readEvents) != int64(len(testPtyData))-fileOffset {
+		t.Errorf("%s: output size %d, expected %d", name, AsciicastOutputSize(readEvents), int64(len(testPtyData))-fileOffset)
+	}
+}
+
+func TestAsciicastRoundTrip(t *testing.T) {
+	testAsciicastRoundTrip(t, "full", 0, PtyTimingMaxEntries, []AsciicastEvent{
+		{Time: 1, Code: AsciicastEventOutput, Data: "hello "},
+		{Time: 1.5, Code: AsciicastEventOutput, Data: "wörld\n"},
+		{Time: 2.1, Code: AsciicastEventOutput, Data: "ö"},
+	})
+	// the first write was overwritten in the cirfile
+	testAsciicastRoundTrip(t, "truncated", 6, PtyTimingMaxEntries, []AsciicastEvent{
+		{Time: 1.5, Code: AsciicastEventOutput, Data: "wörld\n"},
+		{Time: 2.1, Code: AsciicastEventOutput, Data: "ö"},
+	})
+	// merges with a 100ms, 200ms (split "ö" is joined), 400ms, then 800ms window
+	testAsciicastRoundTrip(t, "merged", 0, 2, []AsciicastEvent{
+		{Time: 1, Code: AsciicastEventOutput, Data: "hello wörld\n"},
+		{Time: 2, Code: AsciicastEventOutput, Data: "ö"},
+	})
+}
+
+func TestMakeAsciicastEventsNoTiming(t *testing.T) {
+	events := MakeAsciicastEvents(0, 0, testPtyData, nil)
+	expected := []AsciicastEvent{{Time: 0, Code: AsciicastEventOutput, Data: string(testPtyData)}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %v, expected %v", events, expected)
+	}
+	// output past the last timing entry gets the last time
+	events = MakeAsciicastEvents(0, 0, testPtyData, testPtyTiming[0:1])
+	expected = []AsciicastEvent{
+		{Time: 1, Code: AsciicastEventOutput, Data: "hello "},
+		{Time: 1, Code: AsciicastEventOutput, Data: "wörld\nö"},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %v, expected %v", events, expected)
+	}
+}
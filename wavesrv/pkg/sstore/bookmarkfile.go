@@ -0,0 +1,317 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const BookmarkFileVersion = 1
+const MaxBookmarkFileSize = 10 * 1024 * 1024
+
+// library bookmark ids are derived from the library name and cmdstr so they are stable across reloads
+var bookmarkLibraryNamespace = uuid.MustParse("6e6087cd-2f35-42f9-aa40-8c87ce18e1f0")
+
+// file format for /bookmark:export, /bookmark:import and bookmark libraries (JSON or YAML, with the same fields).
+// bookmarks are stored in order.
+type BookmarkFileType struct {
+	Version   int                  `json:"version" yaml:"version"`
+	ExportTs  int64                `json:"exportts,omitempty" yaml:"exportts,omitempty"`
+	Bookmarks []*BookmarkFileEntry `json:"bookmarks" yaml:"bookmarks"`
+}
+
+type BookmarkFileEntry struct {
+	CmdStr      string   `json:"cmdstr" yaml:"cmdstr"`
+	Alias       string   `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// bookmarks from a library file are merged (read-only) into GetBookmarks under the library name as a tag
+type BookmarkLibraryType struct {
+	Name      string `json:"name" db:"name"`
+	Path      string `json:"path" db:"path"`
+	CreatedTs int64  `json:"createdts" db:"createdts"`
+}
+
+type bookmarkLibCacheEntry struct {
+	ModTime time.Time
+	Size    int64
+	BmFile  *BookmarkFileType
+}
+
+var bookmarkLibCacheLock = &sync.Mutex{}
+var bookmarkLibCache = make(map[string]*bookmarkLibCacheEntry) // key is path
+
+// .yaml and .yml files are written as YAML, everything else as JSON
+func IsYamlBookmarkFile(fullPath string) bool {
+	ext := strings.ToLower(filepath.Ext(fullPath))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func MarshalBookmarkFile(bmFile *BookmarkFileType, isYaml bool) ([]byte, error) {
+	if !isYaml {
+		barr, err := json.MarshalIndent(bmFile, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(barr, '\n'), nil
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(bmFile)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// the format is detected from the contents (a JSON object, otherwise YAML), not the file extension
+func ParseBookmarkFile(data []byte) (*BookmarkFileType, error) {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+	var bmFile BookmarkFileType
+	trimmed := strings.TrimLeft(string(data), " \t\r\n")
+	if strings.HasPrefix(trimmed, "{") {
+		err := json.Unmarshal(data, &bmFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark file: %v", err)
+		}
+	} else {
+		err := yaml.Unmarshal(data, &bmFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark file: %v", err)
+		}
+	}
+	if bmFile.Version <= 0 || bmFile.Version > BookmarkFileVersion {
+		return nil, fmt.Errorf("invalid bookmark file: unsupported version %d", bmFile.Version)
+	}
+	var entries []*BookmarkFileEntry
+	for _, entry := range bmFile.Bookmarks {
+		if entry == nil || entry.CmdStr == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	bmFile.Bookmarks = entries
+	return &bmFile, nil
+}
+
+func ReadBookmarkFile(fullPath string) (*BookmarkFileType, error) {
+	finfo, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if finfo.Size() > MaxBookmarkFileSize {
+		return nil, fmt.Errorf("bookmark file too large (%d bytes, max %d)", finfo.Size(), MaxBookmarkFileSize)
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBookmarkFile(data)
+}
+
+// bookmarks with the given tag ("" for all) in order.  library bookmarks are not included.
+func ExportBookmarks(ctx context.Context, tag string) (*BookmarkFileType, error) {
+	bms, err := getDBBookmarks(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(bms, func(i, j int) bool {
+		return bms[i].OrderIdx < bms[j].OrderIdx
+	})
+	rtn := &BookmarkFileType{Version: BookmarkFileVersion, ExportTs: time.Now().UnixMilli()}
+	for _, bm := range bms {
+		rtn.Bookmarks = append(rtn.Bookmarks, &BookmarkFileEntry{
+			CmdStr:      bm.CmdStr,
+			Alias:       bm.Alias,
+			Description: bm.Description,
+			Tags:        bm.Tags,
+		})
+	}
+	return rtn, nil
+}
+
+// inserts the bookmarks (in file order, after existing bookmarks).  bookmarks with a cmdstr that is
+// already bookmarked are skipped.  if extraTag is set, it is added to every imported bookmark.
+// returns the number of bookmarks imported.
+func ImportBookmarks(ctx context.Context, bmFile *BookmarkFileType, extraTag string) (int, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (int, error) {
+		numImported := 0
+		for _, entry := range bmFile.Bookmarks {
+			existingIds, err := GetBookmarkIdsByCmdStr(tx.Context(), entry.CmdStr)
+			if err != nil {
+				return 0, err
+			}
+			if len(existingIds) > 0 {
+				continue
+			}
+			tags := make([]string, 0, len(entry.Tags)+1)
+			for _, tag := range entry.Tags {
+				if tag != "" && tag != extraTag {
+					tags = append(tags, tag)
+				}
+			}
+			if extraTag != "" {
+				tags = append(tags, extraTag)
+			}
+			bm := &BookmarkType{
+				BookmarkId:  uuid.New().String(),
+				CreatedTs:   time.Now().UnixMilli(),
+				CmdStr:      entry.CmdStr,
+				Alias:       entry.Alias,
+				Tags:        tags,
+				Description: entry.Description,
+			}
+			err = InsertBookmark(tx.Context(), bm)
+			if err != nil {
+				return 0, err
+			}
+			numImported++
+		}
+		fixupBookmarkOrder(tx)
+		return numImported, nil
+	})
+}
+
+func GetBookmarkLibraries(ctx context.Context) ([]*BookmarkLibraryType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*BookmarkLibraryType, error) {
+		var rtn []*BookmarkLibraryType
+		query := `SELECT * FROM bookmark_library ORDER BY createdts`
+		tx.Select(&rtn, query)
+		return rtn, nil
+	})
+}
+
+// adds a new library or changes the path of an existing one
+func SetBookmarkLibrary(ctx context.Context, name string, path string) error {
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT name FROM bookmark_library WHERE name = ?`
+		if tx.Exists(query, name) {
+			query = `UPDATE bookmark_library SET path = ? WHERE name = ?`
+			tx.Exec(query, path, name)
+			return nil
+		}
+		query = `INSERT INTO bookmark_library (name, path, createdts) VALUES (?, ?, ?)`
+		tx.Exec(query, name, path, time.Now().UnixMilli())
+		return nil
+	})
+	return txErr
+}
+
+func RemoveBookmarkLibrary(ctx context.Context, name string) error {
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT name FROM bookmark_library WHERE name = ?`
+		if !tx.Exists(query, name) {
+			return fmt.Errorf("bookmark library %q not found", name)
+		}
+		query = `DELETE FROM bookmark_library WHERE name = ?`
+		tx.Exec(query, name)
+		return nil
+	})
+	return txErr
+}
+
+// library files are only re-read when their modtime or size changes
+func readBookmarkLibraryFile(fullPath string) (*BookmarkFileType, error) {
+	finfo, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	bookmarkLibCacheLock.Lock()
+	cacheEntry := bookmarkLibCache[fullPath]
+	bookmarkLibCacheLock.Unlock()
+	if cacheEntry != nil && cacheEntry.ModTime.Equal(finfo.ModTime()) && cacheEntry.Size == finfo.Size() {
+		return cacheEntry.BmFile, nil
+	}
+	bmFile, err := ReadBookmarkFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	bookmarkLibCacheLock.Lock()
+	bookmarkLibCache[fullPath] = &bookmarkLibCacheEntry{ModTime: finfo.ModTime(), Size: finfo.Size(), BmFile: bmFile}
+	bookmarkLibCacheLock.Unlock()
+	return bmFile, nil
+}
+
+func ReadBookmarkLibrary(lib *BookmarkLibraryType) ([]*BookmarkType, error) {
+	bmFile, err := readBookmarkLibraryFile(lib.Path)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*BookmarkType
+	seen := make(map[string]bool)
+	for _, entry := range bmFile.Bookmarks {
+		if seen[entry.CmdStr] {
+			continue
+		}
+		seen[entry.CmdStr] = true
+		tags := []string{lib.Name}
+		for _, tag := range entry.Tags {
+			if tag != "" && tag != lib.Name {
+				tags = append(tags, tag)
+			}
+		}
+		rtn = append(rtn, &BookmarkType{
+			BookmarkId:  uuid.NewSHA1(bookmarkLibraryNamespace, []byte(lib.Name+"\x00"+entry.CmdStr)).String(),
+			CreatedTs:   lib.CreatedTs,
+			CmdStr:      entry.CmdStr,
+			Alias:       entry.Alias,
+			Tags:        tags,
+			Description: entry.Description,
+			Library:     lib.Name,
+		})
+	}
+	return rtn, nil
+}
+
+// libraries that cannot be read are skipped (and logged)
+func GetLibraryBookmarks(ctx context.Context) ([]*BookmarkType, error) {
+	libs, err := GetBookmarkLibraries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*BookmarkType
+	for _, lib := range libs {
+		bms, err := ReadBookmarkLibrary(lib)
+		if err != nil {
+			log.Printf("error reading bookmark library %q (%s): %v\n", lib.Name, lib.Path, err)
+			continue
+		}
+		rtn = append(rtn, bms...)
+	}
+	return rtn, nil
+}
+
+// bookmarkArg is a full bookmark id or an 8 character prefix (like GetBookmarkIdByArg)
+func GetLibraryBookmarkByArg(ctx context.Context, bookmarkArg string) (*BookmarkType, error) {
+	bms, err := GetLibraryBookmarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, bm := range bms {
+		if bm.BookmarkId == bookmarkArg || (len(bookmarkArg) == 8 && bm.BookmarkId[0:8] == bookmarkArg) {
+			return bm, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var bookmarkTestFile = &BookmarkFileType{
+	Version:  BookmarkFileVersion,
+	ExportTs: 1700000000000,
+	Bookmarks: []*BookmarkFileEntry{
+		{CmdStr: "git status", Alias: "gs", Tags: []string{"git"}},
+		{CmdStr: "kubectl get pods -n {{namespace}}", Description: "list pods: all of them", Tags: []string{"k8s", "ops"}},
+		{CmdStr: "echo 'multi\nline'"},
+	},
+}
+
+func TestParseBookmarkFileYaml(t *testing.T) {
+	data := `# team bookmarks
+version: 1
+bookmarks:
+  - cmdstr: git status
+    alias: gs
+    tags: [git]
+  - cmdstr: "kubectl get pods -n {{namespace}}"
+    description: "list pods: all of them"
+    tags:
+      - k8s
+      - ops
+  - cmdstr: |-
+      echo 'multi
+      line'
+  - alias: nocmd
+`
+	bmFile, err := ParseBookmarkFile([]byte(data))
+	if err != nil {
+		t.Fatalf("cannot parse yaml bookmark file: %v", err)
+	}
+	// entries without a cmdstr are skipped
+	expected := &BookmarkFileType{Version: 1, Bookmarks: bookmarkTestFile.Bookmarks}
+	if !reflect.DeepEqual(bmFile, expected) {
+		t.Errorf("got %+v, expected %+v", bmFile, expected)
+	}
+
+	invalid := map[string]string{
+		"no version":  "bookmarks:\n  - cmdstr: ls\n",
+		"new version": "version: 99\nbookmarks: []\n",
+		"bad yaml":    "version: 1\nbookmarks: [\n",
+		"not a map":   "- cmdstr: ls\n",
+	}
+	for name, data := range invalid {
+		_, err = ParseBookmarkFile([]byte(data))
+		if err == nil {
+			t.Errorf("%s: parse should fail", name)
+		}
+	}
+}
+
+func TestParseBookmarkFileJson(t *testing.T) {
+	data := "\uFEFF" + `{"version": 1, "bookmarks": [{"cmdstr": "ls", "tags": ["a"]}, {"cmdstr": ""}]}`
+	bmFile, err := ParseBookmarkFile([]byte(data))
+	if err != nil {
+		t.Fatalf("cannot parse json bookmark file: %v", err)
+	}
+	expected := &BookmarkFileType{Version: 1, Bookmarks: []*BookmarkFileEntry{{CmdStr: "ls", Tags: []string{"a"}}}}
+	if !reflect.DeepEqual(bmFile, expected) {
+		t.Errorf("got %+v, expected %+v", bmFile, expected)
+	}
+	_, err = ParseBookmarkFile([]byte(`{"version": 1, "bookmarks": [`))
+	if err == nil {
+		t.Errorf("truncated json should fail")
+	}
+}
+
+func TestBookmarkFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"bookmarks.json", "bookmarks.yaml", "bookmarks.YML"} {
+		fullPath := filepath.Join(dir, name)
+		isYaml := IsYamlBookmarkFile(fullPath)
+		if isYaml != !strings.HasSuffix(name, ".json") {
+			t.Errorf("%s: IsYamlBookmarkFile = %v", name, isYaml)
+		}
+		barr, err := MarshalBookmarkFile(bookmarkTestFile, isYaml)
+		if err != nil {
+			t.Fatalf("%s: cannot marshal: %v", name, err)
+		}
+		if isYaml && !strings.HasPrefix(string(barr), "version: 1\n") {
+			t.Errorf("%s: not written as yaml: %q", name, barr)
+		}
+		err = os.WriteFile(fullPath, barr, 0644)
+		if err != nil {
+			t.Fatalf("%s: cannot write: %v", name, err)
+		}
+		bmFile, err := ReadBookmarkFile(fullPath)
+		if err != nil {
+			t.Fatalf("%s: cannot read: %v", name, err)
+		}
+		if !reflect.DeepEqual(bmFile, bookmarkTestFile) {
+			t.Errorf("%s: round trip got %+v, expected %+v", name, bmFile, bookmarkTestFile)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// periodic backups are written to [wavehome]/backups/waveterm-[ts].db with sqlite's online backup api
+// (so the db stays usable while the backup runs).  every backup is checked (quick_check) before it
+// replaces an older one, so the kept backups are known to be good.  the backup made before a migration
+// (GetDBBackupName) is separate and not rotated.
+const DBBackupDirName = "backups"
+const DBBackupFilePrefix = "waveterm-"
+const DBBackupFileSuffix = ".db"
+const DBBackupTsFormat = "20060102-150405"
+const DBSHMFileName = "waveterm.db-shm"
+const DBRestoreFileName = "waveterm.db.restore"
+const DefaultDBBackupHours = 24
+const DefaultDBBackupKeep = 5
+const DBBackupStepPages = 1024
+
+const DBCheckOk = "ok"
+
+type DBBackupInfo struct {
+	Name string `json:"name"`
+	Ts   int64  `json:"ts"`
+	Size int64  `json:"size"`
+}
+
+// a negative DBBackupHours disables the periodic backup, 0 is the default
+func (opts ClientOptsType) GetDBBackupInterval() time.Duration {
+	if opts.DBBackupHours < 0 {
+		return 0
+	}
+	if opts.DBBackupHours == 0 {
+		return DefaultDBBackupHours * time.Hour
+	}
+	return time.Duration(opts.DBBackupHours) * time.Hour
+}
+
+func (opts ClientOptsType) GetDBBackupKeep() int {
+	if opts.DBBackupKeep <= 0 {
+		return DefaultDBBackupKeep
+	}
+	return opts.DBBackupKeep
+}
+
+func GetDBBackupDir() string {
+	return path.Join(scbase.GetWaveHomeDir(), DBBackupDirName)
+}
+
+func GetDBSHMName() string {
+	return path.Join(scbase.GetWaveHomeDir(), DBSHMFileName)
+}
+
+// a backup staged by RestoreDBBackup, swapped in by ApplyStagedDBRestore on the next startup
+func GetDBRestoreName() string {
+	return path.Join(scbase.GetWaveHomeDir(), DBRestoreFileName)
+}
+
+func getDBBackupPath(name string) (string, error) {
+	if name == "" || strings.Contains(name, "/") || !strings.HasPrefix(name, DBBackupFilePrefix) || !strings.HasSuffix(name, DBBackupFileSuffix) {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+	return path.Join(GetDBBackupDir(), name), nil
+}
+
+// returns the db backups, newest first
+func ListDBBackups() ([]*DBBackupInfo, error) {
+	entries, err := os.ReadDir(GetDBBackupDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*DBBackupInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, DBBackupFilePrefix) || !strings.HasSuffix(name, DBBackupFileSuffix) {
+			continue
+		}
+		tsStr := strings.TrimSuffix(strings.TrimPrefix(name, DBBackupFilePrefix), DBBackupFileSuffix)
+		ts, err := time.ParseInLocation(DBBackupTsFormat, tsStr, time.Local)
+		if err != nil {
+			continue
+		}
+		finfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		rtn = append(rtn, &DBBackupInfo{Name: name, Ts: ts.UnixMilli(), Size: finfo.Size()})
+	}
+	sort.Slice(rtn, func(i int, j int) bool { return rtn[i].Ts > rtn[j].Ts })
+	return rtn, nil
+}
+
+// calls fn with the underlying sqlite connection of conn
+func withSQLiteConn(conn *sql.Conn, fn func(*sqlite3.SQLiteConn) error) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("invalid db connection type %T", driverConn)
+		}
+		return fn(sqliteConn)
+	})
+}
+
+// copies the main db to dstFileName (must not exist) with the online backup api
+func backupDBToFile(ctx context.Context, dstFileName string) error {
+	db, err := GetDB(ctx)
+	if err != nil {
+		return err
+	}
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=rwc", dstFileName))
+	if err != nil {
+		return err
+	}
+	defer dstDB.Close()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	return withSQLiteConn(dstConn, func(dstSqlite *sqlite3.SQLiteConn) error {
+		return withSQLiteConn(srcConn, func(srcSqlite *sqlite3.SQLiteConn) error {
+			backup, err := dstSqlite.Backup("main", srcSqlite, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := backup.Step(DBBackupStepPages)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					break
+				}
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// runs integrity_check (or quick_check) on db, returns the problems found (nil if the db is ok)
+func checkDBIntegrity(ctx context.Context, db *sql.DB, quick bool) ([]string, error) {
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	rows, err := db.QueryContext(ctx, pragma)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rtn []string
+	for rows.Next() {
+		var result string
+		err = rows.Scan(&result)
+		if err != nil {
+			return nil, err
+		}
+		if result != DBCheckOk {
+			rtn = append(rtn, result)
+		}
+	}
+	return rtn, rows.Err()
+}
+
+func checkDBFileIntegrity(ctx context.Context, fileName string, quick bool) ([]string, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return checkDBIntegrity(ctx, db, quick)
+}
+
+// checks the main db, returns the problems found (nil if the db is ok)
+func CheckDBIntegrity(ctx context.Context, quick bool) ([]string, error) {
+	db, err := GetDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return checkDBIntegrity(ctx, db.DB, quick)
+}
+
+// backs up the db and removes the oldest backups so at most keep remain.  the backup is checked
+// before anything is removed.
+func BackupDB(ctx context.Context, keep int) (*DBBackupInfo, error) {
+	err := os.MkdirAll(GetDBBackupDir(), 0700)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	name := DBBackupFilePrefix + now.Format(DBBackupTsFormat) + DBBackupFileSuffix
+	fileName := path.Join(GetDBBackupDir(), name)
+	tmpFileName := fileName + ".tmp"
+	os.Remove(tmpFileName)
+	err = backupDBToFile(ctx, tmpFileName)
+	if err != nil {
+		os.Remove(tmpFileName)
+		return nil, fmt.Errorf("error writing backup: %v", err)
+	}
+	problems, err := checkDBFileIntegrity(ctx, tmpFileName, true)
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("%s", problems[0])
+	}
+	if err != nil {
+		os.Remove(tmpFileName)
+		return nil, fmt.Errorf("backup failed integrity check: %v", err)
+	}
+	err = os.Rename(tmpFileName, fileName)
+	if err != nil {
+		return nil, err
+	}
+	finfo, err := os.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := ListDBBackups()
+	if err != nil {
+		return nil, err
+	}
+	for idx, backup := range backups {
+		if idx < keep {
+			continue
+		}
+		err = os.Remove(path.Join(GetDBBackupDir(), backup.Name))
+		if err != nil {
+			log.Printf("[db] error removing old backup %s: %v\n", backup.Name, err)
+		}
+	}
+	return &DBBackupInfo{Name: name, Ts: now.UnixMilli(), Size: finfo.Size()}, nil
+}
+
+// returns the size of the db (including the wal file)
+func GetDBDiskSize() int64 {
+	var rtn int64
+	for _, fileName := range []string{GetDBName(), GetDBWALName()} {
+		if finfo, err := os.Stat(fileName); err == nil {
+			rtn += finfo.Size()
+		}
+	}
+	return rtn
+}
+
+// vacuums the db and truncates the wal file.  returns the db size before and after.
+func VacuumDB(ctx context.Context) (int64, int64, error) {
+	sizeBefore := GetDBDiskSize()
+	db, err := GetDB(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = db.ExecContext(ctx, "VACUUM")
+	if err != nil {
+		return 0, 0, fmt.Errorf("vacuum: %v", err)
+	}
+	_, err = db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("wal checkpoint: %v", err)
+	}
+	return sizeBefore, GetDBDiskSize(), nil
+}
+
+// stages a backup (the most recent backup that passes an integrity check if name is blank) to replace the
+// db on the next startup.  the running server keeps using the current db.  returns the name of the backup.
+func RestoreDBBackup(ctx context.Context, name string) (string, error) {
+	var candidates []string
+	if name != "" {
+		candidates = []string{name}
+	} else {
+		backups, err := ListDBBackups()
+		if err != nil {
+			return "", err
+		}
+		for _, backup := range backups {
+			candidates = append(candidates, backup.Name)
+		}
+		if len(candidates) == 0 {
+			return "", fmt.Errorf("no backups found in %s", GetDBBackupDir())
+		}
+	}
+	var restoreName string
+	for _, candidate := range candidates {
+		backupFileName, err := getDBBackupPath(candidate)
+		if err != nil {
+			return "", err
+		}
+		problems, err := checkDBFileIntegrity(ctx, backupFileName, false)
+		if err == nil && len(problems) == 0 {
+			restoreName = candidate
+			break
+		}
+		if name != "" {
+			if err == nil {
+				err = fmt.Errorf("%s", problems[0])
+			}
+			return "", fmt.Errorf("backup %s failed integrity check: %v", name, err)
+		}
+		log.Printf("[db] skipping backup %s, failed integrity check\n", candidate)
+	}
+	if restoreName == "" {
+		return "", fmt.Errorf("no backup passed the integrity check")
+	}
+	backupFileName, _ := getDBBackupPath(restoreName)
+	tmpFileName := GetDBRestoreName() + ".tmp"
+	err := copyFile(backupFileName, tmpFileName, false)
+	if err != nil {
+		os.Remove(tmpFileName)
+		return "", fmt.Errorf("cannot stage backup: %v", err)
+	}
+	err = os.Rename(tmpFileName, GetDBRestoreName())
+	if err != nil {
+		os.Remove(tmpFileName)
+		return "", fmt.Errorf("cannot stage backup: %v", err)
+	}
+	log.Printf("[db] staged restore of %s, will be applied on the next startup\n", restoreName)
+	return restoreName, nil
+}
+
+// swaps in the db staged by RestoreDBBackup.  must be called at startup before the db is opened (the
+// restored db is migrated to the current version by TryMigrateUp).  the current db is kept as
+// waveterm.db.pre-restore-[ts].  returns false if no restore was staged.
+func ApplyStagedDBRestore() (bool, error) {
+	exists, err := fileExists(GetDBRestoreName())
+	if err != nil || !exists {
+		return false, err
+	}
+	saveSuffix := ".pre-restore-" + time.Now().Format(DBBackupTsFormat)
+	err = os.Rename(GetDBName(), GetDBName()+saveSuffix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("cannot move current db: %v", err)
+	}
+	err = os.Rename(GetDBWALName(), GetDBWALName()+saveSuffix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.Rename(GetDBName()+saveSuffix, GetDBName())
+		return false, fmt.Errorf("cannot move current db wal: %v", err)
+	}
+	os.Remove(GetDBSHMName())
+	err = os.Rename(GetDBRestoreName(), GetDBName())
+	if err != nil {
+		os.Rename(GetDBName()+saveSuffix, GetDBName())
+		os.Rename(GetDBWALName()+saveSuffix, GetDBWALName())
+		return false, fmt.Errorf("cannot move staged db: %v", err)
+	}
+	log.Printf("[db] restored staged db backup (previous db saved with suffix %s)\n", saveSuffix)
+	return true, nil
+}
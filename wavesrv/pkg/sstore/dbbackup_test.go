@@ -0,0 +1,57 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func countTestScreens(t *testing.T) int {
+	numScreens, err := WithTxRtn(context.Background(), func(tx *TxWrap) (int, error) {
+		return tx.GetInt(`SELECT count(*) FROM screen`), nil
+	})
+	if err != nil {
+		t.Fatalf("cannot count screens: %v", err)
+	}
+	return numScreens
+}
+
+func TestRestoreDBBackup(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	backup, err := BackupDB(ctx, DefaultDBBackupKeep)
+	if err != nil {
+		t.Fatalf("cannot back up db: %v", err)
+	}
+	numScreens := countTestScreens(t)
+	makeRetentionTestScreen(t, getTestSessionId(t), "after-backup", time.Now(), nil)
+
+	restoredName, err := RestoreDBBackup(ctx, "")
+	if err != nil || restoredName != backup.Name {
+		t.Fatalf("RestoreDBBackup got %q err %v, expected %q", restoredName, err, backup.Name)
+	}
+	// the restore is only staged, the running db is not touched
+	if countTestScreens(t) != numScreens+1 {
+		t.Fatalf("RestoreDBBackup should not change the open db")
+	}
+
+	CloseDB()
+	applied, err := ApplyStagedDBRestore()
+	if err != nil || !applied {
+		t.Fatalf("ApplyStagedDBRestore got %v err %v", applied, err)
+	}
+	err = TryMigrateUp()
+	if err != nil {
+		t.Fatalf("cannot migrate restored db: %v", err)
+	}
+	if countTestScreens(t) != numScreens {
+		t.Fatalf("restored db should not have the screen added after the backup")
+	}
+	applied, err = ApplyStagedDBRestore()
+	if err != nil || applied {
+		t.Fatalf("ApplyStagedDBRestore should do nothing without a staged restore, got %v err %v", applied, err)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/utilfn"
 )
 
 const HistoryCols = "h.historyid, h.ts, h.userid, h.sessionid, h.screenid, h.lineid, h.haderror, h.cmdstr, h.remoteownerid, h.remoteid, h.remotename, h.ismetacmd, h.incognito, h.linenum"
@@ -228,6 +229,71 @@ func InsertHistoryItem(ctx context.Context, hitem *HistoryItemType) error {
 	return txErr
 }
 
+// commands run in wave are also written to the shell's history file, the file's timestamp is within this
+// window of the wave history item's ts (history files only store seconds, and are written by the shell).
+const ImportNativeMatchWindowMs = 60 * 1000
+
+// imported history items are not attached to a session/screen/line.  an item is skipped if an imported item
+// with the same remote, cmdstr, and ts already exists, or if a wave history item (run in a session) with the
+// same remote and cmdstr is within ImportNativeMatchWindowMs of it.  if matchTs is false, only remote and
+// cmdstr are compared (for both imported and wave items).  returns the number of items inserted.
+func ImportHistoryItems(ctx context.Context, remoteId string, items []*HistoryItemType, matchTs bool) (int, error) {
+	var numInserted int
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		numInserted = 0
+		type importKey struct {
+			CmdStr string `db:"cmdstr"`
+			Ts     int64  `db:"ts"`
+		}
+		var existingKeys []importKey
+		query := `SELECT cmdstr, ts FROM history WHERE sessionid = '' AND remoteid = ?`
+		tx.Select(&existingKeys, query, remoteId)
+		existing := make(map[importKey]bool)
+		for _, key := range existingKeys {
+			if !matchTs {
+				key.Ts = 0
+			}
+			existing[key] = true
+		}
+		var nativeKeys []importKey
+		query = `SELECT cmdstr, ts FROM history WHERE sessionid <> '' AND remoteid = ?`
+		tx.Select(&nativeKeys, query, remoteId)
+		nativeTs := make(map[string][]int64)
+		for _, key := range nativeKeys {
+			nativeTs[key.CmdStr] = append(nativeTs[key.CmdStr], key.Ts)
+		}
+		for _, hitem := range items {
+			key := importKey{CmdStr: hitem.CmdStr}
+			if matchTs {
+				key.Ts = hitem.Ts
+			}
+			if existing[key] || matchesNativeHistory(nativeTs[hitem.CmdStr], hitem.Ts, matchTs) {
+				continue
+			}
+			existing[key] = true
+			query = `INSERT INTO history
+                  ( historyid, ts, userid, sessionid, screenid, lineid, haderror, cmdstr, remoteownerid, remoteid, remotename, ismetacmd, incognito, linenum) VALUES
+                  (:historyid,:ts,:userid,:sessionid,:screenid,:lineid,:haderror,:cmdstr,:remoteownerid,:remoteid,:remotename,:ismetacmd,:incognito,:linenum)`
+			tx.NamedExec(query, hitem.ToMap())
+			numInserted++
+		}
+		return nil
+	})
+	return numInserted, txErr
+}
+
+func matchesNativeHistory(nativeTs []int64, ts int64, matchTs bool) bool {
+	if !matchTs {
+		return len(nativeTs) > 0
+	}
+	for _, nts := range nativeTs {
+		if nts >= ts-ImportNativeMatchWindowMs && nts <= ts+ImportNativeMatchWindowMs {
+			return true
+		}
+	}
+	return false
+}
+
 func IsIncognitoScreen(ctx context.Context, sessionId string, screenId string) (bool, error) {
 	return false, nil
 }
@@ -339,16 +405,50 @@ func runHistoryQuery(tx *TxWrap, opts HistoryQueryOpts, realOffset int, itemLimi
 		likeArg = strings.ReplaceAll(likeArg, "_", "\\_")
 		queryArgs = append(queryArgs, "%"+likeArg+"%")
 	}
-	if opts.FromTs > 0 {
-		whereClause += fmt.Sprintf(" AND h.ts <= %d", opts.FromTs)
+	if opts.MaxTs > 0 {
+		whereClause += fmt.Sprintf(" AND h.ts <= %d", opts.MaxTs)
 	}
 	if opts.RemoteId != "" {
 		whereClause += fmt.Sprintf(" AND h.remoteid = '%s'", opts.RemoteId)
 	}
+	if opts.MinTs > 0 {
+		whereClause += fmt.Sprintf(" AND h.ts >= %d", opts.MinTs)
+	}
 	if opts.NoMeta {
 		whereClause += " AND NOT h.ismetacmd"
 	}
-	query := fmt.Sprintf("SELECT %s, ('%s' || CAST((row_number() OVER win) as text)) historynum FROM history h %s WINDOW win AS (ORDER BY h.ts, h.historyid) ORDER BY h.ts DESC, h.historyid DESC LIMIT %d OFFSET %d", HistoryCols, hNumStr, whereClause, itemLimit, realOffset)
+	if opts.OnlyMeta {
+		whereClause += " AND h.ismetacmd"
+	}
+	joinClause := ""
+	if opts.needsCmdJoin() {
+		joinClause = "JOIN cmd c ON c.screenid = h.screenid AND c.lineid = h.lineid"
+	}
+	switch opts.ExitStatus {
+	case "":
+	case HistoryExitSuccess:
+		whereClause += fmt.Sprintf(" AND c.status = '%s' AND c.exitcode = 0", CmdStatusDone)
+	case HistoryExitFailure:
+		whereClause += fmt.Sprintf(" AND (c.exitcode <> 0 OR c.status IN ('%s', '%s'))", CmdStatusError, CmdStatusHangup)
+	default:
+		return nil, fmt.Errorf("invalid exit status filter '%s'", opts.ExitStatus)
+	}
+	if opts.ExitCode != nil {
+		whereClause += fmt.Sprintf(" AND c.status = '%s' AND c.exitcode = %d", CmdStatusDone, *opts.ExitCode)
+	}
+	if opts.MinDurationMs > 0 {
+		whereClause += fmt.Sprintf(" AND c.durationms >= %d", opts.MinDurationMs)
+	}
+	if opts.CwdPrefix != "" {
+		// match the dir itself or anything under it (so /home/foo does not match /home/foobar)
+		cwdPrefix := strings.TrimSuffix(opts.CwdPrefix, "/")
+		likeArg := strings.ReplaceAll(cwdPrefix, "\\", "\\\\")
+		likeArg = strings.ReplaceAll(likeArg, "%", "\\%")
+		likeArg = strings.ReplaceAll(likeArg, "_", "\\_")
+		whereClause += " AND (json_extract(c.festate, '$.cwd') = ? OR json_extract(c.festate, '$.cwd') LIKE ? ESCAPE '\\')"
+		queryArgs = append(queryArgs, cwdPrefix, likeArg+"/%")
+	}
+	query := fmt.Sprintf("SELECT %s, ('%s' || CAST((row_number() OVER win) as text)) historynum FROM history h %s %s WINDOW win AS (ORDER BY h.ts, h.historyid) ORDER BY h.ts DESC, h.historyid DESC LIMIT %d OFFSET %d", HistoryCols, hNumStr, joinClause, whereClause, itemLimit, realOffset)
 	marr := tx.SelectMaps(query, queryArgs...)
 	rtn := make([]*HistoryItemType, len(marr))
 	for idx, m := range marr {
@@ -874,6 +974,11 @@ func UpdateCmdDoneInfo(ctx context.Context, ck base.CommandKey, donePk *packet.C
 	if rtnCmd == nil {
 		return nil, fmt.Errorf("cmd data not found for ck[%s]", ck)
 	}
+	err := FlushCmdOutputIndex(ctx, screenId, lineIdFromCK(ck))
+	if err != nil {
+		// just log
+		log.Printf("error flushing output index %s: %v\n", ck, err)
+	}
 	return &ModelUpdate{Cmd: rtnCmd}, nil
 }
 
@@ -1026,6 +1131,9 @@ func cleanScreenCmds(ctx context.Context, screenId string) error {
 		removedCmds = tx.SelectStrings(query, screenId, screenId)
 		query = `DELETE FROM cmd WHERE screenid = ? AND lineid NOT IN (SELECT lineid FROM line WHERE screenid = ?)`
 		tx.Exec(query, screenId, screenId)
+		for _, lineId := range removedCmds {
+			deleteCmdOutputIndex(tx, screenId, lineId)
+		}
 		return nil
 	})
 	if txErr != nil {
@@ -1099,9 +1207,12 @@ func UnArchiveScreen(ctx context.Context, sessionId string, screenId string) err
 	return txErr
 }
 
+// moves the screen to the trash.  when sessionDel is set the screen is part of a session purge, the
+// session's trash item holds its rows and PurgeSession moves its files.
 func PurgeScreen(ctx context.Context, screenId string, sessionDel bool) (UpdatePacket, error) {
 	var sessionId string
 	var isActive bool
+	var trashItem *TrashItemType
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `SELECT screenid FROM screen WHERE screenid = ?`
 		if !tx.Exists(query, screenId) {
@@ -1125,6 +1236,10 @@ func PurgeScreen(ctx context.Context, screenId string, sessionDel bool) (UpdateP
 				nextId := getNextId(screenIds, screenId)
 				tx.Exec(`UPDATE session SET activescreenid = ? WHERE sessionid = ?`, nextId, sessionId)
 			}
+			trashData := &TrashDataType{}
+			addScreenTrashData(tx, trashData, screenId)
+			trashItem = &TrashItemType{TrashType: TrashTypeScreen, Name: getTrashScreenName(tx, screenId), SessionId: sessionId, ScreenId: screenId}
+			insertTrashItemTx(tx, trashItem, trashData)
 		}
 		query = `DELETE FROM screen WHERE screenid = ?`
 		tx.Exec(query, screenId)
@@ -1134,6 +1249,7 @@ func PurgeScreen(ctx context.Context, screenId string, sessionDel bool) (UpdateP
 		tx.Exec(query, screenId)
 		query = `DELETE FROM cmd WHERE screenid = ?`
 		tx.Exec(query, screenId)
+		deleteCmdOutputIndex(tx, screenId, "")
 		if webSharing {
 			insertScreenDelUpdate(tx, screenId)
 		}
@@ -1142,13 +1258,13 @@ func PurgeScreen(ctx context.Context, screenId string, sessionDel bool) (UpdateP
 	if txErr != nil {
 		return nil, txErr
 	}
-	delErr := DeleteScreenDir(ctx, screenId)
-	if delErr != nil {
-		log.Printf("error removing screendir")
-	}
 	if sessionDel {
 		return nil, nil
 	}
+	moveErr := moveScreenDirToTrash(trashItem.TrashId, screenId)
+	if moveErr != nil {
+		log.Printf("error moving screendir to trash: %v\n", moveErr)
+	}
 	update := &ModelUpdate{}
 	update.Screens = []*ScreenType{&ScreenType{SessionId: sessionId, ScreenId: screenId, Remove: true}}
 	if isActive {
@@ -1487,9 +1603,11 @@ func ScreenReset(ctx context.Context, screenId string) ([]*RemoteInstance, error
 	})
 }
 
+// moves the session (and all of its screens) to the trash
 func PurgeSession(ctx context.Context, sessionId string) (UpdatePacket, error) {
 	var newActiveSessionId string
 	var screenIds []string
+	var trashItem *TrashItemType
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `SELECT sessionid FROM session WHERE sessionid = ?`
 		if !tx.Exists(query, sessionId) {
@@ -1497,6 +1615,16 @@ func PurgeSession(ctx context.Context, sessionId string) (UpdatePacket, error) {
 		}
 		query = `SELECT screenid FROM screen WHERE sessionid = ?`
 		screenIds = tx.SelectStrings(query, sessionId)
+		session, err := GetBareSessionById(tx.Context(), sessionId)
+		if err != nil {
+			return err
+		}
+		trashData := &TrashDataType{Session: session}
+		for _, screenId := range screenIds {
+			addScreenTrashData(tx, trashData, screenId)
+		}
+		trashItem = &TrashItemType{TrashType: TrashTypeSession, Name: trashData.Session.Name, SessionId: sessionId}
+		insertTrashItemTx(tx, trashItem, trashData)
 		for _, screenId := range screenIds {
 			_, err := PurgeScreen(tx.Context(), screenId, true)
 			if err != nil {
@@ -1511,6 +1639,12 @@ func PurgeSession(ctx context.Context, sessionId string) (UpdatePacket, error) {
 	if txErr != nil {
 		return nil, txErr
 	}
+	for _, screenId := range screenIds {
+		moveErr := moveScreenDirToTrash(trashItem.TrashId, screenId)
+		if moveErr != nil {
+			log.Printf("error moving screendir to trash: %v\n", moveErr)
+		}
+	}
 	update := &ModelUpdate{}
 	if newActiveSessionId != "" {
 		update.ActiveSessionId = newActiveSessionId
@@ -1974,38 +2108,66 @@ func SetLineArchivedById(ctx context.Context, screenId string, lineId string, ar
 	return txErr
 }
 
-func purgeCmdByScreenId(ctx context.Context, screenId string, lineId string) error {
-	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		query := `DELETE FROM cmd WHERE screenid = ? AND lineid = ?`
+// the cmd's pty files are moved (or deleted) after the purge is committed, see movePurgedLineFiles
+func purgeCmdTx(tx *TxWrap, screenId string, lineId string) {
+	query := `DELETE FROM cmd WHERE screenid = ? AND lineid = ?`
+	tx.Exec(query, screenId, lineId)
+	deleteCmdOutputIndex(tx, screenId, lineId)
+}
+
+// moves the line rows to a new trash item, returns the trash data and trashid ("" if none of the lines exist)
+func purgeLinesTx(tx *TxWrap, screenId string, lineIds []string) (*TrashDataType, string) {
+	isWS := isWebShare(tx, screenId)
+	trashData := &TrashDataType{}
+	for _, lineId := range lineIds {
+		addLineTrashData(tx, trashData, screenId, lineId)
+	}
+	var trashId string
+	if len(trashData.Lines) > 0 {
+		query := `SELECT sessionid FROM screen WHERE screenid = ?`
+		trashItem := &TrashItemType{TrashType: TrashTypeLine, Name: getTrashScreenName(tx, screenId), SessionId: tx.GetString(query, screenId), ScreenId: screenId}
+		insertTrashItemTx(tx, trashItem, trashData)
+		trashId = trashItem.TrashId
+	}
+	for _, lineId := range lineIds {
+		query := `DELETE FROM line WHERE screenid = ? AND lineid = ?`
 		tx.Exec(query, screenId, lineId)
-		if tx.Err != nil {
-			// short circuit here because we don't want to delete the ptyfile when the tx will be rolled back
-			return tx.Err
+		query = `DELETE FROM history WHERE screenid = ? AND lineid = ?`
+		tx.Exec(query, screenId, lineId)
+		purgeCmdTx(tx, screenId, lineId)
+		if isWS {
+			insertScreenLineUpdate(tx, screenId, lineId, UpdateType_LineDel)
 		}
-		return DeletePtyOutFile(tx.Context(), screenId, lineId)
-	})
-	return txErr
+	}
+	return trashData, trashId
 }
 
+// moves the lines to the trash (as one item).  their pty files are moved once the purge is committed
 func PurgeLinesByIds(ctx context.Context, screenId string, lineIds []string) error {
+	var trashData *TrashDataType
+	var trashId string
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		isWS := isWebShare(tx, screenId)
-		for _, lineId := range lineIds {
-			query := `DELETE FROM line WHERE screenid = ? AND lineid = ?`
-			tx.Exec(query, screenId, lineId)
-			query = `DELETE FROM history WHERE screenid = ? AND lineid = ?`
-			tx.Exec(query, screenId, lineId)
-			err := purgeCmdByScreenId(tx.Context(), screenId, lineId)
-			if err != nil {
-				return err
-			}
-			if isWS {
-				insertScreenLineUpdate(tx, screenId, lineId, UpdateType_LineDel)
-			}
-		}
+		trashData, trashId = purgeLinesTx(tx, screenId, lineIds)
 		return nil
 	})
-	return txErr
+	if txErr != nil {
+		return txErr
+	}
+	return movePurgedLineFiles(ctx, screenId, lineIds, trashId, trashData)
+}
+
+// like PurgeLinesByIds, but the pty files are deleted instead of moved to the trash (the lines can
+// still be restored, without their output)
+func PurgeLinesDeleteOutput(ctx context.Context, screenId string, lineIds []string) error {
+	var trashData *TrashDataType
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		trashData, _ = purgeLinesTx(tx, screenId, lineIds)
+		return nil
+	})
+	if txErr != nil {
+		return txErr
+	}
+	return movePurgedLineFiles(ctx, screenId, lineIds, "", trashData)
 }
 
 func GetRIsForScreen(ctx context.Context, sessionId string, screenId string) ([]*RemoteInstance, error) {
@@ -2165,7 +2327,34 @@ type bookmarkOrderType struct {
 	OrderIdx   int64
 }
 
+// includes (read-only) bookmarks from bookmark libraries, ordered after the user's own bookmarks
 func GetBookmarks(ctx context.Context, tag string) ([]*BookmarkType, error) {
+	bms, err := getDBBookmarks(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	libBms, err := GetLibraryBookmarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var maxOrderIdx int64
+	for _, bm := range bms {
+		if bm.OrderIdx > maxOrderIdx {
+			maxOrderIdx = bm.OrderIdx
+		}
+	}
+	for _, bm := range libBms {
+		if tag != "" && !utilfn.ContainsStr(bm.Tags, tag) {
+			continue
+		}
+		maxOrderIdx++
+		bm.OrderIdx = maxOrderIdx
+		bms = append(bms, bm)
+	}
+	return bms, nil
+}
+
+func getDBBookmarks(ctx context.Context, tag string) ([]*BookmarkType, error) {
 	var bms []*BookmarkType
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		var query string
@@ -2287,6 +2476,20 @@ func EditBookmark(ctx context.Context, bookmarkId string, editMap map[string]int
 	return txErr
 }
 
+// merges values into the bookmark's remembered placeholder values
+func UpdateBookmarkParamValues(ctx context.Context, bookmarkId string, values map[string]string) error {
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT bookmarkid FROM bookmark WHERE bookmarkid = ?`
+		if !tx.Exists(query, bookmarkId) {
+			return fmt.Errorf("bookmark not found")
+		}
+		query = `UPDATE bookmark SET paramvalues = json_patch(paramvalues, ?) WHERE bookmarkid = ?`
+		tx.Exec(query, quickJson(values), bookmarkId)
+		return nil
+	})
+	return txErr
+}
+
 func fixupBookmarkOrder(tx *TxWrap) {
 	query := `
 WITH new_order AS (
@@ -2316,22 +2519,44 @@ func DeleteBookmark(ctx context.Context, bookmarkId string) error {
 	return txErr
 }
 
-func CreatePlaybook(ctx context.Context, name string) (*PlaybookType, error) {
+func CreatePlaybook(ctx context.Context, name string, description string) (*PlaybookType, error) {
 	return WithTxRtn(ctx, func(tx *TxWrap) (*PlaybookType, error) {
-		query := `SELECT playbookid FROM playbook WHERE name = ?`
+		query := `SELECT playbookid FROM playbook WHERE playbookname = ?`
 		if tx.Exists(query, name) {
 			return nil, fmt.Errorf("playbook %q already exists", name)
 		}
 		rtn := &PlaybookType{}
 		rtn.PlaybookId = uuid.New().String()
 		rtn.PlaybookName = name
+		rtn.Description = description
 		query = `INSERT INTO playbook ( playbookid, playbookname, description, entryids)
                                VALUES (:playbookid,:playbookname,:description,:entryids)`
-		tx.Exec(query, rtn.ToMap())
+		tx.NamedExec(query, rtn.ToMap())
 		return rtn, nil
 	})
 }
 
+// resolves a playbook by name, id, or (8 character) id prefix.  returns "" if not found.
+func GetPlaybookIdByArg(ctx context.Context, playbookArg string) (string, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (string, error) {
+		query := `SELECT playbookid FROM playbook WHERE playbookname = ? OR playbookid = ?`
+		rtnId := tx.GetString(query, playbookArg, playbookArg)
+		if rtnId == "" && len(playbookArg) == 8 {
+			query = `SELECT playbookid FROM playbook WHERE playbookid LIKE (? || '%')`
+			rtnId = tx.GetString(query, playbookArg)
+		}
+		return rtnId, nil
+	})
+}
+
+// does not return entries (only entryids)
+func GetAllPlaybooks(ctx context.Context) ([]*PlaybookType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*PlaybookType, error) {
+		query := `SELECT * FROM playbook ORDER BY playbookname`
+		return dbutil.SelectMapsGen[*PlaybookType](tx, query), nil
+	})
+}
+
 func selectPlaybook(tx *TxWrap, playbookId string) *PlaybookType {
 	query := `SELECT * FROM playbook where playbookid = ?`
 	playbook := dbutil.GetMapGen[*PlaybookType](tx, query, playbookId)
@@ -2353,7 +2578,7 @@ func AddPlaybookEntry(ctx context.Context, entry *PlaybookEntry) error {
 		}
 		query = `INSERT INTO playbook_entry ( entryid, playbookid, description, alias, cmdstr, createdts, updatedts)
                                      VALUES (:entryid,:playbookid,:description,:alias,:cmdstr,:createdts,:updatedts)`
-		tx.Exec(query, entry)
+		tx.NamedExec(query, entry.ToMap())
 		playbook.EntryIds = append(playbook.EntryIds, entry.EntryId)
 		query = `UPDATE playbook SET entryids = ? WHERE playbookid = ?`
 		tx.Exec(query, quickJsonArr(playbook.EntryIds), entry.PlaybookId)
@@ -2367,8 +2592,8 @@ func RemovePlaybookEntry(ctx context.Context, playbookId string, entryId string)
 		if playbook == nil {
 			return fmt.Errorf("cannot remove playbook entry, playbook does not exist")
 		}
-		query := `SELECT entryid FROM playbook_entry WHERE entryid = ?`
-		if !tx.Exists(query, entryId) {
+		query := `SELECT entryid FROM playbook_entry WHERE entryid = ? AND playbookid = ?`
+		if !tx.Exists(query, entryId, playbookId) {
 			return fmt.Errorf("cannot remove playbook entry, entry does not exist")
 		}
 		query = `DELETE FROM playbook_entry WHERE entryid = ?`
@@ -2418,21 +2643,35 @@ func GetLineCmdsFromHistoryItems(ctx context.Context, historyItems []*HistoryIte
 }
 
 func PurgeHistoryByIds(ctx context.Context, historyIds []string) ([]*HistoryItemType, error) {
-	return WithTxRtn(ctx, func(tx *TxWrap) ([]*HistoryItemType, error) {
+	type purgedLine struct {
+		HItem     *HistoryItemType
+		TrashData *TrashDataType
+		TrashId   string
+	}
+	var purgedLines []purgedLine
+	rtn, txErr := WithTxRtn(ctx, func(tx *TxWrap) ([]*HistoryItemType, error) {
 		query := `SELECT * FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
 		rtn := dbutil.SelectMapsGen[*HistoryItemType](tx, query, quickJsonArr(historyIds))
 		query = `DELETE FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
 		tx.Exec(query, quickJsonArr(historyIds))
 		for _, hitem := range rtn {
 			if hitem.LineId != "" {
-				err := PurgeLinesByIds(tx.Context(), hitem.ScreenId, []string{hitem.LineId})
-				if err != nil {
-					return nil, err
-				}
+				trashData, trashId := purgeLinesTx(tx, hitem.ScreenId, []string{hitem.LineId})
+				purgedLines = append(purgedLines, purgedLine{HItem: hitem, TrashData: trashData, TrashId: trashId})
 			}
 		}
 		return rtn, nil
 	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	for _, pl := range purgedLines {
+		err := movePurgedLineFiles(ctx, pl.HItem.ScreenId, []string{pl.HItem.LineId}, pl.TrashId, pl.TrashData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rtn, nil
 }
 
 func CountScreenWebShares(ctx context.Context) (int, error) {
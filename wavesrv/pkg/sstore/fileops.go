@@ -12,6 +12,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"time"
 
 	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
@@ -54,10 +55,20 @@ func AppendToCmdPtyBlob(ctx context.Context, screenId string, lineId string, dat
 		return nil, err
 	}
 	defer f.Close()
-	err = f.WriteAt(ctx, data, pos)
+	if GetPtyOverflowBudget() > 0 {
+		err = writePtyOutWithOverflow(ctx, screenId, lineId, f, data, pos)
+	} else {
+		err = f.WriteAt(ctx, data, pos)
+	}
 	if err != nil {
 		return nil, err
 	}
+	err = recordPtyTiming(screenId, lineId, PtyTimingEntry{Ts: time.Now().UnixMilli(), Pos: pos, Len: int64(len(data))}, f.FileOffset)
+	if err != nil {
+		// just log
+		log.Printf("error writing ptytiming %s/%s: %v\n", screenId, lineId, err)
+	}
+	indexCmdOutput(ctx, screenId, lineId, data)
 	data64 := base64.StdEncoding.EncodeToString(data)
 	update := &PtyDataUpdate{
 		ScreenId:   screenId,
@@ -75,7 +86,8 @@ func AppendToCmdPtyBlob(ctx context.Context, screenId string, lineId string, dat
 }
 
 // returns (real-offset, data, err)
-func ReadFullPtyOutFile(ctx context.Context, screenId string, lineId string) (int64, []byte, error) {
+// only the cirfile data, see ReadFullPtyOutFile for all of the output (including the overflow files)
+func readPtyOutCirFile(ctx context.Context, screenId string, lineId string) (int64, []byte, error) {
 	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
 	if err != nil {
 		return 0, nil, err
@@ -170,6 +182,14 @@ func DeletePtyOutFile(ctx context.Context, screenId string, lineId string) error
 	if err != nil {
 		return err
 	}
+	err = deletePtyTimingFile(screenId, lineId)
+	if err != nil {
+		log.Printf("error removing ptytiming file %s/%s: %v\n", screenId, lineId, err)
+	}
+	err = deletePtyOverflowFiles(screenId, lineId)
+	if err != nil {
+		log.Printf("error removing ptyout overflow files %s/%s: %v\n", screenId, lineId, err)
+	}
 	err = os.Remove(ptyOutFileName)
 	if errors.Is(err, fs.ErrNotExist) {
 		return nil
@@ -183,5 +203,6 @@ func DeleteScreenDir(ctx context.Context, screenId string) error {
 		return fmt.Errorf("error getting screendir: %w", err)
 	}
 	log.Printf("remove-all %s\n", screenDir)
+	clearScreenPtyOverflowCache(screenId)
 	return os.RemoveAll(screenDir)
 }
@@ -0,0 +1,150 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const historyTestBaseTs = 1700000000000
+
+type historyTestItem struct {
+	CmdStr     string
+	TsOffset   int64 // ms after historyTestBaseTs
+	ScreenId   string
+	RemoteId   string
+	Status     string // defaults to CmdStatusDone
+	ExitCode   int
+	DurationMs int
+	Cwd        string
+	IsMetaCmd  bool
+	NoCmd      bool // history item without a cmd (like imported history)
+}
+
+// inserts history items (and their cmds) for screens in sessionId
+func addHistoryTestItems(t *testing.T, sessionId string, items []historyTestItem) {
+	err := WithTx(context.Background(), func(tx *TxWrap) error {
+		for _, item := range items {
+			lineId := uuid.New().String()
+			remotePtr := RemotePtrType{RemoteId: item.RemoteId}
+			hitem := &HistoryItemType{
+				HistoryId: uuid.New().String(),
+				Ts:        historyTestBaseTs + item.TsOffset,
+				SessionId: sessionId,
+				ScreenId:  item.ScreenId,
+				LineId:    lineId,
+				CmdStr:    item.CmdStr,
+				Remote:    remotePtr,
+				IsMetaCmd: item.IsMetaCmd,
+			}
+			insertHistoryItemTx(tx, hitem)
+			if item.NoCmd {
+				continue
+			}
+			status := item.Status
+			if status == "" {
+				status = CmdStatusDone
+			}
+			cmd := &CmdType{
+				ScreenId:   item.ScreenId,
+				LineId:     lineId,
+				Remote:     remotePtr,
+				CmdStr:     item.CmdStr,
+				FeState:    map[string]string{"cwd": item.Cwd},
+				Status:     status,
+				ExitCode:   item.ExitCode,
+				DurationMs: item.DurationMs,
+			}
+			insertCmdTx(tx, cmd)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot add history items: %v", err)
+	}
+}
+
+func getHistoryTestCmdStrs(t *testing.T, opts HistoryQueryOpts) []string {
+	opts.MaxItems = 100
+	result, err := GetHistoryItems(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("history query error: %v", err)
+	}
+	var rtn []string
+	for _, item := range result.Items {
+		rtn = append(rtn, item.CmdStr)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+func checkHistoryTestQuery(t *testing.T, name string, opts HistoryQueryOpts, expected ...string) {
+	sort.Strings(expected)
+	cmdStrs := getHistoryTestCmdStrs(t, opts)
+	if strings.Join(cmdStrs, ",") != strings.Join(expected, ",") {
+		t.Errorf("%s: got %v, expected %v", name, cmdStrs, expected)
+	}
+}
+
+func TestHistoryQueryFilters(t *testing.T) {
+	initTestDB(t)
+	sessionId := getTestSessionId(t)
+	screenId := makeRetentionTestScreen(t, sessionId, "history", time.Now(), nil)
+	addHistoryTestItems(t, sessionId, []historyTestItem{
+		{CmdStr: "ls", TsOffset: 0, ScreenId: screenId, Cwd: "/home/user"},
+		{CmdStr: "make", TsOffset: 1000, ScreenId: screenId, ExitCode: 2, DurationMs: 5000, Cwd: "/home/user/src"},
+		{CmdStr: "sleep 10", TsOffset: 2000, ScreenId: screenId, DurationMs: 10000, Cwd: "/home/username"},
+		{CmdStr: "false", TsOffset: 3000, ScreenId: screenId, ExitCode: 1, Cwd: "/tmp/100%_done"},
+		{CmdStr: "cat x", TsOffset: 4000, ScreenId: screenId, Cwd: "/tmp/100xxdone/sub"},
+		{CmdStr: "bad", TsOffset: 5000, ScreenId: screenId, Status: CmdStatusError, Cwd: `/tmp/a\b`},
+		{CmdStr: "/clear", TsOffset: 6000, ScreenId: screenId, IsMetaCmd: true, Cwd: "/home/user"},
+		{CmdStr: "imported", TsOffset: 7000, ScreenId: screenId, NoCmd: true},
+	})
+
+	all := []string{"ls", "make", "sleep 10", "false", "cat x", "bad", "/clear", "imported"}
+	checkHistoryTestQuery(t, "all", HistoryQueryOpts{}, all...)
+
+	// ts bounds are inclusive
+	checkHistoryTestQuery(t, "mints", HistoryQueryOpts{MinTs: historyTestBaseTs + 5000}, "bad", "/clear", "imported")
+	checkHistoryTestQuery(t, "maxts", HistoryQueryOpts{MaxTs: historyTestBaseTs + 1000}, "ls", "make")
+	checkHistoryTestQuery(t, "ts range", HistoryQueryOpts{MinTs: historyTestBaseTs + 1000, MaxTs: historyTestBaseTs + 3000}, "make", "sleep 10", "false")
+	checkHistoryTestQuery(t, "empty ts range", HistoryQueryOpts{MinTs: historyTestBaseTs + 3000, MaxTs: historyTestBaseTs + 1000})
+
+	// exit filters only match items with a cmd
+	checkHistoryTestQuery(t, "success", HistoryQueryOpts{ExitStatus: HistoryExitSuccess}, "ls", "sleep 10", "cat x", "/clear")
+	checkHistoryTestQuery(t, "failure", HistoryQueryOpts{ExitStatus: HistoryExitFailure}, "make", "false", "bad")
+	exitCode := 2
+	checkHistoryTestQuery(t, "exitcode 2", HistoryQueryOpts{ExitCode: &exitCode}, "make")
+	exitCode = 0
+	checkHistoryTestQuery(t, "exitcode 0", HistoryQueryOpts{ExitCode: &exitCode}, "ls", "sleep 10", "cat x", "/clear")
+	_, err := GetHistoryItems(context.Background(), HistoryQueryOpts{MaxItems: 10, ExitStatus: "bogus"})
+	if err == nil {
+		t.Errorf("invalid exit status should return an error")
+	}
+
+	checkHistoryTestQuery(t, "minduration", HistoryQueryOpts{MinDurationMs: 5000}, "make", "sleep 10")
+
+	// cwd matches the dir itself or subdirs, not siblings with the same prefix
+	checkHistoryTestQuery(t, "cwd", HistoryQueryOpts{CwdPrefix: "/home/user"}, "ls", "make", "/clear")
+	checkHistoryTestQuery(t, "cwd trailing slash", HistoryQueryOpts{CwdPrefix: "/home/user/"}, "ls", "make", "/clear")
+	checkHistoryTestQuery(t, "cwd subdir", HistoryQueryOpts{CwdPrefix: "/home/user/src"}, "make")
+	// % and _ are literal, not LIKE wildcards
+	checkHistoryTestQuery(t, "cwd wildcards", HistoryQueryOpts{CwdPrefix: "/tmp/100%_done"}, "false")
+	checkHistoryTestQuery(t, "cwd percent", HistoryQueryOpts{CwdPrefix: "/tmp/100%"})
+	checkHistoryTestQuery(t, "cwd backslash", HistoryQueryOpts{CwdPrefix: `/tmp/a\b`}, "bad")
+	checkHistoryTestQuery(t, "cwd backslash prefix", HistoryQueryOpts{CwdPrefix: `/tmp/a\`})
+	checkHistoryTestQuery(t, "cwd parent", HistoryQueryOpts{CwdPrefix: `/tmp`}, "false", "cat x", "bad")
+
+	checkHistoryTestQuery(t, "nometa", HistoryQueryOpts{NoMeta: true}, "ls", "make", "sleep 10", "false", "cat x", "bad", "imported")
+	checkHistoryTestQuery(t, "onlymeta", HistoryQueryOpts{OnlyMeta: true}, "/clear")
+
+	// filters combine
+	checkHistoryTestQuery(t, "combined", HistoryQueryOpts{CwdPrefix: "/home", ExitStatus: HistoryExitSuccess, NoMeta: true, MinTs: historyTestBaseTs + 1}, "sleep 10")
+}
@@ -0,0 +1,188 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	HistoryStatsSortCount    = "count"
+	HistoryStatsSortFailRate = "failrate"
+	HistoryStatsSortDuration = "duration"
+)
+
+// aggregates over history (LEFT JOIN cmd, so imported items without a cmd are counted, but have no
+// exit code or duration).  metacmds are not included.
+type HistoryStatsOpts struct {
+	SessionId string
+	ScreenId  string
+	RemoteId  string
+	MinTs     int64 // only items with ts >= MinTs (0 for all)
+	SortBy    string
+	MaxItems  int
+}
+
+type HistoryCmdStats struct {
+	CmdStr        string  `json:"cmdstr" db:"cmdstr"`
+	NumRuns       int64   `json:"numruns" db:"numruns"`
+	NumFailed     int64   `json:"numfailed" db:"numfailed"`
+	FailRate      float64 `json:"failrate" db:"failrate"`
+	AvgDurationMs int64   `json:"avgdurationms" db:"avgdurationms"`
+	MaxDurationMs int64   `json:"maxdurationms" db:"maxdurationms"`
+	LastTs        int64   `json:"lastts" db:"lastts"`
+}
+
+type HistoryRemoteStats struct {
+	RemoteId   string `json:"remoteid" db:"remoteid"`
+	RemoteName string `json:"remotename" db:"remotename"`
+	NumRuns    int64  `json:"numruns" db:"numruns"`
+	NumFailed  int64  `json:"numfailed" db:"numfailed"`
+	LastTs     int64  `json:"lastts" db:"lastts"`
+}
+
+type HistoryHourStats struct {
+	Hour      int   `json:"hour" db:"hour"`
+	NumRuns   int64 `json:"numruns" db:"numruns"`
+	NumFailed int64 `json:"numfailed" db:"numfailed"`
+}
+
+// same definition of failure as the exitcode=failure history filter
+var historyStatsFailedExpr = fmt.Sprintf("CASE WHEN c.exitcode <> 0 OR c.status IN ('%s', '%s') THEN 1 ELSE 0 END", CmdStatusError, CmdStatusHangup)
+
+func (opts HistoryStatsOpts) whereClause() (string, []interface{}) {
+	whereClause := "WHERE NOT h.ismetacmd"
+	var args []interface{}
+	if opts.SessionId != "" {
+		whereClause += " AND h.sessionid = ?"
+		args = append(args, opts.SessionId)
+	}
+	if opts.ScreenId != "" {
+		whereClause += " AND h.screenid = ?"
+		args = append(args, opts.ScreenId)
+	}
+	if opts.RemoteId != "" {
+		whereClause += " AND h.remoteid = ?"
+		args = append(args, opts.RemoteId)
+	}
+	if opts.MinTs > 0 {
+		whereClause += " AND h.ts >= ?"
+		args = append(args, opts.MinTs)
+	}
+	return whereClause, args
+}
+
+func GetHistoryCmdStats(ctx context.Context, opts HistoryStatsOpts) ([]*HistoryCmdStats, error) {
+	var orderBy string
+	switch opts.SortBy {
+	case "", HistoryStatsSortCount:
+		orderBy = "numruns DESC, lastts DESC"
+	case HistoryStatsSortFailRate:
+		orderBy = "failrate DESC, numfailed DESC, numruns DESC"
+	case HistoryStatsSortDuration:
+		orderBy = "avgdurationms DESC, numruns DESC"
+	default:
+		return nil, fmt.Errorf("invalid sort '%s'", opts.SortBy)
+	}
+	whereClause, args := opts.whereClause()
+	var rtn []*HistoryCmdStats
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := fmt.Sprintf(`SELECT h.cmdstr, count(*) numruns, sum(%s) numfailed,
+                                     COALESCE((1.0 * sum(%s)) / NULLIF(count(c.lineid), 0), 0) failrate,
+                                     CAST(COALESCE(avg(CASE WHEN c.status = '%s' THEN c.durationms END), 0) AS int) avgdurationms,
+                                     COALESCE(max(CASE WHEN c.status = '%s' THEN c.durationms END), 0) maxdurationms,
+                                     max(h.ts) lastts
+                              FROM history h LEFT JOIN cmd c ON c.screenid = h.screenid AND c.lineid = h.lineid
+                              %s
+                              GROUP BY h.cmdstr
+                              ORDER BY %s
+                              LIMIT %d`,
+			historyStatsFailedExpr, historyStatsFailedExpr, CmdStatusDone, CmdStatusDone, whereClause, orderBy, opts.MaxItems)
+		tx.Select(&rtn, query, args...)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
+
+func GetHistoryRemoteStats(ctx context.Context, opts HistoryStatsOpts) ([]*HistoryRemoteStats, error) {
+	whereClause, args := opts.whereClause()
+	var rtn []*HistoryRemoteStats
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := fmt.Sprintf(`SELECT h.remoteid, COALESCE(NULLIF(r.remotealias, ''), r.remotecanonicalname, h.remoteid) remotename,
+                                     count(*) numruns, COALESCE(sum(%s), 0) numfailed, max(h.ts) lastts
+                              FROM history h
+                              LEFT JOIN cmd c ON c.screenid = h.screenid AND c.lineid = h.lineid
+                              LEFT JOIN remote r ON r.remoteid = h.remoteid
+                              %s
+                              GROUP BY h.remoteid
+                              ORDER BY numruns DESC
+                              LIMIT %d`, historyStatsFailedExpr, whereClause, opts.MaxItems)
+		tx.Select(&rtn, query, args...)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
+
+// hours are in local time.  returns all 24 hours (including ones with no activity).
+func GetHistoryHourStats(ctx context.Context, opts HistoryStatsOpts) ([]*HistoryHourStats, error) {
+	whereClause, args := opts.whereClause()
+	var dbStats []*HistoryHourStats
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := fmt.Sprintf(`SELECT CAST(strftime('%%H', h.ts / 1000, 'unixepoch', 'localtime') AS int) hour,
+                                     count(*) numruns, COALESCE(sum(%s), 0) numfailed
+                              FROM history h LEFT JOIN cmd c ON c.screenid = h.screenid AND c.lineid = h.lineid
+                              %s
+                              GROUP BY hour`, historyStatsFailedExpr, whereClause)
+		tx.Select(&dbStats, query, args...)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	rtn := make([]*HistoryHourStats, 24)
+	for hour := range rtn {
+		rtn[hour] = &HistoryHourStats{Hour: hour}
+	}
+	for _, stat := range dbStats {
+		if stat.Hour >= 0 && stat.Hour < 24 {
+			rtn[stat.Hour] = stat
+		}
+	}
+	return rtn, nil
+}
+
+type HistoryDirStats struct {
+	Cwd     string `json:"cwd" db:"cwd"`
+	NumCmds int64  `json:"numcmds" db:"numcmds"`
+	LastTs  int64  `json:"lastts" db:"lastts"`
+}
+
+// directories that commands were run in on the given remote (from the cwd in the cmd's festate).
+// this scans the remote's history (joined with cmd) on every call instead of keeping a per-remote
+// dir table.  it only runs for /cd-jump, and a table would have to be kept in sync with line deletes,
+// purges, retention, and trash restores.  imported history (/history:import) has no cmd, and bash/zsh
+// history files do not record the cwd, so imported items never contribute directories (a dir table
+// would not change that).
+func GetHistoryDirStats(ctx context.Context, remoteId string) ([]*HistoryDirStats, error) {
+	var rtn []*HistoryDirStats
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT json_extract(c.festate, '$.cwd') cwd, count(*) numcmds, max(h.ts) lastts
+                  FROM history h JOIN cmd c ON c.screenid = h.screenid AND c.lineid = h.lineid
+                  WHERE h.remoteid = ? AND NOT h.ismetacmd AND COALESCE(json_extract(c.festate, '$.cwd'), '') <> ''
+                  GROUP BY cwd`
+		tx.Select(&rtn, query, remoteId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
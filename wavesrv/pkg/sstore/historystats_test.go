@@ -0,0 +1,240 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type historyStatsTestIds struct {
+	SessionId1    string
+	SessionId2    string
+	ScreenId1     string
+	ScreenId2     string
+	ScreenId3     string
+	LocalRemoteId string
+	OtherRemoteId string
+}
+
+// session1 has screen1 (local remote) and screen2 (other remote), session2 has screen3 (other remote)
+func makeHistoryStatsTestData(t *testing.T) historyStatsTestIds {
+	initTestDB(t)
+	localRemote, err := GetLocalRemote(context.Background())
+	if err != nil || localRemote == nil {
+		t.Fatalf("cannot get local remote: %v", err)
+	}
+	ids := historyStatsTestIds{
+		SessionId1:    uuid.New().String(),
+		SessionId2:    uuid.New().String(),
+		ScreenId1:     uuid.New().String(),
+		ScreenId2:     uuid.New().String(),
+		ScreenId3:     uuid.New().String(),
+		LocalRemoteId: localRemote.RemoteId,
+		OtherRemoteId: uuid.New().String(),
+	}
+	addHistoryTestItems(t, ids.SessionId1, []historyTestItem{
+		{CmdStr: "make", TsOffset: 0, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, DurationMs: 1000},
+		{CmdStr: "make", TsOffset: 1000, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, ExitCode: 2, DurationMs: 3000},
+		{CmdStr: "make", TsOffset: 2000, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, DurationMs: 2000},
+		{CmdStr: "ls", TsOffset: 3000, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, DurationMs: 10},
+		{CmdStr: "sleep 60", TsOffset: 4000, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, DurationMs: 60000},
+		{CmdStr: "bad", TsOffset: 5000, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, Status: CmdStatusError, DurationMs: 99999},
+		{CmdStr: "/clear", TsOffset: 6000, ScreenId: ids.ScreenId1, RemoteId: ids.LocalRemoteId, IsMetaCmd: true},
+		{CmdStr: "ls", TsOffset: 7000, ScreenId: ids.ScreenId2, RemoteId: ids.OtherRemoteId, DurationMs: 20},
+		{CmdStr: "false", TsOffset: 8000, ScreenId: ids.ScreenId2, RemoteId: ids.OtherRemoteId, ExitCode: 1, DurationMs: 5},
+	})
+	addHistoryTestItems(t, ids.SessionId2, []historyTestItem{
+		{CmdStr: "ls", TsOffset: 9000, ScreenId: ids.ScreenId3, RemoteId: ids.OtherRemoteId},
+		{CmdStr: "imported", TsOffset: 10000, ScreenId: ids.ScreenId3, RemoteId: ids.OtherRemoteId, NoCmd: true},
+	})
+	return ids
+}
+
+func getHistoryTestCmdStats(t *testing.T, opts HistoryStatsOpts) []*HistoryCmdStats {
+	if opts.MaxItems == 0 {
+		opts.MaxItems = 100
+	}
+	stats, err := GetHistoryCmdStats(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("cannot get cmd stats: %v", err)
+	}
+	return stats
+}
+
+func checkHistoryTestStatsOrder(t *testing.T, name string, stats []*HistoryCmdStats, expected ...string) {
+	if len(stats) != len(expected) {
+		t.Errorf("%s: got %d items, expected %d", name, len(stats), len(expected))
+		return
+	}
+	for idx, stat := range stats {
+		if stat.CmdStr != expected[idx] {
+			t.Errorf("%s: item %d is %q, expected %q", name, idx, stat.CmdStr, expected[idx])
+		}
+	}
+}
+
+func TestHistoryCmdStats(t *testing.T) {
+	ids := makeHistoryStatsTestData(t)
+
+	// ties on numruns are broken by the most recent run, metacmds are not counted
+	stats := getHistoryTestCmdStats(t, HistoryStatsOpts{})
+	checkHistoryTestStatsOrder(t, "count", stats, "ls", "make", "imported", "false", "bad", "sleep 60")
+	expected := map[string]HistoryCmdStats{
+		"ls":       {NumRuns: 3, AvgDurationMs: 10, MaxDurationMs: 20, LastTs: historyTestBaseTs + 9000},
+		"make":     {NumRuns: 3, NumFailed: 1, FailRate: 1.0 / 3, AvgDurationMs: 2000, MaxDurationMs: 3000, LastTs: historyTestBaseTs + 2000},
+		"imported": {NumRuns: 1, LastTs: historyTestBaseTs + 10000},
+		"false":    {NumRuns: 1, NumFailed: 1, FailRate: 1, AvgDurationMs: 5, MaxDurationMs: 5, LastTs: historyTestBaseTs + 8000},
+		// errored cmds count as failures, but not towards the durations
+		"bad":      {NumRuns: 1, NumFailed: 1, FailRate: 1, LastTs: historyTestBaseTs + 5000},
+		"sleep 60": {NumRuns: 1, AvgDurationMs: 60000, MaxDurationMs: 60000, LastTs: historyTestBaseTs + 4000},
+	}
+	for _, stat := range stats {
+		exp := expected[stat.CmdStr]
+		exp.CmdStr = stat.CmdStr
+		if stat.NumRuns != exp.NumRuns || stat.NumFailed != exp.NumFailed || math.Abs(stat.FailRate-exp.FailRate) > 1e-9 ||
+			stat.AvgDurationMs != exp.AvgDurationMs || stat.MaxDurationMs != exp.MaxDurationMs || stat.LastTs != exp.LastTs {
+			t.Errorf("stats for %q: got %+v, expected %+v", stat.CmdStr, *stat, exp)
+		}
+	}
+
+	stats = getHistoryTestCmdStats(t, HistoryStatsOpts{SortBy: HistoryStatsSortFailRate})
+	if len(stats) != 6 || stats[2].CmdStr != "make" || stats[0].FailRate != 1 || stats[1].FailRate != 1 {
+		t.Errorf("failrate sort: got %v", historyTestStatsCmdStrs(stats))
+	}
+	stats = getHistoryTestCmdStats(t, HistoryStatsOpts{SortBy: HistoryStatsSortDuration, MaxItems: 3})
+	checkHistoryTestStatsOrder(t, "slowest", stats, "sleep 60", "make", "ls")
+	_, err := GetHistoryCmdStats(context.Background(), HistoryStatsOpts{SortBy: "bogus", MaxItems: 10})
+	if err == nil {
+		t.Errorf("invalid sort should return an error")
+	}
+
+	// scoping
+	stats = getHistoryTestCmdStats(t, HistoryStatsOpts{SessionId: ids.SessionId1})
+	checkHistoryTestStatsOrder(t, "session", stats, "make", "ls", "false", "bad", "sleep 60")
+	if stats[1].NumRuns != 2 {
+		t.Errorf("session: got %d runs of ls, expected 2", stats[1].NumRuns)
+	}
+	stats = getHistoryTestCmdStats(t, HistoryStatsOpts{SessionId: ids.SessionId1, ScreenId: ids.ScreenId2})
+	checkHistoryTestStatsOrder(t, "screen", stats, "false", "ls")
+	stats = getHistoryTestCmdStats(t, HistoryStatsOpts{RemoteId: ids.OtherRemoteId})
+	checkHistoryTestStatsOrder(t, "remote", stats, "ls", "imported", "false")
+	stats = getHistoryTestCmdStats(t, HistoryStatsOpts{MinTs: historyTestBaseTs + 8000})
+	checkHistoryTestStatsOrder(t, "mints", stats, "imported", "ls", "false")
+}
+
+func historyTestStatsCmdStrs(stats []*HistoryCmdStats) []string {
+	var rtn []string
+	for _, stat := range stats {
+		rtn = append(rtn, stat.CmdStr)
+	}
+	return rtn
+}
+
+func TestHistoryRemoteStats(t *testing.T) {
+	ids := makeHistoryStatsTestData(t)
+	stats, err := GetHistoryRemoteStats(context.Background(), HistoryStatsOpts{MaxItems: 10})
+	if err != nil {
+		t.Fatalf("cannot get remote stats: %v", err)
+	}
+	// remotes that are not in the remote table are shown by id
+	expected := []HistoryRemoteStats{
+		{RemoteId: ids.LocalRemoteId, RemoteName: LocalRemoteAlias, NumRuns: 6, NumFailed: 2, LastTs: historyTestBaseTs + 5000},
+		{RemoteId: ids.OtherRemoteId, RemoteName: ids.OtherRemoteId, NumRuns: 4, NumFailed: 1, LastTs: historyTestBaseTs + 10000},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("got %d remotes, expected %d", len(stats), len(expected))
+	}
+	for idx, stat := range stats {
+		if *stat != expected[idx] {
+			t.Errorf("remote %d: got %+v, expected %+v", idx, *stat, expected[idx])
+		}
+	}
+
+	stats, err = GetHistoryRemoteStats(context.Background(), HistoryStatsOpts{SessionId: ids.SessionId2, MaxItems: 10})
+	if err != nil {
+		t.Fatalf("cannot get remote stats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].RemoteId != ids.OtherRemoteId || stats[0].NumRuns != 2 || stats[0].NumFailed != 0 {
+		t.Errorf("session remote stats: got %d remotes, expected 1 with 2 runs", len(stats))
+	}
+}
+
+func TestHistoryHourStats(t *testing.T) {
+	ids := makeHistoryStatsTestData(t)
+	// 3 hours after the other items, so in a different (local) hour
+	lateOffset := int64(3 * 60 * 60 * 1000)
+	addHistoryTestItems(t, ids.SessionId2, []historyTestItem{
+		{CmdStr: "late", TsOffset: lateOffset, ScreenId: ids.ScreenId3, RemoteId: ids.OtherRemoteId, ExitCode: 1},
+	})
+	baseHour := time.UnixMilli(historyTestBaseTs).Hour()
+	lateHour := time.UnixMilli(historyTestBaseTs + lateOffset).Hour()
+
+	stats, err := GetHistoryHourStats(context.Background(), HistoryStatsOpts{})
+	if err != nil {
+		t.Fatalf("cannot get hour stats: %v", err)
+	}
+	if len(stats) != 24 {
+		t.Fatalf("got %d hours, expected 24", len(stats))
+	}
+	for hour, stat := range stats {
+		expected := HistoryHourStats{Hour: hour}
+		if hour == baseHour {
+			expected.NumRuns = 10
+			expected.NumFailed = 3
+		} else if hour == lateHour {
+			expected.NumRuns = 1
+			expected.NumFailed = 1
+		}
+		if *stat != expected {
+			t.Errorf("hour %d: got %+v, expected %+v", hour, *stat, expected)
+		}
+	}
+
+	stats, err = GetHistoryHourStats(context.Background(), HistoryStatsOpts{ScreenId: ids.ScreenId2})
+	if err != nil {
+		t.Fatalf("cannot get hour stats: %v", err)
+	}
+	if stats[baseHour].NumRuns != 2 || stats[baseHour].NumFailed != 1 || stats[lateHour].NumRuns != 0 {
+		t.Errorf("screen hour stats: got %+v at hour %d, %+v at hour %d", *stats[baseHour], baseHour, *stats[lateHour], lateHour)
+	}
+}
+
+func TestHistoryDirStats(t *testing.T) {
+	initTestDB(t)
+	sessionId := uuid.New().String()
+	screenId := uuid.New().String()
+	remoteId := uuid.New().String()
+	otherRemoteId := uuid.New().String()
+	addHistoryTestItems(t, sessionId, []historyTestItem{
+		{CmdStr: "ls", TsOffset: 0, ScreenId: screenId, RemoteId: remoteId, Cwd: "/home/user"},
+		{CmdStr: "make", TsOffset: 1000, ScreenId: screenId, RemoteId: remoteId, Cwd: "/home/user/src"},
+		{CmdStr: "make", TsOffset: 2000, ScreenId: screenId, RemoteId: remoteId, Cwd: "/home/user/src"},
+		{CmdStr: "/clear", TsOffset: 3000, ScreenId: screenId, RemoteId: remoteId, IsMetaCmd: true, Cwd: "/meta"},
+		{CmdStr: "echo", TsOffset: 4000, ScreenId: screenId, RemoteId: remoteId},
+		{CmdStr: "imported", TsOffset: 5000, ScreenId: screenId, RemoteId: remoteId, NoCmd: true},
+		{CmdStr: "ls", TsOffset: 6000, ScreenId: screenId, RemoteId: otherRemoteId, Cwd: "/other"},
+	})
+	stats, err := GetHistoryDirStats(context.Background(), remoteId)
+	if err != nil {
+		t.Fatalf("cannot get dir stats: %v", err)
+	}
+	// metacmds, cmds without a cwd, imported items, and other remotes are not included
+	expected := map[string]HistoryDirStats{
+		"/home/user":     {Cwd: "/home/user", NumCmds: 1, LastTs: historyTestBaseTs},
+		"/home/user/src": {Cwd: "/home/user/src", NumCmds: 2, LastTs: historyTestBaseTs + 2000},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("got %d dirs, expected %d", len(stats), len(expected))
+	}
+	for _, stat := range stats {
+		if *stat != expected[stat.Cwd] {
+			t.Errorf("dir %q: got %+v, expected %+v", stat.Cwd, *stat, expected[stat.Cwd])
+		}
+	}
+}
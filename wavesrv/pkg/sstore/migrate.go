@@ -4,6 +4,7 @@
 package sstore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
@@ -22,7 +24,7 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 )
 
-const MaxMigration = 24
+const MaxMigration = 28
 const MigratePrimaryScreenVersion = 9
 const CmdScreenSpecialMigration = 13
 const CmdLineSpecialMigration = 20
@@ -51,12 +53,12 @@ func copyFile(srcFile string, dstFile string, notFoundOk bool) error {
 		return nil
 	}
 	if err != nil {
-		return fmt.Errorf("cannot open %s: %v", err)
+		return fmt.Errorf("cannot open %s: %v", srcFile, err)
 	}
 	defer srcFd.Close()
 	dstFd, err := os.OpenFile(dstFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return fmt.Errorf("cannot open destination file %s: %v", err)
+		return fmt.Errorf("cannot open destination file %s: %v", dstFile, err)
 	}
 	_, err = io.Copy(dstFd, srcFd)
 	if err != nil {
@@ -66,6 +68,22 @@ func copyFile(srcFile string, dstFile string, notFoundOk bool) error {
 	return dstFd.Close()
 }
 
+// copies the db to backup.waveterm.db (replacing the previous migration backup)
+func backupDBForMigration() error {
+	log.Printf("[db] backing up database %s to %s\n", DBFileName, DBFileNameBackup)
+	os.Remove(GetDBBackupName())    // don't report error
+	os.Remove(GetDBWALBackupName()) // don't report error
+	err := copyFile(GetDBName(), GetDBBackupName(), false)
+	if err != nil {
+		return fmt.Errorf("error creating database backup: %v", err)
+	}
+	err = copyFile(GetDBWALName(), GetDBWALBackupName(), true)
+	if err != nil {
+		return fmt.Errorf("error creating database(wal) backup: %v", err)
+	}
+	return nil
+}
+
 func MigrateUpStep(m *migrate.Migrate, newVersion uint) error {
 	startTime := time.Now()
 	err := m.Migrate(newVersion)
@@ -104,16 +122,9 @@ func MigrateUp(targetVersion uint) error {
 		return nil
 	}
 	log.Printf("[db] migrating from %d to %d\n", curVersion, targetVersion)
-	log.Printf("[db] backing up database %s to %s\n", DBFileName, DBFileNameBackup)
-	os.Remove(GetDBBackupName())    // don't report error
-	os.Remove(GetDBWALBackupName()) // don't report error
-	err = copyFile(GetDBName(), GetDBBackupName(), false)
+	err = backupDBForMigration()
 	if err != nil {
-		return fmt.Errorf("error creating database backup: %v", err)
-	}
-	err = copyFile(GetDBWALName(), GetDBWALBackupName(), true)
-	if err != nil {
-		return fmt.Errorf("error creating database(wal) backup: %v", err)
+		return err
 	}
 	for newVersion := curVersion + 1; newVersion <= targetVersion; newVersion++ {
 		err = MigrateUpStep(m, newVersion)
@@ -207,13 +218,8 @@ func MigrateCommandOpts(opts []string) error {
 		fmt.Printf("migrate-down %v\n", GetDBName())
 		time.Sleep(3 * time.Second)
 		err = MigrateDown()
-	} else if opts[0] == "--migrate-goto" {
-		n, err := strconv.Atoi(opts[1])
-		if err == nil {
-			fmt.Printf("migrate-goto %v => %d\n", GetDBName(), n)
-			time.Sleep(3 * time.Second)
-			err = MigrateGoto(uint(n))
-		}
+	} else if opts[0] == "--migrate-goto" || opts[0] == "--migrate-to" || opts[0] == MigrateDryRunOpt {
+		return migrateToCommand(opts)
 	} else {
 		err = fmt.Errorf("invalid migration command")
 	}
@@ -225,3 +231,168 @@ func MigrateCommandOpts(opts []string) error {
 	}
 	return MigratePrintVersion()
 }
+
+const MigrateDryRunOpt = "--migrate-dry-run"
+
+// these migrations have data migrations that cannot be reversed (their down files are invalid)
+var irreversibleMigrations = map[uint]bool{
+	CmdScreenSpecialMigration: true,
+	CmdLineSpecialMigration:   true,
+}
+
+type migrationStep struct {
+	Version  uint
+	Up       bool
+	FileName string
+	SQL      string
+}
+
+func readMigrationFile(version uint, up bool) (string, string, error) {
+	suffix := ".down.sql"
+	if up {
+		suffix = ".up.sql"
+	}
+	entries, err := fs.ReadDir(sh2db.MigrationFS, "migrations")
+	if err != nil {
+		return "", "", err
+	}
+	prefix := fmt.Sprintf("%06d_", version)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) && strings.HasSuffix(entry.Name(), suffix) {
+			data, err := fs.ReadFile(sh2db.MigrationFS, "migrations/"+entry.Name())
+			if err != nil {
+				return "", "", err
+			}
+			return entry.Name(), string(data), nil
+		}
+	}
+	return "", "", fmt.Errorf("migration file for v%d (%s) not found", version, suffix)
+}
+
+// returns the migrations (in order) that take the db from curVersion to targetVersion
+func getMigrationSteps(curVersion uint, targetVersion uint) ([]migrationStep, error) {
+	var rtn []migrationStep
+	if targetVersion > curVersion {
+		for version := curVersion + 1; version <= targetVersion; version++ {
+			fileName, sql, err := readMigrationFile(version, true)
+			if err != nil {
+				return nil, err
+			}
+			rtn = append(rtn, migrationStep{Version: version, Up: true, FileName: fileName, SQL: sql})
+		}
+		return rtn, nil
+	}
+	for version := curVersion; version > targetVersion; version-- {
+		if irreversibleMigrations[version] {
+			return nil, fmt.Errorf("cannot migrate below v%d, migration v%d cannot be reversed", version, version)
+		}
+		fileName, sql, err := readMigrationFile(version, false)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, migrationStep{Version: version, Up: false, FileName: fileName, SQL: sql})
+	}
+	return rtn, nil
+}
+
+// parses: --migrate-to [N] [--migrate-dry-run], --migrate-goto [N], or --migrate-dry-run [N]
+// (N defaults to MaxMigration).  returns (target-version, dry-run, error)
+func parseMigrateToOpts(opts []string) (uint, bool, error) {
+	targetVersion := uint(MaxMigration)
+	var dryRun bool
+	for _, opt := range opts {
+		if opt == MigrateDryRunOpt {
+			dryRun = true
+			continue
+		}
+		if strings.HasPrefix(opt, "--migrate") {
+			continue
+		}
+		n, err := strconv.Atoi(opt)
+		if err != nil || n < 0 {
+			return 0, false, fmt.Errorf("invalid migration version %q", opt)
+		}
+		targetVersion = uint(n)
+	}
+	if targetVersion > MaxMigration {
+		return 0, false, fmt.Errorf("invalid migration version %d (max version for this build is %d)", targetVersion, MaxMigration)
+	}
+	return targetVersion, dryRun, nil
+}
+
+// prints the pending migration sql, and (if not a dry run) backs up the db, migrates it, and verifies the new version
+func migrateToCommand(opts []string) error {
+	targetVersion, dryRun, err := parseMigrateToOpts(opts)
+	if err != nil {
+		return err
+	}
+	m, err := MakeMigrate()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	curVersion, dirty, err := MigrateVersion(m)
+	if err != nil {
+		return fmt.Errorf("cannot get current migration version: %v", err)
+	}
+	if dirty {
+		return fmt.Errorf("database is dirty at v%d (a migration failed), restore %s", curVersion, GetDBBackupName())
+	}
+	steps, err := getMigrationSteps(curVersion, targetVersion)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Printf("%s is already at v%d\n", GetDBName(), curVersion)
+		return nil
+	}
+	fmt.Printf("migrate %s from v%d to v%d (%d steps)\n", GetDBName(), curVersion, targetVersion, len(steps))
+	for _, step := range steps {
+		fmt.Printf("\n-- v%d %s\n%s", step.Version, step.FileName, step.SQL)
+		if !strings.HasSuffix(step.SQL, "\n") {
+			fmt.Printf("\n")
+		}
+		if step.Up && (step.Version == CmdScreenSpecialMigration || step.Version == CmdLineSpecialMigration) {
+			fmt.Printf("-- (followed by the v%d data migration)\n", step.Version)
+		}
+	}
+	fmt.Printf("\n")
+	if dryRun {
+		fmt.Printf("dry run, no changes made\n")
+		return nil
+	}
+	if targetVersion > curVersion {
+		// MigrateUp backs up the db first
+		err = MigrateUp(targetVersion)
+	} else {
+		err = backupDBForMigration()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("backed up database to %s\n", GetDBBackupName())
+		if targetVersion == 0 {
+			err = m.Down()
+		} else {
+			err = m.Migrate(targetVersion)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration failed (backup is in %s): %w", GetDBBackupName(), err)
+	}
+	dbVersion, err := GetDBVersion(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot verify db version: %v", err)
+	}
+	if dbVersion != int(targetVersion) {
+		return fmt.Errorf("db is at v%d after migrating, expected v%d (backup is in %s)", dbVersion, targetVersion, GetDBBackupName())
+	}
+	err = MigratePrintVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %s to v%d\n", GetDBName(), dbVersion)
+	if targetVersion < MaxMigration {
+		fmt.Printf("note: this build migrates the db back up to v%d when it starts, run a build that matches v%d\n", MaxMigration, targetVersion)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testMigrationSteps(t *testing.T, curVersion uint, targetVersion uint, expected string) {
+	steps, err := getMigrationSteps(curVersion, targetVersion)
+	if err != nil {
+		t.Errorf("v%d => v%d: error: %v", curVersion, targetVersion, err)
+		return
+	}
+	var strs []string
+	for _, step := range steps {
+		suffix := ".down.sql"
+		if step.Up {
+			suffix = ".up.sql"
+		}
+		if !strings.HasPrefix(step.FileName, fmt.Sprintf("%06d_", step.Version)) || !strings.HasSuffix(step.FileName, suffix) || step.SQL == "" {
+			t.Errorf("v%d => v%d: bad step v%d %q", curVersion, targetVersion, step.Version, step.FileName)
+		}
+		dir := "down"
+		if step.Up {
+			dir = "up"
+		}
+		strs = append(strs, fmt.Sprintf("%s%d", dir, step.Version))
+	}
+	if strings.Join(strs, " ") != expected {
+		t.Errorf("v%d => v%d: got [%s], expected [%s]", curVersion, targetVersion, strings.Join(strs, " "), expected)
+	}
+}
+
+func TestGetMigrationSteps(t *testing.T) {
+	testMigrationSteps(t, 5, 5, "")
+	testMigrationSteps(t, 5, 8, "up6 up7 up8")
+	testMigrationSteps(t, 8, 5, "down8 down7 down6")
+	testMigrationSteps(t, 0, 2, "up1 up2")
+	testMigrationSteps(t, MaxMigration-1, MaxMigration, fmt.Sprintf("up%d", MaxMigration))
+	// every migration has an up file, and the ones after the last data migration have a down file
+	steps, err := getMigrationSteps(0, MaxMigration)
+	if err != nil || len(steps) != MaxMigration {
+		t.Errorf("v0 => v%d: got %d steps, err %v", MaxMigration, len(steps), err)
+	}
+	steps, err = getMigrationSteps(MaxMigration, CmdLineSpecialMigration)
+	if err != nil || len(steps) != MaxMigration-CmdLineSpecialMigration {
+		t.Errorf("v%d => v%d: got %d steps, err %v", MaxMigration, CmdLineSpecialMigration, len(steps), err)
+	}
+
+	// the data migrations cannot be reversed
+	for _, target := range []uint{CmdLineSpecialMigration - 1, CmdScreenSpecialMigration - 1, 0} {
+		_, err = getMigrationSteps(MaxMigration, target)
+		if err == nil {
+			t.Errorf("v%d => v%d: expected an error (irreversible migration)", MaxMigration, target)
+		}
+	}
+	_, err = getMigrationSteps(MaxMigration, MaxMigration+1)
+	if err == nil {
+		t.Errorf("v%d => v%d: expected an error (no migration file)", MaxMigration, MaxMigration+1)
+	}
+}
+
+func TestParseMigrateToOpts(t *testing.T) {
+	tests := []struct {
+		Opts   []string
+		Target uint
+		DryRun bool
+		Err    bool
+	}{
+		{[]string{"--migrate-to"}, MaxMigration, false, false},
+		{[]string{"--migrate-to", "20"}, 20, false, false},
+		{[]string{"--migrate-goto", "0"}, 0, false, false},
+		{[]string{"--migrate-to", "20", MigrateDryRunOpt}, 20, true, false},
+		{[]string{MigrateDryRunOpt}, MaxMigration, true, false},
+		{[]string{"--migrate-to", "x"}, 0, false, true},
+		{[]string{"--migrate-to", "-1"}, 0, false, true},
+		{[]string{"--migrate-to", fmt.Sprintf("%d", MaxMigration+1)}, 0, false, true},
+	}
+	for _, test := range tests {
+		target, dryRun, err := parseMigrateToOpts(test.Opts)
+		if (err != nil) != test.Err {
+			t.Errorf("%v: got err %v, expected err %v", test.Opts, err, test.Err)
+			continue
+		}
+		if err == nil && (target != test.Target || dryRun != test.DryRun) {
+			t.Errorf("%v: got (%d, %v), expected (%d, %v)", test.Opts, target, dryRun, test.Target, test.DryRun)
+		}
+	}
+}
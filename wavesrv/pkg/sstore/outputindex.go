@@ -0,0 +1,316 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// command output (ansi stripped) is indexed in an fts4 table so history can be searched by output.
+// output is buffered per command and written in chunks (cmd_output_fts has one row per chunk,
+// cmd_output_idx maps the chunks back to their commands).  the tables are created by migration 28.
+//
+// fts4 instead of fts5: go-sqlite3 always compiles in fts3/fts4, but fts5 needs the sqlite_fts5 build tag
+// on every go build/vet/test of wavesrv.  a binary built without it can't run migration 28, so wavesrv
+// would fail to open the db at startup.  the search only needs MATCH, snippet() and the unicode61
+// tokenizer, which fts4 has.
+
+const OutputIndexChunkSize = 32 * 1024
+const OutputIndexFlushInterval = 10 * time.Second
+const maxPendingLineSize = 64 * 1024
+const OutputSearchSnippetTokens = 16
+
+type outputIndexBuf struct {
+	ScreenId    string
+	LineId      string
+	PendingRaw  []byte // incomplete line (not yet stripped)
+	Text        strings.Builder
+	LastWriteTs time.Time
+}
+
+var outputIndexLock = &sync.Mutex{}
+var outputIndexBufs = make(map[string]*outputIndexBuf) // screenid + ":" + lineid
+
+type OutputSearchOpts struct {
+	Query     string
+	SessionId string
+	ScreenId  string
+	MaxItems  int
+}
+
+type OutputSearchResult struct {
+	SessionId   string `json:"sessionid"`
+	SessionName string `json:"sessionname"`
+	ScreenId    string `json:"screenid"`
+	ScreenName  string `json:"screenname"`
+	LineId      string `json:"lineid"`
+	LineNum     int64  `json:"linenum"`
+	Ts          int64  `json:"ts"`
+	CmdStr      string `json:"cmdstr"`
+	Snippet     string `json:"snippet"`
+}
+
+// never returns.  flushes the output of long running commands so it is searchable before they finish.
+func RunOutputIndexFlusher() {
+	for {
+		time.Sleep(OutputIndexFlushInterval)
+		var toFlush []*outputIndexBuf
+		outputIndexLock.Lock()
+		for key, buf := range outputIndexBufs {
+			if time.Since(buf.LastWriteTs) < OutputIndexFlushInterval {
+				continue
+			}
+			buf.flushPending()
+			if buf.Text.Len() > 0 {
+				toFlush = append(toFlush, buf)
+			}
+			delete(outputIndexBufs, key)
+		}
+		outputIndexLock.Unlock()
+		for _, buf := range toFlush {
+			err := writeOutputIndexChunk(context.Background(), buf.ScreenId, buf.LineId, buf.Text.String())
+			if err != nil {
+				log.Printf("error writing output index %s/%s: %v\n", buf.ScreenId, buf.LineId, err)
+			}
+		}
+	}
+}
+
+func indexCmdOutput(ctx context.Context, screenId string, lineId string, data []byte) {
+	var chunk string
+	outputIndexLock.Lock()
+	key := screenId + ":" + lineId
+	buf := outputIndexBufs[key]
+	if buf == nil {
+		buf = &outputIndexBuf{ScreenId: screenId, LineId: lineId}
+		outputIndexBufs[key] = buf
+	}
+	buf.LastWriteTs = time.Now()
+	buf.addData(data)
+	if buf.Text.Len() >= OutputIndexChunkSize {
+		chunk = buf.Text.String()
+		buf.Text.Reset()
+	}
+	outputIndexLock.Unlock()
+	if chunk != "" {
+		err := writeOutputIndexChunk(ctx, screenId, lineId, chunk)
+		if err != nil {
+			log.Printf("error writing output index %s/%s: %v\n", screenId, lineId, err)
+		}
+	}
+}
+
+// called when a command is done, writes any buffered output to the index
+func FlushCmdOutputIndex(ctx context.Context, screenId string, lineId string) error {
+	outputIndexLock.Lock()
+	key := screenId + ":" + lineId
+	buf := outputIndexBufs[key]
+	delete(outputIndexBufs, key)
+	if buf != nil {
+		buf.flushPending()
+	}
+	outputIndexLock.Unlock()
+	if buf == nil || buf.Text.Len() == 0 {
+		return nil
+	}
+	return writeOutputIndexChunk(ctx, screenId, lineId, buf.Text.String())
+}
+
+func writeOutputIndexChunk(ctx context.Context, screenId string, lineId string, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `INSERT INTO cmd_output_fts (output) VALUES (?)`
+		res := tx.Exec(query, text)
+		if tx.Err != nil {
+			return nil
+		}
+		rowId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		query = `INSERT INTO cmd_output_idx (ftsrowid, screenid, lineid, ts) VALUES (?, ?, ?, ?)`
+		tx.Exec(query, rowId, screenId, lineId, time.Now().UnixMilli())
+		return nil
+	})
+}
+
+// lineId can be empty (removes the index for the whole screen)
+func deleteCmdOutputIndex(tx *TxWrap, screenId string, lineId string) {
+	if lineId == "" {
+		query := `DELETE FROM cmd_output_fts WHERE rowid IN (SELECT ftsrowid FROM cmd_output_idx WHERE screenid = ?)`
+		tx.Exec(query, screenId)
+		query = `DELETE FROM cmd_output_idx WHERE screenid = ?`
+		tx.Exec(query, screenId)
+		return
+	}
+	query := `DELETE FROM cmd_output_fts WHERE rowid IN (SELECT ftsrowid FROM cmd_output_idx WHERE screenid = ? AND lineid = ?)`
+	tx.Exec(query, screenId, lineId)
+	query = `DELETE FROM cmd_output_idx WHERE screenid = ? AND lineid = ?`
+	tx.Exec(query, screenId, lineId)
+}
+
+// the query is matched as a phrase (fts query syntax is not exposed).  returns at most one result
+// per command, most recent first.
+func SearchCmdOutput(ctx context.Context, opts OutputSearchOpts) ([]*OutputSearchResult, error) {
+	if strings.TrimSpace(opts.Query) == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = DefaultMaxHistoryItems
+	}
+	ftsQuery := `"` + strings.ReplaceAll(opts.Query, `"`, `""`) + `"`
+	var rtn []*OutputSearchResult
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT s.sessionid, s.name AS sessionname, sc.screenid, sc.name AS screenname, i.lineid, l.linenum, i.ts, c.cmdstr,
+                         snippet(cmd_output_fts, '[', ']', '...', 0, ?) AS snippet
+                  FROM cmd_output_fts f
+                  JOIN cmd_output_idx i ON i.ftsrowid = f.rowid
+                  JOIN line l ON l.screenid = i.screenid AND l.lineid = i.lineid
+                  JOIN cmd c ON c.screenid = i.screenid AND c.lineid = i.lineid
+                  JOIN screen sc ON sc.screenid = i.screenid
+                  JOIN session s ON s.sessionid = sc.sessionid
+                  WHERE cmd_output_fts MATCH ?`
+		args := []interface{}{OutputSearchSnippetTokens, ftsQuery}
+		if opts.SessionId != "" {
+			query += " AND s.sessionid = ?"
+			args = append(args, opts.SessionId)
+		}
+		if opts.ScreenId != "" {
+			query += " AND sc.screenid = ?"
+			args = append(args, opts.ScreenId)
+		}
+		// a command can match in many chunks, fetch extra rows so we still have MaxItems after de-duping
+		query += " ORDER BY i.ts DESC, i.ftsrowid DESC LIMIT ?"
+		args = append(args, opts.MaxItems*5)
+		var results []*OutputSearchResult
+		tx.Select(&results, query, args...)
+		seen := make(map[string]bool)
+		for _, result := range results {
+			key := result.ScreenId + ":" + result.LineId
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			result.Snippet = strings.Join(strings.Fields(result.Snippet), " ")
+			rtn = append(rtn, result)
+			if len(rtn) >= opts.MaxItems {
+				break
+			}
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
+
+// only complete lines are stripped and added to Text (so escape sequences split across writes are handled)
+func (buf *outputIndexBuf) addData(data []byte) {
+	buf.PendingRaw = append(buf.PendingRaw, data...)
+	lastNl := bytes.LastIndexByte(buf.PendingRaw, '\n')
+	if lastNl == -1 {
+		if len(buf.PendingRaw) > maxPendingLineSize {
+			buf.flushPending()
+		}
+		return
+	}
+	buf.Text.WriteString(StripAnsiOutput(buf.PendingRaw[0 : lastNl+1]))
+	buf.PendingRaw = append([]byte(nil), buf.PendingRaw[lastNl+1:]...)
+}
+
+func (buf *outputIndexBuf) flushPending() {
+	if len(buf.PendingRaw) == 0 {
+		return
+	}
+	buf.Text.WriteString(StripAnsiOutput(buf.PendingRaw))
+	buf.Text.WriteString("\n")
+	buf.PendingRaw = nil
+}
+
+// converts raw terminal output to plain text.  removes escape sequences (CSI, OSC, DCS, etc.)
+// and control chars.  a carriage return discards the text before it on the same line (progress bars).
+func StripAnsiOutput(data []byte) string {
+	var rtn strings.Builder
+	var line []byte
+	flushLine := func() {
+		rtn.Write(line)
+		rtn.WriteByte('\n')
+		line = line[:0]
+	}
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		switch {
+		case ch == 0x1b:
+			i = skipEscapeSeq(data, i)
+		case ch == '\n':
+			flushLine()
+		case ch == '\r':
+			if i+1 < len(data) && data[i+1] == '\n' {
+				continue
+			}
+			line = line[:0]
+		case ch == '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case ch == '\t':
+			line = append(line, ch)
+		case ch < 0x20 || ch == 0x7f:
+			// other control chars are dropped
+		default:
+			line = append(line, ch)
+		}
+	}
+	if len(line) > 0 {
+		rtn.Write(line)
+	}
+	rtnStr := rtn.String()
+	if !utf8.ValidString(rtnStr) {
+		rtnStr = strings.ToValidUTF8(rtnStr, "")
+	}
+	return rtnStr
+}
+
+// returns the index of the last byte of the escape sequence starting at data[pos] (ESC)
+func skipEscapeSeq(data []byte, pos int) int {
+	if pos+1 >= len(data) {
+		return pos
+	}
+	switch data[pos+1] {
+	case '[':
+		// CSI: params and intermediates, terminated by a byte in 0x40-0x7e
+		for i := pos + 2; i < len(data); i++ {
+			if data[i] >= 0x40 && data[i] <= 0x7e {
+				return i
+			}
+		}
+		return len(data) - 1
+	case ']', 'P', '_', '^', 'X':
+		// OSC, DCS, APC, PM, SOS: terminated by BEL or ST (ESC \)
+		for i := pos + 2; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 1
+			}
+		}
+		return len(data) - 1
+	case '(', ')', '*', '+', '#', '%':
+		// charset designation (one more byte)
+		return pos + 2
+	default:
+		return pos + 1
+	}
+}
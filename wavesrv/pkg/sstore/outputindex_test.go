@@ -0,0 +1,40 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"testing"
+)
+
+func TestStripAnsiOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain", "hello\nworld\n", "hello\nworld\n"},
+		{"sgr", "\x1b[1;31mred\x1b[0m text\n", "red text\n"},
+		{"sgr-256", "\x1b[38;5;196mx\x1b[m", "x"},
+		{"osc-bel", "\x1b]0;title\x07hello\n", "hello\n"},
+		{"osc-st", "\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\\n", "link\n"},
+		{"cursor", "abc\x1b[2Ddef\x1b[K\x1b[1;1H\n", "abcdef\n"},
+		{"charset", "\x1b(Bok", "ok"},
+		{"dcs", "a\x1bPq#0;1\x1b\\b", "ab"},
+		{"progress", "10%\r50%\r100%\n", "100%\n"},
+		{"crlf", "a\r\nb\r\n", "a\nb\n"},
+		{"backspace", "abx\bc\n", "abc\n"},
+		{"tab", "a\tb", "a\tb"},
+		{"control", "a\x07b\x00c\x7f", "abc"},
+		{"invalid-utf8", "a\xffb", "ab"},
+		{"truncated-csi", "abc\x1b[", "abc"},
+		{"truncated-esc", "abc\x1b", "abc"},
+		{"truncated-osc", "abc\x1b]0;tit", "abc"},
+	}
+	for _, test := range tests {
+		rtn := StripAnsiOutput([]byte(test.input))
+		if rtn != test.expected {
+			t.Errorf("%s: StripAnsiOutput(%q) = %q, expected %q", test.name, test.input, rtn, test.expected)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func makePlaybookTestEntry(playbookId string, cmdStr string) *PlaybookEntry {
+	return &PlaybookEntry{
+		PlaybookId:  playbookId,
+		EntryId:     uuid.New().String(),
+		CmdStr:      cmdStr,
+		Description: "run " + cmdStr,
+		CreatedTs:   1700000000000,
+		UpdatedTs:   1700000000000,
+	}
+}
+
+func getPlaybookTestCmdStrs(t *testing.T, playbookId string) []string {
+	playbook, err := GetPlaybookById(context.Background(), playbookId)
+	if err != nil || playbook == nil {
+		t.Fatalf("cannot get playbook: %v", err)
+	}
+	var rtn []string
+	for _, entry := range playbook.Entries {
+		rtn = append(rtn, entry.CmdStr)
+	}
+	return rtn
+}
+
+func TestPlaybookEntries(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	playbook, err := CreatePlaybook(ctx, "deploy", "deploy the app")
+	if err != nil {
+		t.Fatalf("cannot create playbook: %v", err)
+	}
+	_, err = CreatePlaybook(ctx, "deploy", "")
+	if err == nil {
+		t.Errorf("creating a playbook with a duplicate name should fail")
+	}
+
+	// resolves by name, id, or 8 character id prefix
+	for _, arg := range []string{"deploy", playbook.PlaybookId, playbook.PlaybookId[0:8]} {
+		playbookId, err := GetPlaybookIdByArg(ctx, arg)
+		if err != nil || playbookId != playbook.PlaybookId {
+			t.Errorf("GetPlaybookIdByArg(%q) = %q (%v), expected %q", arg, playbookId, err, playbook.PlaybookId)
+		}
+	}
+	playbookId, _ := GetPlaybookIdByArg(ctx, "nothing")
+	if playbookId != "" {
+		t.Errorf("GetPlaybookIdByArg for a missing playbook should return empty, got %q", playbookId)
+	}
+
+	entries := []*PlaybookEntry{
+		makePlaybookTestEntry(playbook.PlaybookId, "make build"),
+		makePlaybookTestEntry(playbook.PlaybookId, "make test"),
+		makePlaybookTestEntry(playbook.PlaybookId, "make deploy"),
+	}
+	for _, entry := range entries {
+		err = AddPlaybookEntry(ctx, entry)
+		if err != nil {
+			t.Fatalf("cannot add playbook entry: %v", err)
+		}
+	}
+	if err = AddPlaybookEntry(ctx, entries[0]); err == nil {
+		t.Errorf("adding an entry with a duplicate entryid should fail")
+	}
+	if err = AddPlaybookEntry(ctx, makePlaybookTestEntry(uuid.New().String(), "ls")); err == nil {
+		t.Errorf("adding an entry to a missing playbook should fail")
+	}
+	if err = AddPlaybookEntry(ctx, &PlaybookEntry{PlaybookId: playbook.PlaybookId, CmdStr: "ls"}); err == nil {
+		t.Errorf("adding an entry without an entryid should fail")
+	}
+
+	// entries are returned in the order they were added, with all of their fields
+	rtnPlaybook, err := GetPlaybookById(ctx, playbook.PlaybookId)
+	if err != nil || rtnPlaybook == nil {
+		t.Fatalf("cannot get playbook: %v", err)
+	}
+	if rtnPlaybook.PlaybookName != "deploy" || rtnPlaybook.Description != "deploy the app" {
+		t.Errorf("got playbook %+v", *rtnPlaybook)
+	}
+	if !reflect.DeepEqual(rtnPlaybook.Entries, entries) {
+		t.Errorf("got entries %v, expected %v", rtnPlaybook.Entries, entries)
+	}
+
+	// GetAllPlaybooks only returns the entryids
+	allPlaybooks, err := GetAllPlaybooks(ctx)
+	if err != nil || len(allPlaybooks) != 1 {
+		t.Fatalf("cannot get all playbooks: %v", err)
+	}
+	expectedIds := []string{entries[0].EntryId, entries[1].EntryId, entries[2].EntryId}
+	if !reflect.DeepEqual(allPlaybooks[0].EntryIds, expectedIds) || len(allPlaybooks[0].Entries) != 0 {
+		t.Errorf("got all playbooks entryids %v (%d entries), expected %v", allPlaybooks[0].EntryIds, len(allPlaybooks[0].Entries), expectedIds)
+	}
+
+	err = RemovePlaybookEntry(ctx, playbook.PlaybookId, entries[1].EntryId)
+	if err != nil {
+		t.Fatalf("cannot remove playbook entry: %v", err)
+	}
+	cmdStrs := getPlaybookTestCmdStrs(t, playbook.PlaybookId)
+	if !reflect.DeepEqual(cmdStrs, []string{"make build", "make deploy"}) {
+		t.Errorf("after remove got %v", cmdStrs)
+	}
+	if err = RemovePlaybookEntry(ctx, playbook.PlaybookId, entries[1].EntryId); err == nil {
+		t.Errorf("removing a missing entry should fail")
+	}
+	other, err := CreatePlaybook(ctx, "other", "")
+	if err != nil {
+		t.Fatalf("cannot create playbook: %v", err)
+	}
+	if err = RemovePlaybookEntry(ctx, other.PlaybookId, entries[0].EntryId); err == nil {
+		t.Errorf("removing an entry from another playbook should fail")
+	}
+	if err = RemovePlaybookEntry(ctx, uuid.New().String(), entries[0].EntryId); err == nil {
+		t.Errorf("removing an entry from a missing playbook should fail")
+	}
+	cmdStrs = getPlaybookTestCmdStrs(t, playbook.PlaybookId)
+	if !reflect.DeepEqual(cmdStrs, []string{"make build", "make deploy"}) {
+		t.Errorf("after failed removes got %v", cmdStrs)
+	}
+
+	missing, err := GetPlaybookById(ctx, uuid.New().String())
+	if err != nil || missing != nil {
+		t.Errorf("getting a missing playbook should return nil, got %v (%v)", missing, err)
+	}
+}
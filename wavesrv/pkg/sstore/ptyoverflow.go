@@ -0,0 +1,524 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// when overflow is enabled (budget > 0), output that is pushed out of the front of the (fixed size)
+// ptyout cirfile is saved instead of lost.  it is appended (uncompressed) to the pending file, and once
+// that reaches PtyOverflowBlockSize it is compressed (zstd) and appended to the overflow file as one block.
+// overflow data always starts at offset 0 and is contiguous, so once the budget is used up (or if the
+// cirfile had already dropped output when overflow was enabled) the rest of the dropped output is lost.
+// ReadFullPtyOutFile stitches the overflow and the cirfile data back together.
+//
+// the header tag (OVF1) identifies the codec (zstd), another one can be added as a new tag later.
+const PtyOverflowBlockSize = 256 * 1024
+const PtyOverflowBlockHeaderFmt = "OVF1 %d %d\n" // [rawlen] [complen]
+const PtyOverflowGapFmt = "\r\n[... %s of output not saved ...]\r\n"
+
+// a block is the pending data plus at most one write, larger sizes in a block header mean a corrupt file
+const PtyOverflowMaxBlockSize = PtyOverflowBlockSize + shexec.MaxMaxPtySize
+
+var ptyOverflowLock = &sync.Mutex{} // protects ptyOverflowBudget and ptyOverflowLines
+var ptyOverflowBudget int64
+var ptyOverflowLines = make(map[string]*ptyOverflowLine) // key is screenid:lineid
+
+// EncodeAll and DecodeAll are safe for concurrent use
+var ptyOverflowEncoder, _ = zstd.NewWriter(nil)
+var ptyOverflowDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+// Lock serializes the writes (and compression) of one line's overflow files.  it is held across the
+// cirfile write and the overflow write (and by readers across the overflow snapshot and the cirfile read),
+// so output is always in one or the other.  it is taken before the line's cirfile is locked.
+type ptyOverflowLine struct {
+	Lock *sync.Mutex
+	Info *ptyOverflowInfo // nil until loaded, protected by Lock
+}
+
+type ptyOverflowInfo struct {
+	RawLen     int64 // uncompressed size of the blocks in the overflow file
+	CompLen    int64 // size of the overflow file
+	PendingLen int64 // size of the pending file
+}
+
+func (info *ptyOverflowInfo) totalRawLen() int64 {
+	return info.RawLen + info.PendingLen
+}
+
+func (info *ptyOverflowInfo) diskSize() int64 {
+	return info.CompLen + info.PendingLen
+}
+
+// max bytes of overflow data (on disk) per command, 0 disables overflow
+func SetPtyOverflowBudget(budget int64) {
+	ptyOverflowLock.Lock()
+	defer ptyOverflowLock.Unlock()
+	if budget < 0 {
+		budget = 0
+	}
+	ptyOverflowBudget = budget
+}
+
+func GetPtyOverflowBudget() int64 {
+	ptyOverflowLock.Lock()
+	defer ptyOverflowLock.Unlock()
+	return ptyOverflowBudget
+}
+
+func ptyOverflowKey(screenId string, lineId string) string {
+	return screenId + ":" + lineId
+}
+
+func getPtyOverflowLine(screenId string, lineId string) *ptyOverflowLine {
+	ptyOverflowLock.Lock()
+	defer ptyOverflowLock.Unlock()
+	key := ptyOverflowKey(screenId, lineId)
+	ol := ptyOverflowLines[key]
+	if ol == nil {
+		ol = &ptyOverflowLine{Lock: &sync.Mutex{}}
+		ptyOverflowLines[key] = ol
+	}
+	return ol
+}
+
+func fileSizeOrZero(fileName string) (int64, error) {
+	finfo, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return finfo.Size(), nil
+}
+
+// calls blockFn for each block in the overflow file (compData is nil if readData is false).  returns the
+// size of the valid part of the file (a partially written block at the end is ignored)
+func scanPtyOverflowFile(fileName string, readData bool, blockFn func(rawLen int64, compData []byte) error) (int64, error) {
+	fd, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	br := bufio.NewReader(fd)
+	var validLen int64
+	for {
+		headerLine, err := br.ReadString('\n')
+		if err == io.EOF {
+			return validLen, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		var rawLen, compLen int64
+		_, err = fmt.Sscanf(headerLine, PtyOverflowBlockHeaderFmt, &rawLen, &compLen)
+		if err != nil || rawLen < 0 || compLen < 0 {
+			return validLen, nil
+		}
+		var compData []byte
+		if readData {
+			compData = make([]byte, compLen)
+			_, err = io.ReadFull(br, compData)
+			if err != nil {
+				return validLen, nil
+			}
+		} else {
+			nd, err := br.Discard(int(compLen))
+			if int64(nd) < compLen || err != nil {
+				return validLen, nil
+			}
+		}
+		err = blockFn(rawLen, compData)
+		if err != nil {
+			return 0, err
+		}
+		validLen += int64(len(headerLine)) + compLen
+	}
+}
+
+// must hold ol.Lock
+func getPtyOverflowInfo(screenId string, lineId string, ol *ptyOverflowLine) (*ptyOverflowInfo, error) {
+	if ol.Info != nil {
+		return ol.Info, nil
+	}
+	overflowFileName, err := scbase.PtyOverflowFile(screenId, lineId)
+	if err != nil {
+		return nil, err
+	}
+	pendingFileName, err := scbase.PtyOverflowPendingFile(screenId, lineId)
+	if err != nil {
+		return nil, err
+	}
+	info := &ptyOverflowInfo{}
+	validLen, err := scanPtyOverflowFile(overflowFileName, false, func(rawLen int64, compData []byte) error {
+		info.RawLen += rawLen
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fileSize, err := fileSizeOrZero(overflowFileName)
+	if err != nil {
+		return nil, err
+	}
+	if fileSize > validLen {
+		// partial write of the last block, drop it
+		err = os.Truncate(overflowFileName, validLen)
+		if err != nil {
+			return nil, err
+		}
+	}
+	info.CompLen = validLen
+	info.PendingLen, err = fileSizeOrZero(pendingFileName)
+	if err != nil {
+		return nil, err
+	}
+	ol.Info = info
+	return info, nil
+}
+
+func appendToFile(fileName string, data []byte) error {
+	fd, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(data)
+	closeErr := fd.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// compresses the pending file into a new block.  must hold the line's Lock
+func flushPtyOverflowPending(screenId string, lineId string, info *ptyOverflowInfo) error {
+	if info.PendingLen == 0 {
+		return nil
+	}
+	overflowFileName, err := scbase.PtyOverflowFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	pendingFileName, err := scbase.PtyOverflowPendingFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	pendingData, err := os.ReadFile(pendingFileName)
+	if err != nil {
+		return err
+	}
+	compData := ptyOverflowEncoder.EncodeAll(pendingData, nil)
+	header := fmt.Sprintf(PtyOverflowBlockHeaderFmt, len(pendingData), len(compData))
+	err = appendToFile(overflowFileName, append([]byte(header), compData...))
+	if err != nil {
+		return err
+	}
+	err = os.Remove(pendingFileName)
+	if err != nil {
+		return err
+	}
+	info.RawLen += int64(len(pendingData))
+	info.CompLen += int64(len(header) + len(compData))
+	info.PendingLen = 0
+	return nil
+}
+
+// writes data to the ptyout cirfile at pos, the data it pushes out of the front of the cirfile is saved
+// to the overflow files.  the overflow is written after the cirfile write (not from inside it) so the
+// cirfile is not locked while a block is compressed, the line's Lock is held across both.
+func writePtyOutWithOverflow(ctx context.Context, screenId string, lineId string, f *cirfile.File, data []byte, pos int64) error {
+	ol := getPtyOverflowLine(screenId, lineId)
+	ol.Lock.Lock()
+	defer ol.Lock.Unlock()
+	var overflowOffset int64
+	var overflowData []byte
+	err := f.WriteAtWithOverflow(ctx, data, pos, func(offset int64, dropData []byte) error {
+		overflowOffset, overflowData = offset, dropData
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = writePtyOverflow_nolock(screenId, lineId, ol, overflowOffset, overflowData)
+	if err != nil {
+		// just log, never fail the pty write
+		log.Printf("error writing ptyout overflow %s/%s: %v\n", screenId, lineId, err)
+	}
+	return nil
+}
+
+// saves data (at cirfile offset) that was pushed out of the ptyout file.  data that does not continue
+// the saved overflow (out of order, or after a gap) is dropped.  must hold ol.Lock
+func writePtyOverflow_nolock(screenId string, lineId string, ol *ptyOverflowLine, offset int64, data []byte) error {
+	budget := GetPtyOverflowBudget()
+	if budget <= 0 || len(data) == 0 {
+		return nil
+	}
+	info, err := getPtyOverflowInfo(screenId, lineId, ol)
+	if err != nil {
+		return err
+	}
+	if offset != info.totalRawLen() {
+		return nil
+	}
+	if info.diskSize()+int64(len(data)) > budget {
+		// compress what we have to make room
+		err = flushPtyOverflowPending(screenId, lineId, info)
+		if err != nil {
+			return err
+		}
+	}
+	available := budget - info.diskSize()
+	if available <= 0 {
+		return nil
+	}
+	if int64(len(data)) > available {
+		data = data[:available]
+	}
+	pendingFileName, err := scbase.PtyOverflowPendingFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	err = appendToFile(pendingFileName, data)
+	if err != nil {
+		// the pending file may be partially written, re-read the sizes next time
+		ol.Info = nil
+		return err
+	}
+	info.PendingLen += int64(len(data))
+	if info.PendingLen >= PtyOverflowBlockSize {
+		return flushPtyOverflowPending(screenId, lineId, info)
+	}
+	return nil
+}
+
+// returns the size of the valid part of the overflow file, the uncompressed size of its blocks, and the
+// pending data.  the overflow file is append-only, so its first compLen bytes can be read without the lock.
+// must hold ol.Lock
+func snapshotPtyOverflow_nolock(screenId string, lineId string, ol *ptyOverflowLine) (int64, int64, []byte, error) {
+	info, err := getPtyOverflowInfo(screenId, lineId, ol)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if info.PendingLen == 0 {
+		return info.CompLen, info.RawLen, nil, nil
+	}
+	pendingFileName, err := scbase.PtyOverflowPendingFile(screenId, lineId)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	pendingData, err := os.ReadFile(pendingFileName)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return info.CompLen, info.RawLen, pendingData[:info.PendingLen], nil
+}
+
+// decompresses the blocks of an overflow file one at a time (only the current block is held in memory)
+type ptyOverflowReader struct {
+	br    *bufio.Reader
+	block *bytes.Reader // uncompressed data of the current block
+}
+
+func (r *ptyOverflowReader) Read(buf []byte) (int, error) {
+	for {
+		if r.block != nil && r.block.Len() > 0 {
+			return r.block.Read(buf)
+		}
+		headerLine, err := r.br.ReadString('\n')
+		if err == io.EOF && headerLine == "" {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		var rawLen, compLen int64
+		_, err = fmt.Sscanf(headerLine, PtyOverflowBlockHeaderFmt, &rawLen, &compLen)
+		if err != nil || rawLen < 0 || compLen < 0 {
+			return 0, fmt.Errorf("invalid ptyout overflow block header %q", headerLine)
+		}
+		if rawLen > PtyOverflowMaxBlockSize || compLen > PtyOverflowMaxBlockSize {
+			return 0, fmt.Errorf("invalid ptyout overflow block size %d/%d", rawLen, compLen)
+		}
+		compData := make([]byte, compLen)
+		_, err = io.ReadFull(r.br, compData)
+		if err != nil {
+			return 0, err
+		}
+		blockData, err := ptyOverflowDecoder.DecodeAll(compData, make([]byte, 0, rawLen))
+		if err != nil {
+			return 0, fmt.Errorf("cannot decompress ptyout overflow block: %v", err)
+		}
+		if int64(len(blockData)) != rawLen {
+			return 0, fmt.Errorf("invalid ptyout overflow block, got %d bytes, expected %d", len(blockData), rawLen)
+		}
+		r.block = bytes.NewReader(blockData)
+	}
+}
+
+type ptyOutReadCloser struct {
+	io.Reader
+	closeFn func() error
+}
+
+func (rc ptyOutReadCloser) Close() error {
+	if rc.closeFn == nil {
+		return nil
+	}
+	return rc.closeFn()
+}
+
+// returns (real-offset, reader, err) for all saved output of the line, the overflow followed by the
+// cirfile data.  the overflow is decompressed while the reader is read, so large histories are not
+// held in memory.  the offsets line up with the cirfile (real-offset + the length of the data is the
+// cirfile's end offset), output that was not saved is replaced by a marker.
+func ReadFullPtyOutFile(ctx context.Context, screenId string, lineId string) (int64, io.ReadCloser, error) {
+	// the overflow snapshot and the cirfile data are read under the line's Lock, so a concurrent write
+	// cannot push output out of the cirfile in between
+	ol := getPtyOverflowLine(screenId, lineId)
+	ol.Lock.Lock()
+	compLen, rawLen, pendingData, overflowErr := snapshotPtyOverflow_nolock(screenId, lineId, ol)
+	realOffset, data, err := readPtyOutCirFile(ctx, screenId, lineId)
+	ol.Lock.Unlock()
+	if err != nil {
+		return 0, nil, err
+	}
+	cirData := ptyOutReadCloser{Reader: bytes.NewReader(data)}
+	if overflowErr != nil {
+		log.Printf("error reading ptyout overflow %s/%s: %v\n", screenId, lineId, overflowErr)
+		return realOffset, cirData, nil
+	}
+	overflowLen := rawLen + int64(len(pendingData))
+	if realOffset == 0 || overflowLen == 0 {
+		return realOffset, cirData, nil
+	}
+	if overflowLen > realOffset {
+		overflowLen = realOffset
+	}
+	overflowFileName, err := scbase.PtyOverflowFile(screenId, lineId)
+	if err != nil {
+		return 0, nil, err
+	}
+	var blockReader io.Reader = bytes.NewReader(nil)
+	var closeFn func() error
+	if compLen > 0 {
+		fd, err := os.Open(overflowFileName)
+		if err != nil {
+			return 0, nil, err
+		}
+		blockReader = &ptyOverflowReader{br: bufio.NewReader(io.LimitReader(fd, compLen))}
+		closeFn = fd.Close
+	}
+	marker := ptyOverflowGapMarker(realOffset - overflowLen)
+	overflowReader := io.LimitReader(io.MultiReader(blockReader, bytes.NewReader(pendingData)), overflowLen)
+	rtn := ptyOutReadCloser{
+		Reader:  io.MultiReader(overflowReader, strings.NewReader(marker), bytes.NewReader(data)),
+		closeFn: closeFn,
+	}
+	return realOffset - overflowLen - int64(len(marker)), rtn, nil
+}
+
+// like ReadFullPtyOutFile but returns the data, returns (real-offset, data, err)
+func ReadFullPtyOutData(ctx context.Context, screenId string, lineId string) (int64, []byte, error) {
+	realOffset, rc, err := ReadFullPtyOutFile(ctx, screenId, lineId)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot read ptyout overflow: %v", err)
+	}
+	return realOffset, data, nil
+}
+
+// marks output that was not saved between the overflow and the cirfile data.  the marker replaces
+// (part of) the gap so the offsets still line up, it is left out if it does not fit.
+func ptyOverflowGapMarker(gap int64) string {
+	if gap <= 0 {
+		return ""
+	}
+	marker := fmt.Sprintf(PtyOverflowGapFmt, scbase.NumFormatB2(gap))
+	if int64(len(marker)) > gap {
+		return ""
+	}
+	return marker
+}
+
+func deletePtyOverflowFiles(screenId string, lineId string) error {
+	ol := getPtyOverflowLine(screenId, lineId)
+	ol.Lock.Lock()
+	defer ol.Lock.Unlock()
+	ol.Info = nil
+	ptyOverflowLock.Lock()
+	delete(ptyOverflowLines, ptyOverflowKey(screenId, lineId))
+	ptyOverflowLock.Unlock()
+	overflowFileName, err := scbase.PtyOverflowFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	pendingFileName, err := scbase.PtyOverflowPendingFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range []string{overflowFileName, pendingFileName} {
+		err = os.Remove(fileName)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runs fn while no overflow data is written for the line (so the overflow files are consistent)
+func withPtyOverflowLock(screenId string, lineId string, fn func() error) error {
+	ol := getPtyOverflowLine(screenId, lineId)
+	ol.Lock.Lock()
+	defer ol.Lock.Unlock()
+	return fn()
+}
+
+// the line's files were moved or replaced, re-read the sizes on the next write
+func clearPtyOverflowCache(screenId string, lineId string) {
+	ol := getPtyOverflowLine(screenId, lineId)
+	ol.Lock.Lock()
+	defer ol.Lock.Unlock()
+	ol.Info = nil
+}
+
+func clearScreenPtyOverflowCache(screenId string) {
+	var lines []*ptyOverflowLine
+	ptyOverflowLock.Lock()
+	for key, ol := range ptyOverflowLines {
+		if strings.HasPrefix(key, screenId+":") {
+			lines = append(lines, ol)
+		}
+	}
+	ptyOverflowLock.Unlock()
+	for _, ol := range lines {
+		ol.Lock.Lock()
+		ol.Info = nil
+		ol.Lock.Unlock()
+	}
+}
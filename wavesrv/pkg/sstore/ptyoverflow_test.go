@@ -0,0 +1,173 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// pty writes are much smaller than the cirfile (a write larger than the whole cirfile would skip the overflow)
+const testCirSize = 64 * 1024
+const testOverflowScreenId = "9a5e8c86-4b1f-4c61-a1d4-3c0f2b6b7a11"
+
+// sets a new (temp) wave home for tests that only use ptyout files (no db)
+func initTestPtyOutHome(t *testing.T) {
+	os.Setenv("WAVETERM_HOME", t.TempDir())
+	// the screen dir and the overflow sizes are cached, they have to be re-read in the new home
+	scbase.ClearScreenDirCache(testOverflowScreenId)
+	clearScreenPtyOverflowCache(testOverflowScreenId)
+}
+
+// writes data to a new ptyout file (in chunks) the way AppendToCmdPtyBlob does
+func writeTestPtyOut(t *testing.T, lineId string, data []byte) {
+	ctx := context.Background()
+	err := CreateCmdPtyFile(ctx, testOverflowScreenId, lineId, testCirSize)
+	if err != nil {
+		t.Fatalf("cannot create ptyout file: %v", err)
+	}
+	fileName, _ := scbase.PtyOutFile(testOverflowScreenId, lineId)
+	f, err := cirfile.OpenCirFile(fileName)
+	if err != nil {
+		t.Fatalf("cannot open ptyout file: %v", err)
+	}
+	defer f.Close()
+	const chunkSize = 4 * 1024
+	for pos := 0; pos < len(data); pos += chunkSize {
+		end := pos + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		err = writePtyOutWithOverflow(ctx, testOverflowScreenId, lineId, f, data[pos:end], int64(pos))
+		if err != nil {
+			t.Fatalf("cannot write ptyout file: %v", err)
+		}
+	}
+}
+
+func TestPtyOverflow(t *testing.T) {
+	initTestPtyOutHome(t)
+	SetPtyOverflowBudget(1024 * 1024)
+	defer SetPtyOverflowBudget(0)
+	ctx := context.Background()
+
+	// compressible output, everything fits in the budget (one compressed block + pending data)
+	data := []byte(strings.Repeat("line of build output\n", 20000))
+	lineId := "1d1f4c55-8f8e-4f57-9a42-2b8e0c7f3f01"
+	writeTestPtyOut(t, lineId, data)
+	realOffset, cirData, err := readPtyOutCirFile(ctx, testOverflowScreenId, lineId)
+	if err != nil || realOffset != int64(len(data))-testCirSize || !bytes.Equal(cirData, data[realOffset:]) {
+		t.Fatalf("readPtyOutCirFile got offset %d len %d err %v", realOffset, len(cirData), err)
+	}
+	realOffset, fullData, err := ReadFullPtyOutData(ctx, testOverflowScreenId, lineId)
+	if err != nil || realOffset != 0 || !bytes.Equal(fullData, data) {
+		t.Fatalf("ReadFullPtyOutData got offset %d len %d err %v, expected the full output", realOffset, len(fullData), err)
+	}
+	// blocks are zstd frames
+	overflowFileName, _ := scbase.PtyOverflowFile(testOverflowScreenId, lineId)
+	var numBlocks int
+	_, err = scanPtyOverflowFile(overflowFileName, true, func(rawLen int64, compData []byte) error {
+		numBlocks++
+		if !bytes.HasPrefix(compData, []byte{0x28, 0xb5, 0x2f, 0xfd}) || int64(len(compData)) >= rawLen/10 {
+			t.Errorf("overflow block %d is not zstd compressed (%d => %d bytes)", numBlocks, rawLen, len(compData))
+		}
+		return nil
+	})
+	if err != nil || numBlocks != 1 {
+		t.Fatalf("overflow file has %d blocks, err %v", numBlocks, err)
+	}
+
+	// incompressible output, the budget runs out and the rest is replaced by the gap marker
+	SetPtyOverflowBudget(50 * 1024)
+	data = make([]byte, 400*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	lineId = "1d1f4c55-8f8e-4f57-9a42-2b8e0c7f3f02"
+	writeTestPtyOut(t, lineId, data)
+	realOffset, fullData, err = ReadFullPtyOutData(ctx, testOverflowScreenId, lineId)
+	if err != nil {
+		t.Fatalf("ReadFullPtyOutData error: %v", err)
+	}
+	// the offset is moved up so that the end of the data still lines up with the cirfile
+	if realOffset <= 0 || realOffset+int64(len(fullData)) != int64(len(data)) {
+		t.Fatalf("ReadFullPtyOutData got offset %d len %d, expected the offsets to line up", realOffset, len(fullData))
+	}
+	markerIdx := bytes.Index(fullData, []byte("of output not saved"))
+	if markerIdx < 1024 || !bytes.Equal(fullData[:1024], data[:1024]) || !bytes.Equal(fullData[len(fullData)-testCirSize:], data[len(data)-testCirSize:]) {
+		t.Fatalf("ReadFullPtyOutData expected saved overflow + marker (at %d) + cirfile data", markerIdx)
+	}
+
+	err = deletePtyOverflowFiles(testOverflowScreenId, lineId)
+	if err != nil {
+		t.Fatalf("cannot delete overflow files: %v", err)
+	}
+	realOffset, fullData, err = ReadFullPtyOutData(ctx, testOverflowScreenId, lineId)
+	if err != nil || realOffset != int64(len(data))-testCirSize || len(fullData) != testCirSize {
+		t.Fatalf("after delete got offset %d len %d err %v, expected only the cirfile data", realOffset, len(fullData), err)
+	}
+}
+
+// reads while the output is written, every read must return all of the output so far (no gaps between
+// the overflow and the cirfile data)
+func TestPtyOverflowConcurrentRead(t *testing.T) {
+	initTestPtyOutHome(t)
+	SetPtyOverflowBudget(1024 * 1024)
+	defer SetPtyOverflowBudget(0)
+	ctx := context.Background()
+	var data []byte
+	for idx := 0; len(data) < 600*1024; idx++ {
+		data = append(data, fmt.Sprintf("line %d of build output\n", idx)...)
+	}
+	lineId := "1d1f4c55-8f8e-4f57-9a42-2b8e0c7f3f03"
+	err := CreateCmdPtyFile(ctx, testOverflowScreenId, lineId, testCirSize)
+	if err != nil {
+		t.Fatalf("cannot create ptyout file: %v", err)
+	}
+	fileName, _ := scbase.PtyOutFile(testOverflowScreenId, lineId)
+	f, err := cirfile.OpenCirFile(fileName)
+	if err != nil {
+		t.Fatalf("cannot open ptyout file: %v", err)
+	}
+	defer f.Close()
+	writeDone := make(chan bool)
+	go func() {
+		defer close(writeDone)
+		const chunkSize = 1024
+		for pos := 0; pos < len(data); pos += chunkSize {
+			end := pos + chunkSize
+			if end > len(data) {
+				end = len(data)
+			}
+			err := writePtyOutWithOverflow(ctx, testOverflowScreenId, lineId, f, data[pos:end], int64(pos))
+			if err != nil {
+				t.Errorf("cannot write ptyout file: %v", err)
+				return
+			}
+		}
+	}()
+	for numReads := 0; ; numReads++ {
+		select {
+		case <-writeDone:
+			if numReads == 0 {
+				t.Logf("writes finished before the first read")
+			}
+			return
+		default:
+		}
+		realOffset, fullData, err := ReadFullPtyOutData(ctx, testOverflowScreenId, lineId)
+		if err != nil {
+			t.Fatalf("read %d: error: %v", numReads, err)
+		}
+		if realOffset != 0 || !bytes.Equal(fullData, data[:len(fullData)]) {
+			t.Fatalf("read %d: got offset %d len %d, expected all of the output so far", numReads, realOffset, len(fullData))
+		}
+	}
+}
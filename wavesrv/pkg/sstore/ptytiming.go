@@ -0,0 +1,280 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// the timing file is a sidecar to the ptyout cirfile.  every write to the ptyout file appends
+// one line: "[ts-ms] [pos] [len]\n".  pos is the (absolute) cirfile offset of the write.
+// lines that cannot be parsed (e.g. a partial write) are skipped.
+const PtyTimingLineFmt = "%d %d %d\n"
+
+// when the timing file grows past PtyTimingCompactSize it is rewritten without the entries for data
+// that has been overwritten in the cirfile.  if it still has more than PtyTimingMaxEntries, adjacent
+// entries are merged (losing some timing resolution).
+const PtyTimingCompactSize = 256 * 1024
+const PtyTimingMaxEntries = 4 * 1024
+const PtyTimingInitialMergeMs = 100
+
+// real-offset returned by the time range reads when there is no output for the range (no writes
+// in the range, or the output has since been overwritten in the cirfile)
+const PtyOutNoData = -1
+
+type PtyTimingEntry struct {
+	Ts  int64 `json:"ts"`
+	Pos int64 `json:"pos"`
+	Len int64 `json:"len"`
+}
+
+func (e PtyTimingEntry) EndPos() int64 {
+	return e.Pos + e.Len
+}
+
+func formatPtyTiming(entries []PtyTimingEntry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		buf.WriteString(fmt.Sprintf(PtyTimingLineFmt, entry.Ts, entry.Pos, entry.Len))
+	}
+	return buf.Bytes()
+}
+
+func parsePtyTiming(data []byte) []PtyTimingEntry {
+	var rtn []PtyTimingEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		ts, err1 := strconv.ParseInt(fields[0], 10, 64)
+		pos, err2 := strconv.ParseInt(fields[1], 10, 64)
+		dataLen, err3 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || pos < 0 || dataLen < 0 {
+			continue
+		}
+		rtn = append(rtn, PtyTimingEntry{Ts: ts, Pos: pos, Len: dataLen})
+	}
+	return rtn
+}
+
+func appendPtyTiming(screenId string, lineId string, entries []PtyTimingEntry) error {
+	_, err := appendPtyTimingWithSize(screenId, lineId, entries)
+	return err
+}
+
+// returns the new size of the timing file
+func appendPtyTimingWithSize(screenId string, lineId string, entries []PtyTimingEntry) (int64, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	timingFileName, err := scbase.PtyTimingFile(screenId, lineId)
+	if err != nil {
+		return 0, err
+	}
+	fd, err := os.OpenFile(timingFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	_, err = fd.Write(formatPtyTiming(entries))
+	if err != nil {
+		return 0, err
+	}
+	finfo, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return finfo.Size(), nil
+}
+
+// drops entries for data before fileOffset (overwritten by the circular buffer), then merges
+// contiguous entries (with a growing time window) until there are at most maxEntries.
+// entries must be sorted by ts.
+func compactPtyTiming(entries []PtyTimingEntry, fileOffset int64, maxEntries int) []PtyTimingEntry {
+	var rtn []PtyTimingEntry
+	for _, entry := range entries {
+		if entry.EndPos() <= fileOffset {
+			continue
+		}
+		rtn = append(rtn, entry)
+	}
+	mergeMs := int64(PtyTimingInitialMergeMs)
+	for len(rtn) > maxEntries {
+		var merged []PtyTimingEntry
+		for _, entry := range rtn {
+			if len(merged) > 0 {
+				last := &merged[len(merged)-1]
+				if last.EndPos() == entry.Pos && entry.Ts-last.Ts < mergeMs {
+					last.Len += entry.Len
+					continue
+				}
+			}
+			merged = append(merged, entry)
+		}
+		if len(merged) == len(rtn) && mergeMs > 24*60*60*1000 {
+			// nothing left to merge (non-contiguous writes)
+			break
+		}
+		rtn = merged
+		mergeMs *= 2
+	}
+	return rtn
+}
+
+// rewrites the timing file (via a temp file + rename so a crash cannot lose the whole file)
+func compactPtyTimingFile(screenId string, lineId string, fileOffset int64) error {
+	timingFileName, err := scbase.PtyTimingFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	entries, err := ReadPtyTiming(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	entries = compactPtyTiming(entries, fileOffset, PtyTimingMaxEntries)
+	tmpFileName := timingFileName + ".tmp"
+	err = os.WriteFile(tmpFileName, formatPtyTiming(entries), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, timingFileName)
+}
+
+// records a write to the ptyout file.  fileOffset is the cirfile offset after the write, used to
+// drop timing entries for overwritten data when the timing file gets large.
+func recordPtyTiming(screenId string, lineId string, entry PtyTimingEntry, fileOffset int64) error {
+	size, err := appendPtyTimingWithSize(screenId, lineId, []PtyTimingEntry{entry})
+	if err != nil {
+		return err
+	}
+	if size < PtyTimingCompactSize {
+		return nil
+	}
+	return compactPtyTimingFile(screenId, lineId, fileOffset)
+}
+
+// returns the timing entries for the line sorted by ts (nil if the line has no timing file,
+// e.g. it was created before timing was recorded)
+func ReadPtyTiming(screenId string, lineId string) ([]PtyTimingEntry, error) {
+	timingFileName, err := scbase.PtyTimingFile(screenId, lineId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(timingFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rtn := parsePtyTiming(data)
+	sort.SliceStable(rtn, func(i int, j int) bool { return rtn[i].Ts < rtn[j].Ts })
+	return rtn, nil
+}
+
+func deletePtyTimingFile(screenId string, lineId string) error {
+	timingFileName, err := scbase.PtyTimingFile(screenId, lineId)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(timingFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// returns the ts of the write that produced the output at pos (0 if unknown).
+// entries must be sorted by ts.  the last write wins if pos was overwritten.
+func PtyTimeAtPos(entries []PtyTimingEntry, pos int64) int64 {
+	var rtn int64
+	for _, entry := range entries {
+		if pos >= entry.Pos && pos < entry.EndPos() {
+			rtn = entry.Ts
+		}
+	}
+	return rtn
+}
+
+// reads the [startPos, endPos) range of the ptyout file.  returns (real-offset, data, err), the data
+// is truncated at the front if the range was (partially) overwritten by the circular buffer.
+// returns PtyOutNoData if the range is empty or was completely overwritten.
+func readPtyOutRange(ctx context.Context, screenId string, lineId string, startPos int64, endPos int64) (int64, []byte, error) {
+	if endPos <= startPos {
+		return PtyOutNoData, nil, nil
+	}
+	realOffset, data, err := ReadPtyOutFile(ctx, screenId, lineId, startPos, endPos-startPos)
+	if err != nil {
+		return 0, nil, err
+	}
+	if realOffset >= endPos {
+		return PtyOutNoData, nil, nil
+	}
+	if realOffset+int64(len(data)) > endPos {
+		data = data[:endPos-realOffset]
+	}
+	return realOffset, data, nil
+}
+
+// returns the output written between startTs and endTs (ms, inclusive).  returns (real-offset, data, err).
+// the range spans from the first to the last matching write, so overwrites in between are included.
+// real-offset is PtyOutNoData if no output was written in the range (or it was overwritten).
+func ReadPtyOutBetween(ctx context.Context, screenId string, lineId string, startTs int64, endTs int64) (int64, []byte, error) {
+	entries, err := ReadPtyTiming(screenId, lineId)
+	if err != nil {
+		return 0, nil, err
+	}
+	startPos, endPos := int64(-1), int64(-1)
+	for _, entry := range entries {
+		if entry.Ts < startTs || entry.Ts > endTs || entry.Len == 0 {
+			continue
+		}
+		if startPos == -1 || entry.Pos < startPos {
+			startPos = entry.Pos
+		}
+		if entry.EndPos() > endPos {
+			endPos = entry.EndPos()
+		}
+	}
+	if startPos == -1 {
+		return PtyOutNoData, nil, nil
+	}
+	return readPtyOutRange(ctx, screenId, lineId, startPos, endPos)
+}
+
+// returns the output as it was at time ts (ms), everything written up to and including ts.
+// returns (real-offset, data, err).  lines without timing information return all output.
+// real-offset is PtyOutNoData if there was no output yet at ts (or it was overwritten).
+func ReadPtyOutAtTime(ctx context.Context, screenId string, lineId string, ts int64) (int64, []byte, error) {
+	entries, err := ReadPtyTiming(screenId, lineId)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(entries) == 0 {
+		return ReadFullPtyOutData(ctx, screenId, lineId)
+	}
+	var endPos int64
+	for _, entry := range entries {
+		if entry.Ts > ts {
+			break
+		}
+		if entry.EndPos() > endPos {
+			endPos = entry.EndPos()
+		}
+	}
+	return readPtyOutRange(ctx, screenId, lineId, 0, endPos)
+}
@@ -0,0 +1,129 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+const ptyTimingTestBaseTs = 1700000000000
+const ptyTimingTestChunkSize = 16 * 1024
+
+// writes numChunks chunks (chunk idx is filled with 'a'+idx) to a new ptyout file, chunk idx is written at base ts + idx seconds.
+// the cirfile is testCirSize, so only the last 4 chunks are kept and the later chunks wrap around the end of the file.
+func writePtyTimingTestOut(t *testing.T, lineId string, numChunks int, withTiming bool) {
+	ctx := context.Background()
+	err := CreateCmdPtyFile(ctx, testOverflowScreenId, lineId, testCirSize)
+	if err != nil {
+		t.Fatalf("cannot create ptyout file: %v", err)
+	}
+	fileName, _ := scbase.PtyOutFile(testOverflowScreenId, lineId)
+	f, err := cirfile.OpenCirFile(fileName)
+	if err != nil {
+		t.Fatalf("cannot open ptyout file: %v", err)
+	}
+	defer f.Close()
+	for idx := 0; idx < numChunks; idx++ {
+		pos := int64(idx * ptyTimingTestChunkSize)
+		err = f.WriteAt(ctx, bytes.Repeat([]byte{byte('a' + idx)}, ptyTimingTestChunkSize), pos)
+		if err != nil {
+			t.Fatalf("cannot write ptyout file: %v", err)
+		}
+		if !withTiming {
+			continue
+		}
+		entry := PtyTimingEntry{Ts: ptyTimingTestBaseTs + int64(idx)*1000, Pos: pos, Len: ptyTimingTestChunkSize}
+		err = appendPtyTiming(testOverflowScreenId, lineId, []PtyTimingEntry{entry})
+		if err != nil {
+			t.Fatalf("cannot write ptytiming file: %v", err)
+		}
+	}
+}
+
+// the expected output for chunks [startChunk, endChunk)
+func getPtyTimingTestChunks(startChunk int, endChunk int) []byte {
+	var rtn []byte
+	for idx := startChunk; idx < endChunk; idx++ {
+		rtn = append(rtn, bytes.Repeat([]byte{byte('a' + idx)}, ptyTimingTestChunkSize)...)
+	}
+	return rtn
+}
+
+type ptyTimingTestCase struct {
+	Name           string
+	StartTs        int64 // for ReadPtyOutBetween
+	EndTs          int64 // EndTs for ReadPtyOutBetween, ts for ReadPtyOutAtTime
+	ExpectedOffset int64
+	ExpectedData   []byte
+}
+
+func checkPtyTimingTestRead(t *testing.T, test ptyTimingTestCase, realOffset int64, data []byte, err error) {
+	if err != nil {
+		t.Errorf("%s: error: %v", test.Name, err)
+		return
+	}
+	if realOffset != test.ExpectedOffset || !bytes.Equal(data, test.ExpectedData) {
+		t.Errorf("%s: got offset %d len %d, expected offset %d len %d", test.Name, realOffset, len(data), test.ExpectedOffset, len(test.ExpectedData))
+	}
+}
+
+func TestReadPtyOutBetween(t *testing.T) {
+	initTestPtyOutHome(t)
+	ctx := context.Background()
+	lineId := "5b0e3a9c-2f7d-4d8e-9c1a-6e4f8b2d0c01"
+	// 10 chunks, the cirfile holds chunks 6-9 (chunks 8 and 9 wrapped around to the start of the file)
+	writePtyTimingTestOut(t, lineId, 10, true)
+	const chunkSize = ptyTimingTestChunkSize
+	tests := []ptyTimingTestCase{
+		{"kept", ptyTimingTestBaseTs + 6000, ptyTimingTestBaseTs + 7000, 6 * chunkSize, getPtyTimingTestChunks(6, 8)},
+		{"wraparound", ptyTimingTestBaseTs + 7000, ptyTimingTestBaseTs + 9000, 7 * chunkSize, getPtyTimingTestChunks(7, 10)},
+		{"between writes", ptyTimingTestBaseTs + 7500, ptyTimingTestBaseTs + 8500, 8 * chunkSize, getPtyTimingTestChunks(8, 9)},
+		// the front of the range was overwritten
+		{"partially overwritten", ptyTimingTestBaseTs + 3000, ptyTimingTestBaseTs + 6000, 6 * chunkSize, getPtyTimingTestChunks(6, 7)},
+		{"overwritten", ptyTimingTestBaseTs + 1000, ptyTimingTestBaseTs + 2000, PtyOutNoData, nil},
+		{"before", 0, ptyTimingTestBaseTs - 1, PtyOutNoData, nil},
+		{"after", ptyTimingTestBaseTs + 9001, ptyTimingTestBaseTs + 20000, PtyOutNoData, nil},
+		{"gap", ptyTimingTestBaseTs + 8001, ptyTimingTestBaseTs + 8999, PtyOutNoData, nil},
+	}
+	for _, test := range tests {
+		realOffset, data, err := ReadPtyOutBetween(ctx, testOverflowScreenId, lineId, test.StartTs, test.EndTs)
+		checkPtyTimingTestRead(t, test, realOffset, data, err)
+	}
+
+	// a line without a timing file has no output in any range
+	lineId = "5b0e3a9c-2f7d-4d8e-9c1a-6e4f8b2d0c02"
+	writePtyTimingTestOut(t, lineId, 2, false)
+	realOffset, data, err := ReadPtyOutBetween(ctx, testOverflowScreenId, lineId, 0, ptyTimingTestBaseTs+20000)
+	checkPtyTimingTestRead(t, ptyTimingTestCase{Name: "no timing", ExpectedOffset: PtyOutNoData}, realOffset, data, err)
+}
+
+func TestReadPtyOutAtTime(t *testing.T) {
+	initTestPtyOutHome(t)
+	ctx := context.Background()
+	lineId := "5b0e3a9c-2f7d-4d8e-9c1a-6e4f8b2d0c03"
+	writePtyTimingTestOut(t, lineId, 10, true)
+	const chunkSize = ptyTimingTestChunkSize
+	tests := []ptyTimingTestCase{
+		{Name: "all", EndTs: ptyTimingTestBaseTs + 20000, ExpectedOffset: 6 * chunkSize, ExpectedData: getPtyTimingTestChunks(6, 10)},
+		{Name: "wraparound", EndTs: ptyTimingTestBaseTs + 8000, ExpectedOffset: 6 * chunkSize, ExpectedData: getPtyTimingTestChunks(6, 9)},
+		{Name: "between writes", EndTs: ptyTimingTestBaseTs + 6500, ExpectedOffset: 6 * chunkSize, ExpectedData: getPtyTimingTestChunks(6, 7)},
+		{Name: "overwritten", EndTs: ptyTimingTestBaseTs + 5000, ExpectedOffset: PtyOutNoData},
+		{Name: "before", EndTs: ptyTimingTestBaseTs - 1, ExpectedOffset: PtyOutNoData},
+	}
+	for _, test := range tests {
+		realOffset, data, err := ReadPtyOutAtTime(ctx, testOverflowScreenId, lineId, test.EndTs)
+		checkPtyTimingTestRead(t, test, realOffset, data, err)
+	}
+
+	// without a timing file all of the output is returned
+	lineId = "5b0e3a9c-2f7d-4d8e-9c1a-6e4f8b2d0c04"
+	writePtyTimingTestOut(t, lineId, 6, false)
+	realOffset, data, err := ReadPtyOutAtTime(ctx, testOverflowScreenId, lineId, ptyTimingTestBaseTs)
+	checkPtyTimingTestRead(t, ptyTimingTestCase{Name: "no timing", ExpectedOffset: 2 * chunkSize, ExpectedData: getPtyTimingTestChunks(2, 6)}, realOffset, data, err)
+}
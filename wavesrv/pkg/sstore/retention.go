@@ -0,0 +1,247 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+const (
+	RetentionActionPurge   = "purge"
+	RetentionActionArchive = "archive"
+)
+
+const (
+	RetentionReasonAge      = "age"
+	RetentionReasonLines    = "maxlines"
+	RetentionReasonPtyBytes = "maxptysize"
+)
+
+// stored in ClientOptsType.  a zero value disables a rule.
+// MaxPtyBytes is the total pty output size over all lines (archived lines included).  lines removed
+// to meet it are purged with their output deleted (moving it to the trash would not free any space),
+// so it cannot be used with the archive action.
+type RetentionOptsType struct {
+	MaxAgeDays        int    `json:"maxagedays,omitempty"`
+	MaxPtyBytes       int64  `json:"maxptybytes,omitempty"`
+	MaxLinesPerScreen int    `json:"maxlinesperscreen,omitempty"`
+	KeepStarred       bool   `json:"keepstarred,omitempty"`
+	KeepBookmarked    bool   `json:"keepbookmarked,omitempty"`
+	Action            string `json:"action,omitempty"` // purge (default) or archive
+}
+
+func (opts *RetentionOptsType) IsEnabled() bool {
+	return opts != nil && (opts.MaxAgeDays > 0 || opts.MaxPtyBytes > 0 || opts.MaxLinesPerScreen > 0)
+}
+
+func (opts *RetentionOptsType) GetAction() string {
+	if opts.Action == RetentionActionArchive {
+		return RetentionActionArchive
+	}
+	return RetentionActionPurge
+}
+
+func (opts *RetentionOptsType) Validate() error {
+	if opts.MaxPtyBytes > 0 && opts.GetAction() == RetentionActionArchive {
+		return fmt.Errorf("maxptysize cannot be used with action=%s (archived lines keep their output)", RetentionActionArchive)
+	}
+	return nil
+}
+
+type RetentionLineType struct {
+	ScreenId    string `json:"screenid" db:"screenid"`
+	LineId      string `json:"lineid" db:"lineid"`
+	LineNum     int64  `json:"linenum" db:"linenum"`
+	Ts          int64  `json:"ts" db:"ts"`
+	Star        int    `json:"star" db:"star"`
+	CmdStr      string `json:"cmdstr" db:"cmdstr"`
+	ScreenName  string `json:"screenname" db:"screenname"`
+	SessionName string `json:"sessionname" db:"sessionname"`
+	PtyBytes    int64  `json:"ptybytes" db:"-"`
+	Reason      string `json:"reason" db:"-"`
+}
+
+// total size of the ptyout file and its sidecar files
+func LinePtyDiskSize(screenId string, lineId string) int64 {
+	var rtn int64
+	for _, fileFn := range []func(string, string) (string, error){scbase.PtyOutFile, scbase.PtyTimingFile, scbase.PtyOverflowFile, scbase.PtyOverflowPendingFile} {
+		fileName, err := fileFn(screenId, lineId)
+		if err != nil {
+			continue
+		}
+		finfo, err := os.Stat(fileName)
+		if err != nil {
+			continue
+		}
+		rtn += finfo.Size()
+	}
+	return rtn
+}
+
+// returns the lines that the retention rules would remove (archive or purge), oldest first.
+// running/detached commands are never removed.  with the archive action only non-archived lines
+// on non-archived screens are considered (and counted against the limits).
+func GetRetentionCandidates(ctx context.Context, opts *RetentionOptsType, now time.Time) ([]*RetentionLineType, error) {
+	if !opts.IsEnabled() {
+		return nil, nil
+	}
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	var lines []*RetentionLineType
+	var bookmarkCmds []string
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT l.screenid, l.lineid, l.linenum, l.ts, l.star, COALESCE(c.cmdstr, '') cmdstr, s.name screenname, COALESCE(ss.name, '') sessionname
+		          FROM line l
+		          JOIN screen s ON s.screenid = l.screenid
+		          LEFT JOIN session ss ON ss.sessionid = s.sessionid
+		          LEFT JOIN cmd c ON c.screenid = l.screenid AND c.lineid = l.lineid
+		          WHERE COALESCE(c.status, '') NOT IN (?, ?)`
+		if opts.GetAction() == RetentionActionArchive {
+			query += ` AND NOT l.archived AND NOT s.archived`
+		}
+		query += ` ORDER BY l.ts, l.linenum`
+		tx.Select(&lines, query, CmdStatusRunning, CmdStatusDetached)
+		if opts.KeepBookmarked {
+			bookmarkCmds = tx.SelectStrings(`SELECT cmdstr FROM bookmark`)
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	bookmarkSet := make(map[string]bool)
+	for _, cmdStr := range bookmarkCmds {
+		bookmarkSet[cmdStr] = true
+	}
+	isKept := func(line *RetentionLineType) bool {
+		if opts.KeepStarred && line.Star > 0 {
+			return true
+		}
+		if opts.KeepBookmarked && line.CmdStr != "" && bookmarkSet[line.CmdStr] {
+			return true
+		}
+		return false
+	}
+	if opts.MaxAgeDays > 0 {
+		cutoffTs := now.AddDate(0, 0, -opts.MaxAgeDays).UnixMilli()
+		for _, line := range lines {
+			if line.Ts < cutoffTs && !isKept(line) {
+				line.Reason = RetentionReasonAge
+			}
+		}
+	}
+	if opts.MaxLinesPerScreen > 0 {
+		screenLines := make(map[string][]*RetentionLineType)
+		for _, line := range lines {
+			if line.Reason == "" {
+				screenLines[line.ScreenId] = append(screenLines[line.ScreenId], line)
+			}
+		}
+		for _, slines := range screenLines {
+			sort.Slice(slines, func(i int, j int) bool { return slines[i].LineNum > slines[j].LineNum })
+			for idx, line := range slines {
+				if idx >= opts.MaxLinesPerScreen && !isKept(line) {
+					line.Reason = RetentionReasonLines
+				}
+			}
+		}
+	}
+	var totalBytes int64
+	for _, line := range lines {
+		line.PtyBytes = LinePtyDiskSize(line.ScreenId, line.LineId)
+		if line.Reason == "" {
+			totalBytes += line.PtyBytes
+		}
+	}
+	if opts.MaxPtyBytes > 0 {
+		// lines are sorted oldest first
+		for _, line := range lines {
+			if totalBytes <= opts.MaxPtyBytes {
+				break
+			}
+			if line.Reason != "" || line.PtyBytes == 0 || isKept(line) {
+				continue
+			}
+			line.Reason = RetentionReasonPtyBytes
+			totalBytes -= line.PtyBytes
+		}
+	}
+	var rtn []*RetentionLineType
+	for _, line := range lines {
+		if line.Reason != "" {
+			rtn = append(rtn, line)
+		}
+	}
+	return rtn, nil
+}
+
+// archives or purges the lines, returns an update for the removed/archived lines.
+// the output of lines removed for maxptysize is deleted instead of moved to the trash.
+func ApplyRetention(ctx context.Context, opts *RetentionOptsType, lines []*RetentionLineType) (*ModelUpdate, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	screenLineIds := make(map[string][]string)
+	deleteOutput := make(map[string]bool) // key is [screenid]/[lineid]
+	var screenIds []string
+	for _, line := range lines {
+		if _, found := screenLineIds[line.ScreenId]; !found {
+			screenIds = append(screenIds, line.ScreenId)
+		}
+		screenLineIds[line.ScreenId] = append(screenLineIds[line.ScreenId], line.LineId)
+		if line.Reason == RetentionReasonPtyBytes {
+			deleteOutput[line.ScreenId+"/"+line.LineId] = true
+		}
+	}
+	update := &ModelUpdate{}
+	for _, screenId := range screenIds {
+		lineIds := screenLineIds[screenId]
+		if opts.GetAction() == RetentionActionArchive {
+			for _, lineId := range lineIds {
+				err := SetLineArchivedById(ctx, screenId, lineId, true)
+				if err != nil {
+					return update, fmt.Errorf("error archiving line: %v", err)
+				}
+				line, err := GetLineById(ctx, screenId, lineId)
+				if err == nil && line != nil {
+					update.Lines = append(update.Lines, line)
+				}
+			}
+			continue
+		}
+		var trashLineIds, deleteLineIds []string
+		for _, lineId := range lineIds {
+			if deleteOutput[screenId+"/"+lineId] {
+				deleteLineIds = append(deleteLineIds, lineId)
+			} else {
+				trashLineIds = append(trashLineIds, lineId)
+			}
+		}
+		if len(trashLineIds) > 0 {
+			err := PurgeLinesByIds(ctx, screenId, trashLineIds)
+			if err != nil {
+				return update, fmt.Errorf("error purging lines: %v", err)
+			}
+		}
+		if len(deleteLineIds) > 0 {
+			err := PurgeLinesDeleteOutput(ctx, screenId, deleteLineIds)
+			if err != nil {
+				return update, fmt.Errorf("error purging lines: %v", err)
+			}
+		}
+		for _, lineId := range lineIds {
+			update.Lines = append(update.Lines, &LineType{ScreenId: screenId, LineId: lineId, Remove: true})
+		}
+	}
+	return update, nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// creates a new db (with a session and the local remote) in a temp wave home
+func initTestDB(t *testing.T) {
+	CloseDB()
+	os.Setenv("WAVETERM_HOME", t.TempDir())
+	t.Cleanup(func() {
+		reindexWaitGroup.Wait()
+		CloseDB()
+	})
+	err := TryMigrateUp()
+	if err != nil {
+		t.Fatalf("cannot migrate db: %v", err)
+	}
+	ctx := context.Background()
+	_, err = EnsureClientData(ctx)
+	if err != nil {
+		t.Fatalf("cannot create client data: %v", err)
+	}
+	err = EnsureLocalRemote(ctx)
+	if err != nil {
+		t.Fatalf("cannot create local remote: %v", err)
+	}
+	err = EnsureOneSession(ctx)
+	if err != nil {
+		t.Fatalf("cannot create session: %v", err)
+	}
+}
+
+func getTestSessionId(t *testing.T) string {
+	sessionId, err := WithTxRtn(context.Background(), func(tx *TxWrap) (string, error) {
+		return tx.GetString(`SELECT sessionid FROM session ORDER BY sessionidx LIMIT 1`), nil
+	})
+	if err != nil || sessionId == "" {
+		t.Fatalf("cannot get session: %v", err)
+	}
+	return sessionId
+}
+
+// creates a screen with one text line per entry of lineAges (age in days)
+func makeRetentionTestScreen(t *testing.T, sessionId string, name string, now time.Time, lineAges []int) string {
+	ctx := context.Background()
+	_, err := InsertScreen(ctx, sessionId, name, ScreenCreateOpts{}, false)
+	if err != nil {
+		t.Fatalf("cannot create screen: %v", err)
+	}
+	screenId, _ := WithTxRtn(ctx, func(tx *TxWrap) (string, error) {
+		return tx.GetString(`SELECT screenid FROM screen WHERE name = ?`, name), nil
+	})
+	for idx, ageDays := range lineAges {
+		line, err := AddCommentLine(ctx, screenId, "user", fmt.Sprintf("%s-%d", name, idx+1))
+		if err != nil {
+			t.Fatalf("cannot add line: %v", err)
+		}
+		lineTs := now.AddDate(0, 0, -ageDays).UnixMilli()
+		err = WithTx(ctx, func(tx *TxWrap) error {
+			tx.Exec(`UPDATE line SET ts = ? WHERE screenid = ? AND lineid = ?`, lineTs, screenId, line.LineId)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("cannot set line ts: %v", err)
+		}
+	}
+	return screenId
+}
+
+func testRetention(t *testing.T, name string, opts *RetentionOptsType, now time.Time, expected string) {
+	lines, err := GetRetentionCandidates(context.Background(), opts, now)
+	if err != nil {
+		t.Errorf("%s: error: %v", name, err)
+		return
+	}
+	var strs []string
+	for _, line := range lines {
+		strs = append(strs, fmt.Sprintf("%s:%d:%s", line.ScreenName, line.LineNum, line.Reason))
+	}
+	if strings.Join(strs, " ") != expected {
+		t.Errorf("%s: got [%s], expected [%s]", name, strings.Join(strs, " "), expected)
+	}
+}
+
+func TestGetRetentionCandidates(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	sessionId := getTestSessionId(t)
+	screenA := makeRetentionTestScreen(t, sessionId, "a", now, []int{60, 40, 10, 5, 1})
+	screenB := makeRetentionTestScreen(t, sessionId, "b", now, []int{50, 2})
+	_, err := ArchiveScreen(ctx, sessionId, screenB)
+	if err != nil {
+		t.Fatalf("cannot archive screen: %v", err)
+	}
+
+	testRetention(t, "disabled", &RetentionOptsType{}, now, "")
+	testRetention(t, "age-purge", &RetentionOptsType{MaxAgeDays: 30}, now, "a:1:age b:1:age a:2:age")
+	// archived screens are already archived, the archive action leaves them alone
+	testRetention(t, "age-archive", &RetentionOptsType{MaxAgeDays: 30, Action: RetentionActionArchive}, now, "a:1:age a:2:age")
+	// newest lines (by linenum) are kept
+	testRetention(t, "lines-archive", &RetentionOptsType{MaxLinesPerScreen: 2, Action: RetentionActionArchive}, now, "a:1:maxlines a:2:maxlines a:3:maxlines")
+	testRetention(t, "lines-purge", &RetentionOptsType{MaxLinesPerScreen: 1, Action: RetentionActionPurge}, now, "a:1:maxlines b:1:maxlines a:2:maxlines a:3:maxlines a:4:maxlines")
+	// lines removed for age do not count against the line limit
+	testRetention(t, "age-lines", &RetentionOptsType{MaxAgeDays: 30, MaxLinesPerScreen: 2, Action: RetentionActionArchive}, now, "a:1:age a:2:age a:3:maxlines")
+
+	err = UpdateLineStar(ctx, screenA, getRetentionTestLineId(t, screenA, 1), 1)
+	if err != nil {
+		t.Fatalf("cannot star line: %v", err)
+	}
+	testRetention(t, "keep-starred", &RetentionOptsType{MaxAgeDays: 30, KeepStarred: true, Action: RetentionActionArchive}, now, "a:2:age")
+	testRetention(t, "starred", &RetentionOptsType{MaxAgeDays: 30, Action: RetentionActionArchive}, now, "a:1:age a:2:age")
+
+	err = SetLineArchivedById(ctx, screenA, getRetentionTestLineId(t, screenA, 2), true)
+	if err != nil {
+		t.Fatalf("cannot archive line: %v", err)
+	}
+	testRetention(t, "archived-line", &RetentionOptsType{MaxAgeDays: 30, Action: RetentionActionArchive}, now, "a:1:age")
+}
+
+func getRetentionTestLineId(t *testing.T, screenId string, lineNum int) string {
+	lineId, _ := WithTxRtn(context.Background(), func(tx *TxWrap) (string, error) {
+		return tx.GetString(`SELECT lineid FROM line WHERE screenid = ? AND linenum = ?`, screenId, lineNum), nil
+	})
+	if lineId == "" {
+		t.Fatalf("line %d not found", lineNum)
+	}
+	return lineId
+}
+
+// writes dataLen bytes of output to a new ptyout file for the line
+func writeRetentionTestPtyOut(t *testing.T, screenId string, lineId string, dataLen int) {
+	ctx := context.Background()
+	err := CreateCmdPtyFile(ctx, screenId, lineId, testCirSize)
+	if err != nil {
+		t.Fatalf("cannot create ptyout file: %v", err)
+	}
+	fileName, _ := scbase.PtyOutFile(screenId, lineId)
+	f, err := cirfile.OpenCirFile(fileName)
+	if err != nil {
+		t.Fatalf("cannot open ptyout file: %v", err)
+	}
+	defer f.Close()
+	err = f.WriteAt(ctx, []byte(strings.Repeat("x", dataLen)), 0)
+	if err != nil {
+		t.Fatalf("cannot write ptyout file: %v", err)
+	}
+}
+
+func TestRetentionMaxPtyBytes(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	sessionId := getTestSessionId(t)
+	screenId := makeRetentionTestScreen(t, sessionId, "p", now, []int{40, 20, 10, 5})
+	var lineIds []string
+	var lineSizes []int64
+	for lineNum := 1; lineNum <= 4; lineNum++ {
+		lineId := getRetentionTestLineId(t, screenId, lineNum)
+		writeRetentionTestPtyOut(t, screenId, lineId, 10*1024)
+		lineIds = append(lineIds, lineId)
+		lineSizes = append(lineSizes, LinePtyDiskSize(screenId, lineId))
+	}
+	// archived lines keep their output, so they count against the limit
+	err := SetLineArchivedById(ctx, screenId, lineIds[3], true)
+	if err != nil {
+		t.Fatalf("cannot archive line: %v", err)
+	}
+	opts := &RetentionOptsType{MaxAgeDays: 30, MaxPtyBytes: lineSizes[2] + lineSizes[3]}
+	testRetention(t, "maxptysize", opts, now, "p:1:age p:2:maxptysize")
+
+	archiveOpts := &RetentionOptsType{MaxPtyBytes: 1024, Action: RetentionActionArchive}
+	if _, err = GetRetentionCandidates(ctx, archiveOpts, now); err == nil {
+		t.Errorf("maxptysize with the archive action should be rejected")
+	}
+	if _, err = ApplyRetention(ctx, archiveOpts, nil); err == nil {
+		t.Errorf("applying maxptysize with the archive action should be rejected")
+	}
+
+	lines, err := GetRetentionCandidates(ctx, opts, now)
+	if err != nil {
+		t.Fatalf("cannot get retention candidates: %v", err)
+	}
+	_, err = ApplyRetention(ctx, opts, lines)
+	if err != nil {
+		t.Fatalf("cannot apply retention: %v", err)
+	}
+	// the line purged for age is in the trash with its output, the output of the maxptysize line is deleted
+	for idx, lineId := range lineIds[0:2] {
+		fileName, _ := scbase.PtyOutFile(screenId, lineId)
+		if _, err := os.Stat(fileName); err == nil {
+			t.Errorf("line %d: ptyout file should be removed from the screen dir", idx+1)
+		}
+	}
+	if trashSize := GetTrashDiskSize(); trashSize != lineSizes[0] {
+		t.Errorf("trash holds %d bytes, expected only the output of line 1 (%d bytes)", trashSize, lineSizes[0])
+	}
+	items, err := GetTrashItems(ctx)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("got %d trash items (%v), expected both lines to be restorable", len(items), err)
+	}
+	testRetention(t, "after apply", opts, now, "")
+}
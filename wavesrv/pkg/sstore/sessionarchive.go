@@ -0,0 +1,452 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+const SessionArchiveVersion = 1
+const SessionArchiveManifestName = "session.json"
+const SessionArchivePtyDir = "ptyout"
+const MaxSessionArchiveEntrySize = 100 * 1024 * 1024
+
+// a session archive is a tar.gz with a SessionArchiveType manifest (session.json) and the
+// pty output files of its cmds (ptyout/[screenid]/[lineid].ptyout.cf and the .ts, .ovf and .ovp sidecars).
+// ids in the archive are the ids from the exporting install.
+type SessionArchiveType struct {
+	Version    int                     `json:"version"`
+	ExportTs   int64                   `json:"exportts"`
+	Session    *SessionType            `json:"session"`
+	Screens    []*ScreenType           `json:"screens"`
+	Lines      []*LineType             `json:"lines"`
+	Cmds       []*CmdType              `json:"cmds"`
+	Remotes    []*SessionArchiveRemote `json:"remotes"`
+	StateBases []*StateBase            `json:"statebases"`
+	StateDiffs []*StateDiff            `json:"statediffs"`
+}
+
+// remotes are matched by canonical name on import (remoteids are different in every install)
+type SessionArchiveRemote struct {
+	RemoteId            string `json:"remoteid"`
+	RemoteCanonicalName string `json:"remotecanonicalname"`
+	RemoteAlias         string `json:"remotealias,omitempty"`
+}
+
+// the ptyout cirfile and its sidecar files (timing and overflow), keyed by extension
+var sessionArchivePtyExts = []string{"cf", "ts", "ovf", "ovp"}
+
+func sessionArchivePtyName(screenId string, lineId string, ext string) string {
+	return path.Join(SessionArchivePtyDir, screenId, lineId+".ptyout."+ext)
+}
+
+func linePtyFileName(screenId string, lineId string, ext string) (string, error) {
+	switch ext {
+	case "cf":
+		return scbase.PtyOutFile(screenId, lineId)
+	case "ts":
+		return scbase.PtyTimingFile(screenId, lineId)
+	case "ovf":
+		return scbase.PtyOverflowFile(screenId, lineId)
+	case "ovp":
+		return scbase.PtyOverflowPendingFile(screenId, lineId)
+	}
+	return "", fmt.Errorf("invalid ptyout file type %q", ext)
+}
+
+func addStatePtrHashes(ptr ShellStatePtr, baseHashes map[string]bool, diffHashes map[string]bool) {
+	if ptr.BaseHash == "" {
+		return
+	}
+	baseHashes[ptr.BaseHash] = true
+	for _, diffHash := range ptr.DiffHashArr {
+		diffHashes[diffHash] = true
+	}
+}
+
+func getSessionArchive(ctx context.Context, sessionId string) (*SessionArchiveType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*SessionArchiveType, error) {
+		session, err := GetBareSessionById(tx.Context(), sessionId)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil {
+			return nil, fmt.Errorf("session not found")
+		}
+		rtn := &SessionArchiveType{Version: SessionArchiveVersion, ExportTs: time.Now().UnixMilli(), Session: session}
+		query := `SELECT * FROM screen WHERE sessionid = ? ORDER BY archived, screenidx, archivedts`
+		rtn.Screens = dbutil.SelectMapsGen[*ScreenType](tx, query, sessionId)
+		remoteIds := make(map[string]bool)
+		baseHashes := make(map[string]bool)
+		diffHashes := make(map[string]bool)
+		for _, screen := range rtn.Screens {
+			remoteIds[screen.CurRemote.RemoteId] = true
+			query = `SELECT * FROM line WHERE screenid = ? ORDER BY linenum`
+			rtn.Lines = append(rtn.Lines, dbutil.SelectMappable[*LineType](tx, query, screen.ScreenId)...)
+			query = `SELECT * FROM cmd WHERE screenid = ?`
+			cmds := dbutil.SelectMapsGen[*CmdType](tx, query, screen.ScreenId)
+			for _, cmd := range cmds {
+				remoteIds[cmd.Remote.RemoteId] = true
+				addStatePtrHashes(cmd.StatePtr, baseHashes, diffHashes)
+				addStatePtrHashes(cmd.RtnStatePtr, baseHashes, diffHashes)
+			}
+			rtn.Cmds = append(rtn.Cmds, cmds...)
+		}
+		for remoteId := range remoteIds {
+			query = `SELECT * FROM remote WHERE remoteid = ?`
+			remote := dbutil.GetMapGen[*RemoteType](tx, query, remoteId)
+			if remote == nil {
+				continue
+			}
+			rtn.Remotes = append(rtn.Remotes, &SessionArchiveRemote{
+				RemoteId:            remote.RemoteId,
+				RemoteCanonicalName: remote.RemoteCanonicalName,
+				RemoteAlias:         remote.RemoteAlias,
+			})
+		}
+		for diffHash := range diffHashes {
+			query = `SELECT * FROM state_diff WHERE diffhash = ?`
+			stateDiff := dbutil.GetMapGen[*StateDiff](tx, query, diffHash)
+			if stateDiff == nil {
+				continue
+			}
+			baseHashes[stateDiff.BaseHash] = true
+			rtn.StateDiffs = append(rtn.StateDiffs, stateDiff)
+		}
+		for baseHash := range baseHashes {
+			var stateBase StateBase
+			query = `SELECT * FROM state_base WHERE basehash = ?`
+			if tx.Get(&stateBase, query, baseHash) {
+				rtn.StateBases = append(rtn.StateBases, &stateBase)
+			}
+		}
+		return rtn, nil
+	})
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// streams fileName into the archive, missing files are skipped
+func copyFileToTar(tw *tar.Writer, name string, fileName string, modTime time.Time) error {
+	fd, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	finfo, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    finfo.Size(),
+		ModTime: modTime,
+	}
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	// the file can grow while it is copied (running cmd), only copy what was there at Stat
+	_, err = io.CopyN(tw, fd, finfo.Size())
+	return err
+}
+
+func writeLinePtyFiles(tw *tar.Writer, screenId string, lineId string, modTime time.Time) error {
+	for _, ext := range sessionArchivePtyExts {
+		fileName, err := linePtyFileName(screenId, lineId, ext)
+		if err != nil {
+			return err
+		}
+		copyFn := func() error {
+			return copyFileToTar(tw, sessionArchivePtyName(screenId, lineId, ext), fileName, modTime)
+		}
+		if ext == "ovf" || ext == "ovp" {
+			err = withPtyOverflowLock(screenId, lineId, copyFn)
+		} else {
+			err = copyFn()
+		}
+		if err != nil {
+			return fmt.Errorf("cannot archive %s file for line %s: %v", ext, lineId, err)
+		}
+	}
+	return nil
+}
+
+// writes the session (including archived screens) to w as a tar.gz.  returns the manifest.
+func WriteSessionArchive(ctx context.Context, sessionId string, w io.Writer) (*SessionArchiveType, error) {
+	archive, err := getSessionArchive(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	barr, err := json.Marshal(archive)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize session: %v", err)
+	}
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	exportTime := time.UnixMilli(archive.ExportTs)
+	err = writeTarFile(tw, SessionArchiveManifestName, barr, exportTime)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range archive.Cmds {
+		err = writeLinePtyFiles(tw, cmd.ScreenId, cmd.LineId, exportTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gzw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// ptyout entries are streamed to files in tempDir (they can be large), the returned map is
+// archive name => temp file name
+func readSessionArchive(r io.Reader, tempDir string) (*SessionArchiveType, map[string]string, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid session archive: %v", err)
+	}
+	tr := tar.NewReader(gzr)
+	var archive *SessionArchiveType
+	ptyFiles := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid session archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > MaxSessionArchiveEntrySize {
+			return nil, nil, fmt.Errorf("invalid session archive: %q too large", hdr.Name)
+		}
+		if hdr.Name == SessionArchiveManifestName {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid session archive: %v", err)
+			}
+			archive = &SessionArchiveType{}
+			err = json.Unmarshal(data, archive)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid session archive manifest: %v", err)
+			}
+			continue
+		}
+		if !strings.HasPrefix(hdr.Name, SessionArchivePtyDir+"/") {
+			continue
+		}
+		// temp names are generated, hdr.Name is only used as a key
+		tempFileName := path.Join(tempDir, fmt.Sprintf("%d", len(ptyFiles)))
+		fd, err := os.OpenFile(tempFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = io.Copy(fd, tr)
+		closeErr := fd.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid session archive: %v", err)
+		}
+		if closeErr != nil {
+			return nil, nil, closeErr
+		}
+		ptyFiles[hdr.Name] = tempFileName
+	}
+	if archive == nil || archive.Session == nil {
+		return nil, nil, fmt.Errorf("invalid session archive, no %s found", SessionArchiveManifestName)
+	}
+	if archive.Version <= 0 || archive.Version > SessionArchiveVersion {
+		return nil, nil, fmt.Errorf("invalid session archive, unsupported version %d", archive.Version)
+	}
+	err = verifySessionArchiveStates(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	return archive, ptyFiles, nil
+}
+
+// states are content addressed (the hash is the sha1 of the encoded state), a state whose data does not
+// match its hash would be shared with (and corrupt) every cmd in this install that has the same hash.
+func verifySessionArchiveStates(archive *SessionArchiveType) error {
+	for _, stateBase := range archive.StateBases {
+		var state packet.ShellState
+		err := state.DecodeShellState(stateBase.Data)
+		if err != nil {
+			return fmt.Errorf("invalid session archive, cannot decode state %s: %v", stateBase.BaseHash, err)
+		}
+		if state.HashVal != stateBase.BaseHash {
+			return fmt.Errorf("invalid session archive, state %s does not match its hash", stateBase.BaseHash)
+		}
+	}
+	for _, stateDiff := range archive.StateDiffs {
+		var diff packet.ShellStateDiff
+		err := diff.DecodeShellStateDiff(stateDiff.Data)
+		if err != nil {
+			return fmt.Errorf("invalid session archive, cannot decode state diff %s: %v", stateDiff.DiffHash, err)
+		}
+		if diff.HashVal != stateDiff.DiffHash {
+			return fmt.Errorf("invalid session archive, state diff %s does not match its hash", stateDiff.DiffHash)
+		}
+		if diff.BaseHash != stateDiff.BaseHash || strings.Join(diff.DiffHashArr, ",") != strings.Join(stateDiff.DiffHashArr, ",") {
+			return fmt.Errorf("invalid session archive, state diff %s does not match its base", stateDiff.DiffHash)
+		}
+	}
+	return nil
+}
+
+// restores a session archive as a new session (with new session, screen, and line ids).  cmds that
+// were still running when the session was exported are marked as hung up.  returns the new session id.
+func ImportSessionArchive(ctx context.Context, r io.Reader) (string, error) {
+	// the temp dir is in the wave home so the files can be renamed into the screen dirs
+	tempDir, err := os.MkdirTemp(scbase.GetWaveHomeDir(), "sessionimport-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	archive, ptyFiles, err := readSessionArchive(r, tempDir)
+	if err != nil {
+		return "", err
+	}
+	newSessionId := scbase.GenWaveUUID()
+	screenIdMap := make(map[string]string)
+	lineIdMap := make(map[string]string) // key is [old screenid]/[old lineid]
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		localRemoteId := tx.GetString(`SELECT remoteid FROM remote WHERE remotealias = ?`, LocalRemoteAlias)
+		if localRemoteId == "" {
+			return fmt.Errorf("cannot import session, no local remote found")
+		}
+		remoteIdMap := make(map[string]string)
+		for _, remote := range archive.Remotes {
+			query := `SELECT remoteid FROM remote WHERE remotecanonicalname = ?`
+			remoteIdMap[remote.RemoteId] = tx.GetString(query, remote.RemoteCanonicalName)
+		}
+		for _, screen := range archive.Screens {
+			screenIdMap[screen.ScreenId] = scbase.GenWaveUUID()
+		}
+		names := tx.SelectStrings(`SELECT name FROM session`)
+		sessionName := fmtUniqueName(archive.Session.Name, "workspace-%d", len(names)+1, names)
+		maxSessionIdx := tx.GetInt(`SELECT COALESCE(max(sessionidx), 0) FROM session`)
+		query := `INSERT INTO session (sessionid, name, activescreenid, sessionidx, notifynum, archived, archivedts, sharemode)
+                               VALUES (?,         ?,    ?,              ?,          0,         0,        0,          ?)`
+		tx.Exec(query, newSessionId, sessionName, screenIdMap[archive.Session.ActiveScreenId], maxSessionIdx+1, ShareModeLocal)
+		for _, screen := range archive.Screens {
+			screen.SessionId = newSessionId
+			screen.ScreenId = screenIdMap[screen.ScreenId]
+			screen.OwnerId = ""
+			screen.ShareMode = ShareModeLocal
+			screen.WebShareOpts = nil
+			// the screen's current remote has no state in this install, so new commands use the local remote
+			screen.CurRemote = RemotePtrType{RemoteId: localRemoteId}
+			insertScreenTx(tx, screen)
+		}
+		for _, line := range archive.Lines {
+			newScreenId := screenIdMap[line.ScreenId]
+			if newScreenId == "" {
+				continue
+			}
+			newLineId := scbase.GenWaveUUID()
+			lineIdMap[line.ScreenId+"/"+line.LineId] = newLineId
+			line.ScreenId = newScreenId
+			line.LineId = newLineId
+			insertLineTx(tx, line)
+		}
+		for _, cmd := range archive.Cmds {
+			newLineId := lineIdMap[cmd.ScreenId+"/"+cmd.LineId]
+			if newLineId == "" {
+				continue
+			}
+			cmd.ScreenId = screenIdMap[cmd.ScreenId]
+			cmd.LineId = newLineId
+			if newRemoteId := remoteIdMap[cmd.Remote.RemoteId]; newRemoteId != "" {
+				cmd.Remote.RemoteId = newRemoteId
+			}
+			if cmd.Status == CmdStatusRunning || cmd.Status == CmdStatusDetached {
+				cmd.Status = CmdStatusHangup
+			}
+			cmd.CmdPid = 0
+			cmd.RemotePid = 0
+			insertCmdTx(tx, cmd)
+		}
+		// states are content addressed, so they keep their hashes
+		for _, stateBase := range archive.StateBases {
+			query = `SELECT basehash FROM state_base WHERE basehash = ?`
+			if tx.Exists(query, stateBase.BaseHash) {
+				continue
+			}
+			query = `INSERT INTO state_base (basehash, ts, version, data) VALUES (:basehash,:ts,:version,:data)`
+			tx.NamedExec(query, stateBase)
+		}
+		for _, stateDiff := range archive.StateDiffs {
+			query = `SELECT diffhash FROM state_diff WHERE diffhash = ?`
+			if tx.Exists(query, stateDiff.DiffHash) {
+				continue
+			}
+			query = `INSERT INTO state_diff (diffhash, ts, basehash, diffhasharr, data) VALUES (:diffhash,:ts,:basehash,:diffhasharr,:data)`
+			tx.NamedExec(query, stateDiff.ToMap())
+		}
+		return nil
+	})
+	if txErr != nil {
+		return "", txErr
+	}
+	for oldKey, newLineId := range lineIdMap {
+		oldScreenId, oldLineId, _ := strings.Cut(oldKey, "/")
+		for _, ext := range sessionArchivePtyExts {
+			tempFileName, found := ptyFiles[sessionArchivePtyName(oldScreenId, oldLineId, ext)]
+			if !found {
+				continue
+			}
+			fileName, err := linePtyFileName(screenIdMap[oldScreenId], newLineId, ext)
+			if err != nil {
+				return newSessionId, err
+			}
+			err = os.Rename(tempFileName, fileName)
+			if err != nil {
+				return newSessionId, fmt.Errorf("cannot write ptyout %s file: %v", ext, err)
+			}
+		}
+	}
+	return newSessionId, nil
+}
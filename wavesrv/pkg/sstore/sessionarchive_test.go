@@ -0,0 +1,206 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// stores a base state and a diff (cwd change) on top of it, returns the ptr to the diffed state
+func storeArchiveTestState(t *testing.T) ShellStatePtr {
+	ctx := context.Background()
+	state := &packet.ShellState{Version: "bash v5.1.16", Cwd: "/home/user", Aliases: "alias ll='ls -l'\n"}
+	err := StoreStateBase(ctx, state)
+	if err != nil {
+		t.Fatalf("cannot store state base: %v", err)
+	}
+	baseHash := state.GetHashVal(false)
+	diff := &packet.ShellStateDiff{Version: state.Version, BaseHash: baseHash, Cwd: "/home/user/src"}
+	err = StoreStateDiff(ctx, diff)
+	if err != nil {
+		t.Fatalf("cannot store state diff: %v", err)
+	}
+	return ShellStatePtr{BaseHash: baseHash, DiffHashArr: []string{diff.GetHashVal(false)}}
+}
+
+func getArchiveTestCmds(t *testing.T, screenId string) []*CmdType {
+	cmds, err := WithTxRtn(context.Background(), func(tx *TxWrap) ([]*CmdType, error) {
+		return dbutil.SelectMapsGen[*CmdType](tx, `SELECT * FROM cmd WHERE screenid = ?`, screenId), nil
+	})
+	if err != nil {
+		t.Fatalf("cannot get cmds: %v", err)
+	}
+	return cmds
+}
+
+func TestSessionArchiveRoundTrip(t *testing.T) {
+	initTestDB(t)
+	SetPtyOverflowBudget(1024 * 1024)
+	defer SetPtyOverflowBudget(0)
+	ctx := context.Background()
+	sessionId := getTestSessionId(t)
+	screenId := makeRetentionTestScreen(t, sessionId, "archive", time.Now(), []int{1})
+	localRemote, err := GetLocalRemote(ctx)
+	if err != nil {
+		t.Fatalf("cannot get local remote: %v", err)
+	}
+	statePtr := storeArchiveTestState(t)
+	cmd := &CmdType{
+		ScreenId: screenId,
+		LineId:   uuid.New().String(),
+		Remote:   RemotePtrType{RemoteId: localRemote.RemoteId},
+		CmdStr:   "make",
+		Status:   CmdStatusRunning,
+		StatePtr: statePtr,
+		CmdPid:   1234,
+	}
+	_, err = AddCmdLine(ctx, screenId, "user", cmd, "", nil)
+	if err != nil {
+		t.Fatalf("cannot add cmd line: %v", err)
+	}
+	// more output than the cirfile holds (and more than an overflow block), so all of the sidecar files are written
+	data := []byte(strings.Repeat("line of build output\n", 20000))
+	err = CreateCmdPtyFile(ctx, screenId, cmd.LineId, testCirSize)
+	if err != nil {
+		t.Fatalf("cannot create ptyout file: %v", err)
+	}
+	const chunkSize = 4 * 1024
+	for pos := 0; pos < len(data); pos += chunkSize {
+		end := pos + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err = AppendToCmdPtyBlob(ctx, screenId, cmd.LineId, data[pos:end], int64(pos))
+		if err != nil {
+			t.Fatalf("cannot append ptyout: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	archive, err := WriteSessionArchive(ctx, sessionId, &buf)
+	if err != nil {
+		t.Fatalf("cannot write session archive: %v", err)
+	}
+	if len(archive.Cmds) != 1 || len(archive.StateBases) != 1 || len(archive.StateDiffs) != 1 {
+		t.Fatalf("archive has %d cmds, %d statebases, %d statediffs", len(archive.Cmds), len(archive.StateBases), len(archive.StateDiffs))
+	}
+
+	// import into a new install (the local remote gets a new remoteid)
+	initTestDB(t)
+	newSessionId, err := ImportSessionArchive(ctx, &buf)
+	if err != nil {
+		t.Fatalf("cannot import session archive: %v", err)
+	}
+	newLocalRemote, err := GetLocalRemote(ctx)
+	if err != nil {
+		t.Fatalf("cannot get local remote: %v", err)
+	}
+	if newSessionId == sessionId || newLocalRemote.RemoteId == localRemote.RemoteId {
+		t.Fatalf("import should be into a new db, got session %s remote %s", newSessionId, newLocalRemote.RemoteId)
+	}
+	screens, err := GetSessionScreens(ctx, newSessionId)
+	if err != nil {
+		t.Fatalf("cannot get imported screens: %v", err)
+	}
+	var newScreen *ScreenType
+	for _, screen := range screens {
+		if screen.Name == "archive" {
+			newScreen = screen
+		}
+	}
+	if newScreen == nil || newScreen.ScreenId == screenId || newScreen.CurRemote.RemoteId != newLocalRemote.RemoteId {
+		t.Fatalf("imported screen not found or not remapped: %+v", newScreen)
+	}
+	lines, err := GetScreenLinesById(ctx, newScreen.ScreenId)
+	if err != nil || len(lines.Lines) != 2 {
+		t.Fatalf("cannot get imported lines: %v", err)
+	}
+	cmds := getArchiveTestCmds(t, newScreen.ScreenId)
+	if len(cmds) != 1 {
+		t.Fatalf("got %d imported cmds, expected 1", len(cmds))
+	}
+	newCmd := cmds[0]
+	if newCmd.LineId == cmd.LineId || newCmd.LineId != lines.Lines[1].LineId {
+		t.Errorf("imported cmd lineid %s, expected a new lineid matching line %s", newCmd.LineId, lines.Lines[1].LineId)
+	}
+	if newCmd.Remote.RemoteId != newLocalRemote.RemoteId {
+		t.Errorf("imported cmd remote %s, expected the new local remote %s", newCmd.Remote.RemoteId, newLocalRemote.RemoteId)
+	}
+	if newCmd.Status != CmdStatusHangup || newCmd.CmdPid != 0 {
+		t.Errorf("running cmd imported with status %s pid %d, expected hangup", newCmd.Status, newCmd.CmdPid)
+	}
+	state, err := GetFullState(ctx, newCmd.StatePtr)
+	if err != nil || state.Cwd != "/home/user/src" || state.Aliases != "alias ll='ls -l'\n" {
+		t.Errorf("imported state %+v (%v)", state, err)
+	}
+
+	// the cirfile and its sidecars are restored under the new ids
+	for _, ext := range sessionArchivePtyExts {
+		fileName, _ := linePtyFileName(newScreen.ScreenId, newCmd.LineId, ext)
+		if _, err := os.Stat(fileName); err != nil {
+			t.Errorf("imported ptyout %s file: %v", ext, err)
+		}
+	}
+	realOffset, fullData, err := ReadFullPtyOutData(ctx, newScreen.ScreenId, newCmd.LineId)
+	if err != nil || realOffset != 0 || !bytes.Equal(fullData, data) {
+		t.Errorf("imported ptyout got offset %d len %d err %v, expected the full output", realOffset, len(fullData), err)
+	}
+	_, timingData, err := ReadPtyOutAtTime(ctx, newScreen.ScreenId, newCmd.LineId, time.Now().UnixMilli())
+	if err != nil || len(timingData) == 0 {
+		t.Errorf("cannot read imported ptyout by time: %v", err)
+	}
+}
+
+func TestVerifySessionArchiveStates(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	statePtr := storeArchiveTestState(t)
+	makeArchive := func() *SessionArchiveType {
+		rtn, err := WithTxRtn(ctx, func(tx *TxWrap) (*SessionArchiveType, error) {
+			var stateBase StateBase
+			tx.Get(&stateBase, `SELECT * FROM state_base WHERE basehash = ?`, statePtr.BaseHash)
+			stateDiff := dbutil.GetMapGen[*StateDiff](tx, `SELECT * FROM state_diff WHERE diffhash = ?`, statePtr.DiffHashArr[0])
+			return &SessionArchiveType{StateBases: []*StateBase{&stateBase}, StateDiffs: []*StateDiff{stateDiff}}, nil
+		})
+		if err != nil {
+			t.Fatalf("cannot get states: %v", err)
+		}
+		return rtn
+	}
+	err := verifySessionArchiveStates(makeArchive())
+	if err != nil {
+		t.Fatalf("valid states should verify: %v", err)
+	}
+	tests := map[string]func(archive *SessionArchiveType){
+		"base data": func(archive *SessionArchiveType) {
+			archive.StateBases[0].Data = bytes.Replace(archive.StateBases[0].Data, []byte("/home/user"), []byte("/home/evil"), 1)
+		},
+		"base hash": func(archive *SessionArchiveType) { archive.StateBases[0].BaseHash = statePtr.DiffHashArr[0] },
+		"base garbage": func(archive *SessionArchiveType) {
+			archive.StateBases[0].Data = []byte("garbage")
+		},
+		"diff data": func(archive *SessionArchiveType) {
+			archive.StateDiffs[0].Data = bytes.Replace(archive.StateDiffs[0].Data, []byte("/home/user/src"), []byte("/home/user/bin"), 1)
+		},
+		"diff base": func(archive *SessionArchiveType) { archive.StateDiffs[0].BaseHash = uuid.New().String() },
+		"diff arr":  func(archive *SessionArchiveType) { archive.StateDiffs[0].DiffHashArr = []string{uuid.New().String()} },
+	}
+	for name, tamperFn := range tests {
+		archive := makeArchive()
+		tamperFn(archive)
+		err = verifySessionArchiveStates(archive)
+		if err == nil {
+			t.Errorf("%s: tampered state should not verify", name)
+		}
+	}
+}
@@ -54,11 +54,12 @@ const (
 )
 
 const (
-	LineState_Source   = "prompt:source"
-	LineState_File     = "prompt:file"
-	LineState_Template = "template"
-	LineState_Mode     = "mode"
-	LineState_Lang     = "lang"
+	LineState_Source       = "prompt:source"
+	LineState_File         = "prompt:file"
+	LineState_Template     = "template"
+	LineState_Mode         = "mode"
+	LineState_Lang         = "lang"
+	LineState_PromptClosed = "prompt:closed"
 )
 
 const (
@@ -261,8 +262,13 @@ func (tdata *TelemetryData) Scan(val interface{}) error {
 }
 
 type ClientOptsType struct {
-	NoTelemetry bool  `json:"notelemetry,omitempty"`
-	AcceptedTos int64 `json:"acceptedtos,omitempty"`
+	NoTelemetry       bool               `json:"notelemetry,omitempty"`
+	AcceptedTos       int64              `json:"acceptedtos,omitempty"`
+	PtyOverflowBudget int64              `json:"ptyoverflowbudget,omitempty"`
+	Retention         *RetentionOptsType `json:"retention,omitempty"`
+	TrashExpireDays   int                `json:"trashexpiredays,omitempty"`
+	DBBackupHours     int                `json:"dbbackuphours,omitempty"`
+	DBBackupKeep      int                `json:"dbbackupkeep,omitempty"`
 }
 
 type FeOptsType struct {
@@ -433,7 +439,7 @@ func (h *HistoryItemType) FromMap(m map[string]interface{}) bool {
 
 type ScreenOptsType struct {
 	TabColor string `json:"tabcolor,omitempty"`
-	TabIcon string `json:"tabicon,omitempty"`
+	TabIcon  string `json:"tabicon,omitempty"`
 	PTerm    string `json:"pterm,omitempty"`
 }
 
@@ -577,17 +583,34 @@ type HistoryItemType struct {
 	LineNum    int64  `json:"linenum"`
 }
 
+const (
+	HistoryExitSuccess = "success"
+	HistoryExitFailure = "failure" // non-zero exit code, or the command errored / was hung up
+)
+
+// MinTs and MaxTs are inclusive bounds on h.ts (0 for no bound), the query goes backwards in time starting at MaxTs.
+// the ExitStatus, ExitCode, MinDurationMs, and CwdPrefix filters only match items that still have a cmd.
 type HistoryQueryOpts struct {
-	Offset     int
-	MaxItems   int
-	FromTs     int64
-	SearchText string
-	SessionId  string
-	RemoteId   string
-	ScreenId   string
-	NoMeta     bool
-	RawOffset  int
-	FilterFn   func(*HistoryItemType) bool
+	Offset        int
+	MaxItems      int
+	MinTs         int64
+	MaxTs         int64
+	SearchText    string
+	SessionId     string
+	RemoteId      string
+	ScreenId      string
+	NoMeta        bool
+	OnlyMeta      bool
+	ExitStatus    string // HistoryExitSuccess, HistoryExitFailure, or "" for all
+	ExitCode      *int
+	MinDurationMs int64
+	CwdPrefix     string
+	RawOffset     int
+	FilterFn      func(*HistoryItemType) bool
+}
+
+func (opts HistoryQueryOpts) needsCmdJoin() bool {
+	return opts.ExitStatus != "" || opts.ExitCode != nil || opts.MinDurationMs > 0 || opts.CwdPrefix != ""
 }
 
 type HistoryQueryResult struct {
@@ -801,7 +824,7 @@ func (p *PlaybookType) FromMap(m map[string]interface{}) bool {
 	quickSetStr(&p.PlaybookId, m, "playbookid")
 	quickSetStr(&p.PlaybookName, m, "playbookname")
 	quickSetStr(&p.Description, m, "description")
-	quickSetJsonArr(&p.Entries, m, "entries")
+	quickSetJsonArr(&p.EntryIds, m, "entryids")
 	return true
 }
 
@@ -850,6 +873,18 @@ type PlaybookEntry struct {
 	Remove      bool   `json:"remove,omitempty"`
 }
 
+func (e *PlaybookEntry) ToMap() map[string]interface{} {
+	rtn := make(map[string]interface{})
+	rtn["entryid"] = e.EntryId
+	rtn["playbookid"] = e.PlaybookId
+	rtn["description"] = e.Description
+	rtn["alias"] = e.Alias
+	rtn["cmdstr"] = e.CmdStr
+	rtn["createdts"] = e.CreatedTs
+	rtn["updatedts"] = e.UpdatedTs
+	return rtn
+}
+
 type BookmarkType struct {
 	BookmarkId  string   `json:"bookmarkid"`
 	CreatedTs   int64    `json:"createdts"`
@@ -859,6 +894,12 @@ type BookmarkType struct {
 	Description string   `json:"description"`
 	OrderIdx    int64    `json:"orderidx"`
 	Remove      bool     `json:"remove,omitempty"`
+
+	// last values used for the {{placeholders}} in CmdStr
+	ParamValues map[string]string `json:"paramvalues,omitempty"`
+
+	// set for (read-only) bookmarks from a bookmark library, not stored in the DB
+	Library string `json:"library,omitempty"`
 }
 
 func (bm *BookmarkType) GetSimpleKey() string {
@@ -883,6 +924,7 @@ func (bm *BookmarkType) FromMap(m map[string]interface{}) bool {
 	quickSetStr(&bm.CmdStr, m, "cmdstr")
 	quickSetStr(&bm.Description, m, "description")
 	quickSetJsonArr(&bm.Tags, m, "tags")
+	quickSetJson(&bm.ParamValues, m, "paramvalues")
 	return true
 }
 
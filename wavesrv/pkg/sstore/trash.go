@@ -0,0 +1,551 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// purged lines, screens, and sessions are moved to the trash instead of being deleted.  the trash table
+// holds a snapshot of their rows (TrashDataType), and their pty files are moved to
+// [trashdir]/[trashid]/[screenid]/.  items are deleted for good when they expire or the trash is emptied.
+const (
+	TrashTypeLine    = "line"
+	TrashTypeScreen  = "screen"
+	TrashTypeSession = "session"
+)
+
+const DefaultTrashExpireDays = 7
+
+type TrashDataType struct {
+	Session *SessionType       `json:"session,omitempty"`
+	Screens []*ScreenType      `json:"screens,omitempty"`
+	Lines   []*LineType        `json:"lines,omitempty"`
+	Cmds    []*CmdType         `json:"cmds,omitempty"`
+	History []*HistoryItemType `json:"history,omitempty"`
+}
+
+// the trash row without its data
+type TrashItemType struct {
+	TrashId   string `json:"trashid" db:"trashid"`
+	TrashType string `json:"trashtype" db:"trashtype"`
+	Name      string `json:"name" db:"name"`
+	SessionId string `json:"sessionid" db:"sessionid"`
+	ScreenId  string `json:"screenid" db:"screenid"`
+	NumLines  int    `json:"numlines" db:"numlines"`
+	TrashTs   int64  `json:"trashts" db:"trashts"`
+}
+
+func (opts ClientOptsType) GetTrashExpireDays() int {
+	if opts.TrashExpireDays <= 0 {
+		return DefaultTrashExpireDays
+	}
+	return opts.TrashExpireDays
+}
+
+func insertScreenTx(tx *TxWrap, screen *ScreenType) {
+	query := `INSERT INTO screen ( sessionid, screenid, name, screenidx, screenopts, ownerid, sharemode, webshareopts, curremoteownerid, curremoteid, curremotename, nextlinenum, selectedline, anchor, focustype, archived, archivedts)
+                          VALUES (:sessionid,:screenid,:name,:screenidx,:screenopts,:ownerid,:sharemode,:webshareopts,:curremoteownerid,:curremoteid,:curremotename,:nextlinenum,:selectedline,:anchor,:focustype,:archived,:archivedts)`
+	tx.NamedExec(query, screen.ToMap())
+}
+
+func insertLineTx(tx *TxWrap, line *LineType) {
+	query := `INSERT INTO line  ( screenid, userid, lineid, ts, linenum, linenumtemp, linelocal, linetype, linestate, text, renderer, ephemeral, contentheight, star, archived)
+                         VALUES (:screenid,:userid,:lineid,:ts,:linenum,:linenumtemp,:linelocal,:linetype,:linestate,:text,:renderer,:ephemeral,:contentheight,:star,:archived)`
+	tx.NamedExec(query, dbutil.ToDBMap(line, false))
+}
+
+func insertCmdTx(tx *TxWrap, cmd *CmdType) {
+	query := `
+INSERT INTO cmd  ( screenid, lineid, remoteownerid, remoteid, remotename, cmdstr, rawcmdstr, festate, statebasehash, statediffhasharr, termopts, origtermopts, status, cmdpid, remotepid, donets, exitcode, durationms, rtnstate, runout, rtnbasehash, rtndiffhasharr)
+          VALUES (:screenid,:lineid,:remoteownerid,:remoteid,:remotename,:cmdstr,:rawcmdstr,:festate,:statebasehash,:statediffhasharr,:termopts,:origtermopts,:status,:cmdpid,:remotepid,:donets,:exitcode,:durationms,:rtnstate,:runout,:rtnbasehash,:rtndiffhasharr)
+`
+	tx.NamedExec(query, cmd.ToMap())
+}
+
+func insertHistoryItemTx(tx *TxWrap, hitem *HistoryItemType) {
+	query := `INSERT INTO history
+                  ( historyid, ts, userid, sessionid, screenid, lineid, haderror, cmdstr, remoteownerid, remoteid, remotename, ismetacmd, incognito, linenum) VALUES
+                  (:historyid,:ts,:userid,:sessionid,:screenid,:lineid,:haderror,:cmdstr,:remoteownerid,:remoteid,:remotename,:ismetacmd,:incognito,:linenum)`
+	tx.NamedExec(query, hitem.ToMap())
+}
+
+// adds the rows of a screen (and its lines) to data
+func addScreenTrashData(tx *TxWrap, data *TrashDataType, screenId string) {
+	query := `SELECT * FROM screen WHERE screenid = ?`
+	screen := dbutil.GetMapGen[*ScreenType](tx, query, screenId)
+	if screen == nil {
+		return
+	}
+	data.Screens = append(data.Screens, screen)
+	query = `SELECT * FROM line WHERE screenid = ? ORDER BY linenum`
+	data.Lines = append(data.Lines, dbutil.SelectMappable[*LineType](tx, query, screenId)...)
+	query = `SELECT * FROM cmd WHERE screenid = ?`
+	data.Cmds = append(data.Cmds, dbutil.SelectMapsGen[*CmdType](tx, query, screenId)...)
+	query = `SELECT * FROM history WHERE screenid = ?`
+	data.History = append(data.History, dbutil.SelectMapsGen[*HistoryItemType](tx, query, screenId)...)
+}
+
+// adds the rows of one line to data
+func addLineTrashData(tx *TxWrap, data *TrashDataType, screenId string, lineId string) {
+	query := `SELECT * FROM line WHERE screenid = ? AND lineid = ?`
+	data.Lines = append(data.Lines, dbutil.SelectMappable[*LineType](tx, query, screenId, lineId)...)
+	query = `SELECT * FROM cmd WHERE screenid = ? AND lineid = ?`
+	data.Cmds = append(data.Cmds, dbutil.SelectMapsGen[*CmdType](tx, query, screenId, lineId)...)
+	query = `SELECT * FROM history WHERE screenid = ? AND lineid = ?`
+	data.History = append(data.History, dbutil.SelectMapsGen[*HistoryItemType](tx, query, screenId, lineId)...)
+}
+
+func getTrashScreenName(tx *TxWrap, screenId string) string {
+	query := `SELECT COALESCE(ss.name, '') || '/' || s.name
+	          FROM screen s
+	          LEFT JOIN session ss ON ss.sessionid = s.sessionid
+	          WHERE s.screenid = ?`
+	return tx.GetString(query, screenId)
+}
+
+func insertTrashItemTx(tx *TxWrap, item *TrashItemType, data *TrashDataType) {
+	item.TrashId = scbase.GenWaveUUID()
+	item.TrashTs = time.Now().UnixMilli()
+	item.NumLines = len(data.Lines)
+	query := `INSERT INTO trash (trashid, trashtype, name, sessionid, screenid, numlines, trashts, data)
+	                     VALUES (?,       ?,         ?,    ?,         ?,        ?,        ?,       ?)`
+	tx.Exec(query, item.TrashId, item.TrashType, item.Name, item.SessionId, item.ScreenId, item.NumLines, item.TrashTs, quickJson(data))
+}
+
+func fileExists(fileName string) (bool, error) {
+	_, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func getTrashScreenDir(trashId string, screenId string) (string, error) {
+	trashDir, err := scbase.EnsureTrashItemDir(trashId)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(trashDir, screenId), nil
+}
+
+// renames done after a db commit, so they can be undone if a later rename fails
+type fileMoves struct {
+	Moves [][2]string // [src, dst]
+}
+
+func (m *fileMoves) rename(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err != nil {
+		return err
+	}
+	m.Moves = append(m.Moves, [2]string{src, dst})
+	return nil
+}
+
+// moves the files back (newest first)
+func (m *fileMoves) undo() {
+	for idx := len(m.Moves) - 1; idx >= 0; idx-- {
+		err := os.Rename(m.Moves[idx][1], m.Moves[idx][0])
+		if err != nil {
+			log.Printf("error moving %s back to %s: %v\n", m.Moves[idx][1], m.Moves[idx][0], err)
+		}
+	}
+	m.Moves = nil
+}
+
+// moves the ptyout file (and its sidecar files) of a line into the trash
+func moveLinePtyFilesToTrash(trashId string, screenId string, lineId string, moves *fileMoves) error {
+	dstDir, err := getTrashScreenDir(trashId, screenId)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dstDir, 0700)
+	if err != nil {
+		return err
+	}
+	clearPtyOverflowCache(screenId, lineId)
+	for _, fileFn := range []func(string, string) (string, error){scbase.PtyOutFile, scbase.PtyTimingFile, scbase.PtyOverflowFile, scbase.PtyOverflowPendingFile} {
+		fileName, err := fileFn(screenId, lineId)
+		if err != nil {
+			return err
+		}
+		err = moves.rename(fileName, path.Join(dstDir, filepath.Base(fileName)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// called after purgeLinesTx is committed.  moves the pty files of the purged lines to the trash (deleted
+// if there is no trash item).  if a move fails, the moves are undone and the lines are put back.
+func movePurgedLineFiles(ctx context.Context, screenId string, lineIds []string, trashId string, data *TrashDataType) error {
+	if trashId == "" {
+		for _, lineId := range lineIds {
+			err := DeletePtyOutFile(ctx, screenId, lineId)
+			if err != nil {
+				log.Printf("error removing ptyout file %s/%s: %v\n", screenId, lineId, err)
+			}
+		}
+		return nil
+	}
+	moves := &fileMoves{}
+	for _, lineId := range lineIds {
+		err := moveLinePtyFilesToTrash(trashId, screenId, lineId, moves)
+		if err == nil {
+			continue
+		}
+		moves.undo()
+		undoErr := WithTx(ctx, func(tx *TxWrap) error {
+			insertTrashLinesTx(tx, data, nil)
+			tx.Exec(`DELETE FROM trash WHERE trashid = ?`, trashId)
+			return nil
+		})
+		if undoErr != nil {
+			return fmt.Errorf("cannot move pty files to trash: %v (cannot undo the purge: %v)", err, undoErr)
+		}
+		removeErr := removeTrashItemDir(trashId)
+		if removeErr != nil {
+			log.Printf("error removing trash dir %s: %v\n", trashId, removeErr)
+		}
+		startReindexRestoredOutput([]string{screenId})
+		return fmt.Errorf("cannot move pty files to trash, lines were not purged: %v", err)
+	}
+	return nil
+}
+
+func moveScreenDirToTrash(trashId string, screenId string) error {
+	screenDir := path.Join(scbase.GetScreensDir(), screenId)
+	exists, err := fileExists(screenDir)
+	if err != nil || !exists {
+		return err
+	}
+	dstDir, err := getTrashScreenDir(trashId, screenId)
+	if err != nil {
+		return err
+	}
+	clearScreenPtyOverflowCache(screenId)
+	scbase.ClearScreenDirCache(screenId)
+	return os.Rename(screenDir, dstDir)
+}
+
+// moves the files of a trashed screen back into its screen dir (the screen dir may already exist).
+// the (emptied) trash dir is removed with the trash item.
+func restoreScreenDirFromTrash(trashId string, screenId string, moves *fileMoves) error {
+	srcDir := path.Join(scbase.GetTrashDir(), trashId, screenId)
+	exists, err := fileExists(srcDir)
+	if err != nil || !exists {
+		return err
+	}
+	screenDir := path.Join(scbase.GetScreensDir(), screenId)
+	scbase.ClearScreenDirCache(screenId)
+	clearScreenPtyOverflowCache(screenId)
+	exists, err = fileExists(screenDir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = os.MkdirAll(scbase.GetScreensDir(), 0700)
+		if err != nil {
+			return err
+		}
+		return moves.rename(srcDir, screenDir)
+	}
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = moves.rename(path.Join(srcDir, entry.Name()), path.Join(screenDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeTrashItemDir(trashId string) error {
+	if trashId == "" {
+		return nil
+	}
+	return os.RemoveAll(path.Join(scbase.GetTrashDir(), trashId))
+}
+
+// inserts the lines, cmds, and history items of data that do not exist.  the inserted rows are added
+// to inserted (if not nil).
+func insertTrashLinesTx(tx *TxWrap, data *TrashDataType, inserted *TrashDataType) {
+	if inserted == nil {
+		inserted = &TrashDataType{}
+	}
+	for _, line := range data.Lines {
+		if tx.Exists(`SELECT lineid FROM line WHERE screenid = ? AND lineid = ?`, line.ScreenId, line.LineId) {
+			continue
+		}
+		insertLineTx(tx, line)
+		inserted.Lines = append(inserted.Lines, line)
+	}
+	for _, cmd := range data.Cmds {
+		if tx.Exists(`SELECT lineid FROM cmd WHERE screenid = ? AND lineid = ?`, cmd.ScreenId, cmd.LineId) {
+			continue
+		}
+		insertCmdTx(tx, cmd)
+		inserted.Cmds = append(inserted.Cmds, cmd)
+	}
+	for _, hitem := range data.History {
+		if tx.Exists(`SELECT historyid FROM history WHERE historyid = ?`, hitem.HistoryId) {
+			continue
+		}
+		insertHistoryItemTx(tx, hitem)
+		inserted.History = append(inserted.History, hitem)
+	}
+}
+
+// deletes the rows of data (the inverse of a restore)
+func deleteTrashDataTx(tx *TxWrap, data *TrashDataType) {
+	for _, hitem := range data.History {
+		tx.Exec(`DELETE FROM history WHERE historyid = ?`, hitem.HistoryId)
+	}
+	for _, cmd := range data.Cmds {
+		tx.Exec(`DELETE FROM cmd WHERE screenid = ? AND lineid = ?`, cmd.ScreenId, cmd.LineId)
+		deleteCmdOutputIndex(tx, cmd.ScreenId, cmd.LineId)
+	}
+	for _, line := range data.Lines {
+		tx.Exec(`DELETE FROM line WHERE screenid = ? AND lineid = ?`, line.ScreenId, line.LineId)
+	}
+	for _, screen := range data.Screens {
+		tx.Exec(`DELETE FROM screen WHERE screenid = ?`, screen.ScreenId)
+	}
+	if data.Session != nil {
+		tx.Exec(`DELETE FROM session WHERE sessionid = ?`, data.Session.SessionId)
+	}
+}
+
+// returns the trash items, newest first
+func GetTrashItems(ctx context.Context) ([]*TrashItemType, error) {
+	var rtn []*TrashItemType
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT trashid, trashtype, name, sessionid, screenid, numlines, trashts FROM trash ORDER BY trashts DESC`
+		tx.Select(&rtn, query)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
+
+// restores a trash item with its original ids.  a line or screen can only be restored into its
+// screen or session if that still exists.  a restored session is renamed if its name is in use.
+// commands that were running when they were purged are marked as hung up.
+func RestoreTrashItem(ctx context.Context, trashId string) (*TrashItemType, error) {
+	var item TrashItemType
+	var screenIds []string
+	var dataStr string
+	restored := &TrashDataType{}
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT trashid, trashtype, name, sessionid, screenid, numlines, trashts FROM trash WHERE trashid = ?`
+		if !tx.Get(&item, query, trashId) {
+			return fmt.Errorf("trash item not found")
+		}
+		var data TrashDataType
+		dataStr = tx.GetString(`SELECT data FROM trash WHERE trashid = ?`, trashId)
+		err := json.Unmarshal([]byte(dataStr), &data)
+		if err != nil {
+			return fmt.Errorf("cannot read trash item data: %v", err)
+		}
+		switch item.TrashType {
+		case TrashTypeSession:
+			if data.Session == nil {
+				return fmt.Errorf("invalid trash item, no session")
+			}
+			if tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ?`, data.Session.SessionId) {
+				return fmt.Errorf("session already exists")
+			}
+			names := tx.SelectStrings(`SELECT name FROM session`)
+			sessionName := fmtUniqueName(data.Session.Name, "workspace-%d", len(names)+1, names)
+			maxSessionIdx := tx.GetInt(`SELECT COALESCE(max(sessionidx), 0) FROM session`)
+			query = `INSERT INTO session (sessionid, name, activescreenid, sessionidx, notifynum, archived, archivedts, sharemode)
+                                   VALUES (?,         ?,    ?,              ?,          0,         ?,        ?,          ?)`
+			tx.Exec(query, data.Session.SessionId, sessionName, data.Session.ActiveScreenId, maxSessionIdx+1, data.Session.Archived, data.Session.ArchivedTs, ShareModeLocal)
+			restored.Session = data.Session
+		case TrashTypeScreen:
+			if !tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ?`, item.SessionId) {
+				return fmt.Errorf("cannot restore screen, its session no longer exists")
+			}
+			maxScreenIdx := tx.GetInt(`SELECT COALESCE(max(screenidx), 0) FROM screen WHERE sessionid = ?`, item.SessionId)
+			for _, screen := range data.Screens {
+				screen.ScreenIdx = int64(maxScreenIdx + 1)
+			}
+		case TrashTypeLine:
+			if !tx.Exists(`SELECT screenid FROM screen WHERE screenid = ?`, item.ScreenId) {
+				return fmt.Errorf("cannot restore lines, their screen no longer exists")
+			}
+		default:
+			return fmt.Errorf("invalid trash type %q", item.TrashType)
+		}
+		for _, screen := range data.Screens {
+			if tx.Exists(`SELECT screenid FROM screen WHERE screenid = ?`, screen.ScreenId) {
+				return fmt.Errorf("screen already exists")
+			}
+			// web sharing is not restored
+			screen.ShareMode = ShareModeLocal
+			screen.WebShareOpts = nil
+			insertScreenTx(tx, screen)
+			restored.Screens = append(restored.Screens, screen)
+			screenIds = append(screenIds, screen.ScreenId)
+		}
+		for _, cmd := range data.Cmds {
+			if cmd.Status == CmdStatusRunning || cmd.Status == CmdStatusDetached {
+				cmd.Status = CmdStatusHangup
+			}
+		}
+		insertTrashLinesTx(tx, &data, restored)
+		if item.TrashType == TrashTypeLine {
+			screenIds = append(screenIds, item.ScreenId)
+		}
+		tx.Exec(`DELETE FROM trash WHERE trashid = ?`, trashId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	// the files are moved once the rows are committed.  if that fails, the restore is undone
+	moves := &fileMoves{}
+	for _, screenId := range screenIds {
+		err := restoreScreenDirFromTrash(trashId, screenId, moves)
+		if err == nil {
+			continue
+		}
+		moves.undo()
+		for _, undoScreenId := range screenIds {
+			scbase.ClearScreenDirCache(undoScreenId)
+			clearScreenPtyOverflowCache(undoScreenId)
+		}
+		undoErr := WithTx(ctx, func(tx *TxWrap) error {
+			deleteTrashDataTx(tx, restored)
+			query := `INSERT INTO trash (trashid, trashtype, name, sessionid, screenid, numlines, trashts, data)
+			                     VALUES (?,       ?,         ?,    ?,         ?,        ?,        ?,       ?)`
+			tx.Exec(query, item.TrashId, item.TrashType, item.Name, item.SessionId, item.ScreenId, item.NumLines, item.TrashTs, dataStr)
+			return nil
+		})
+		if undoErr != nil {
+			return nil, fmt.Errorf("cannot restore files for screen[%s]: %v (cannot undo the restore: %v)", screenId, err, undoErr)
+		}
+		return nil, fmt.Errorf("cannot restore files for screen[%s], item was not restored: %v", screenId, err)
+	}
+	err := removeTrashItemDir(trashId)
+	if err != nil {
+		log.Printf("error removing trash dir %s: %v\n", trashId, err)
+	}
+	startReindexRestoredOutput(screenIds)
+	return &item, nil
+}
+
+// tracks the running reindexRestoredOutput goroutines (so tests can wait for them before closing the db)
+var reindexWaitGroup sync.WaitGroup
+
+func startReindexRestoredOutput(screenIds []string) {
+	reindexWaitGroup.Add(1)
+	go func() {
+		defer reindexWaitGroup.Done()
+		reindexRestoredOutput(screenIds)
+	}()
+}
+
+// the output index rows are deleted on purge, rebuild them for the restored cmds
+func reindexRestoredOutput(screenIds []string) {
+	ctx := context.Background()
+	for _, screenId := range screenIds {
+		var lineIds []string
+		txErr := WithTx(ctx, func(tx *TxWrap) error {
+			query := `SELECT c.lineid FROM cmd c WHERE c.screenid = ? AND NOT EXISTS (SELECT 1 FROM cmd_output_idx i WHERE i.screenid = c.screenid AND i.lineid = c.lineid)`
+			lineIds = tx.SelectStrings(query, screenId)
+			return nil
+		})
+		if txErr != nil {
+			log.Printf("error reindexing restored output for screen[%s]: %v\n", screenId, txErr)
+			continue
+		}
+		for _, lineId := range lineIds {
+			_, data, err := ReadFullPtyOutData(ctx, screenId, lineId)
+			if err != nil || len(data) == 0 {
+				continue
+			}
+			indexCmdOutput(ctx, screenId, lineId, data)
+			FlushCmdOutputIndex(ctx, screenId, lineId)
+		}
+	}
+}
+
+// deletes the trash items (rows and files) for good.  nil trashIds empties the trash.
+func DeleteTrashItems(ctx context.Context, trashIds []string) (int, error) {
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		if trashIds == nil {
+			trashIds = tx.SelectStrings(`SELECT trashid FROM trash`)
+		}
+		for _, trashId := range trashIds {
+			tx.Exec(`DELETE FROM trash WHERE trashid = ?`, trashId)
+		}
+		return nil
+	})
+	if txErr != nil {
+		return 0, txErr
+	}
+	for _, trashId := range trashIds {
+		err := removeTrashItemDir(trashId)
+		if err != nil {
+			log.Printf("error removing trash dir %s: %v\n", trashId, err)
+		}
+	}
+	return len(trashIds), nil
+}
+
+// deletes the trash items that are older than expireDays
+func ExpireTrash(ctx context.Context, expireDays int, now time.Time) (int, error) {
+	cutoffTs := now.AddDate(0, 0, -expireDays).UnixMilli()
+	var trashIds []string
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		trashIds = tx.SelectStrings(`SELECT trashid FROM trash WHERE trashts < ?`, cutoffTs)
+		return nil
+	})
+	if txErr != nil {
+		return 0, txErr
+	}
+	if len(trashIds) == 0 {
+		return 0, nil
+	}
+	return DeleteTrashItems(ctx, trashIds)
+}
+
+// total size of the files in the trash
+func GetTrashDiskSize() int64 {
+	var rtn int64
+	filepath.WalkDir(scbase.GetTrashDir(), func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if finfo, err := entry.Info(); err == nil {
+			rtn += finfo.Size()
+		}
+		return nil
+	})
+	return rtn
+}
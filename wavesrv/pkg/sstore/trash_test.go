@@ -0,0 +1,117 @@
+// Copyright 2023, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// adds a line with a ptyout file, returns the lineid and the ptyout file name
+func makeTrashTestLine(t *testing.T, screenId string) (string, string) {
+	ctx := context.Background()
+	line, err := AddCommentLine(ctx, screenId, "user", "trash test")
+	if err != nil {
+		t.Fatalf("cannot add line: %v", err)
+	}
+	err = CreateCmdPtyFile(ctx, screenId, line.LineId, testCirSize)
+	if err != nil {
+		t.Fatalf("cannot create ptyout file: %v", err)
+	}
+	fileName, _ := scbase.PtyOutFile(screenId, line.LineId)
+	return line.LineId, fileName
+}
+
+func checkTrashTestLine(t *testing.T, name string, screenId string, lineId string, fileName string, inDB bool) {
+	exists, _ := WithTxRtn(context.Background(), func(tx *TxWrap) (bool, error) {
+		return tx.Exists(`SELECT lineid FROM line WHERE screenid = ? AND lineid = ?`, screenId, lineId), nil
+	})
+	if exists != inDB {
+		t.Errorf("%s: line in db %v, expected %v", name, exists, inDB)
+	}
+	fileFound, _ := fileExists(fileName)
+	if fileFound != inDB {
+		t.Errorf("%s: ptyout file exists %v, expected %v", name, fileFound, inDB)
+	}
+}
+
+func getTrashTestItemId(t *testing.T, screenId string) string {
+	trashId, _ := WithTxRtn(context.Background(), func(tx *TxWrap) (string, error) {
+		return tx.GetString(`SELECT trashid FROM trash WHERE screenid = ?`, screenId), nil
+	})
+	return trashId
+}
+
+func TestTrashPurgeRestore(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	screenId := makeRetentionTestScreen(t, getTestSessionId(t), "trash", time.Now(), nil)
+	lineId, fileName := makeTrashTestLine(t, screenId)
+
+	err := PurgeLinesByIds(ctx, screenId, []string{lineId})
+	if err != nil {
+		t.Fatalf("cannot purge line: %v", err)
+	}
+	checkTrashTestLine(t, "purge", screenId, lineId, fileName, false)
+	trashId := getTrashTestItemId(t, screenId)
+	if trashId == "" {
+		t.Fatalf("purge did not create a trash item")
+	}
+
+	// a directory in the way of the ptyout file, the restore is undone
+	blockDir := fileName
+	os.MkdirAll(blockDir, 0700)
+	os.WriteFile(path.Join(blockDir, "block"), nil, 0600)
+	_, err = RestoreTrashItem(ctx, trashId)
+	if err == nil {
+		t.Fatalf("restore should fail with the ptyout file blocked")
+	}
+	os.RemoveAll(blockDir)
+	checkTrashTestLine(t, "failed restore", screenId, lineId, fileName, false)
+	if getTrashTestItemId(t, screenId) != trashId {
+		t.Fatalf("failed restore should keep the trash item")
+	}
+
+	_, err = RestoreTrashItem(ctx, trashId)
+	if err != nil {
+		t.Fatalf("cannot restore trash item: %v", err)
+	}
+	checkTrashTestLine(t, "restore", screenId, lineId, fileName, true)
+	if getTrashTestItemId(t, screenId) != "" {
+		t.Errorf("restore should remove the trash item")
+	}
+}
+
+func TestTrashPurgeUndo(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	screenId := makeRetentionTestScreen(t, getTestSessionId(t), "trash", time.Now(), nil)
+	lineId, fileName := makeTrashTestLine(t, screenId)
+
+	var trashData *TrashDataType
+	var trashId string
+	err := WithTx(ctx, func(tx *TxWrap) error {
+		trashData, trashId = purgeLinesTx(tx, screenId, []string{lineId})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot purge line: %v", err)
+	}
+	// a file in the way of the trash screen dir, the purge is undone
+	trashDir, _ := scbase.EnsureTrashItemDir(trashId)
+	os.WriteFile(path.Join(trashDir, screenId), nil, 0600)
+	err = movePurgedLineFiles(ctx, screenId, []string{lineId}, trashId, trashData)
+	if err == nil {
+		t.Fatalf("moving the pty files should fail with the trash dir blocked")
+	}
+	checkTrashTestLine(t, "undo", screenId, lineId, fileName, true)
+	if getTrashTestItemId(t, screenId) != "" {
+		t.Errorf("undone purge should remove the trash item")
+	}
+}
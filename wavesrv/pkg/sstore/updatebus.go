@@ -36,26 +36,28 @@ func (*PtyDataUpdate) UpdateType() string {
 func (pdu *PtyDataUpdate) Clean() {}
 
 type ModelUpdate struct {
-	Sessions         []*SessionType   `json:"sessions,omitempty"`
-	ActiveSessionId  string           `json:"activesessionid,omitempty"`
-	Screens          []*ScreenType    `json:"screens,omitempty"`
-	ScreenLines      *ScreenLinesType `json:"screenlines,omitempty"`
-	Line             *LineType        `json:"line,omitempty"`
-	Lines            []*LineType      `json:"lines,omitempty"`
-	Cmd              *CmdType         `json:"cmd,omitempty"`
-	CmdLine          *CmdLineType     `json:"cmdline,omitempty"`
-	Info             *InfoMsgType     `json:"info,omitempty"`
-	ClearInfo        bool             `json:"clearinfo,omitempty"`
-	Remotes          []interface{}    `json:"remotes,omitempty"` // []*remote.RemoteState
-	History          *HistoryInfoType `json:"history,omitempty"`
-	Interactive      bool             `json:"interactive"`
-	Connect          bool             `json:"connect,omitempty"`
-	MainView         string           `json:"mainview,omitempty"`
-	Bookmarks        []*BookmarkType  `json:"bookmarks,omitempty"`
-	SelectedBookmark string           `json:"selectedbookmark,omitempty"`
-	HistoryViewData  *HistoryViewData `json:"historyviewdata,omitempty"`
-	ClientData       *ClientData      `json:"clientdata,omitempty"`
-	RemoteView       *RemoteViewType  `json:"remoteview,omitempty"`
+	Sessions         []*SessionType      `json:"sessions,omitempty"`
+	ActiveSessionId  string              `json:"activesessionid,omitempty"`
+	Screens          []*ScreenType       `json:"screens,omitempty"`
+	ScreenLines      *ScreenLinesType    `json:"screenlines,omitempty"`
+	Line             *LineType           `json:"line,omitempty"`
+	Lines            []*LineType         `json:"lines,omitempty"`
+	Cmd              *CmdType            `json:"cmd,omitempty"`
+	CmdLine          *CmdLineType        `json:"cmdline,omitempty"`
+	Info             *InfoMsgType        `json:"info,omitempty"`
+	ClearInfo        bool                `json:"clearinfo,omitempty"`
+	Remotes          []interface{}       `json:"remotes,omitempty"` // []*remote.RemoteState
+	History          *HistoryInfoType    `json:"history,omitempty"`
+	Interactive      bool                `json:"interactive"`
+	Connect          bool                `json:"connect,omitempty"`
+	MainView         string              `json:"mainview,omitempty"`
+	Bookmarks        []*BookmarkType     `json:"bookmarks,omitempty"`
+	SelectedBookmark string              `json:"selectedbookmark,omitempty"`
+	HistoryViewData  *HistoryViewData    `json:"historyviewdata,omitempty"`
+	ClientData       *ClientData         `json:"clientdata,omitempty"`
+	RemoteView       *RemoteViewType     `json:"remoteview,omitempty"`
+	FileChanged      *FileChangedType    `json:"filechanged,omitempty"`
+	BookmarkPrompt   *BookmarkPromptType `json:"bookmarkprompt,omitempty"`
 }
 
 func (*ModelUpdate) UpdateType() string {
@@ -69,6 +71,29 @@ func (update *ModelUpdate) Clean() {
 	update.ClientData = update.ClientData.Clean()
 }
 
+// sent when a bookmark with {{placeholders}} is run without values for all of them
+type BookmarkPromptType struct {
+	BookmarkId string               `json:"bookmarkid"`
+	CmdStr     string               `json:"cmdstr"`
+	Params     []*BookmarkParamType `json:"params"`
+}
+
+type BookmarkParamType struct {
+	Name      string `json:"name"`
+	Default   string `json:"default,omitempty"`
+	LastValue string `json:"lastvalue,omitempty"`
+}
+
+// sent when a file open in a codeedit line is changed on the remote
+type FileChangedType struct {
+	ScreenId string `json:"screenid"`
+	LineId   string `json:"lineid"`
+	Path     string `json:"path"`
+	ModTs    int64  `json:"modts,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
 type RemoteViewType struct {
 	RemoteShowAll bool            `json:"remoteshowall,omitempty"`
 	PtyRemoteId   string          `json:"ptyremoteid,omitempty"`